@@ -0,0 +1,179 @@
+// keygen генерирует и конвертирует RSA ключи для шифрования метрик(CRYPTO_KEY агента и сервера).
+//
+// Использование:
+//
+//	keygen generate [-bits 4096] [-format pkcs1|pkcs8] [-public-format pkcs1|pkix] [-private private.pem] [-public public.pem]
+//	keygen inspect file.pem...
+//	keygen convert -in file.pem [-out out.pem] -format pkcs1|pkcs8|pkix
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/firesworder/devopsmetrics/internal/crypt"
+)
+
+const usage = `usage:
+  keygen generate [-bits 4096] [-format pkcs1|pkcs8] [-public-format pkcs1|pkix] [-private file] [-public file]
+  keygen inspect file.pem...
+  keygen convert -in file.pem [-out file.pem] -format pkcs1|pkcs8|pkix`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run выполняет подкоманду args[0], результат выводит в stdout.
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "generate":
+		return generate(args[1:], stdout)
+	case "inspect":
+		return inspect(args[1:], stdout)
+	case "convert":
+		return convert(args[1:], stdout)
+	default:
+		return fmt.Errorf("unknown command '%s'\n%s", args[0], usage)
+	}
+}
+
+// generate создает пару ключей и выводит их id и отпечаток.
+func generate(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	bits := fs.Int("bits", 4096, "key size in bits")
+	format := fs.String("format", crypt.FormatPKCS1, "private key format: pkcs1 or pkcs8")
+	publicFormat := fs.String("public-format", crypt.FormatPKCS1, "public key format: pkcs1 or pkix")
+	privateFp := fs.String("private", "private.pem", "filepath to write private key")
+	publicFp := fs.String("public", "public.pem", "filepath to write public key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, *bits)
+	if err != nil {
+		return err
+	}
+	privateContent, err := crypt.MarshalPrivateKey(privateKey, *format)
+	if err != nil {
+		return err
+	}
+	publicContent, err := crypt.MarshalPublicKey(&privateKey.PublicKey, *publicFormat)
+	if err != nil {
+		return err
+	}
+
+	// существующие ключи не перезаписываются
+	if err = writeNewFile(*privateFp, privateContent, 0600); err != nil {
+		return err
+	}
+	if err = writeNewFile(*publicFp, publicContent, 0644); err != nil {
+		return err
+	}
+	return printKeyInfo(stdout, *privateFp, "private", *format, &privateKey.PublicKey)
+}
+
+// inspect выводит тип, формат, размер, id и отпечаток ключей из файлов.
+func inspect(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	for _, fp := range args {
+		content, err := os.ReadFile(fp)
+		if err != nil {
+			return err
+		}
+		if privateKey, format, err := crypt.ParsePrivateKey(content); err == nil {
+			if err = printKeyInfo(stdout, fp, "private", format, &privateKey.PublicKey); err != nil {
+				return err
+			}
+			continue
+		}
+		publicKey, format, err := crypt.ParsePublicKey(content)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fp, err)
+		}
+		if err = printKeyInfo(stdout, fp, "public", format, publicKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convert переписывает ключ в другом формате: приватный - pkcs1\pkcs8, публичный - pkcs1\pkix.
+func convert(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	inFp := fs.String("in", "", "filepath to source key")
+	outFp := fs.String("out", "", "filepath to write converted key(empty - stdout)")
+	format := fs.String("format", "", "target format: pkcs1, pkcs8(private) or pkix(public)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *inFp == "" || *format == "" {
+		return errors.New(usage)
+	}
+
+	content, err := os.ReadFile(*inFp)
+	if err != nil {
+		return err
+	}
+	var converted []byte
+	var perm os.FileMode
+	if privateKey, _, err := crypt.ParsePrivateKey(content); err == nil {
+		converted, err = crypt.MarshalPrivateKey(privateKey, *format)
+		if err != nil {
+			return err
+		}
+		perm = 0600
+	} else {
+		publicKey, _, err := crypt.ParsePublicKey(content)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *inFp, err)
+		}
+		converted, err = crypt.MarshalPublicKey(publicKey, *format)
+		if err != nil {
+			return err
+		}
+		perm = 0644
+	}
+
+	if *outFp == "" {
+		_, err = stdout.Write(converted)
+		return err
+	}
+	return writeNewFile(*outFp, converted, perm)
+}
+
+// printKeyInfo выводит строку с описанием ключа.
+func printKeyInfo(stdout io.Writer, fp, keyType, format string, publicKey *rsa.PublicKey) error {
+	fingerprint, err := crypt.Fingerprint(publicKey)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s: type=%s format=%s bits=%d key_id=%s fingerprint=%s\n",
+		fp, keyType, format, publicKey.N.BitLen(), crypt.KeyID(publicKey), fingerprint)
+	return err
+}
+
+// writeNewFile записывает content в новый файл fp, если файл уже существует - возвращает ошибку.
+func writeNewFile(fp string, content []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
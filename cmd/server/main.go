@@ -33,9 +33,23 @@ func main() {
 		log.Fatal(err)
 	}
 	serverObj := &http.Server{
-		Addr:    server.Env.ServerAddress,
-		Handler: serverParams.Router,
+		Addr:      server.Env.ServerAddress,
+		Handler:   serverParams.Router,
+		TLSConfig: serverParams.TLSConfig,
 	}
+	// SSE подписки не завершаются сами, закрываю их при остановке сервера
+	serverObj.RegisterOnShutdown(serverParams.CloseStreams)
+
+	// по SIGHUP перечитываю приватные ключи(ротация ключей без перезапуска)
+	sigReload := make(chan os.Signal, 1)
+	signal.Notify(sigReload, syscall.SIGHUP)
+	go func() {
+		for range sigReload {
+			if err := serverParams.ReloadKeys(); err != nil {
+				log.Printf("cannot reload private keys: %v", err)
+			}
+		}
+	}()
 	go func() {
 		<-sigClose
 		if err := serverObj.Shutdown(context.Background()); err != nil {
@@ -44,10 +58,17 @@ func main() {
 		}
 		serverStopCtx()
 	}()
-	err = serverObj.ListenAndServe()
+	if serverParams.TLSConfig != nil {
+		// сертификаты уже загружены в TLSConfig
+		err = serverObj.ListenAndServeTLS("", "")
+	} else {
+		err = serverObj.ListenAndServe()
+	}
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatal(err)
 	}
 	<-serverCtx.Done()
+	// уведомления, уже поставленные в очередь, отправляются до выхода
+	serverParams.CloseNotifier()
 	log.Println("server was shutdown gracefully")
 }
@@ -0,0 +1,37 @@
+// storemigrate шифрует существующий открытый файл хранилища метрик сервера(STORE_FILE).
+// Ключ берется из переменной окружения STORE_KEY или файла -store-key-file, как и на сервере.
+// Сервер на время миграции должен быть остановлен, иначе он перезапишет файл.
+//
+// Использование:
+//
+//	STORE_KEY=<hex> storemigrate -f /tmp/devops-metrics-db.json
+//	storemigrate -f /tmp/devops-metrics-db.json -store-key-file store.key
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/firesworder/devopsmetrics/internal/filestore"
+)
+
+func main() {
+	storeFile := flag.String("f", "/tmp/devops-metrics-db.json", "store file to encrypt")
+	storeKeyFile := flag.String("store-key-file", "", "filepath to AES key(hex or base64)")
+	flag.Parse()
+
+	storeCipher, err := filestore.NewCipherByEnv(os.Getenv("STORE_KEY"), *storeKeyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if storeCipher == nil {
+		log.Fatal(errors.New("store key is not set: use STORE_KEY env or -store-key-file"))
+	}
+
+	if err = filestore.Encrypt(*storeFile, storeCipher); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("store file '%s' was encrypted", *storeFile)
+}
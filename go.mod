@@ -8,12 +8,15 @@ require (
 	github.com/go-resty/resty/v2 v2.7.0
 	github.com/gordonklaus/ineffassign v0.0.0-20230610083614-0e73809eb601
 	github.com/jackc/pgx/v5 v5.3.1
+	github.com/klauspost/compress v1.16.7
 	github.com/sashamelentyev/usestdlibvars v1.23.0
 	github.com/shirou/gopsutil/v3 v3.23.3
 	github.com/stretchr/testify v1.8.2
 	github.com/swaggo/swag v1.16.1
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	golang.org/x/sync v0.3.0
 	golang.org/x/tools v0.10.0
+	google.golang.org/protobuf v1.31.0
 	honnef.co/go/tools v0.4.3
 )
 
@@ -39,6 +42,7 @@ require (
 	github.com/shoenig/go-m1cpu v0.1.4 // indirect
 	github.com/tklauser/go-sysconf v0.3.11 // indirect
 	github.com/tklauser/numcpus v0.6.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	github.com/yusufpapurcu/wmi v1.2.2 // indirect
 	golang.org/x/crypto v0.10.0 // indirect
 	golang.org/x/exp/typeparams v0.0.0-20230626212559-97b1e661b5df // indirect
@@ -1,60 +1,45 @@
-// Package agent реализует работу агента сбора метрик(в cmd/agent/ используются функции этого пакета).
-// Агент собирает требуемые(по заданию) метрики, подготавливает их к отправке и отправляет на сервер.
-// Также в рамках этого пакета определены функции работающие с переменными окружения агента.
+// Package agent реализует работу агента сбора метрик(в cmd/agent/ используется тип Agent этого пакета).
+// Агент собирает требуемые(по заданию) метрики коллекторами, подготавливает их к отправке и отправляет на сервер.
+// Также в рамках этого пакета определены функции получения конфигурации агента(cmd, ENV и json конфиг).
 package agent
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/firesworder/devopsmetrics/internal/crypt"
 	"log"
-	"math/rand"
+	"net"
+	"net/http"
 	"net/url"
-	"runtime"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/caarlos0/env/v7"
 	"github.com/go-resty/resty/v2"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/compression"
+	"github.com/firesworder/devopsmetrics/internal/crypt"
 	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/tlsconfig"
 )
 
-// типы необходимые для использования с метриками(по заданию).
+// Типы значений метрик(по заданию), коллекторы возвращают значения этих типов.
 type (
-	gauge   float64
-	counter int64
-)
-
-// serverURL содержит адрес сервера.
-var serverURL string
-
-// переменные в которых хранятся значения метрик(в сыром виде) для отправки.
-var (
-	memstats      runtime.MemStats
-	pollCount     counter
-	randomValue   gauge
-	goPsutilStats = struct {
-		CPUutilization []float64
-		TotalMemory    float64
-		FreeMemory     float64
-	}{}
+	Gauge   float64
+	Counter int64
 )
 
-// updateMetricsMutex для RW блокировки переменных со значениями метрик на время записи и чтения.
-var updateMetricsMutex sync.RWMutex
-
-// Для тестирования функции UpdateMetrics
-var testUMWG *sync.WaitGroup
+// ErrAgentIsRunning агент уже запущен(Run).
+var ErrAgentIsRunning = errors.New("agent is already running")
 
-// environment для получения(из ENV и cmd) и хранения переменных окружения агента.
-type environment struct {
+// Config конфигурация агента(из ENV, cmd и json конфига).
+type Config struct {
 	Key               string        `env:"KEY"`
 	ServerAddress     string        `env:"ADDRESS"`
 	RateLimit         int           `env:"RATE_LIMIT"`
@@ -62,81 +47,328 @@ type environment struct {
 	PollInterval      time.Duration `env:"POLL_INTERVAL"`
 	PublicCryptoKeyFp string        `env:"CRYPTO_KEY"`
 	ConfigFilepath    string        `env:"CONFIG"`
+	TLS               bool          `env:"TLS"`
+	TLSCAFp           string        `env:"TLS_CA"`
+	TLSCertFp         string        `env:"TLS_CERT"`
+	TLSKeyFp          string        `env:"TLS_KEY"`
+	Token             string        `env:"TOKEN"`
+	AgentID           string        `env:"AGENT_ID"`
+	ClientKeyFp       string        `env:"CLIENT_KEY"`
+	VerifyResponses   bool          `env:"VERIFY_RESPONSES"`
+	Compression       string        `env:"COMPRESSION"`
+	CompressMinSize   int           `env:"COMPRESS_MIN_SIZE"`
+	Format            string        `env:"FORMAT"`
+	PartialBatch      bool          `env:"PARTIAL_BATCH"`
+	Aggregate         string        `env:"AGGREGATE"`
+	IncludeMetrics    string        `env:"INCLUDE_METRICS"`
+	ExcludeMetrics    string        `env:"EXCLUDE_METRICS"`
+	RenameMetrics     string        `env:"RENAME_METRICS"`
+	MetricPrefix      string        `env:"METRIC_PREFIX"`
 }
 
-// workPool содержит переменные служебного использования для воркпула.
-type workPool struct {
-	ch           chan bool
-	workersCount int
-	wgStart      sync.WaitGroup
-	wgFinish     sync.WaitGroup
+// Agent агент сбора метрик. Создается NewAgent, запускается Run и останавливается Stop(или отменой контекста Run).
+// Несколько агентов в одном процессе не влияют друг на друга.
+type Agent struct {
+	config Config
+
+	// serverURL адрес сервера.
+	serverURL string
+	// agentIP ip адрес исходящего интерфейса агента, передается серверу в заголовке X-Real-IP.
+	agentIP string
+	// tlsConfig tls конфигурация клиента, если nil - метрики отправляются по http.
+	tlsConfig *tls.Config
+	// encoder публичный ключ сервера(CRYPTO_KEY): им шифруются сообщения и проверяются подписи ответов.
+	encoder *crypt.Encoder
+	// clientDecoder приватный ключ агента, которым сервер шифрует ответы(CLIENT_KEY).
+	clientDecoder *crypt.Decoder
+	// clientKeyID id ключа агента, зарегистрированного на сервере. Пустой - ответы не шифруются.
+	clientKeyID string
+	// httpClient http клиент для запросов к серверу, если nil - создается resty.
+	httpClient *http.Client
+
+	collectors []Collector
+	// filter фильтр метрик, если nil - отправляются все метрики без изменений.
+	filter *metricsFilter
+	// aggregator агрегатор метрик, если nil - агрегаты не отправляются.
+	aggregator *aggregator
+	// stats служебные метрики агента.
+	stats *selfStats
+	// pool воркпул, отправляющий метрики на сервер.
+	pool workPool
+
+	// metricsMutex для RW блокировки значений метрик на время записи и чтения.
+	metricsMutex sync.RWMutex
+	// metrics последние собранные коллекторами значения метрик.
+	metrics   map[string]interface{}
+	pollCount Counter
+
+	runMutex sync.Mutex
+	cancel   context.CancelFunc
+	done     chan struct{}
 }
 
-// WPool воркпул, отправляющий метрики на сервер.
-var WPool workPool
+// Option дополнительный параметр NewAgent.
+type Option func(a *Agent)
 
-// Env объект с переменными окружения(из ENV и cmd args).
-var Env environment
+// WithHTTPClient задает http клиент для запросов к серверу.
+// TLS параметры конфигурации к переданному клиенту не применяются, они настраиваются в его Transport.
+func WithHTTPClient(client *http.Client) Option {
+	return func(a *Agent) {
+		a.httpClient = client
+	}
+}
 
-var encoder *crypt.Encoder
+// WithCollectors задает коллекторы метрик вместо DefaultCollectors.
+func WithCollectors(collectors ...Collector) Option {
+	return func(a *Agent) {
+		a.collectors = collectors
+	}
+}
 
-func init() {
-	InitCmdArgs()
-	if Env.PublicCryptoKeyFp != "" {
-		var err error
-		encoder, err = crypt.NewEncoder(Env.PublicCryptoKeyFp)
-		if err != nil {
-			panic(err)
+// NewAgent конструктор Agent: загружает ключи и tls конфигурацию, определяет адрес сервера,
+// инициализирует фильтр и агрегатор метрик.
+func NewAgent(config Config, options ...Option) (*Agent, error) {
+	if config.PollInterval <= 0 || config.ReportInterval <= 0 {
+		return nil, fmt.Errorf("poll(%s) and report(%s) intervals must be positive",
+			config.PollInterval, config.ReportInterval)
+	}
+	a := &Agent{
+		config:     config,
+		collectors: DefaultCollectors(),
+		stats:      newSelfStats(),
+		metrics:    map[string]interface{}{},
+	}
+	a.pool.stats = a.stats
+	for _, option := range options {
+		option(a)
+	}
+
+	if err := a.initEncoder(); err != nil {
+		return nil, err
+	}
+	if err := a.initTLS(); err != nil {
+		return nil, err
+	}
+	a.initServerURL()
+	if err := a.initFilter(); err != nil {
+		return nil, err
+	}
+	if err := a.initAggregator(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// initEncoder загружает публичный ключ сервера(CRYPTO_KEY): им шифруются сообщения и проверяются подписи ответов.
+func (a *Agent) initEncoder() error {
+	if a.config.PublicCryptoKeyFp == "" {
+		return nil
+	}
+	var err error
+	a.encoder, err = crypt.NewEncoder(a.config.PublicCryptoKeyFp)
+	return err
+}
+
+// initTLS инициализирует tls конфигурацию клиента, если задан хотя бы один из параметров TLS.
+// Должна вызываться до initServerURL.
+func (a *Agent) initTLS() error {
+	if !a.config.TLS && a.config.TLSCAFp == "" && a.config.TLSCertFp == "" && a.config.TLSKeyFp == "" {
+		a.tlsConfig = nil
+		return nil
+	}
+	config, err := tlsconfig.NewClientConfig(a.config.TLSCAFp, a.config.TLSCertFp, a.config.TLSKeyFp)
+	if err != nil {
+		return err
+	}
+	a.tlsConfig = config
+	return nil
+}
+
+// initServerURL устанавливает serverURL по ServerAddress. Если инициализирован tls - используется https.
+// Также определяет agentIP - адрес интерфейса, через который агент обращается к серверу.
+func (a *Agent) initServerURL() {
+	scheme := "http"
+	if a.tlsConfig != nil {
+		scheme = "https"
+	}
+	a.serverURL = (&url.URL{Scheme: scheme, Host: a.config.ServerAddress}).String()
+
+	var err error
+	a.agentIP, err = getOutboundIP(a.config.ServerAddress)
+	if err != nil {
+		log.Printf("cannot get outbound ip, X-Real-IP will not be set: %s", err)
+	}
+}
+
+// getOutboundIP возвращает ip адрес исходящего интерфейса для обращения к address.
+// Используется udp "соединение", поэтому пакеты на сервер не отправляются.
+func getOutboundIP(address string) (string, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+}
+
+// newClient возвращает resty клиент для отправки метрик на сервер.
+func (a *Agent) newClient() *resty.Client {
+	var client *resty.Client
+	if a.httpClient != nil {
+		client = resty.NewWithClient(a.httpClient)
+	} else {
+		client = resty.New()
+		if a.tlsConfig != nil {
+			client.SetTLSClientConfig(a.tlsConfig)
 		}
 	}
-	memstats = runtime.MemStats{}
-	runtime.ReadMemStats(&memstats)
+	client.SetBaseURL(a.serverURL)
+	if a.agentIP != "" {
+		client.SetHeader("X-Real-IP", a.agentIP)
+	}
+	if a.config.Token != "" {
+		client.SetAuthToken(a.config.Token)
+	}
+	if a.encoder != nil {
+		client.SetHeader(crypt.KeyIDHeader, a.encoder.KeyID())
+	}
+	if a.clientKeyID != "" {
+		client.SetHeader(crypt.ClientKeyIDHeader, a.clientKeyID)
+	}
+	return client
 }
 
-// InitServerURLByEnv Устанавливает глоб-ую переменную serverURL по переменной окружения ServerAddress.
-func InitServerURLByEnv() {
-	serverURL = (&url.URL{Scheme: "http", Host: Env.ServerAddress}).String()
+// getAgentID возвращает id агента, передаваемый в подписи запроса: AgentID, иначе имя хоста, иначе agentIP.
+func (a *Agent) getAgentID() string {
+	if a.config.AgentID != "" {
+		return a.config.AgentID
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return a.agentIP
+}
+
+// signRequest подписывает запрос целиком(message.BatchSignature), если задан Key.
+// body - тело запроса до шифрования. Query параметры и заголовки запроса должны быть заданы до подписи.
+func (a *Agent) signRequest(request *resty.Request, path string, body []byte) error {
+	if a.config.Key == "" {
+		return nil
+	}
+	target := message.SignatureTarget(path, request.QueryParam.Encode())
+	signature, err := message.NewBatchSignature(a.config.Key, http.MethodPost, target, request.Header, body,
+		a.getAgentID(), time.Now())
+	if err != nil {
+		return err
+	}
+	signature.SetHeader(request.Header)
+	return nil
+}
+
+// formatContentTypes Content-Type тела запросов для значений Format.
+var formatContentTypes = map[string]string{
+	"json":     message.ContentTypeJSON,
+	"protobuf": message.ContentTypeProtobuf,
+	"msgpack":  message.ContentTypeMsgpack,
+}
+
+// contentType возвращает Content-Type тела запросов с метриками по Format, по умолчанию - JSON.
+func (a *Agent) contentType() string {
+	if ct, ok := formatContentTypes[a.config.Format]; ok {
+		return ct
+	}
+	return message.ContentTypeJSON
 }
 
-// InitCmdArgs Определяет флаги командной строки и линкует их с соотв полями объекта Env.
+// compressRequest сжимает тело запроса кодеком Compression, если оно не меньше CompressMinSize.
+// Зашифрованное тело не сжимается: сервер распаковывает запрос до расшифровки, а шифротекст не сжимается.
+func (a *Agent) compressRequest(request *resty.Request, body []byte) ([]byte, error) {
+	if a.config.Compression == "" || a.encoder != nil || len(body) < a.config.CompressMinSize {
+		return body, nil
+	}
+	compressed, err := compression.Compress(a.config.Compression, body)
+	if err != nil {
+		return nil, err
+	}
+	request.SetHeader("Content-Encoding", a.config.Compression)
+	return compressed, nil
+}
+
+// initCmdArgs Определяет флаги командной строки и линкует их с соотв полями config.
 // В рамках этой же функции происходит и заполнение дефолтными значениями.
-func InitCmdArgs() {
-	flag.StringVar(&Env.ServerAddress, "a", "localhost:8080", "Server address")
-	flag.DurationVar(&Env.ReportInterval, "r", 10*time.Second, "report interval")
-	flag.DurationVar(&Env.PollInterval, "p", 2*time.Second, "poll(update) interval")
-	flag.StringVar(&Env.Key, "k", "", "key for hash func")
-	flag.IntVar(&Env.RateLimit, "l", 0, "rate limit(send routines at one time)")
-	flag.StringVar(&Env.PublicCryptoKeyFp, "crypto-key", "", "filepath to public key")
-	flag.StringVar(&Env.ConfigFilepath, "config", "", "filepath to json env config")
-	flag.StringVar(&Env.ConfigFilepath, "c", "", "filepath to json env config")
+func initCmdArgs(config *Config) {
+	flag.StringVar(&config.ServerAddress, "a", "localhost:8080", "Server address")
+	flag.DurationVar(&config.ReportInterval, "r", 10*time.Second, "report interval")
+	flag.DurationVar(&config.PollInterval, "p", 2*time.Second, "poll(update) interval")
+	flag.StringVar(&config.Key, "k", "", "key for hash func")
+	flag.IntVar(&config.RateLimit, "l", 0, "rate limit(send routines at one time)")
+	flag.StringVar(&config.PublicCryptoKeyFp, "crypto-key", "", "filepath to public key")
+	flag.StringVar(&config.ConfigFilepath, "config", "", "filepath to json env config")
+	flag.StringVar(&config.ConfigFilepath, "c", "", "filepath to json env config")
+	flag.BoolVar(&config.TLS, "tls", false, "send metrics over https")
+	flag.StringVar(&config.TLSCAFp, "tls-ca", "", "filepath to pinned server CA bundle(implies tls)")
+	flag.StringVar(&config.TLSCertFp, "tls-cert", "", "filepath to client tls certificate(implies tls)")
+	flag.StringVar(&config.TLSKeyFp, "tls-key", "", "filepath to client tls private key")
+	flag.StringVar(&config.Token, "token", "", "bearer token for server api")
+	flag.StringVar(&config.AgentID, "agent-id", "", "agent id for request signature(empty - hostname)")
+	flag.StringVar(&config.ClientKeyFp, "client-key", "", "filepath to agent private key, server encrypts responses to it")
+	flag.BoolVar(&config.VerifyResponses, "verify-responses", false, "require server responses to be signed")
+	flag.StringVar(&config.Compression, "compression", compression.Gzip, "request compression codec: gzip, zstd(empty - disabled)")
+	flag.IntVar(&config.CompressMinSize, "compress-min-size", 1024, "min request body size in bytes to compress")
+	flag.StringVar(&config.Format, "format", "json", "metrics body format: json, protobuf, msgpack")
+	flag.BoolVar(&config.PartialBatch, "partial-batch", false, "server applies valid metrics of batch, rejected are logged")
+	flag.StringVar(&config.IncludeMetrics, "include", "", "comma separated glob patterns of sent metrics(empty - all)")
+	flag.StringVar(&config.ExcludeMetrics, "exclude", "", "comma separated glob patterns of not sent metrics")
+	flag.StringVar(&config.RenameMetrics, "rename", "", "comma separated metric renames, e.g. Alloc=mem_alloc")
+	flag.StringVar(&config.MetricPrefix, "prefix", "", "prefix for all metric names, e.g. web1.")
+	flag.StringVar(&config.Aggregate, "aggregate", "",
+		"gauge aggregations over report window by glob pattern, e.g. 'CPU*=max,avg;*=min,max,avg,last'")
 }
 
-// ParseEnvArgs Парсит значения полей Env. Сначала из cmd аргументов, затем из перем-х окружения.
-func ParseEnvArgs() {
+// ParseConfig возвращает конфигурацию агента. Сначала из cmd аргументов, затем из перем-х окружения и json конфига.
+// Флаги определяются в flag.CommandLine, поэтому функция вызывается один раз(в cmd).
+func ParseConfig() (Config, error) {
+	config := Config{}
+	initCmdArgs(&config)
 	// Парсинг аргументов cmd
 	flag.Parse()
 
 	// Парсинг перем окружения
-	err := env.Parse(&Env)
-	if err != nil {
-		panic(err)
+	if err := env.Parse(&config); err != nil {
+		return Config{}, err
 	}
 
-	if Env.ConfigFilepath != "" {
-		err = parseJSONConfig()
-		if err != nil {
-			panic(err)
+	if config.ConfigFilepath != "" {
+		if err := parseJSONConfig(&config); err != nil {
+			return Config{}, err
 		}
 	}
+	if config.Compression != "" && !compression.IsSupported(config.Compression) {
+		return Config{}, fmt.Errorf("%w '%s'", compression.ErrUnsupportedEncoding, config.Compression)
+	}
+	if _, ok := formatContentTypes[config.Format]; !ok {
+		return Config{}, fmt.Errorf("%w: format '%s'", message.ErrUnsupportedContentType, config.Format)
+	}
+	return config, nil
+}
+
+// workPool содержит переменные служебного использования для воркпула.
+type workPool struct {
+	ch           chan bool
+	workersCount int
+	wgStart      sync.WaitGroup
+	wgFinish     sync.WaitGroup
+	stats        *selfStats
 }
 
-// Start запускает воркпул. Возвращает управление когда все воркеры запущены.
-func (wp *workPool) Start() {
+// Start запускает воркпул из workersCount(минимум 1) воркеров, выполняющих job по заданиям.
+// Возвращает управление когда все воркеры запущены.
+func (wp *workPool) Start(workersCount int, job func()) {
 	// определен. кол-ва воркеров
-	if Env.RateLimit == 0 {
+	if workersCount == 0 {
 		wp.workersCount = 1
 	} else {
-		wp.workersCount = Env.RateLimit
+		wp.workersCount = workersCount
 	}
 
 	// определение буф.канала
@@ -146,14 +378,18 @@ func (wp *workPool) Start() {
 	wp.wgStart, wp.wgFinish = sync.WaitGroup{}, sync.WaitGroup{}
 	wp.wgStart.Add(wp.workersCount)
 	wp.wgFinish.Add(wp.workersCount)
+	wp.stats.workersCount.Store(int64(wp.workersCount))
 
 	// создание и запуск воркеров
 	for i := 0; i < wp.workersCount; i++ {
 		go func(workerIndex int) {
 			wp.wgStart.Done() // сигнал о том, что горутина-воркер запустилась
 			for range wp.ch {
+				wp.stats.queueLength.Add(-1)
+				wp.stats.busyWorkers.Add(1)
 				log.Printf("worker with index '%d' used for sendMetrics()", workerIndex)
-				sendMetrics()
+				job()
+				wp.stats.busyWorkers.Add(-1)
 			}
 			wp.wgFinish.Done()
 		}(i)
@@ -166,159 +402,201 @@ func (wp *workPool) Start() {
 func (wp *workPool) Close() {
 	close(wp.ch)
 	wp.wgFinish.Wait()
+	wp.stats.workersCount.Store(0)
 }
 
-// UpdateMetrics основная(вызываемая в cmd) функция получения метрик для отправки.
-// Внутри отдельными горутинами собираются данные из memstats и go-psutil.
-func UpdateMetrics() {
-	// полностью блокируем данные метрик на время обновления
-	updateMetricsMutex.Lock()
-	defer updateMetricsMutex.Unlock()
+// CreateSendMetricsJob создает задание на отправку метрик в воркпуле.
+func (wp *workPool) CreateSendMetricsJob(ctx context.Context) {
+	wp.stats.queueLength.Add(1)
+	select {
+	case wp.ch <- true:
+		log.Println("job sent into workpool channel")
+	case <-ctx.Done():
+		wp.stats.queueLength.Add(-1)
+		log.Println("job was canceled by context")
+	}
+}
+
+// Run запускает агент: регистрирует ключ агента(CLIENT_KEY), собирает метрики каждый PollInterval
+// и отправляет их каждый ReportInterval. Блокирует до отмены ctx или вызова Stop,
+// перед возвратом дожидается завершения начатых сборов и отправок метрик.
+func (a *Agent) Run(ctx context.Context) error {
+	a.runMutex.Lock()
+	if a.cancel != nil {
+		a.runMutex.Unlock()
+		return ErrAgentIsRunning
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	a.cancel, a.done = cancel, done
+	a.runMutex.Unlock()
 	defer func() {
-		if testUMWG != nil {
-			testUMWG.Done()
-		}
+		cancel()
+		a.runMutex.Lock()
+		a.cancel, a.done = nil, nil
+		a.runMutex.Unlock()
+		close(done)
 	}()
 
-	g, _ := errgroup.WithContext(context.Background())
-	g.Go(updateMemStats)
-	g.Go(updateGoPsutilStats)
-
-	if err := g.Wait(); err != nil {
-		log.Println(err)
-		return
+	// без регистрации ключа агент работает, но ответы сервера не шифруются
+	if err := a.initClientKey(); err != nil {
+		log.Printf("cannot init client key: %v", err)
+	}
+	a.pool.Start(a.config.RateLimit, a.sendMetrics)
+
+	// подготовка тикеров на обновление и отправку
+	pollTicker := time.NewTicker(a.config.PollInterval)
+	defer pollTicker.Stop()
+	reportTicker := time.NewTicker(a.config.ReportInterval)
+	defer reportTicker.Stop()
+
+	var wg sync.WaitGroup
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			a.pool.Close()
+			return nil
+		case <-pollTicker.C:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				a.updateMetrics(ctx)
+			}()
+		case <-reportTicker.C:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				a.pool.CreateSendMetricsJob(ctx)
+			}()
+		}
 	}
 }
 
-// updateMemStats получает актуальные значения метрик из memstats.
-func updateMemStats() (err error) {
-	runtime.ReadMemStats(&memstats)
-	pollCount++
-	randomValue = gauge(rand.Float64())
-	return
+// Stop останавливает запущенный агент и дожидается завершения Run. Если агент не запущен - ничего не делает.
+func (a *Agent) Stop() {
+	a.runMutex.Lock()
+	cancel, done := a.cancel, a.done
+	a.runMutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
 }
 
-// updateGoPsutilStats получает актуальные значения метрик из go-psutil.
-func updateGoPsutilStats() (err error) {
-	vM, err := mem.VirtualMemory()
-	if err != nil {
-		return
+// updateMetrics получает метрики для отправки: коллекторы запускаются отдельными горутинами.
+func (a *Agent) updateMetrics(ctx context.Context) {
+	results := make([]map[string]interface{}, len(a.collectors))
+	g, ctx := errgroup.WithContext(ctx)
+	for i, collector := range a.collectors {
+		i, collector := i, collector
+		g.Go(func() error {
+			start := time.Now()
+			metrics, err := collector.Collect(ctx)
+			a.stats.observeCollect(collector.Name(), time.Since(start))
+			if err != nil {
+				return fmt.Errorf("collector '%s': %w", collector.Name(), err)
+			}
+			results[i] = metrics
+			return nil
+		})
 	}
-	goPsutilStats.TotalMemory = float64(vM.Total)
-	goPsutilStats.FreeMemory = float64(vM.Free)
+	err := g.Wait()
 
-	cpuS, err := cpu.Percent(500*time.Millisecond, true)
+	// полностью блокируем данные метрик на время обновления
+	a.metricsMutex.Lock()
+	defer a.metricsMutex.Unlock()
+	for _, metrics := range results {
+		for name, value := range metrics {
+			a.metrics[name] = value
+		}
+	}
 	if err != nil {
+		log.Println(err)
 		return
 	}
-	goPsutilStats.CPUutilization = cpuS
-	return
-}
-
-// CreateSendMetricsJob создает задание на отправку метрик в воркпуле.
-func (wp *workPool) CreateSendMetricsJob(ctx context.Context) {
-	select {
-	case wp.ch <- true:
-		log.Println("job sent into workpool channel")
-	case <-ctx.Done():
-		log.Println("job was canceled by context")
-	}
+	a.pollCount++
+	a.aggregator.observe(a.collectMetrics())
 }
 
 // sendMetrics отправляет метрики на сервер.
-func sendMetrics() {
-	updateMetricsMutex.RLock()
-	defer updateMetricsMutex.RUnlock()
-
-	metrics := map[string]interface{}{
-		"Alloc":       gauge(memstats.Alloc),
-		"BuckHashSys": gauge(memstats.BuckHashSys),
-		"Frees":       gauge(memstats.Frees),
-
-		"GCCPUFraction": gauge(memstats.GCCPUFraction),
-		"GCSys":         gauge(memstats.GCSys),
-		"HeapAlloc":     gauge(memstats.HeapAlloc),
-
-		"HeapIdle":    gauge(memstats.HeapIdle),
-		"HeapInuse":   gauge(memstats.HeapInuse),
-		"HeapObjects": gauge(memstats.HeapObjects),
-
-		"HeapReleased": gauge(memstats.HeapReleased),
-		"HeapSys":      gauge(memstats.HeapSys),
-		"LastGC":       gauge(memstats.LastGC),
-
-		"Lookups":     gauge(memstats.Lookups),
-		"MCacheInuse": gauge(memstats.MCacheInuse),
-		"MCacheSys":   gauge(memstats.MCacheSys),
-
-		"MSpanInuse": gauge(memstats.MSpanInuse),
-		"MSpanSys":   gauge(memstats.MSpanSys),
-		"Mallocs":    gauge(memstats.Mallocs),
-
-		"NextGC":      gauge(memstats.NextGC),
-		"NumForcedGC": gauge(memstats.NumForcedGC),
-		"NumGC":       gauge(memstats.NumGC),
-
-		"OtherSys":     gauge(memstats.OtherSys),
-		"PauseTotalNs": gauge(memstats.PauseTotalNs),
-		"StackInuse":   gauge(memstats.StackInuse),
-
-		"StackSys":   gauge(memstats.StackSys),
-		"Sys":        gauge(memstats.Sys),
-		"TotalAlloc": gauge(memstats.TotalAlloc),
-
-		// Кастомные метрики
-		"PollCount":   counter(pollCount),
-		"RandomValue": gauge(randomValue),
+// Названия метрик изменяются фильтром filter, к gauge метрикам добавляются агрегаты за окно отправки,
+// также отправляются служебные метрики агента(stats).
+func (a *Agent) sendMetrics() {
+	a.metricsMutex.RLock()
+	metrics := a.filter.apply(a.collectMetrics())
+	a.metricsMutex.RUnlock()
+
+	for baseName, values := range a.aggregator.flush() {
+		name, ok := a.filter.rename(baseName)
+		if !ok {
+			continue
+		}
+		for aggregation, value := range values {
+			metrics[name+"_"+aggregation] = value
+		}
 	}
-
-	// goPSUtil метрики
-	metrics["TotalMemory"] = gauge(goPsutilStats.TotalMemory)
-	metrics["FreeMemory"] = gauge(goPsutilStats.FreeMemory)
-	var metricID string
-	for i, cpuUtilStat := range goPsutilStats.CPUutilization {
-		metricID = fmt.Sprintf("CPUutilization%d", i)
-		metrics[metricID] = gauge(cpuUtilStat)
+	selfMetrics := a.stats.report()
+	for selfName, value := range selfMetrics {
+		if name, ok := a.filter.rename(selfName); ok {
+			metrics[name] = value
+		}
+	}
+	if !a.sendMetricsBatchByJSON(metrics) {
+		a.stats.unreported(selfMetrics)
 	}
+}
 
-	sendMetricsBatchByJSON(metrics)
+// collectMetrics возвращает копию текущих значений метрик по исходным названиям.
+// Вызывается при заблокированном metricsMutex.
+func (a *Agent) collectMetrics() map[string]interface{} {
+	metrics := make(map[string]interface{}, len(a.metrics)+1)
+	for name, value := range a.metrics {
+		metrics[name] = value
+	}
+	metrics["PollCount"] = a.pollCount
+	return metrics
 }
 
 // sendMetricByURL отправляет метрику Post запросом, посредством url.
-func sendMetricByURL(paramName string, paramValue interface{}) {
-	client := resty.New()
-	var requestURL string
+func (a *Agent) sendMetricByURL(paramName string, paramValue interface{}) {
+	client := a.newClient()
+	var requestPath string
 	switch value := paramValue.(type) {
-	case gauge:
-		requestURL = fmt.Sprintf("%s/update/%s/%s/%f", serverURL, internal.GaugeTypeName, paramName, value)
-	case counter:
-		requestURL = fmt.Sprintf("%s/update/%s/%s/%d", serverURL, internal.CounterTypeName, paramName, value)
+	case Gauge:
+		requestPath = fmt.Sprintf("/update/%s/%s/%f", internal.GaugeTypeName, paramName, value)
+	case Counter:
+		requestPath = fmt.Sprintf("/update/%s/%s/%d", internal.CounterTypeName, paramName, value)
 	default:
 		log.Printf("unhandled metric type '%T'", value)
+		return
 	}
 
-	_, err := client.R().
-		SetHeader("Content-Type", "text/plain").
-		Post(requestURL)
+	request := client.R().SetHeader("Content-Type", "text/plain")
+	if err := a.signRequest(request, requestPath, nil); err != nil {
+		log.Println(err)
+		return
+	}
+	_, err := request.Post(requestPath)
 	if err != nil {
 		log.Println(err)
 	}
 }
 
 // sendMetricByJSON отправляет метрику Post запросом, в Json формате.
-func sendMetricByJSON(paramName string, paramValue interface{}) {
+func (a *Agent) sendMetricByJSON(paramName string, paramValue interface{}) {
 	var err error
 
-	client := resty.New()
-	client.SetBaseURL(serverURL)
+	client := a.newClient()
 	var msg message.Metrics
 	msg.ID = paramName
 	switch value := paramValue.(type) {
-	case gauge:
+	case Gauge:
 		msg.MType = internal.GaugeTypeName
 		float64Val := float64(value)
 		msg.Value = &float64Val
-	case counter:
+	case Counter:
 		msg.MType = internal.CounterTypeName
 		int64Val := int64(value)
 		msg.Delta = &int64Val
@@ -327,8 +605,8 @@ func sendMetricByJSON(paramName string, paramValue interface{}) {
 		return
 	}
 
-	if Env.Key != "" {
-		err := msg.InitHash(Env.Key)
+	if a.config.Key != "" {
+		err := msg.InitHash(a.config.Key)
 		if err != nil {
 			log.Println(err)
 			return
@@ -336,36 +614,51 @@ func sendMetricByJSON(paramName string, paramValue interface{}) {
 	}
 
 	var bodyContent []byte
-	bodyContent, err = json.Marshal(msg)
+	bodyContent, err = message.Marshal(a.contentType(), msg)
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
+	request := client.R().SetHeader("Content-Type", a.contentType())
+	if err = a.signRequest(request, `/update/`, bodyContent); err != nil {
+		log.Println(err)
+		return
+	}
+	if err = setResponseNonce(request); err != nil {
+		log.Println(err)
+		return
+	}
+
 	// если передан публичный ключ - шифровать сообщение
-	if encoder != nil {
-		bodyContent, err = encoder.Encode(bodyContent)
+	if a.encoder != nil {
+		bodyContent, err = a.encoder.Encode(bodyContent)
 		if err != nil {
 			log.Println(err)
 		}
 	}
 
-	_, err = client.R().
-		SetHeader("Content-Type", "application/json").
+	resp, err := request.
 		SetBody(bodyContent).
 		Post(`/update/`)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	// ответ содержит сохраненное значение метрики, проверяю его подпись
+	if resp.StatusCode() == http.StatusOK {
+		if _, err = a.readResponse(resp); err != nil {
+			log.Println(err)
+		}
+	}
 }
 
 // sendMetricsBatchByJSON отправляет словарь метрик Post запросом, в json формате.
-func sendMetricsBatchByJSON(metrics map[string]interface{}) {
+// Возвращает true, если батч принят сервером.
+func (a *Agent) sendMetricsBatchByJSON(metrics map[string]interface{}) bool {
 	var err error
 
-	client := resty.New()
-	client.SetBaseURL(serverURL)
+	client := a.newClient()
 
 	var metricsToSend []message.Metrics
 	var msg *message.Metrics
@@ -374,24 +667,24 @@ func sendMetricsBatchByJSON(metrics map[string]interface{}) {
 
 		msg.ID = mN
 		switch value := mV.(type) {
-		case gauge:
+		case Gauge:
 			msg.MType = internal.GaugeTypeName
 			float64Val := float64(value)
 			msg.Value = &float64Val
-		case counter:
+		case Counter:
 			msg.MType = internal.CounterTypeName
 			int64Val := int64(value)
 			msg.Delta = &int64Val
 		default:
 			log.Printf("unhandled metric type '%T'", value)
-			return
+			return false
 		}
 
-		if Env.Key != "" {
-			err := msg.InitHash(Env.Key)
+		if a.config.Key != "" {
+			err := msg.InitHash(a.config.Key)
 			if err != nil {
 				log.Println(err)
-				return
+				return false
 			}
 		}
 
@@ -399,33 +692,67 @@ func sendMetricsBatchByJSON(metrics map[string]interface{}) {
 	}
 
 	var bodyContent []byte
-	bodyContent, err = json.Marshal(metricsToSend)
+	bodyContent, err = message.MarshalBatch(a.contentType(), metricsToSend)
 	if err != nil {
 		log.Println(err)
-		return
+		return false
+	}
+
+	request := client.R().SetHeader("Content-Type", a.contentType())
+	if a.config.PartialBatch {
+		request.SetQueryParam("partial", "true")
+	}
+	if err = a.signRequest(request, `/updates/`, bodyContent); err != nil {
+		log.Println(err)
+		return false
 	}
 
 	// если передан публичный ключ - шифровать сообщение
-	if encoder != nil {
-		bodyContent, err = encoder.Encode(bodyContent)
+	if a.encoder != nil {
+		bodyContent, err = a.encoder.Encode(bodyContent)
 		if err != nil {
 			log.Println(err)
 		}
 	}
+	if bodyContent, err = a.compressRequest(request, bodyContent); err != nil {
+		log.Println(err)
+		return false
+	}
 
-	_, err = client.R().
-		SetHeader("Content-Type", "application/json").
+	start := time.Now()
+	resp, err := request.
 		SetBody(bodyContent).
 		Post(`/updates/`)
+	a.stats.observeSend(len(metricsToSend), time.Since(start), err == nil && resp.IsSuccess())
 	if err != nil {
 		log.Println(err)
-		return
+		return false
+	}
+	if !resp.IsSuccess() {
+		log.Printf("metrics batch was not accepted by server: %s", resp.Status())
+		return false
+	}
+	if a.config.PartialBatch && resp.StatusCode() == http.StatusOK {
+		if _, err = logRejectedMetrics(resp.Body()); err != nil {
+			log.Println(err)
+		}
 	}
+	return true
 }
 
-func StopAgent() {
-	// блокируем мьютекс обновления значений метрик
-	updateMetricsMutex.Lock()
-	// закрываем workpool
-	WPool.Close()
+// logRejectedMetrics разбирает ответ /updates/ в режиме PartialBatch и логирует отклоненные сервером метрики.
+// Отклоненные метрики не отправляются повторно. Возвращает результаты по отклоненным метрикам.
+func logRejectedMetrics(body []byte) ([]message.BatchItemResult, error) {
+	var results []message.BatchItemResult
+	if err := json.Unmarshal(body, &results); err != nil {
+		return nil, fmt.Errorf("cannot parse batch results: %w", err)
+	}
+	var rejected []message.BatchItemResult
+	for _, result := range results {
+		if result.Status != http.StatusOK {
+			log.Printf("metric '%s'(%s) rejected by server: %d %s", result.ID, result.MType, result.Status, result.Error)
+			rejected = append(rejected, result)
+		}
+	}
+	return rejected, nil
 }
@@ -3,11 +3,13 @@ package agent
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"runtime"
 	"sort"
 	"strings"
 	"sync"
@@ -18,10 +20,18 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/compression"
+	"github.com/firesworder/devopsmetrics/internal/crypt"
 	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/tlsconfig"
 )
 
-var testEnvVars = []string{"ADDRESS", "REPORT_INTERVAL", "POLL_INTERVAL", "KEY", "RATE_LIMIT", "CRYPTO_KEY", "CONFIG"}
+var testEnvVars = []string{
+	"ADDRESS", "REPORT_INTERVAL", "POLL_INTERVAL", "KEY", "RATE_LIMIT", "CRYPTO_KEY", "CONFIG",
+	"TLS", "TLS_CA", "TLS_CERT", "TLS_KEY", "TOKEN", "AGENT_ID", "CLIENT_KEY", "VERIFY_RESPONSES",
+	"COMPRESSION", "COMPRESS_MIN_SIZE", "FORMAT", "PARTIAL_BATCH",
+	"INCLUDE_METRICS", "EXCLUDE_METRICS", "RENAME_METRICS", "METRIC_PREFIX", "AGGREGATE",
+}
 
 func SaveOSVarsState(testEnvVars []string) map[string]string {
 	osEnvVarsState := map[string]string{}
@@ -46,28 +56,11 @@ func UpdateOSEnvState(t *testing.T, testEnvVars []string, newState map[string]st
 	}
 }
 
-func Test_updateMemStats(t *testing.T) {
-	runtime.ReadMemStats(&memstats)
-	allocMetricBefore := memstats.Alloc
-	pollCountBefore := pollCount
-	randomValueBefore := randomValue
-
-	// нагрузка, чтобы повлиять на значения параметров в runtime.memstats
-	demoSlice := []string{"demo"}
-	for i := 0; i < 100; i++ {
-		demoSlice = append(demoSlice, "demo")
-	}
-
-	err := updateMemStats()
-	require.NoError(t, err)
-	allocMetricAfter := memstats.Alloc
-	pollCountAfter := pollCount
-	randomValueAfter := randomValue
-
-	assert.NotEqual(t, allocMetricBefore, allocMetricAfter, "metric values were not updated")
-	assert.Equal(t, true, pollCountBefore+1 == pollCountAfter,
-		"PollCount was not updated correctly")
-	assert.NotEqual(t, randomValueBefore, randomValueAfter, "RandomValue was not updated")
+// newTestAgent агент для тестов(без NewAgent), отправляющий метрики на serverURL.
+func newTestAgent(config Config, serverURL string) *Agent {
+	a := &Agent{config: config, serverURL: serverURL, stats: newSelfStats(), metrics: map[string]interface{}{}}
+	a.pool.stats = a.stats
+	return a
 }
 
 func TestSendMetricByURL(t *testing.T) {
@@ -82,12 +75,12 @@ func TestSendMetricByURL(t *testing.T) {
 	}{
 		{
 			name:           "Test 1. Gauge metric.",
-			args:           args{paramName: "Alloc", paramValue: gauge(12.133)},
+			args:           args{paramName: "Alloc", paramValue: Gauge(12.133)},
 			wantRequestURL: "/update/gauge/Alloc/12.133000",
 		},
 		{
 			name:           "Test 2. Counter metric.",
-			args:           args{paramName: "PollCount", paramValue: counter(10)},
+			args:           args{paramName: "PollCount", paramValue: Counter(10)},
 			wantRequestURL: "/update/counter/PollCount/10",
 		},
 		{
@@ -108,8 +101,7 @@ func TestSendMetricByURL(t *testing.T) {
 				actualRequestURL = r.URL.Path
 			}))
 			defer svr.Close()
-			serverURL = svr.URL
-			sendMetricByURL(tt.args.paramName, tt.args.paramValue)
+			newTestAgent(Config{}, svr.URL).sendMetricByURL(tt.args.paramName, tt.args.paramValue)
 			assert.Equal(t, tt.wantRequestURL, actualRequestURL)
 		})
 	}
@@ -118,13 +110,6 @@ func TestSendMetricByURL(t *testing.T) {
 func TestSendMetricByJson(t *testing.T) {
 	int64Value, float64Value := int64(10), float64(12.133)
 
-	// отключаю шифрование в тесте, т.к. эта функция(шифрования\дешифрования) проверяется отдельно
-	envCK := Env.PublicCryptoKeyFp
-	Env.PublicCryptoKeyFp = ""
-	defer func() {
-		Env.PublicCryptoKeyFp = envCK
-	}()
-
 	type args struct {
 		paramValue interface{}
 		paramName  string
@@ -142,7 +127,7 @@ func TestSendMetricByJson(t *testing.T) {
 	}{
 		{
 			name:   "Test 1. Gauge metric.",
-			args:   args{paramName: "RandomValue", paramValue: gauge(12.133)},
+			args:   args{paramName: "RandomValue", paramValue: Gauge(12.133)},
 			envKey: "Ayayaka",
 			wantRequest: &wantRequest{
 				contentType: "application/json",
@@ -157,7 +142,7 @@ func TestSendMetricByJson(t *testing.T) {
 		},
 		{
 			name:   "Test 2. Counter metric.",
-			args:   args{paramName: "PollCount", paramValue: counter(10)},
+			args:   args{paramName: "PollCount", paramValue: Counter(10)},
 			envKey: "Ayayaka",
 			wantRequest: &wantRequest{
 				contentType: "application/json",
@@ -184,7 +169,7 @@ func TestSendMetricByJson(t *testing.T) {
 		},
 		{
 			name:   "Test 5. Gauge metric. Key(env) is not set",
-			args:   args{paramName: "RandomValue", paramValue: gauge(12.133)},
+			args:   args{paramName: "RandomValue", paramValue: Gauge(12.133)},
 			envKey: "",
 			wantRequest: &wantRequest{
 				contentType: "application/json",
@@ -199,7 +184,7 @@ func TestSendMetricByJson(t *testing.T) {
 		},
 		{
 			name:   "Test 6. Counter metric. Key(env) is not set",
-			args:   args{paramName: "PollCount", paramValue: counter(10)},
+			args:   args{paramName: "PollCount", paramValue: Counter(10)},
 			envKey: "",
 			wantRequest: &wantRequest{
 				contentType: "application/json",
@@ -226,9 +211,7 @@ func TestSendMetricByJson(t *testing.T) {
 				gotRequest.msg = &msg
 			}))
 			defer svr.Close()
-			Env.Key = tt.envKey
-			serverURL = svr.URL
-			sendMetricByJSON(tt.args.paramName, tt.args.paramValue)
+			newTestAgent(Config{Key: tt.envKey}, svr.URL).sendMetricByJSON(tt.args.paramName, tt.args.paramValue)
 			require.Equal(t, tt.wantRequest, gotRequest)
 		})
 	}
@@ -243,38 +226,37 @@ func TestSendMetrics(t *testing.T) {
 		gotMetricsReq = append(gotMetricsReq, r.URL.Path)
 	}))
 	defer svr.Close()
-	serverURL = svr.URL
-	sendMetrics()
+	newTestAgent(Config{}, svr.URL).sendMetrics()
 	assert.Lenf(t, gotMetricsReq, metricsCount, "Expected %d requests, got %d", metricsCount, len(gotMetricsReq))
 }
 
-func TestParseEnvArgs(t *testing.T) {
+func TestParseConfig(t *testing.T) {
 	savedState := SaveOSVarsState(testEnvVars)
 
 	tests := []struct {
-		name      string
-		cmdStr    string
-		envVars   map[string]string
-		wantEnv   environment
-		wantPanic bool
+		name       string
+		cmdStr     string
+		envVars    map[string]string
+		wantConfig Config
+		wantErr    bool
 	}{
 		{
 			name:    "Test correct 1. Empty cmd args and env vars.",
 			cmdStr:  "file.exe",
 			envVars: map[string]string{},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress: "localhost:8080", PollInterval: 2 * time.Second, ReportInterval: 10 * time.Second,
+				Compression: "gzip", CompressMinSize: 1024, Format: "json",
 			},
-			wantPanic: false,
 		},
 		{
 			name:    "Test correct 2. Set cmd args and empty env vars.",
 			cmdStr:  "file.exe --a=localhost:3030 -r=15s -p=3s",
 			envVars: map[string]string{},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress: "localhost:3030", PollInterval: 3 * time.Second, ReportInterval: 15 * time.Second,
+				Compression: "gzip", CompressMinSize: 1024, Format: "json",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test correct 3. Empty cmd args and set env vars.",
@@ -282,10 +264,10 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"ADDRESS": "localhost:3030", "REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress: "localhost:3030", PollInterval: 5 * time.Second, ReportInterval: 20 * time.Second,
+				Compression: "gzip", CompressMinSize: 1024, Format: "json",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test correct 4. Set cmd args and set env vars.",
@@ -293,10 +275,10 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"ADDRESS": "env.site", "REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress: "env.site", PollInterval: 5 * time.Second, ReportInterval: 20 * time.Second,
+				Compression: "gzip", CompressMinSize: 1024, Format: "json",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test correct 5. Partially set cmd args and set env vars. Field ADDRESS",
@@ -304,10 +286,10 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"ADDRESS": "env.site", "REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress: "env.site", PollInterval: 5 * time.Second, ReportInterval: 20 * time.Second,
+				Compression: "gzip", CompressMinSize: 1024, Format: "json",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test correct 6. Set cmd args and partially set env vars. Field ADDRESS",
@@ -315,10 +297,10 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress: "cmd.site", PollInterval: 5 * time.Second, ReportInterval: 20 * time.Second,
+				Compression: "gzip", CompressMinSize: 1024, Format: "json",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 7. Field key, cmd",
@@ -326,13 +308,15 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
-				ServerAddress:  "cmd.site",
-				PollInterval:   5 * time.Second,
-				ReportInterval: 20 * time.Second,
-				Key:            "ad123a",
+			wantConfig: Config{
+				ServerAddress:   "cmd.site",
+				PollInterval:    5 * time.Second,
+				ReportInterval:  20 * time.Second,
+				Compression:     "gzip",
+				CompressMinSize: 1024,
+				Format:          "json",
+				Key:             "ad123a",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 8. Field key, env",
@@ -340,13 +324,15 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s", "KEY": "ad123b",
 			},
-			wantEnv: environment{
-				ServerAddress:  "cmd.site",
-				PollInterval:   5 * time.Second,
-				ReportInterval: 20 * time.Second,
-				Key:            "ad123b",
+			wantConfig: Config{
+				ServerAddress:   "cmd.site",
+				PollInterval:    5 * time.Second,
+				ReportInterval:  20 * time.Second,
+				Compression:     "gzip",
+				CompressMinSize: 1024,
+				Format:          "json",
+				Key:             "ad123b",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 9. Field key, not set",
@@ -354,13 +340,15 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
-				ServerAddress:  "cmd.site",
-				PollInterval:   5 * time.Second,
-				ReportInterval: 20 * time.Second,
-				Key:            "",
+			wantConfig: Config{
+				ServerAddress:   "cmd.site",
+				PollInterval:    5 * time.Second,
+				ReportInterval:  20 * time.Second,
+				Compression:     "gzip",
+				CompressMinSize: 1024,
+				Format:          "json",
+				Key:             "",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 10. Field 'RateLimit', cmd",
@@ -368,14 +356,16 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
-				ServerAddress:  "cmd.site",
-				PollInterval:   5 * time.Second,
-				ReportInterval: 20 * time.Second,
-				Key:            "",
-				RateLimit:      2,
+			wantConfig: Config{
+				ServerAddress:   "cmd.site",
+				PollInterval:    5 * time.Second,
+				ReportInterval:  20 * time.Second,
+				Compression:     "gzip",
+				CompressMinSize: 1024,
+				Format:          "json",
+				Key:             "",
+				RateLimit:       2,
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 11. Field 'RateLimit', env",
@@ -383,14 +373,16 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s", "RATE_LIMIT": "3",
 			},
-			wantEnv: environment{
-				ServerAddress:  "cmd.site",
-				PollInterval:   5 * time.Second,
-				ReportInterval: 20 * time.Second,
-				Key:            "",
-				RateLimit:      3,
+			wantConfig: Config{
+				ServerAddress:   "cmd.site",
+				PollInterval:    5 * time.Second,
+				ReportInterval:  20 * time.Second,
+				Compression:     "gzip",
+				CompressMinSize: 1024,
+				Format:          "json",
+				Key:             "",
+				RateLimit:       3,
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 12. Field 'RateLimit', not set",
@@ -398,14 +390,16 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
-				ServerAddress:  "cmd.site",
-				PollInterval:   5 * time.Second,
-				ReportInterval: 20 * time.Second,
-				Key:            "",
-				RateLimit:      0,
+			wantConfig: Config{
+				ServerAddress:   "cmd.site",
+				PollInterval:    5 * time.Second,
+				ReportInterval:  20 * time.Second,
+				Compression:     "gzip",
+				CompressMinSize: 1024,
+				Format:          "json",
+				Key:             "",
+				RateLimit:       0,
 			},
-			wantPanic: false,
 		},
 
 		{
@@ -414,15 +408,17 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress:     "cmd.site",
 				PollInterval:      5 * time.Second,
 				ReportInterval:    20 * time.Second,
+				Compression:       "gzip",
+				CompressMinSize:   1024,
+				Format:            "json",
 				Key:               "",
 				PublicCryptoKeyFp: "C:/tmp/cert.pem",
 				RateLimit:         2,
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 14. Field 'PublicCryptoKeyFp', env",
@@ -430,15 +426,17 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s", "RATE_LIMIT": "3", "CRYPTO_KEY": "C:/tmp/cert2.pem",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress:     "cmd.site",
 				PollInterval:      5 * time.Second,
 				ReportInterval:    20 * time.Second,
+				Compression:       "gzip",
+				CompressMinSize:   1024,
+				Format:            "json",
 				Key:               "",
 				PublicCryptoKeyFp: "C:/tmp/cert2.pem",
 				RateLimit:         3,
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 15. Field 'PublicCryptoKeyFp', not set",
@@ -446,15 +444,17 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress:     "cmd.site",
 				PollInterval:      5 * time.Second,
 				ReportInterval:    20 * time.Second,
+				Compression:       "gzip",
+				CompressMinSize:   1024,
+				Format:            "json",
 				Key:               "",
 				RateLimit:         0,
 				PublicCryptoKeyFp: "",
 			},
-			wantPanic: false,
 		},
 
 		// поле ConfigFilepath
@@ -464,16 +464,18 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress:     "cmd.site",
 				PollInterval:      5 * time.Second,
 				ReportInterval:    20 * time.Second,
+				Compression:       "gzip",
+				CompressMinSize:   1024,
+				Format:            "json",
 				Key:               "",
 				RateLimit:         0,
 				PublicCryptoKeyFp: "/path/to/key.pem",
 				ConfigFilepath:    "env_config_test.json",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 17. Field 'ConfigFilepath', set by cmd key 'config'. File exist.",
@@ -481,16 +483,18 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress:     "cmd.site",
 				PollInterval:      5 * time.Second,
 				ReportInterval:    20 * time.Second,
+				Compression:       "gzip",
+				CompressMinSize:   1024,
+				Format:            "json",
 				Key:               "",
 				RateLimit:         0,
 				PublicCryptoKeyFp: "/path/to/key.pem",
 				ConfigFilepath:    "env_config_test.json",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 18. Field 'ConfigFilepath', set by env var 'CONFIG'. File exist.",
@@ -498,16 +502,18 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s", "CONFIG": "env_config_test.json",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress:     "cmd.site",
 				PollInterval:      5 * time.Second,
 				ReportInterval:    20 * time.Second,
+				Compression:       "gzip",
+				CompressMinSize:   1024,
+				Format:            "json",
 				Key:               "",
 				RateLimit:         0,
 				PublicCryptoKeyFp: "/path/to/key.pem",
 				ConfigFilepath:    "env_config_test.json",
 			},
-			wantPanic: false,
 		},
 		{
 			name:   "Test 19. Field 'ConfigFilepath', set by env var 'CONFIG'. File not exist.",
@@ -515,41 +521,103 @@ func TestParseEnvArgs(t *testing.T) {
 			envVars: map[string]string{
 				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s", "CONFIG": "not_existed_config.json",
 			},
-			wantEnv: environment{
+			wantConfig: Config{
 				ServerAddress:     "cmd.site",
 				PollInterval:      5 * time.Second,
 				ReportInterval:    20 * time.Second,
+				Compression:       "gzip",
+				CompressMinSize:   1024,
+				Format:            "json",
 				Key:               "",
 				RateLimit:         0,
 				PublicCryptoKeyFp: "",
 				ConfigFilepath:    "not_existed_config.json",
 			},
-			wantPanic: true,
+			wantErr: true,
+		},
+		{
+			name:   "Test 20. Fields 'Compression' and 'CompressMinSize', env.",
+			cmdStr: "file.exe --compression=gzip",
+			envVars: map[string]string{
+				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s", "COMPRESSION": "zstd", "COMPRESS_MIN_SIZE": "0",
+			},
+			wantConfig: Config{
+				ServerAddress:   "localhost:8080",
+				PollInterval:    5 * time.Second,
+				ReportInterval:  20 * time.Second,
+				Compression:     "zstd",
+				CompressMinSize: 0,
+				Format:          "json",
+			},
+		},
+		{
+			name:   "Test 21. Field 'Format', env.",
+			cmdStr: "file.exe --format=msgpack",
+			envVars: map[string]string{
+				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s", "FORMAT": "protobuf",
+			},
+			wantConfig: Config{
+				ServerAddress:   "localhost:8080",
+				PollInterval:    5 * time.Second,
+				ReportInterval:  20 * time.Second,
+				Compression:     "gzip",
+				CompressMinSize: 1024,
+				Format:          "protobuf",
+			},
+		},
+		{
+			name:   "Test 22. Field 'Format', unsupported format.",
+			cmdStr: "file.exe --format=xml",
+			envVars: map[string]string{
+				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
+			},
+			wantErr: true,
+		},
+		{
+			name:   "Test 23. Field 'Compression', unsupported codec.",
+			cmdStr: "file.exe --compression=br",
+			envVars: map[string]string{
+				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s",
+			},
+			wantErr: true,
+		},
+		{
+			name:   "Test 24. Fields 'IncludeMetrics', 'ExcludeMetrics', 'RenameMetrics', 'MetricPrefix', 'Aggregate'.",
+			cmdStr: "file.exe --include=CPU*,Alloc --rename=Alloc=mem_alloc --prefix=cmd.",
+			envVars: map[string]string{
+				"REPORT_INTERVAL": "20s", "POLL_INTERVAL": "5s", "EXCLUDE_METRICS": "CPUutilization0",
+				"METRIC_PREFIX": "web1.", "AGGREGATE": "CPU*=max,avg",
+			},
+			wantConfig: Config{
+				ServerAddress:   "localhost:8080",
+				PollInterval:    5 * time.Second,
+				ReportInterval:  20 * time.Second,
+				Compression:     "gzip",
+				CompressMinSize: 1024,
+				Format:          "json",
+				IncludeMetrics:  "CPU*,Alloc",
+				ExcludeMetrics:  "CPUutilization0",
+				RenameMetrics:   "Alloc=mem_alloc",
+				MetricPrefix:    "web1.",
+				Aggregate:       "CPU*=max,avg",
+			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Устанавливаю env в дефолтные значения(обнулять я его не могу, т.к. flag линки потеряются)
-			Env.ServerAddress = "localhost:8080"
-			Env.ReportInterval = 10 * time.Second
-			Env.PollInterval = 2 * time.Second
-			Env.Key = ""
-			Env.RateLimit = 0
-			Env.PublicCryptoKeyFp = ""
-
 			UpdateOSEnvState(t, testEnvVars, tt.envVars)
 			// устанавливаю os.Args как эмулятор вызванной команды
 			os.Args = strings.Split(tt.cmdStr, " ")
 			flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.PanicOnError)
-			InitCmdArgs()
 
 			// сама проверка корректности парсинга\получения ошибок
-			if tt.wantPanic {
-				assert.Panics(t, ParseEnvArgs)
-			} else {
-				ParseEnvArgs()
-				assert.Equal(t, tt.wantEnv, Env)
+			config, err := ParseConfig()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
 			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantConfig, config)
 		})
 	}
 	UpdateOSEnvState(t, testEnvVars, savedState)
@@ -558,13 +626,6 @@ func TestParseEnvArgs(t *testing.T) {
 func Test_sendMetricsBatchByJSON(t *testing.T) {
 	int64Value, float64Value := int64(10), float64(2.27)
 
-	// отключаю шифрование в тесте, т.к. эта функция(шифрования\дешифрования) проверяется отдельно
-	envCK := Env.PublicCryptoKeyFp
-	Env.PublicCryptoKeyFp = ""
-	defer func() {
-		Env.PublicCryptoKeyFp = envCK
-	}()
-
 	envKey := "Ayaka"
 	type request struct {
 		contentType string
@@ -591,8 +652,8 @@ func Test_sendMetricsBatchByJSON(t *testing.T) {
 		},
 	}
 	args := map[string]interface{}{
-		"PollCount":   counter(10),
-		"RandomValue": gauge(2.27),
+		"PollCount":   Counter(10),
+		"RandomValue": Gauge(2.27),
 	}
 
 	var gotRequest request
@@ -604,68 +665,475 @@ func Test_sendMetricsBatchByJSON(t *testing.T) {
 		require.NoError(t, err, "cannot decode request body")
 	}))
 	defer svr.Close()
-	Env.Key = envKey
-	serverURL = svr.URL
-	sendMetricsBatchByJSON(args)
+	newTestAgent(Config{Key: envKey}, svr.URL).sendMetricsBatchByJSON(args)
 	sort.Slice(gotRequest.msgBatch, func(i, j int) bool {
 		return gotRequest.msgBatch[i].ID < gotRequest.msgBatch[j].ID
 	})
 	assert.Equal(t, wantRequest, gotRequest)
 }
 
-func Test_updateGoPsutilStats(t *testing.T) {
+func Test_compressRequest(t *testing.T) {
+	var gotEncoding string
+	var gotBatch []message.Metrics
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncoding = r.Header.Get("Content-Encoding")
+		body := r.Body
+		if gotEncoding != "" {
+			var err error
+			body, err = compression.NewReader(gotEncoding, r.Body)
+			require.NoError(t, err)
+		}
+		gotBatch = nil
+		require.NoError(t, json.NewDecoder(body).Decode(&gotBatch))
+	}))
+	defer svr.Close()
+	a := newTestAgent(Config{}, svr.URL)
+
+	metrics := map[string]interface{}{}
+	for i := 0; i < 40; i++ {
+		metrics[fmt.Sprintf("Gauge%d", i)] = Gauge(i)
+	}
+	encryptedEncoder, err := crypt.NewEncoder("../crypt/test/publicKey_1_test.pem")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		compression  string
+		minSize      int
+		encoder      *crypt.Encoder
+		metrics      map[string]interface{}
+		wantEncoding string
+	}{
+		{name: "Test 1. Gzip.", compression: compression.Gzip, minSize: 256, metrics: metrics, wantEncoding: "gzip"},
+		{name: "Test 2. Zstd.", compression: compression.Zstd, minSize: 256, metrics: metrics, wantEncoding: "zstd"},
+		{
+			name:        "Test 3. Body is less than min size.",
+			compression: compression.Gzip,
+			minSize:     1024,
+			metrics:     map[string]interface{}{"PollCount": Counter(10)},
+		},
+		{name: "Test 4. Compression is disabled.", compression: "", metrics: metrics},
+		{
+			name:        "Test 5. Encrypted body is not compressed.",
+			compression: compression.Gzip,
+			encoder:     encryptedEncoder,
+			metrics:     map[string]interface{}{"PollCount": Counter(10)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.config.Compression, a.config.CompressMinSize, a.encoder = tt.compression, tt.minSize, tt.encoder
+			gotEncoding, gotBatch = "", nil
+			request := a.newClient().R()
+			body, err := json.Marshal(tt.metrics)
+			require.NoError(t, err)
+			_, err = a.compressRequest(request, body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantEncoding, request.Header.Get("Content-Encoding"))
+
+			if tt.encoder == nil {
+				a.sendMetricsBatchByJSON(tt.metrics)
+				assert.Equal(t, tt.wantEncoding, gotEncoding)
+				assert.Len(t, gotBatch, len(tt.metrics))
+			}
+		})
+	}
+}
+
+func Test_sendMetricsFormat(t *testing.T) {
+	var gotContentType string
+	var gotBatch []message.Metrics
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotContentType = r.Header.Get("Content-Type")
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		switch r.URL.Path {
+		case "/updates/":
+			gotBatch, err = message.UnmarshalBatch(gotContentType, body)
+			require.NoError(t, err)
+		case "/update/":
+			var m message.Metrics
+			require.NoError(t, message.Unmarshal(gotContentType, body, &m))
+			gotBatch = []message.Metrics{m}
+		case "/value/":
+			var m message.Metrics
+			require.NoError(t, message.Unmarshal(gotContentType, body, &m))
+			delta := int64(10)
+			m.Delta = &delta
+			response, err := message.Marshal(r.Header.Get("Accept"), m)
+			require.NoError(t, err)
+			w.Header().Set("Content-Type", r.Header.Get("Accept"))
+			w.Write(response)
+		}
+	}))
+	defer svr.Close()
+	a := newTestAgent(Config{}, svr.URL)
+
+	delta := int64(10)
+	wantBatch := []message.Metrics{{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta}}
+	tests := []struct {
+		format          string
+		wantContentType string
+	}{
+		{format: "json", wantContentType: message.ContentTypeJSON},
+		{format: "protobuf", wantContentType: message.ContentTypeProtobuf},
+		{format: "msgpack", wantContentType: message.ContentTypeMsgpack},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			a.config.Format = tt.format
+
+			gotContentType, gotBatch = "", nil
+			a.sendMetricsBatchByJSON(map[string]interface{}{"PollCount": Counter(10)})
+			assert.Equal(t, tt.wantContentType, gotContentType)
+			assert.Equal(t, wantBatch, gotBatch)
+
+			gotContentType, gotBatch = "", nil
+			a.sendMetricByJSON("PollCount", Counter(10))
+			assert.Equal(t, tt.wantContentType, gotContentType)
+			assert.Equal(t, wantBatch, gotBatch)
+
+			got, err := a.GetMetric("PollCount", internal.CounterTypeName)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantContentType, gotContentType)
+			assert.Equal(t, wantBatch[0], *got)
+		})
+	}
+}
+
+func Test_logRejectedMetrics(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantRejected []message.BatchItemResult
+		wantErr      bool
+	}{
+		{
+			name: "Test 1. One metric is rejected.",
+			body: `[{"id":"PollCount","type":"counter","status":200,"metric":{"id":"PollCount","type":"counter","delta":10}},` +
+				`{"id":"Unknown","type":"unknownType","status":501,"error":"unhandled value type"}]`,
+			wantRejected: []message.BatchItemResult{
+				{ID: "Unknown", MType: "unknownType", Status: http.StatusNotImplemented, Error: "unhandled value type"},
+			},
+		},
+		{
+			name: "Test 2. All metrics are applied.",
+			body: `[{"id":"PollCount","type":"counter","status":200}]`,
+		},
+		{
+			name: "Test 3. Empty results.",
+			body: `[]`,
+		},
+		{
+			name:    "Test 4. Incorrect body.",
+			body:    `not json`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := logRejectedMetrics([]byte(tt.body))
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantRejected, got)
+		})
+	}
+}
+
+func Test_sendMetricsBatchByJSONPartial(t *testing.T) {
+	var gotPartial string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPartial = r.URL.Query().Get("partial")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"id":"PollCount","type":"counter","status":400,"error":"hash is not correct"}]`))
+	}))
+	defer svr.Close()
+	a := newTestAgent(Config{Format: "json"}, svr.URL)
+
+	for _, partial := range []bool{true, false} {
+		a.config.PartialBatch = partial
+		gotPartial = ""
+		a.sendMetricsBatchByJSON(map[string]interface{}{"PollCount": Counter(10)})
+		assert.Equal(t, partial, gotPartial == "true")
+	}
+}
+
+func Test_getOutboundIP(t *testing.T) {
+	ip, err := getOutboundIP("127.0.0.1:8080")
+	require.NoError(t, err)
+	assert.Equal(t, "127.0.0.1", ip)
+
+	_, err = getOutboundIP("incorrect address")
+	assert.Error(t, err)
+}
+
+func Test_newClient(t *testing.T) {
+	var gotRealIP, gotAuthorization, gotKeyID string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotRealIP = r.Header.Get("X-Real-IP")
+		gotAuthorization = r.Header.Get("Authorization")
+		gotKeyID = r.Header.Get(crypt.KeyIDHeader)
+	}))
+	defer svr.Close()
+	a := newTestAgent(Config{}, svr.URL)
+
+	tests := []struct {
+		agentIP           string
+		token             string
+		wantAuthorization string
+	}{
+		{agentIP: "192.168.1.15", token: "agent-token", wantAuthorization: "Bearer agent-token"},
+		{agentIP: "", token: "", wantAuthorization: ""},
+	}
+	for _, tt := range tests {
+		a.agentIP, a.config.Token = tt.agentIP, tt.token
+		a.sendMetricsBatchByJSON(map[string]interface{}{"PollCount": Counter(10)})
+		assert.Equal(t, tt.agentIP, gotRealIP)
+		assert.Equal(t, tt.wantAuthorization, gotAuthorization)
+		assert.Equal(t, "", gotKeyID)
+	}
+
+	// при шифровании передается идентификатор ключа
 	var err error
-	err = updateGoPsutilStats()
+	a.encoder, err = crypt.NewEncoder("../crypt/test/publicKey_1_test.pem")
 	require.NoError(t, err)
-	// before
-	tmB, fmB, cpuUB := goPsutilStats.TotalMemory, goPsutilStats.FreeMemory, goPsutilStats.CPUutilization
+	a.sendMetricsBatchByJSON(map[string]interface{}{"PollCount": Counter(10)})
+	assert.Equal(t, a.encoder.KeyID(), gotKeyID)
+}
+
+func Test_signRequest(t *testing.T) {
+	type gotRequest struct {
+		signed    bool
+		agentID   string
+		signValid bool
+	}
+	var got gotRequest
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = gotRequest{}
+		signature, err := message.BatchSignatureFromHeader(r.Header)
+		if errors.Is(err, message.ErrNoBatchSignature) {
+			return
+		}
+		require.NoError(t, err)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		got.signed, got.agentID = true, signature.AgentID
+		got.signValid, err = signature.Check("Ayayaka", r.Method, message.SignatureTarget(r.URL.Path, r.URL.RawQuery),
+			r.Header, body)
+		require.NoError(t, err)
+	}))
+	defer svr.Close()
+	a := newTestAgent(Config{}, svr.URL)
 
-	// нагрузка, чтобы повлиять на значения параметров
-	demoSlice := []string{"demo"}
-	for i := 0; i < 100; i++ {
-		demoSlice = append(demoSlice, "demo")
+	sendFuncs := map[string]func(){
+		"batch": func() { a.sendMetricsBatchByJSON(map[string]interface{}{"PollCount": Counter(10)}) },
+		"json":  func() { a.sendMetricByJSON("PollCount", Counter(10)) },
+		"url":   func() { a.sendMetricByURL("PollCount", Counter(10)) },
+		"partial batch": func() {
+			a.config.PartialBatch = true
+			defer func() { a.config.PartialBatch = false }()
+			a.sendMetricsBatchByJSON(map[string]interface{}{"PollCount": Counter(10)})
+		},
+	}
+	tests := []struct {
+		name    string
+		key     string
+		agentID string
+		want    gotRequest
+	}{
+		{
+			name:    "Test 1. Key is set, requests are signed.",
+			key:     "Ayayaka",
+			agentID: "agent1",
+			want:    gotRequest{signed: true, agentID: "agent1", signValid: true},
+		},
+		{
+			name: "Test 2. Key is not set, requests are not signed.",
+			key:  "",
+			want: gotRequest{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.config.Key, a.config.AgentID = tt.key, tt.agentID
+			for sendName, send := range sendFuncs {
+				send()
+				assert.Equal(t, tt.want, got, sendName)
+			}
+		})
+	}
+}
+
+func TestAgent_initTLS(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        Config
+		wantServerURL string
+		wantErr       bool
+	}{
+		{
+			name:          "Test 1. TLS is not set.",
+			config:        Config{ServerAddress: "localhost:8080"},
+			wantServerURL: "http://localhost:8080",
+		},
+		{
+			name:          "Test 2. TLS with system CAs.",
+			config:        Config{ServerAddress: "localhost:8080", TLS: true},
+			wantServerURL: "https://localhost:8080",
+		},
+		{
+			name: "Test 3. Pinned CA and client cert, TLS is implied.",
+			config: Config{
+				ServerAddress: "localhost:8080", TLSCAFp: "../tlsconfig/test/ca_test.pem",
+				TLSCertFp: "../tlsconfig/test/client_test.pem", TLSKeyFp: "../tlsconfig/test/client_key_test.pem",
+			},
+			wantServerURL: "https://localhost:8080",
+		},
+		{
+			name:    "Test 4. CA file is not exist.",
+			config:  Config{ServerAddress: "localhost:8080", TLSCAFp: "../tlsconfig/test/not_exist.pem"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &Agent{config: tt.config}
+			err := a.initTLS()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			a.initServerURL()
+			assert.Equal(t, tt.wantServerURL, a.serverURL)
+		})
 	}
+}
 
-	err = updateGoPsutilStats()
+func Test_sendMetricsBatchByJSONOverMutualTLS(t *testing.T) {
+	serverTLSConfig, err := tlsconfig.NewServerConfig(
+		"../tlsconfig/test/server_test.pem", "../tlsconfig/test/server_key_test.pem", "../tlsconfig/test/ca_test.pem")
 	require.NoError(t, err)
-	// after
-	tmA, fmA, cpuUA := goPsutilStats.TotalMemory, goPsutilStats.FreeMemory, goPsutilStats.CPUutilization
-	assert.Equal(t, tmB, tmA, "total memory stat differs")
-	assert.NotEqual(t, fmB, fmA, "free memory has not changed")
-	assert.NotEqual(t, cpuUB, cpuUA, "cpu utils stats have not changed")
+	var gotBatch []message.Metrics
+	svr := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&gotBatch))
+	}))
+	svr.TLS = serverTLSConfig
+	svr.StartTLS()
+	defer svr.Close()
+
+	a, err := NewAgent(Config{
+		ServerAddress:  strings.TrimPrefix(svr.URL, "https://"),
+		PollInterval:   time.Second,
+		ReportInterval: time.Second,
+		TLSCAFp:        "../tlsconfig/test/ca_test.pem",
+		TLSCertFp:      "../tlsconfig/test/client_test.pem",
+		TLSKeyFp:       "../tlsconfig/test/client_key_test.pem",
+	})
+	require.NoError(t, err)
+
+	a.sendMetricsBatchByJSON(map[string]interface{}{"PollCount": Counter(10)})
+	require.Len(t, gotBatch, 1)
+	assert.Equal(t, "PollCount", gotBatch[0].ID)
+}
+
+// testCollector коллектор для тестов, возвращает metrics и err.
+type testCollector struct {
+	name    string
+	metrics map[string]interface{}
+	err     error
+}
+
+func (c testCollector) Name() string {
+	return c.name
 }
 
-func TestUpdateMetrics(t *testing.T) {
-	// взять текущие значения метрик
-	testUMWG = &sync.WaitGroup{}
-	testUMWG.Add(1)
-	go UpdateMetrics()
-	testUMWG.Wait()
-	memstatsBefore, goPsutilStatsBefore := memstats, goPsutilStats
-
-	// нагрузка, чтобы повлиять на значения параметров
-	demoSlice := []string{"demo"}
-	for i := 0; i < 100; i++ {
-		demoSlice = append(demoSlice, "demo")
-	}
-
-	// получить значения метрик после создания нагрузки
-	testUMWG.Add(1)
-	go UpdateMetrics()
-	testUMWG.Wait()
-	memstatsNow, goPsutilStatsNow := memstats, goPsutilStats
-
-	// сравнение значений
-	assert.NotEqual(t, memstatsNow, memstatsBefore)
-	assert.NotEqual(t, goPsutilStatsNow, goPsutilStatsBefore)
-	testUMWG = nil
+func (c testCollector) Collect(ctx context.Context) (map[string]interface{}, error) {
+	return c.metrics, c.err
+}
+
+func TestNewAgent(t *testing.T) {
+	intervals := Config{PollInterval: time.Second, ReportInterval: time.Second}
+	withIntervals := func(config Config) Config {
+		config.PollInterval, config.ReportInterval = intervals.PollInterval, intervals.ReportInterval
+		return config
+	}
+	httpClient := &http.Client{}
+	collector := testCollector{name: "test"}
+
+	tests := []struct {
+		name    string
+		config  Config
+		options []Option
+		wantErr bool
+	}{
+		{name: "Test 1. Default collectors.", config: withIntervals(Config{ServerAddress: "localhost:8080"})},
+		{
+			name:    "Test 2. Options.",
+			config:  withIntervals(Config{ServerAddress: "localhost:8080", MetricPrefix: "web1.", Aggregate: "*"}),
+			options: []Option{WithHTTPClient(httpClient), WithCollectors(collector)},
+		},
+		{name: "Test 3. Intervals are not set.", config: Config{ServerAddress: "localhost:8080"}, wantErr: true},
+		{name: "Test 4. Crypto key is not exist.", config: withIntervals(Config{PublicCryptoKeyFp: "not_exist.pem"}), wantErr: true},
+		{name: "Test 5. TLS CA is not exist.", config: withIntervals(Config{TLSCAFp: "not_exist.pem"}), wantErr: true},
+		{name: "Test 6. Incorrect rename.", config: withIntervals(Config{RenameMetrics: "Alloc"}), wantErr: true},
+		{name: "Test 7. Incorrect aggregate.", config: withIntervals(Config{Aggregate: "*=median"}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := NewAgent(tt.config, tt.options...)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "http://"+tt.config.ServerAddress, a.serverURL)
+			assert.Equal(t, tt.config.MetricPrefix != "", a.filter != nil)
+			assert.Equal(t, tt.config.Aggregate != "", a.aggregator != nil)
+			if len(tt.options) == 0 {
+				assert.Equal(t, DefaultCollectors(), a.collectors)
+				assert.Nil(t, a.httpClient)
+			} else {
+				assert.Equal(t, []Collector{collector}, a.collectors)
+				assert.Same(t, httpClient, a.httpClient)
+			}
+		})
+	}
+}
+
+func TestAgent_updateMetrics(t *testing.T) {
+	a := newTestAgent(Config{}, "")
+	require.NoError(t, a.initAggregator())
+	a.collectors = []Collector{
+		testCollector{name: "first", metrics: map[string]interface{}{"Alloc": Gauge(1)}},
+		testCollector{name: "second", metrics: map[string]interface{}{"Requests": Counter(5)}},
+	}
+
+	a.updateMetrics(context.Background())
+	a.updateMetrics(context.Background())
+	assert.Equal(t, map[string]interface{}{
+		"Alloc": Gauge(1), "Requests": Counter(5), "PollCount": Counter(2),
+	}, a.collectMetrics())
+	stats := a.stats.report()
+	assert.Contains(t, stats, "AgentCollectDurationfirst")
+	assert.Contains(t, stats, "AgentCollectDurationsecond")
+
+	// значения коллекторов без ошибки обновляются, но сбор не учитывается
+	collectErr := errors.New("collect error")
+	a.collectors = []Collector{
+		testCollector{name: "first", metrics: map[string]interface{}{"Alloc": Gauge(2)}},
+		testCollector{name: "second", err: collectErr},
+	}
+	a.updateMetrics(context.Background())
+	assert.Equal(t, map[string]interface{}{
+		"Alloc": Gauge(2), "Requests": Counter(5), "PollCount": Counter(2),
+	}, a.collectMetrics())
 }
 
 func TestInitWorkPool(t *testing.T) {
-	Env.RateLimit = 15
-	wp := workPool{}
-	require.NotPanics(t, wp.Start)
+	wp := workPool{stats: newSelfStats()}
+	require.NotPanics(t, func() { wp.Start(15, func() {}) })
 	assert.NotEqual(t, wp.ch, nil)
+	assert.Equal(t, 15, wp.workersCount)
 	wp.Close()
 }
 
@@ -673,9 +1141,6 @@ func TestInitWorkPool(t *testing.T) {
 func TestCreateSendMetricsJob(t *testing.T) {
 	// данные для теста
 	gotRequestCountCh := make(chan bool)
-	Env.RateLimit = 3
-	wp := workPool{}
-	wp.Start()
 	gotRequestCount := 0
 	wantRequestCount := 5
 
@@ -690,20 +1155,95 @@ func TestCreateSendMetricsJob(t *testing.T) {
 		serverMutex.Unlock()
 	}))
 	defer svr.Close()
-	serverURL = svr.URL
+	a := newTestAgent(Config{}, svr.URL)
+	a.pool.Start(3, a.sendMetrics)
 
 	timeoutTime := time.Second * 2
 	ctxWT, cancelCtx := context.WithTimeout(context.Background(), timeoutTime)
 	defer cancelCtx()
 	for i := 0; i < wantRequestCount; i++ {
-		go wp.CreateSendMetricsJob(ctxWT)
+		go a.pool.CreateSendMetricsJob(ctxWT)
 	}
 
 	select {
 	case <-ctxWT.Done():
 		t.Errorf("timeout exceeded")
 	case <-gotRequestCountCh:
-		wp.Close()
+		a.pool.Close()
 		assert.Equal(t, wantRequestCount, gotRequestCount)
 	}
 }
+
+func TestAgent_Run(t *testing.T) {
+	// два агента в одном процессе отправляют свои метрики на свои сервера
+	newAgentServer := func(gotIDs chan<- []string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			require.NoError(t, err)
+			batch, err := message.UnmarshalBatch(message.ContentTypeJSON, body)
+			require.NoError(t, err)
+			var ids []string
+			for _, m := range batch {
+				if !strings.HasPrefix(m.ID, "Agent") {
+					ids = append(ids, m.ID)
+				}
+			}
+			sort.Strings(ids)
+			select {
+			case gotIDs <- ids:
+			default:
+			}
+		}))
+	}
+	newRunAgent := func(svr *httptest.Server, metricName string) *Agent {
+		a, err := NewAgent(
+			Config{
+				ServerAddress:  strings.TrimPrefix(svr.URL, "http://"),
+				PollInterval:   10 * time.Millisecond,
+				ReportInterval: 30 * time.Millisecond,
+			},
+			WithHTTPClient(svr.Client()),
+			WithCollectors(testCollector{name: "test", metrics: map[string]interface{}{metricName: Gauge(1)}}),
+		)
+		require.NoError(t, err)
+		return a
+	}
+
+	gotIDs1, gotIDs2 := make(chan []string, 1), make(chan []string, 1)
+	svr1, svr2 := newAgentServer(gotIDs1), newAgentServer(gotIDs2)
+	defer svr1.Close()
+	defer svr2.Close()
+	agent1, agent2 := newRunAgent(svr1, "First"), newRunAgent(svr2, "Second")
+
+	runErr := make(chan error, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { runErr <- agent1.Run(ctx) }()
+	go func() { runErr <- agent2.Run(ctx) }()
+
+	for gotIDs, wantIDs := range map[chan []string][]string{
+		gotIDs1: {"First", "PollCount"},
+		gotIDs2: {"PollCount", "Second"},
+	} {
+		select {
+		case ids := <-gotIDs:
+			// первая отправка может опередить первый сбор метрик
+			for len(ids) < len(wantIDs) {
+				ids = <-gotIDs
+			}
+			assert.Equal(t, wantIDs, ids)
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout exceeded")
+		}
+	}
+
+	// агент нельзя запустить повторно, пока он работает
+	assert.ErrorIs(t, agent1.Run(ctx), ErrAgentIsRunning)
+
+	agent1.Stop()
+	assert.NoError(t, <-runErr)
+	cancel()
+	assert.NoError(t, <-runErr)
+	// остановленный агент
+	agent2.Stop()
+}
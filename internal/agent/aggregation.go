@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Функции агрегации gauge метрик за окно отправки, отправляются как метрики <название>_<функция>.
+const (
+	aggregationMin  = "min"
+	aggregationMax  = "max"
+	aggregationAvg  = "avg"
+	aggregationLast = "last"
+)
+
+// allAggregations функции агрегации, если в правиле они не указаны.
+var allAggregations = []string{aggregationMin, aggregationMax, aggregationAvg, aggregationLast}
+
+// aggregationRule функции агрегации для gauge метрик, подходящих под glob шаблон(синтаксис path.Match).
+type aggregationRule struct {
+	pattern      string
+	aggregations []string
+}
+
+// windowStats значения gauge метрики, собранные за окно отправки.
+type windowStats struct {
+	min, max, sum, last float64
+	count               int
+}
+
+// aggregator агрегирует значения gauge метрик, собранные между отправками(каждый PollInterval).
+type aggregator struct {
+	rules []aggregationRule
+	stats map[string]*windowStats
+	mutex sync.Mutex
+}
+
+// initAggregator инициализирует агрегатор по Aggregate.
+func (a *Agent) initAggregator() error {
+	if a.config.Aggregate == "" {
+		a.aggregator = nil
+		return nil
+	}
+	aggregator, err := newAggregator(a.config.Aggregate)
+	if err != nil {
+		return err
+	}
+	a.aggregator = aggregator
+	return nil
+}
+
+// newAggregator конструктор aggregator.
+// rawRules - правила через ";" вида "шаблон=функция,функция", например "CPU*=max,avg;*=last".
+// Без "=функции" - все функции(min, max, avg, last), с пустым списком("шаблон=") - метрика не агрегируется.
+// Для метрики используется первое подходящее правило.
+func newAggregator(rawRules string) (*aggregator, error) {
+	a := &aggregator{stats: map[string]*windowStats{}}
+	for _, rawRule := range strings.Split(rawRules, ";") {
+		rawRule = strings.TrimSpace(rawRule)
+		if rawRule == "" {
+			continue
+		}
+		pattern, rawAggregations, found := strings.Cut(rawRule, "=")
+		rule := aggregationRule{pattern: strings.TrimSpace(pattern), aggregations: allAggregations}
+		if _, err := path.Match(rule.pattern, ""); err != nil || rule.pattern == "" {
+			return nil, fmt.Errorf("aggregate: incorrect pattern '%s'", rule.pattern)
+		}
+		if found {
+			rule.aggregations = nil
+			for _, aggregation := range strings.Split(rawAggregations, ",") {
+				aggregation = strings.TrimSpace(aggregation)
+				switch aggregation {
+				case aggregationMin, aggregationMax, aggregationAvg, aggregationLast:
+					rule.aggregations = append(rule.aggregations, aggregation)
+				case "":
+				default:
+					return nil, fmt.Errorf("aggregate: unknown aggregation '%s' for '%s'", aggregation, rule.pattern)
+				}
+			}
+		}
+		a.rules = append(a.rules, rule)
+	}
+	return a, nil
+}
+
+// aggregations возвращает функции агрегации метрики name по первому подходящему правилу.
+func (a *aggregator) aggregations(name string) []string {
+	for _, rule := range a.rules {
+		if matched, _ := path.Match(rule.pattern, name); matched {
+			return rule.aggregations
+		}
+	}
+	return nil
+}
+
+// observe добавляет в окно значения gauge метрик, для которых заданы функции агрегации.
+func (a *aggregator) observe(metrics map[string]interface{}) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for name, value := range metrics {
+		g, ok := value.(Gauge)
+		if !ok || len(a.aggregations(name)) == 0 {
+			continue
+		}
+		v := float64(g)
+		stats, ok := a.stats[name]
+		if !ok {
+			a.stats[name] = &windowStats{min: v, max: v, sum: v, last: v, count: 1}
+			continue
+		}
+		if v < stats.min {
+			stats.min = v
+		}
+		if v > stats.max {
+			stats.max = v
+		}
+		stats.sum += v
+		stats.last = v
+		stats.count++
+	}
+}
+
+// flush возвращает агрегаты метрик за окно([название][функция]значение) и начинает новое окно.
+func (a *aggregator) flush() map[string]map[string]Gauge {
+	if a == nil {
+		return nil
+	}
+	a.mutex.Lock()
+	stats := a.stats
+	a.stats = map[string]*windowStats{}
+	a.mutex.Unlock()
+
+	result := make(map[string]map[string]Gauge, len(stats))
+	for name, s := range stats {
+		values := map[string]Gauge{}
+		for _, aggregation := range a.aggregations(name) {
+			switch aggregation {
+			case aggregationMin:
+				values[aggregation] = Gauge(s.min)
+			case aggregationMax:
+				values[aggregation] = Gauge(s.max)
+			case aggregationAvg:
+				values[aggregation] = Gauge(s.sum / float64(s.count))
+			case aggregationLast:
+				values[aggregation] = Gauge(s.last)
+			}
+		}
+		result[name] = values
+	}
+	return result
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+func Test_newAggregator(t *testing.T) {
+	tests := []struct {
+		name      string
+		rawRules  string
+		wantRules []aggregationRule
+		wantErr   bool
+	}{
+		{name: "Test 1. Empty rules.", rawRules: "", wantRules: nil},
+		{
+			name:     "Test 2. Rules with and without aggregations.",
+			rawRules: "CPU*=max, avg; Alloc=; *",
+			wantRules: []aggregationRule{
+				{pattern: "CPU*", aggregations: []string{aggregationMax, aggregationAvg}},
+				{pattern: "Alloc", aggregations: nil},
+				{pattern: "*", aggregations: allAggregations},
+			},
+		},
+		{name: "Test 3. Unknown aggregation.", rawRules: "CPU*=median", wantErr: true},
+		{name: "Test 4. Incorrect pattern.", rawRules: "CPU[=max", wantErr: true},
+		{name: "Test 5. Empty pattern.", rawRules: "=max", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newAggregator(tt.rawRules)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRules, got.rules)
+		})
+	}
+}
+
+func Test_aggregator(t *testing.T) {
+	a, err := newAggregator("CPU*=max,avg;Alloc=;*")
+	require.NoError(t, err)
+
+	a.observe(map[string]interface{}{
+		"CPUutilization0": Gauge(10), "Alloc": Gauge(100), "HeapAlloc": Gauge(5), "PollCount": Counter(1),
+	})
+	a.observe(map[string]interface{}{
+		"CPUutilization0": Gauge(30), "Alloc": Gauge(200), "HeapAlloc": Gauge(1), "PollCount": Counter(2),
+	})
+	a.observe(map[string]interface{}{"HeapAlloc": Gauge(3)})
+
+	assert.Equal(t, map[string]map[string]Gauge{
+		"CPUutilization0": {aggregationMax: 30, aggregationAvg: 20},
+		"HeapAlloc":       {aggregationMin: 1, aggregationMax: 5, aggregationAvg: 3, aggregationLast: 3},
+	}, a.flush())
+	// после отправки окно начинается заново
+	assert.Empty(t, a.flush())
+
+	var nilAggregator *aggregator
+	nilAggregator.observe(map[string]interface{}{"HeapAlloc": Gauge(3)})
+	assert.Nil(t, nilAggregator.flush())
+}
+
+func Test_sendMetricsAggregated(t *testing.T) {
+	var gotBatch []message.Metrics
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		gotBatch, err = message.UnmarshalBatch(message.ContentTypeJSON, body)
+		require.NoError(t, err)
+	}))
+	defer svr.Close()
+	a := newTestAgent(Config{IncludeMetrics: "RandomValue", MetricPrefix: "web1.", Aggregate: "RandomValue=min,max"}, svr.URL)
+	require.NoError(t, a.initFilter())
+	require.NoError(t, a.initAggregator())
+	a.metrics = map[string]interface{}{"RandomValue": Gauge(0.8)}
+	a.aggregator.observe(map[string]interface{}{"RandomValue": Gauge(0.2)})
+	a.aggregator.observe(map[string]interface{}{"RandomValue": Gauge(0.8)})
+
+	a.sendMetrics()
+	got := map[string]float64{}
+	for _, m := range gotBatch {
+		require.NotNil(t, m.Value)
+		got[m.ID] = *m.Value
+	}
+	assert.Len(t, got, 3)
+	assert.Contains(t, got, "web1.RandomValue")
+	assert.Equal(t, 0.2, got["web1.RandomValue_min"])
+	assert.Equal(t, 0.8, got["web1.RandomValue_max"])
+}
+
+func TestAgent_initAggregator(t *testing.T) {
+	a := &Agent{}
+	require.NoError(t, a.initAggregator())
+	assert.Nil(t, a.aggregator)
+
+	a.config.Aggregate = "*"
+	require.NoError(t, a.initAggregator())
+	assert.NotNil(t, a.aggregator)
+
+	a.config.Aggregate = "*=median"
+	assert.Error(t, a.initAggregator())
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// Collector источник метрик агента, вызывается каждый PollInterval.
+type Collector interface {
+	// Name название коллектора, длительность сбора отправляется как AgentCollectDuration<Name>.
+	Name() string
+	// Collect возвращает текущие значения метрик(Gauge или Counter) по названиям.
+	Collect(ctx context.Context) (map[string]interface{}, error)
+}
+
+// DefaultCollectors коллекторы агента по умолчанию: runtime.MemStats(с RandomValue) и go-psutil.
+func DefaultCollectors() []Collector {
+	return []Collector{NewMemStatsCollector(), NewGoPsutilCollector()}
+}
+
+// memStatsCollector собирает метрики из runtime.MemStats.
+type memStatsCollector struct{}
+
+// NewMemStatsCollector конструктор коллектора метрик runtime.MemStats.
+func NewMemStatsCollector() Collector {
+	return memStatsCollector{}
+}
+
+// Name возвращает название коллектора.
+func (c memStatsCollector) Name() string {
+	return "MemStats"
+}
+
+// Collect получает актуальные значения метрик из memstats.
+func (c memStatsCollector) Collect(ctx context.Context) (map[string]interface{}, error) {
+	memstats := runtime.MemStats{}
+	runtime.ReadMemStats(&memstats)
+	return map[string]interface{}{
+		"Alloc":       Gauge(memstats.Alloc),
+		"BuckHashSys": Gauge(memstats.BuckHashSys),
+		"Frees":       Gauge(memstats.Frees),
+
+		"GCCPUFraction": Gauge(memstats.GCCPUFraction),
+		"GCSys":         Gauge(memstats.GCSys),
+		"HeapAlloc":     Gauge(memstats.HeapAlloc),
+
+		"HeapIdle":    Gauge(memstats.HeapIdle),
+		"HeapInuse":   Gauge(memstats.HeapInuse),
+		"HeapObjects": Gauge(memstats.HeapObjects),
+
+		"HeapReleased": Gauge(memstats.HeapReleased),
+		"HeapSys":      Gauge(memstats.HeapSys),
+		"LastGC":       Gauge(memstats.LastGC),
+
+		"Lookups":     Gauge(memstats.Lookups),
+		"MCacheInuse": Gauge(memstats.MCacheInuse),
+		"MCacheSys":   Gauge(memstats.MCacheSys),
+
+		"MSpanInuse": Gauge(memstats.MSpanInuse),
+		"MSpanSys":   Gauge(memstats.MSpanSys),
+		"Mallocs":    Gauge(memstats.Mallocs),
+
+		"NextGC":      Gauge(memstats.NextGC),
+		"NumForcedGC": Gauge(memstats.NumForcedGC),
+		"NumGC":       Gauge(memstats.NumGC),
+
+		"OtherSys":     Gauge(memstats.OtherSys),
+		"PauseTotalNs": Gauge(memstats.PauseTotalNs),
+		"StackInuse":   Gauge(memstats.StackInuse),
+
+		"StackSys":   Gauge(memstats.StackSys),
+		"Sys":        Gauge(memstats.Sys),
+		"TotalAlloc": Gauge(memstats.TotalAlloc),
+
+		// Кастомные метрики
+		"RandomValue": Gauge(rand.Float64()),
+	}, nil
+}
+
+// goPsutilCollector собирает метрики памяти и загрузки cpu из go-psutil.
+type goPsutilCollector struct{}
+
+// NewGoPsutilCollector конструктор коллектора метрик go-psutil.
+func NewGoPsutilCollector() Collector {
+	return goPsutilCollector{}
+}
+
+// Name возвращает название коллектора.
+func (c goPsutilCollector) Name() string {
+	return "GoPsutil"
+}
+
+// Collect получает актуальные значения метрик из go-psutil.
+func (c goPsutilCollector) Collect(ctx context.Context) (map[string]interface{}, error) {
+	vM, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cpuS, err := cpu.PercentWithContext(ctx, 500*time.Millisecond, true)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := map[string]interface{}{
+		"TotalMemory": Gauge(vM.Total),
+		"FreeMemory":  Gauge(vM.Free),
+	}
+	for i, cpuUtilStat := range cpuS {
+		metrics[fmt.Sprintf("CPUutilization%d", i)] = Gauge(cpuUtilStat)
+	}
+	return metrics, nil
+}
@@ -0,0 +1,49 @@
+package agent
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_memStatsCollector_Collect(t *testing.T) {
+	collector := NewMemStatsCollector()
+	before, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	// нагрузка, чтобы повлиять на значения параметров в runtime.memstats
+	demoSlice := []string{"demo"}
+	for i := 0; i < 100; i++ {
+		demoSlice = append(demoSlice, "demo")
+	}
+
+	after, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Len(t, after, 28)
+	assert.NotEqual(t, before["Alloc"], after["Alloc"], "metric values were not updated")
+	assert.NotEqual(t, before["RandomValue"], after["RandomValue"], "RandomValue was not updated")
+}
+
+func Test_goPsutilCollector_Collect(t *testing.T) {
+	collector := NewGoPsutilCollector()
+	before, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	after, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, before["TotalMemory"], after["TotalMemory"], "total memory stat differs")
+
+	// свободная память системы может не измениться между сборами, проверяется только корректность значения
+	require.Contains(t, after, "TotalMemory")
+	require.Contains(t, after, "FreeMemory")
+	totalMemory, freeMemory := after["TotalMemory"].(Gauge), after["FreeMemory"].(Gauge)
+	assert.Greater(t, freeMemory, Gauge(0))
+	assert.LessOrEqual(t, freeMemory, totalMemory)
+	assert.Contains(t, after, "CPUutilization0")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = collector.Collect(ctx)
+	assert.Error(t, err)
+}
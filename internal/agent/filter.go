@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// metricsFilter отбор, переименование и префикс метрик перед отправкой на сервер.
+type metricsFilter struct {
+	// include glob шаблоны(синтаксис path.Match) отправляемых метрик, пустой список - все метрики.
+	include []string
+	// exclude glob шаблоны не отправляемых метрик, приоритетнее include.
+	exclude []string
+	// renames новые названия метрик по исходным.
+	renames map[string]string
+	// prefix добавляется к названиям всех метрик(после переименования), например "web1.".
+	prefix string
+}
+
+// initFilter инициализирует фильтр метрик по IncludeMetrics, ExcludeMetrics, RenameMetrics и MetricPrefix.
+func (a *Agent) initFilter() error {
+	c := a.config
+	if c.IncludeMetrics == "" && c.ExcludeMetrics == "" && c.RenameMetrics == "" && c.MetricPrefix == "" {
+		a.filter = nil
+		return nil
+	}
+	f, err := newMetricsFilter(c.IncludeMetrics, c.ExcludeMetrics, c.RenameMetrics, c.MetricPrefix)
+	if err != nil {
+		return err
+	}
+	a.filter = f
+	return nil
+}
+
+// newMetricsFilter конструктор metricsFilter.
+// include и exclude - glob шаблоны через запятую, renames - пары "исходное=новое" через запятую.
+func newMetricsFilter(include, exclude, renames, prefix string) (*metricsFilter, error) {
+	f := &metricsFilter{prefix: prefix}
+	var err error
+	if f.include, err = parsePatterns(include); err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+	if f.exclude, err = parsePatterns(exclude); err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+	for _, pair := range strings.Split(renames, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		from, to, found := strings.Cut(pair, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !found || from == "" || to == "" {
+			return nil, fmt.Errorf("rename: incorrect pair '%s', want 'from=to'", pair)
+		}
+		if f.renames == nil {
+			f.renames = map[string]string{}
+		}
+		f.renames[from] = to
+	}
+	return f, nil
+}
+
+// parsePatterns разбирает glob шаблоны через запятую.
+func parsePatterns(rawPatterns string) ([]string, error) {
+	var patterns []string
+	for _, pattern := range strings.Split(rawPatterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("pattern '%s': %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// matchAny возвращает true, если name подходит под один из шаблонов patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// apply возвращает отобранные метрики(по исходным названиям) с новыми названиями, metrics не изменяется.
+func (f *metricsFilter) apply(metrics map[string]interface{}) map[string]interface{} {
+	if f == nil {
+		return metrics
+	}
+	result := make(map[string]interface{}, len(metrics))
+	for name, value := range metrics {
+		if newName, ok := f.rename(name); ok {
+			result[newName] = value
+		}
+	}
+	return result
+}
+
+// rename возвращает новое название метрики name(с переименованием и префиксом) и false, если метрика не отправляется.
+func (f *metricsFilter) rename(name string) (string, bool) {
+	if f == nil {
+		return name, true
+	}
+	if len(f.include) > 0 && !matchAny(f.include, name) || matchAny(f.exclude, name) {
+		return "", false
+	}
+	newName, ok := f.renames[name]
+	if !ok {
+		newName = name
+	}
+	return f.prefix + newName, true
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+func Test_newMetricsFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		include    string
+		exclude    string
+		renames    string
+		prefix     string
+		wantFilter *metricsFilter
+		wantErr    bool
+	}{
+		{
+			name:       "Test 1. Empty params.",
+			wantFilter: &metricsFilter{},
+		},
+		{
+			name:    "Test 2. All params.",
+			include: "CPU*, Alloc,",
+			exclude: "CPUutilization0",
+			renames: "Alloc=mem_alloc, PollCount = polls",
+			prefix:  "web1.",
+			wantFilter: &metricsFilter{
+				include: []string{"CPU*", "Alloc"},
+				exclude: []string{"CPUutilization0"},
+				renames: map[string]string{"Alloc": "mem_alloc", "PollCount": "polls"},
+				prefix:  "web1.",
+			},
+		},
+		{name: "Test 3. Incorrect include pattern.", include: "CPU[", wantErr: true},
+		{name: "Test 4. Incorrect exclude pattern.", exclude: "[", wantErr: true},
+		{name: "Test 5. Rename without '='.", renames: "Alloc", wantErr: true},
+		{name: "Test 6. Rename to empty name.", renames: "Alloc=", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newMetricsFilter(tt.include, tt.exclude, tt.renames, tt.prefix)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFilter, got)
+		})
+	}
+}
+
+func Test_metricsFilter_apply(t *testing.T) {
+	metrics := map[string]interface{}{
+		"Alloc":           Gauge(10),
+		"CPUutilization0": Gauge(5),
+		"CPUutilization1": Gauge(7),
+		"PollCount":       Counter(3),
+	}
+
+	tests := []struct {
+		name    string
+		include string
+		exclude string
+		renames string
+		prefix  string
+		want    map[string]interface{}
+	}{
+		{
+			name:    "Test 1. Include and exclude.",
+			include: "CPU*,PollCount", exclude: "CPUutilization0",
+			want: map[string]interface{}{"CPUutilization1": Gauge(7), "PollCount": Counter(3)},
+		},
+		{
+			name:    "Test 2. Exclude only.",
+			exclude: "CPU*",
+			want:    map[string]interface{}{"Alloc": Gauge(10), "PollCount": Counter(3)},
+		},
+		{
+			name:    "Test 3. Rename and prefix.",
+			include: "Alloc,PollCount", renames: "Alloc=mem_alloc", prefix: "web1.",
+			want: map[string]interface{}{"web1.mem_alloc": Gauge(10), "web1.PollCount": Counter(3)},
+		},
+		{
+			name:    "Test 4. Include matches by original name.",
+			include: "Alloc", renames: "Alloc=mem_alloc,PollCount=Alloc",
+			want: map[string]interface{}{"mem_alloc": Gauge(10)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newMetricsFilter(tt.include, tt.exclude, tt.renames, tt.prefix)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, f.apply(metrics))
+			assert.Len(t, metrics, 4)
+		})
+	}
+
+	var nilFilter *metricsFilter
+	assert.Equal(t, metrics, nilFilter.apply(metrics))
+}
+
+func TestAgent_initFilter(t *testing.T) {
+	a := &Agent{}
+	require.NoError(t, a.initFilter())
+	assert.Nil(t, a.filter)
+
+	a.config.MetricPrefix = "web1."
+	require.NoError(t, a.initFilter())
+	assert.Equal(t, &metricsFilter{prefix: "web1."}, a.filter)
+
+	a.config.RenameMetrics = "Alloc"
+	assert.Error(t, a.initFilter())
+}
+
+func Test_sendMetricsFiltered(t *testing.T) {
+	var gotIDs []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		batch, err := message.UnmarshalBatch(message.ContentTypeJSON, body)
+		require.NoError(t, err)
+		for _, m := range batch {
+			gotIDs = append(gotIDs, m.ID)
+		}
+	}))
+	defer svr.Close()
+	a := newTestAgent(Config{IncludeMetrics: "PollCount,Alloc", MetricPrefix: "web1."}, svr.URL)
+	require.NoError(t, a.initFilter())
+	a.metrics = map[string]interface{}{"Alloc": Gauge(1), "HeapAlloc": Gauge(1)}
+
+	a.sendMetrics()
+	sort.Strings(gotIDs)
+	assert.Equal(t, []string{"web1.Alloc", "web1.PollCount"}, gotIDs)
+}
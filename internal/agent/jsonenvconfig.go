@@ -12,35 +12,103 @@ type envConfig struct {
 	ReportInterval    string `json:"report_interval"`
 	PollInterval      string `json:"poll_interval"`
 	PublicCryptoKeyFp string `json:"crypto_key"`
+	TLS               bool   `json:"tls"`
+	TLSCAFp           string `json:"tls_ca"`
+	TLSCertFp         string `json:"tls_cert"`
+	TLSKeyFp          string `json:"tls_key"`
+	Token             string `json:"token"`
+	AgentID           string `json:"agent_id"`
+	ClientKeyFp       string `json:"client_key"`
+	VerifyResponses   bool   `json:"verify_responses"`
+	Compression       string `json:"compression"`
+	CompressMinSize   int    `json:"compress_min_size"`
+	Format            string `json:"format"`
+	PartialBatch      bool   `json:"partial_batch"`
+	IncludeMetrics    string `json:"include_metrics"`
+	ExcludeMetrics    string `json:"exclude_metrics"`
+	RenameMetrics     string `json:"rename_metrics"`
+	MetricPrefix      string `json:"metric_prefix"`
+	Aggregate         string `json:"aggregate"`
 }
 
-func parseJSONConfig() error {
+func parseJSONConfig(env *Config) error {
 	// поля заполняемые из JSON(константа)
 	var fieldsToSet = map[string]bool{
-		"ServerAddress":  true,
-		"ReportInterval": true,
-		"PollInterval":   true,
-		"CryptoKey":      true,
+		"ServerAddress":   true,
+		"ReportInterval":  true,
+		"PollInterval":    true,
+		"CryptoKey":       true,
+		"TLS":             true,
+		"TLSCAFp":         true,
+		"TLSCertFp":       true,
+		"TLSKeyFp":        true,
+		"Token":           true,
+		"AgentID":         true,
+		"ClientKeyFp":     true,
+		"VerifyResponses": true,
+		"Compression":     true,
+		"CompressMinSize": true,
+		"Format":          true,
+		"PartialBatch":    true,
+		"IncludeMetrics":  true,
+		"ExcludeMetrics":  true,
+		"RenameMetrics":   true,
+		"MetricPrefix":    true,
+		"Aggregate":       true,
 	}
 
-	// словарь [ключ ком.строки: имя ассоц. поля Env]
+	// словарь [ключ ком.строки: имя ассоц. поля Config]
 	var cmdEnvDict = map[string]string{
-		"a":          "ServerAddress",
-		"r":          "ReportInterval",
-		"p":          "PollInterval",
-		"crypto-key": "CryptoKey",
+		"a":                 "ServerAddress",
+		"r":                 "ReportInterval",
+		"p":                 "PollInterval",
+		"crypto-key":        "CryptoKey",
+		"tls":               "TLS",
+		"tls-ca":            "TLSCAFp",
+		"tls-cert":          "TLSCertFp",
+		"tls-key":           "TLSKeyFp",
+		"token":             "Token",
+		"agent-id":          "AgentID",
+		"client-key":        "ClientKeyFp",
+		"verify-responses":  "VerifyResponses",
+		"compression":       "Compression",
+		"compress-min-size": "CompressMinSize",
+		"format":            "Format",
+		"partial-batch":     "PartialBatch",
+		"include":           "IncludeMetrics",
+		"exclude":           "ExcludeMetrics",
+		"rename":            "RenameMetrics",
+		"prefix":            "MetricPrefix",
+		"aggregate":         "Aggregate",
 	}
 
-	// словарь [перем.окружения: имя ассоц. поля Env]
+	// словарь [перем.окружения: имя ассоц. поля Config]
 	var osEnvEnvDict = map[string]string{
-		"ADDRESS":         "ServerAddress",
-		"REPORT_INTERVAL": "ReportInterval",
-		"POLL_INTERVAL":   "PollInterval",
-		"CRYPTO_KEY":      "CryptoKey",
+		"ADDRESS":           "ServerAddress",
+		"REPORT_INTERVAL":   "ReportInterval",
+		"POLL_INTERVAL":     "PollInterval",
+		"CRYPTO_KEY":        "CryptoKey",
+		"TLS":               "TLS",
+		"TLS_CA":            "TLSCAFp",
+		"TLS_CERT":          "TLSCertFp",
+		"TLS_KEY":           "TLSKeyFp",
+		"TOKEN":             "Token",
+		"AGENT_ID":          "AgentID",
+		"CLIENT_KEY":        "ClientKeyFp",
+		"VERIFY_RESPONSES":  "VerifyResponses",
+		"COMPRESSION":       "Compression",
+		"COMPRESS_MIN_SIZE": "CompressMinSize",
+		"FORMAT":            "Format",
+		"PARTIAL_BATCH":     "PartialBatch",
+		"INCLUDE_METRICS":   "IncludeMetrics",
+		"EXCLUDE_METRICS":   "ExcludeMetrics",
+		"RENAME_METRICS":    "RenameMetrics",
+		"METRIC_PREFIX":     "MetricPrefix",
+		"AGGREGATE":         "Aggregate",
 	}
 
 	// получаю json из конфига, путь беру из переменной env
-	config, err := getJSONData(Env.ConfigFilepath)
+	config, err := getJSONData(env.ConfigFilepath)
 	if err != nil {
 		return err
 	}
@@ -50,24 +118,76 @@ func parseJSONConfig() error {
 
 	// записываю новые значения
 	if fieldsToSet["ServerAddress"] {
-		Env.ServerAddress = config.ServerAddress
+		env.ServerAddress = config.ServerAddress
 	}
 	if fieldsToSet["ReportInterval"] {
 		dur, err := time.ParseDuration(config.ReportInterval)
 		if err != nil {
 			return err
 		}
-		Env.ReportInterval = dur
+		env.ReportInterval = dur
 	}
 	if fieldsToSet["PollInterval"] {
 		dur, err := time.ParseDuration(config.PollInterval)
 		if err != nil {
 			return err
 		}
-		Env.PollInterval = dur
+		env.PollInterval = dur
 	}
 	if fieldsToSet["CryptoKey"] {
-		Env.PublicCryptoKeyFp = config.PublicCryptoKeyFp
+		env.PublicCryptoKeyFp = config.PublicCryptoKeyFp
+	}
+	// tls параметры необязательны в конфиге, пустые значения не переопределяют конфигурацию
+	if fieldsToSet["TLS"] && config.TLS {
+		env.TLS = config.TLS
+	}
+	if fieldsToSet["TLSCAFp"] && config.TLSCAFp != "" {
+		env.TLSCAFp = config.TLSCAFp
+	}
+	if fieldsToSet["TLSCertFp"] && config.TLSCertFp != "" {
+		env.TLSCertFp = config.TLSCertFp
+	}
+	if fieldsToSet["TLSKeyFp"] && config.TLSKeyFp != "" {
+		env.TLSKeyFp = config.TLSKeyFp
+	}
+	if fieldsToSet["Token"] && config.Token != "" {
+		env.Token = config.Token
+	}
+	if fieldsToSet["AgentID"] && config.AgentID != "" {
+		env.AgentID = config.AgentID
+	}
+	if fieldsToSet["ClientKeyFp"] && config.ClientKeyFp != "" {
+		env.ClientKeyFp = config.ClientKeyFp
+	}
+	if fieldsToSet["VerifyResponses"] && config.VerifyResponses {
+		env.VerifyResponses = config.VerifyResponses
+	}
+	if fieldsToSet["Compression"] && config.Compression != "" {
+		env.Compression = config.Compression
+	}
+	if fieldsToSet["CompressMinSize"] && config.CompressMinSize != 0 {
+		env.CompressMinSize = config.CompressMinSize
+	}
+	if fieldsToSet["Format"] && config.Format != "" {
+		env.Format = config.Format
+	}
+	if fieldsToSet["PartialBatch"] && config.PartialBatch {
+		env.PartialBatch = config.PartialBatch
+	}
+	if fieldsToSet["IncludeMetrics"] && config.IncludeMetrics != "" {
+		env.IncludeMetrics = config.IncludeMetrics
+	}
+	if fieldsToSet["ExcludeMetrics"] && config.ExcludeMetrics != "" {
+		env.ExcludeMetrics = config.ExcludeMetrics
+	}
+	if fieldsToSet["RenameMetrics"] && config.RenameMetrics != "" {
+		env.RenameMetrics = config.RenameMetrics
+	}
+	if fieldsToSet["MetricPrefix"] && config.MetricPrefix != "" {
+		env.MetricPrefix = config.MetricPrefix
+	}
+	if fieldsToSet["Aggregate"] && config.Aggregate != "" {
+		env.Aggregate = config.Aggregate
 	}
 	return nil
 }
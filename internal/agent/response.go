@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-resty/resty/v2"
+
+	"github.com/firesworder/devopsmetrics/internal/crypt"
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+// ErrResponseNotSigned ответ сервера не подписан, а VerifyResponses требует подписи.
+var ErrResponseNotSigned = errors.New("server response is not signed")
+
+// initClientKey загружает приватный ключ агента(CLIENT_KEY) и регистрирует его публичную часть на сервере,
+// после чего сервер шифрует ответы /value/ и /update/ этим ключом.
+func (a *Agent) initClientKey() error {
+	a.clientDecoder, a.clientKeyID = nil, ""
+	if a.config.ClientKeyFp == "" {
+		return nil
+	}
+
+	decoder, err := crypt.NewDecoder(a.config.ClientKeyFp)
+	if err != nil {
+		return err
+	}
+	publicKey, err := crypt.MarshalPublicKey(decoder.PublicKey(), crypt.FormatPKIX)
+	if err != nil {
+		return err
+	}
+
+	request := a.newClient().R().SetHeader("Content-Type", "text/plain")
+	if err = a.signRequest(request, `/keys/`, publicKey); err != nil {
+		return err
+	}
+	resp, err := request.SetBody(publicKey).Post(`/keys/`)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return fmt.Errorf("cannot register client key: %s %s", resp.Status(), resp.Body())
+	}
+	var registered struct {
+		KeyID string `json:"key_id"`
+	}
+	if err = json.Unmarshal(resp.Body(), &registered); err != nil {
+		return err
+	}
+	if registered.KeyID != decoder.KeyID() {
+		return fmt.Errorf("server registered key id '%s', want '%s'", registered.KeyID, decoder.KeyID())
+	}
+	a.clientDecoder, a.clientKeyID = decoder, registered.KeyID
+	return nil
+}
+
+// setResponseNonce задает случайный nonce запроса, который сервер включает в подпись ответа(см. readResponse).
+func setResponseNonce(request *resty.Request) error {
+	nonce, err := crypt.NewResponseNonce()
+	if err != nil {
+		return err
+	}
+	request.SetHeader(crypt.ResponseNonceHeader, nonce)
+	return nil
+}
+
+// readResponse возвращает тело ответа сервера: расшифровывает ключом агента и проверяет подпись сервера.
+// Подпись проверяется публичным ключом сервера(CRYPTO_KEY), если он задан: подписаны метод, путь и nonce
+// запроса(setResponseNonce) и тело ответа. Если задан VerifyResponses - ответ без подписи считается ошибкой.
+func (a *Agent) readResponse(resp *resty.Response) ([]byte, error) {
+	body := resp.Body()
+	if resp.Header().Get(crypt.ClientKeyIDHeader) != "" {
+		if a.clientDecoder == nil || resp.Header().Get(crypt.ClientKeyIDHeader) != a.clientKeyID {
+			return nil, fmt.Errorf("response is encrypted with unknown key '%s'",
+				resp.Header().Get(crypt.ClientKeyIDHeader))
+		}
+		var err error
+		if body, err = a.clientDecoder.Decode(body); err != nil {
+			return nil, err
+		}
+	}
+
+	encodedSignature := resp.Header().Get(crypt.SignatureHeader)
+	if encodedSignature == "" {
+		if a.config.VerifyResponses {
+			return nil, ErrResponseNotSigned
+		}
+		return body, nil
+	}
+	if a.encoder == nil {
+		if a.config.VerifyResponses {
+			return nil, errors.New("server public key(crypto-key) is required to verify responses")
+		}
+		return body, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, err
+	}
+	requestURL := resp.Request.RawRequest.URL
+	signed := crypt.ResponseSignatureMessage(resp.Request.Method,
+		message.SignatureTarget(requestURL.Path, requestURL.RawQuery),
+		resp.Request.Header.Get(crypt.ResponseNonceHeader), body)
+	if err = a.encoder.Verify(signed, signature); err != nil {
+		return nil, fmt.Errorf("server response signature is not correct: %w", err)
+	}
+	return body, nil
+}
+
+// GetMetric запрашивает у сервера значение метрики id типа mType(/value/), в формате Format.
+// Ответ расшифровывается и проверяется(см. readResponse): метрика ответа должна совпадать с запрошенной,
+// при заданном Key - также проверяется хеш метрики.
+func (a *Agent) GetMetric(id, mType string) (*message.Metrics, error) {
+	bodyContent, err := message.Marshal(a.contentType(), message.Metrics{ID: id, MType: mType})
+	if err != nil {
+		return nil, err
+	}
+	request := a.newClient().R().
+		SetHeader("Content-Type", a.contentType()).
+		SetHeader("Accept", a.contentType())
+	if err = setResponseNonce(request); err != nil {
+		return nil, err
+	}
+	resp, err := request.SetBody(bodyContent).Post(`/value/`)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("cannot get metric '%s': %s %s", id, resp.Status(), resp.Body())
+	}
+
+	body, err := a.readResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	msg := &message.Metrics{}
+	if err = message.Unmarshal(a.contentType(), body, msg); err != nil {
+		return nil, err
+	}
+	if msg.ID != id || msg.MType != mType {
+		return nil, fmt.Errorf("response metric '%s'(%s) does not match requested '%s'(%s)",
+			msg.ID, msg.MType, id, mType)
+	}
+	if a.config.Key != "" {
+		isHashCorrect, err := msg.CheckHash(a.config.Key)
+		if err != nil {
+			return nil, err
+		} else if !isHashCorrect {
+			return nil, fmt.Errorf("metric '%s' hash is not correct", id)
+		}
+	}
+	return msg, nil
+}
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal/crypt"
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+// secureTestResponse параметры ответа тестового сервера на /value/.
+type secureTestResponse struct {
+	sign bool
+	// nonce подписываемый nonce(если пустой - nonce запроса)
+	nonce string
+	// metricID id метрики в ответе(если пустой - PollCount)
+	metricID string
+}
+
+// newSecureTestServer тестовый сервер: регистрирует ключ клиента(/keys/) и отвечает на /value/ метрикой,
+// подписывая ответ ключом privateKey_1(если sign) и шифруя ключом клиента(если он передан в запросе).
+func newSecureTestServer(t *testing.T, options secureTestResponse) *httptest.Server {
+	serverKey, err := crypt.NewDecoder("../crypt/test/privateKey_1_test.pem")
+	require.NoError(t, err)
+	clientKeys := map[string]*crypt.Encoder{}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		switch r.URL.Path {
+		case "/keys/":
+			clientKey, err := crypt.NewEncoderFromPEM(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			clientKeys[clientKey.KeyID()] = clientKey
+			w.Write([]byte(`{"key_id":"` + clientKey.KeyID() + `"}`))
+		case "/value/":
+			delta := int64(10)
+			metricID := options.metricID
+			if metricID == "" {
+				metricID = "PollCount"
+			}
+			response, err := json.Marshal(message.Metrics{ID: metricID, MType: "counter", Delta: &delta})
+			require.NoError(t, err)
+			if options.sign {
+				nonce := options.nonce
+				if nonce == "" {
+					nonce = r.Header.Get(crypt.ResponseNonceHeader)
+				}
+				signed := crypt.ResponseSignatureMessage(r.Method, r.URL.Path, nonce, response)
+				signature, err := serverKey.Sign(signed)
+				require.NoError(t, err)
+				w.Header().Set(crypt.SignatureHeader, base64.StdEncoding.EncodeToString(signature))
+			}
+			if clientKeyID := r.Header.Get(crypt.ClientKeyIDHeader); clientKeyID != "" {
+				response, err = clientKeys[clientKeyID].Encode(response)
+				require.NoError(t, err)
+				w.Header().Set(crypt.ClientKeyIDHeader, clientKeyID)
+			}
+			w.Write(response)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestAgent_initClientKey(t *testing.T) {
+	svr := newSecureTestServer(t, secureTestResponse{})
+	defer svr.Close()
+	a := newTestAgent(Config{}, svr.URL)
+
+	wantDecoder, err := crypt.NewDecoder("../crypt/test/privateKey_2_test.pem")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		clientKeyFp string
+		wantKeyID   string
+		wantErr     bool
+	}{
+		{name: "Test 1. Client key is not set.", clientKeyFp: ""},
+		{
+			name:        "Test 2. Client key is registered.",
+			clientKeyFp: "../crypt/test/privateKey_2_test.pem",
+			wantKeyID:   wantDecoder.KeyID(),
+		},
+		{name: "Test 3. Client key file is not exist.", clientKeyFp: "not_exist.pem", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a.config.ClientKeyFp = tt.clientKeyFp
+			err := a.initClientKey()
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantKeyID, a.clientKeyID)
+			assert.Equal(t, tt.wantKeyID != "", a.clientDecoder != nil)
+		})
+	}
+}
+
+func TestAgent_GetMetric(t *testing.T) {
+	serverPublicKey, err := crypt.NewEncoder("../crypt/test/publicKey_1_test.pem")
+	require.NoError(t, err)
+	otherPublicKey, err := crypt.NewEncoder("../crypt/test/publicKey_2_test.pem")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		response        secureTestResponse
+		clientKeyFp     string
+		encoder         *crypt.Encoder
+		verifyResponses bool
+		wantErr         bool
+	}{
+		{name: "Test 1. Plain response."},
+		{
+			name:            "Test 2. Signed response.",
+			response:        secureTestResponse{sign: true},
+			encoder:         serverPublicKey,
+			verifyResponses: true,
+		},
+		{
+			name:            "Test 3. Signed and encrypted response.",
+			response:        secureTestResponse{sign: true},
+			clientKeyFp:     "../crypt/test/privateKey_2_test.pem",
+			encoder:         serverPublicKey,
+			verifyResponses: true,
+		},
+		{name: "Test 4. Response is not signed.", encoder: serverPublicKey, verifyResponses: true, wantErr: true},
+		{
+			name:     "Test 5. Signed by other key.",
+			response: secureTestResponse{sign: true},
+			encoder:  otherPublicKey,
+			wantErr:  true,
+		},
+		{name: "Test 6. Signature is not required.", response: secureTestResponse{sign: true}},
+		{
+			name:            "Test 7. Replayed response(signed for other request nonce).",
+			response:        secureTestResponse{sign: true, nonce: "00"},
+			encoder:         serverPublicKey,
+			verifyResponses: true,
+			wantErr:         true,
+		},
+		{
+			name:     "Test 8. Response for other metric.",
+			response: secureTestResponse{sign: true, metricID: "RandomValue"},
+			encoder:  serverPublicKey,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := newSecureTestServer(t, tt.response)
+			defer svr.Close()
+			a := newTestAgent(Config{ClientKeyFp: tt.clientKeyFp, VerifyResponses: tt.verifyResponses}, svr.URL)
+			require.NoError(t, a.initClientKey())
+			a.encoder = tt.encoder
+
+			got, err := a.GetMetric("PollCount", "counter")
+			require.Equal(t, tt.wantErr, err != nil)
+			if tt.wantErr {
+				return
+			}
+			assert.Equal(t, "PollCount", got.ID)
+			require.NotNil(t, got.Delta)
+			assert.Equal(t, int64(10), *got.Delta)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// selfStats служебные метрики агента(самомониторинг), отправляются в каждом батче вместе с собранными метриками.
+// Отключаются фильтром, например EXCLUDE_METRICS="Agent*".
+type selfStats struct {
+	mutex            sync.Mutex
+	lastSendSuccess  time.Time
+	lastSendDuration time.Duration
+	lastBatchSize    int
+	collectDurations map[string]time.Duration
+
+	// sendAttempts, sendFailures попытки отправки с последнего принятого сервером отчета(report).
+	sendAttempts Counter
+	sendFailures Counter
+
+	// queueLength задания на отправку, ожидающие свободного воркера.
+	queueLength atomic.Int64
+	// busyWorkers воркеры, занятые отправкой.
+	busyWorkers atomic.Int64
+	// workersCount воркеры запущенного воркпула.
+	workersCount atomic.Int64
+}
+
+// newSelfStats конструктор selfStats.
+func newSelfStats() *selfStats {
+	return &selfStats{collectDurations: map[string]time.Duration{}}
+}
+
+// observeSend учитывает попытку отправки батча из batchSize метрик, длившуюся duration.
+func (s *selfStats) observeSend(batchSize int, duration time.Duration, success bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.sendAttempts++
+	s.lastBatchSize = batchSize
+	s.lastSendDuration = duration
+	if success {
+		s.lastSendSuccess = time.Now()
+	} else {
+		s.sendFailures++
+	}
+}
+
+// observeCollect сохраняет длительность duration последнего сбора метрик коллектором name.
+func (s *selfStats) observeCollect(name string, duration time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.collectDurations[name] = duration
+}
+
+// report возвращает служебные метрики агента, длительности - в секундах, время успешной отправки - unix timestamp.
+// Счетчики попыток отправки - приращение с прошлого отчета, после вызова обнуляются.
+// Если отчет не принят сервером, счетчики возвращаются вызовом unreported.
+func (s *selfStats) report() map[string]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	metrics := map[string]interface{}{
+		"AgentSendAttempts":    s.sendAttempts,
+		"AgentSendFailures":    s.sendFailures,
+		"AgentLastSendSuccess": Gauge(0),
+		"AgentSendDuration":    Gauge(s.lastSendDuration.Seconds()),
+		"AgentBatchSize":       Gauge(s.lastBatchSize),
+		"AgentQueueLength":     Gauge(s.queueLength.Load()),
+		"AgentWorkersBusy":     Gauge(s.busyWorkers.Load()),
+	}
+	if !s.lastSendSuccess.IsZero() {
+		metrics["AgentLastSendSuccess"] = Gauge(s.lastSendSuccess.Unix())
+	}
+	utilization := Gauge(0)
+	if workersCount := s.workersCount.Load(); workersCount > 0 {
+		utilization = Gauge(s.busyWorkers.Load()) / Gauge(workersCount)
+	}
+	metrics["AgentWorkPoolUtilization"] = utilization
+	for name, duration := range s.collectDurations {
+		metrics["AgentCollectDuration"+name] = Gauge(duration.Seconds())
+	}
+	s.sendAttempts, s.sendFailures = 0, 0
+	return metrics
+}
+
+// unreported возвращает счетчики попыток отправки неотправленного отчета report, чтобы учесть их в следующем.
+func (s *selfStats) unreported(report map[string]interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if attempts, ok := report["AgentSendAttempts"].(Counter); ok {
+		s.sendAttempts += attempts
+	}
+	if failures, ok := report["AgentSendFailures"].(Counter); ok {
+		s.sendFailures += failures
+	}
+}
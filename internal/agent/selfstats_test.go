@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+func Test_selfStats_report(t *testing.T) {
+	s := newSelfStats()
+	assert.Equal(t, map[string]interface{}{
+		"AgentSendAttempts":        Counter(0),
+		"AgentSendFailures":        Counter(0),
+		"AgentLastSendSuccess":     Gauge(0),
+		"AgentSendDuration":        Gauge(0),
+		"AgentBatchSize":           Gauge(0),
+		"AgentQueueLength":         Gauge(0),
+		"AgentWorkersBusy":         Gauge(0),
+		"AgentWorkPoolUtilization": Gauge(0),
+	}, s.report())
+
+	s.observeSend(30, time.Second, false)
+	s.observeSend(31, 2*time.Second, true)
+	s.queueLength.Store(2)
+	s.busyWorkers.Store(1)
+	s.workersCount.Store(4)
+	s.observeCollect("MemStats", time.Second)
+
+	got := s.report()
+	assert.Equal(t, Counter(2), got["AgentSendAttempts"])
+	assert.Equal(t, Counter(1), got["AgentSendFailures"])
+	assert.InDelta(t, float64(time.Now().Unix()), float64(got["AgentLastSendSuccess"].(Gauge)), 1)
+	assert.Equal(t, Gauge(2), got["AgentSendDuration"])
+	assert.Equal(t, Gauge(31), got["AgentBatchSize"])
+	assert.Equal(t, Gauge(2), got["AgentQueueLength"])
+	assert.Equal(t, Gauge(1), got["AgentWorkersBusy"])
+	assert.Equal(t, Gauge(0.25), got["AgentWorkPoolUtilization"])
+	assert.Equal(t, Gauge(1), got["AgentCollectDurationMemStats"])
+	assert.NotContains(t, got, "AgentCollectDurationGoPsutil")
+
+	// счетчики попыток - приращение с прошлого отчета
+	got = s.report()
+	assert.Equal(t, Counter(0), got["AgentSendAttempts"])
+	assert.Equal(t, Counter(0), got["AgentSendFailures"])
+
+	// неотправленный отчет учитывается в следующем
+	s.observeSend(30, time.Second, false)
+	s.unreported(s.report())
+	got = s.report()
+	assert.Equal(t, Counter(1), got["AgentSendAttempts"])
+	assert.Equal(t, Counter(1), got["AgentSendFailures"])
+}
+
+func Test_sendMetricsSelfStats(t *testing.T) {
+	var gotBatches []map[string]message.Metrics
+	statusCode := http.StatusInternalServerError
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		batch, err := message.UnmarshalBatch(message.ContentTypeJSON, body)
+		require.NoError(t, err)
+		got := map[string]message.Metrics{}
+		for _, m := range batch {
+			got[m.ID] = m
+		}
+		gotBatches = append(gotBatches, got)
+		w.WriteHeader(statusCode)
+	}))
+	defer svr.Close()
+	a := newTestAgent(Config{IncludeMetrics: "PollCount,Agent*"}, svr.URL)
+	require.NoError(t, a.initFilter())
+
+	// первая отправка неуспешна, ее результат отправляется во второй
+	a.sendMetrics()
+	statusCode = http.StatusOK
+	a.sendMetrics()
+	// в третьей - только вторая(успешная) попытка, отправленные ранее не повторяются
+	a.sendMetrics()
+
+	require.Len(t, gotBatches, 3)
+	wantDeltas := []struct{ attempts, failures int64 }{{0, 0}, {1, 1}, {1, 0}}
+	for i, want := range wantDeltas {
+		got := gotBatches[i]
+		require.Contains(t, got, "AgentSendAttempts")
+		require.Contains(t, got, "AgentSendFailures")
+		assert.Equal(t, want.attempts, *got["AgentSendAttempts"].Delta, "batch %d", i)
+		assert.Equal(t, want.failures, *got["AgentSendFailures"].Delta, "batch %d", i)
+	}
+	assert.Equal(t, float64(len(gotBatches[1])), *gotBatches[2]["AgentBatchSize"].Value)
+	assert.Equal(t, float64(0), *gotBatches[1]["AgentLastSendSuccess"].Value)
+	assert.NotEqual(t, float64(0), *gotBatches[2]["AgentLastSendSuccess"].Value)
+
+	stats := a.stats.report()
+	assert.Equal(t, Counter(1), stats["AgentSendAttempts"])
+	assert.Equal(t, Counter(0), stats["AgentSendFailures"])
+}
@@ -0,0 +1,202 @@
+package alerts
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+// State состояние алерта.
+type State string
+
+// Состояния алерта.
+const (
+	// StatePending условие выполняется, но меньше чем For правила.
+	StatePending State = "pending"
+	// StateFiring условие выполняется дольше For правила.
+	StateFiring State = "firing"
+	// StateResolved алерт был в состоянии firing, условие перестало выполняться.
+	StateResolved State = "resolved"
+)
+
+// ParseState проверяет и возвращает состояние алерта по строке.
+func ParseState(s string) (State, bool) {
+	switch state := State(s); state {
+	case StatePending, StateFiring, StateResolved:
+		return state, true
+	}
+	return "", false
+}
+
+// Alert состояние правила по конкретной метрике.
+type Alert struct {
+	Rule        string     `json:"rule"`
+	Metric      string     `json:"metric"`
+	State       State      `json:"state"`
+	Value       float64    `json:"value"`
+	Op          string     `json:"op"`
+	Threshold   float64    `json:"threshold"`
+	ActiveSince time.Time  `json:"active_since"`
+	FiredAt     *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+}
+
+// resolvedRetention время, в течение которого resolved алерт хранится(и возвращается Alerts) после разрешения.
+const resolvedRetention = time.Hour
+
+// alertKey ключ алерта: правило + метрика.
+type alertKey struct {
+	rule   string
+	metric string
+}
+
+// Engine проверяет правила алертов и хранит состояния алертов.
+type Engine struct {
+	rules  []Rule
+	alerts map[alertKey]*Alert
+	mutex  sync.RWMutex
+}
+
+// NewEngine конструктор Engine.
+func NewEngine(rules []Rule) *Engine {
+	return &Engine{rules: rules, alerts: map[alertKey]*Alert{}}
+}
+
+// Evaluate проверяет правила по текущим значениям метрик на момент now.
+// Возвращает алерты, перешедшие в состояния firing или resolved. Resolved алерты хранятся resolvedRetention.
+func (e *Engine) Evaluate(metrics map[string]storage.Metric, now time.Time) []Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.pruneResolved(now)
+	var transitions []Alert
+	for _, rule := range e.rules {
+		for name, metric := range metrics {
+			if !rule.matchMetric(name) {
+				continue
+			}
+			value := metricValue(metric)
+			key := alertKey{rule: rule.Name, metric: name}
+			if rule.check(value) {
+				if alert := e.activate(rule, key, value, now); alert != nil {
+					transitions = append(transitions, *alert)
+				}
+			} else if alert := e.deactivate(key, value, now); alert != nil {
+				transitions = append(transitions, *alert)
+			}
+		}
+
+		// метрики, пропавшие из хранилища, считаются не удовлетворяющими условию
+		for key, alert := range e.alerts {
+			if _, ok := metrics[key.metric]; ok || key.rule != rule.Name {
+				continue
+			}
+			if resolved := e.deactivate(key, alert.Value, now); resolved != nil {
+				transitions = append(transitions, *resolved)
+			}
+		}
+	}
+	sortAlerts(transitions)
+	return transitions
+}
+
+// activate обновляет алерт, условие которого выполняется. Возвращает алерт, если он перешел в firing.
+func (e *Engine) activate(rule Rule, key alertKey, value float64, now time.Time) *Alert {
+	alert, ok := e.alerts[key]
+	if !ok || alert.State == StateResolved {
+		alert = &Alert{
+			Rule: rule.Name, Metric: key.metric, State: StatePending,
+			Op: rule.Op, Threshold: rule.Threshold, ActiveSince: now,
+		}
+		e.alerts[key] = alert
+	}
+	alert.Value = value
+
+	if alert.State == StatePending && now.Sub(alert.ActiveSince) >= rule.For {
+		alert.State = StateFiring
+		firedAt := now
+		alert.FiredAt = &firedAt
+		return alert
+	}
+	return nil
+}
+
+// deactivate обновляет алерт, условие которого не выполняется. Возвращает алерт, если он перешел в resolved.
+func (e *Engine) deactivate(key alertKey, value float64, now time.Time) *Alert {
+	alert, ok := e.alerts[key]
+	if !ok {
+		return nil
+	}
+	switch alert.State {
+	case StatePending:
+		delete(e.alerts, key)
+	case StateFiring:
+		alert.State = StateResolved
+		alert.Value = value
+		resolvedAt := now
+		alert.ResolvedAt = &resolvedAt
+		return alert
+	}
+	return nil
+}
+
+// pruneResolved удаляет алерты, разрешенные раньше чем resolvedRetention до now.
+func (e *Engine) pruneResolved(now time.Time) {
+	for key, alert := range e.alerts {
+		if alert.State == StateResolved && now.Sub(*alert.ResolvedAt) >= resolvedRetention {
+			delete(e.alerts, key)
+		}
+	}
+}
+
+// Alerts возвращает текущие алерты, отсортированные по правилу и метрике.
+// Если states переданы - только алерты в этих состояниях.
+func (e *Engine) Alerts(states ...State) []Alert {
+	result := make([]Alert, 0)
+	if e == nil {
+		return result
+	}
+
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+	for _, alert := range e.alerts {
+		if len(states) != 0 && !containsState(states, alert.State) {
+			continue
+		}
+		result = append(result, *alert)
+	}
+	sortAlerts(result)
+	return result
+}
+
+// containsState возвращает true, если state есть в states.
+func containsState(states []State, state State) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// sortAlerts сортирует алерты по правилу и метрике.
+func sortAlerts(alerts []Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Metric < alerts[j].Metric
+	})
+}
+
+// metricValue возвращает значение метрики как float64.
+func metricValue(metric storage.Metric) float64 {
+	msg := metric.GetMessageMetric()
+	if msg.MType == internal.CounterTypeName {
+		return float64(*msg.Delta)
+	}
+	return *msg.Value
+}
@@ -0,0 +1,128 @@
+package alerts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+// gaugeMetrics возвращает словарь gauge метрик по словарю значений.
+func gaugeMetrics(t *testing.T, values map[string]float64) map[string]storage.Metric {
+	metrics := map[string]storage.Metric{}
+	for name, value := range values {
+		m, err := storage.NewMetric(name, internal.GaugeTypeName, value)
+		require.NoError(t, err)
+		metrics[name] = *m
+	}
+	return metrics
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine := NewEngine([]Rule{
+		{Name: "HighCPU", Metric: "CPUutilization*", Op: OpGreater, Threshold: 90, For: time.Minute},
+		{Name: "LowFreeMemory", Metric: "FreeMemory", Op: OpLess, Threshold: 100},
+	})
+
+	type step struct {
+		name            string
+		offset          time.Duration
+		values          map[string]float64
+		wantTransitions []State
+		wantStates      map[string]State
+	}
+	steps := []step{
+		{
+			name:       "Step 1. Condition met, pending.",
+			values:     map[string]float64{"CPUutilization1": 95, "CPUutilization2": 10, "FreeMemory": 500},
+			wantStates: map[string]State{"HighCPU/CPUutilization1": StatePending},
+		},
+		{
+			name:            "Step 2. Without 'for' - firing immediately.",
+			offset:          30 * time.Second,
+			values:          map[string]float64{"CPUutilization1": 95, "CPUutilization2": 10, "FreeMemory": 50},
+			wantTransitions: []State{StateFiring},
+			wantStates: map[string]State{
+				"HighCPU/CPUutilization1": StatePending, "LowFreeMemory/FreeMemory": StateFiring,
+			},
+		},
+		{
+			name:            "Step 3. 'for' is passed, firing.",
+			offset:          time.Minute,
+			values:          map[string]float64{"CPUutilization1": 99, "CPUutilization2": 95, "FreeMemory": 50},
+			wantTransitions: []State{StateFiring},
+			wantStates: map[string]State{
+				"HighCPU/CPUutilization1":  StateFiring,
+				"HighCPU/CPUutilization2":  StatePending,
+				"LowFreeMemory/FreeMemory": StateFiring,
+			},
+		},
+		{
+			name:            "Step 4. Conditions are not met, firing is resolved, pending is removed.",
+			offset:          90 * time.Second,
+			values:          map[string]float64{"CPUutilization1": 10, "CPUutilization2": 10},
+			wantTransitions: []State{StateResolved, StateResolved},
+			wantStates: map[string]State{
+				"HighCPU/CPUutilization1": StateResolved, "LowFreeMemory/FreeMemory": StateResolved,
+			},
+		},
+		{
+			name:       "Step 5. Resolved alert is pending again.",
+			offset:     2 * time.Minute,
+			values:     map[string]float64{"CPUutilization1": 91},
+			wantStates: map[string]State{"HighCPU/CPUutilization1": StatePending, "LowFreeMemory/FreeMemory": StateResolved},
+		},
+		{
+			name:            "Step 6. Resolved alert is removed after retention.",
+			offset:          90*time.Second + resolvedRetention,
+			values:          map[string]float64{"CPUutilization1": 91},
+			wantTransitions: []State{StateFiring},
+			wantStates:      map[string]State{"HighCPU/CPUutilization1": StateFiring},
+		},
+	}
+	for _, st := range steps {
+		t.Run(st.name, func(t *testing.T) {
+			transitions := engine.Evaluate(gaugeMetrics(t, st.values), start.Add(st.offset))
+			var transitionStates []State
+			for _, alert := range transitions {
+				transitionStates = append(transitionStates, alert.State)
+			}
+			assert.Equal(t, st.wantTransitions, transitionStates)
+
+			gotStates := map[string]State{}
+			for _, alert := range engine.Alerts() {
+				gotStates[alert.Rule+"/"+alert.Metric] = alert.State
+			}
+			assert.Equal(t, st.wantStates, gotStates)
+		})
+	}
+}
+
+func TestEngine_Alerts(t *testing.T) {
+	now := time.Now()
+	engine := NewEngine([]Rule{{Name: "HighCPU", Metric: "CPU*", Op: OpGreater, Threshold: 90, For: time.Minute}})
+	engine.Evaluate(gaugeMetrics(t, map[string]float64{"CPU2": 95}), now.Add(-time.Minute))
+	engine.Evaluate(gaugeMetrics(t, map[string]float64{"CPU1": 95, "CPU2": 95}), now)
+
+	firedAt := now
+	assert.Equal(t, []Alert{
+		{
+			Rule: "HighCPU", Metric: "CPU1", State: StatePending, Value: 95,
+			Op: OpGreater, Threshold: 90, ActiveSince: now,
+		},
+		{
+			Rule: "HighCPU", Metric: "CPU2", State: StateFiring, Value: 95,
+			Op: OpGreater, Threshold: 90, ActiveSince: now.Add(-time.Minute), FiredAt: &firedAt,
+		},
+	}, engine.Alerts())
+	assert.Len(t, engine.Alerts(StateFiring), 1)
+	assert.Len(t, engine.Alerts(StateResolved), 0)
+
+	var nilEngine *Engine
+	assert.Equal(t, []Alert{}, nilEngine.Alerts())
+}
@@ -0,0 +1,127 @@
+// Package alerts реализует пороговые алерты по метрикам.
+// Правила загружаются из json файла и периодически проверяются по текущим значениям метрик.
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// ErrIncorrectRule ошибка "некорректное правило алерта".
+var ErrIncorrectRule = errors.New("incorrect alert rule")
+
+// Операторы сравнения значения метрики с порогом.
+const (
+	OpGreater        = ">"
+	OpGreaterOrEqual = ">="
+	OpLess           = "<"
+	OpLessOrEqual    = "<="
+	OpEqual          = "=="
+	OpNotEqual       = "!="
+)
+
+// Rule правило алерта: срабатывает, если значение метрики удовлетворяет условию дольше For.
+type Rule struct {
+	Name string
+	// Metric glob шаблон названия метрики, например CPUutilization*
+	Metric    string
+	Op        string
+	Threshold float64
+	For       time.Duration
+}
+
+// ruleConfig представление Rule в json файле правил.
+type ruleConfig struct {
+	Name      string  `json:"name"`
+	Metric    string  `json:"metric"`
+	Op        string  `json:"op"`
+	Threshold float64 `json:"threshold"`
+	For       string  `json:"for"`
+}
+
+// rulesConfig структура json файла правил.
+type rulesConfig struct {
+	Rules []ruleConfig `json:"rules"`
+}
+
+// LoadRules читает и проверяет правила алертов из json файла.
+func LoadRules(filePath string) ([]Rule, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := rulesConfig{}
+	if err = json.NewDecoder(f).Decode(&config); err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, 0, len(config.Rules))
+	names := map[string]bool{}
+	for _, rc := range config.Rules {
+		rule := Rule{Name: rc.Name, Metric: rc.Metric, Op: rc.Op, Threshold: rc.Threshold}
+		if rc.For != "" {
+			if rule.For, err = time.ParseDuration(rc.For); err != nil {
+				return nil, fmt.Errorf("%w '%s': %s", ErrIncorrectRule, rc.Name, err)
+			}
+		}
+		if err = rule.validate(); err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%w '%s': duplicate name", ErrIncorrectRule, rule.Name)
+		}
+		names[rule.Name] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// validate проверяет корректность правила.
+func (r Rule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrIncorrectRule)
+	}
+	if _, err := path.Match(r.Metric, ""); err != nil || r.Metric == "" {
+		return fmt.Errorf("%w '%s': incorrect metric pattern '%s'", ErrIncorrectRule, r.Name, r.Metric)
+	}
+	switch r.Op {
+	case OpGreater, OpGreaterOrEqual, OpLess, OpLessOrEqual, OpEqual, OpNotEqual:
+	default:
+		return fmt.Errorf("%w '%s': unknown op '%s'", ErrIncorrectRule, r.Name, r.Op)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("%w '%s': negative 'for'", ErrIncorrectRule, r.Name)
+	}
+	return nil
+}
+
+// matchMetric возвращает true, если название метрики подходит под шаблон правила.
+func (r Rule) matchMetric(name string) bool {
+	matched, _ := path.Match(r.Metric, name)
+	return matched
+}
+
+// check возвращает true, если значение удовлетворяет условию правила.
+func (r Rule) check(value float64) bool {
+	switch r.Op {
+	case OpGreater:
+		return value > r.Threshold
+	case OpGreaterOrEqual:
+		return value >= r.Threshold
+	case OpLess:
+		return value < r.Threshold
+	case OpLessOrEqual:
+		return value <= r.Threshold
+	case OpEqual:
+		return value == r.Threshold
+	case OpNotEqual:
+		return value != r.Threshold
+	}
+	return false
+}
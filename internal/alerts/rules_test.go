@@ -0,0 +1,93 @@
+package alerts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantRules []Rule
+		wantErr   error
+	}{
+		{
+			name: "Test 1. Correct rules.",
+			content: `{"rules":[` +
+				`{"name":"LowFreeMemory","metric":"FreeMemory","op":"<","threshold":1000,"for":"1m"},` +
+				`{"name":"HighCPU","metric":"CPUutilization*","op":">=","threshold":90}]}`,
+			wantRules: []Rule{
+				{Name: "LowFreeMemory", Metric: "FreeMemory", Op: OpLess, Threshold: 1000, For: time.Minute},
+				{Name: "HighCPU", Metric: "CPUutilization*", Op: OpGreaterOrEqual, Threshold: 90},
+			},
+		},
+		{
+			name:    "Test 2. Unknown op.",
+			content: `{"rules":[{"name":"HighCPU","metric":"CPUutilization*","op":"=>","threshold":90}]}`,
+			wantErr: ErrIncorrectRule,
+		},
+		{
+			name:    "Test 3. Incorrect metric pattern.",
+			content: `{"rules":[{"name":"HighCPU","metric":"CPU[","op":">","threshold":90}]}`,
+			wantErr: ErrIncorrectRule,
+		},
+		{
+			name:    "Test 4. Incorrect for.",
+			content: `{"rules":[{"name":"HighCPU","metric":"CPU*","op":">","threshold":90,"for":"minute"}]}`,
+			wantErr: ErrIncorrectRule,
+		},
+		{
+			name: "Test 5. Duplicate name.",
+			content: `{"rules":[{"name":"HighCPU","metric":"CPU*","op":">","threshold":90},` +
+				`{"name":"HighCPU","metric":"CPU*","op":">","threshold":95}]}`,
+			wantErr: ErrIncorrectRule,
+		},
+		{
+			name:    "Test 6. Name is not set.",
+			content: `{"rules":[{"metric":"CPU*","op":">","threshold":90}]}`,
+			wantErr: ErrIncorrectRule,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "rules.json")
+			require.NoError(t, os.WriteFile(filePath, []byte(tt.content), 0644))
+
+			rules, err := LoadRules(filePath)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantRules, rules)
+		})
+	}
+
+	_, err := LoadRules(filepath.Join(t.TempDir(), "not_exist.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestRule_check(t *testing.T) {
+	tests := []struct {
+		op    string
+		value float64
+		want  bool
+	}{
+		{op: OpGreater, value: 11, want: true},
+		{op: OpGreater, value: 10, want: false},
+		{op: OpGreaterOrEqual, value: 10, want: true},
+		{op: OpLess, value: 9, want: true},
+		{op: OpLess, value: 10, want: false},
+		{op: OpLessOrEqual, value: 10, want: true},
+		{op: OpEqual, value: 10, want: true},
+		{op: OpNotEqual, value: 10, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.op, func(t *testing.T) {
+			rule := Rule{Op: tt.op, Threshold: 10}
+			assert.Equal(t, tt.want, rule.check(tt.value))
+		})
+	}
+}
@@ -0,0 +1,148 @@
+// Package auth реализует аутентификацию по bearer токенам с правами(scope) доступа.
+// Токены хранятся в конфиге в виде хэшей: hex(sha256(токен)), например `echo -n <token> | sha256sum`.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Scope право доступа токена.
+type Scope string
+
+// Права доступа.
+const (
+	ScopeRead  Scope = "read"
+	ScopeWrite Scope = "write"
+	// ScopeAdmin включает в себя все остальные права.
+	ScopeAdmin Scope = "admin"
+)
+
+// Ошибки загрузки и проверки токенов.
+var (
+	ErrIncorrectToken = errors.New("incorrect token config")
+	ErrNoToken        = errors.New("bearer token is required")
+	ErrUnknownToken   = errors.New("unknown token")
+)
+
+// Token описание токена: имя(для логов), хэш и права доступа.
+type Token struct {
+	Name   string  `json:"name"`
+	Hash   string  `json:"hash"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// HasScope возвращает true, если у токена есть право scope(или admin).
+func (t Token) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenStore хранилище токенов по их хэшам.
+type TokenStore struct {
+	tokens map[string]Token
+}
+
+// NewTokenStore конструктор TokenStore.
+func NewTokenStore(tokens []Token) (*TokenStore, error) {
+	store := &TokenStore{tokens: map[string]Token{}}
+	for _, token := range tokens {
+		token.Hash = strings.ToLower(token.Hash)
+		if err := token.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := store.tokens[token.Hash]; ok {
+			return nil, fmt.Errorf("%w '%s': duplicate hash", ErrIncorrectToken, token.Name)
+		}
+		store.tokens[token.Hash] = token
+	}
+	return store, nil
+}
+
+// LoadTokens читает токены из json файла вида {"tokens": [{"name": ..., "hash": ..., "scopes": [...]}]}.
+func LoadTokens(filePath string) (*TokenStore, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := struct {
+		Tokens []Token `json:"tokens"`
+	}{}
+	if err = json.NewDecoder(f).Decode(&config); err != nil {
+		return nil, err
+	}
+	return NewTokenStore(config.Tokens)
+}
+
+// validate проверяет корректность описания токена.
+func (t Token) validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrIncorrectToken)
+	}
+	if hash, err := hex.DecodeString(t.Hash); err != nil || len(hash) != sha256.Size {
+		return fmt.Errorf("%w '%s': hash must be hex encoded sha256", ErrIncorrectToken, t.Name)
+	}
+	if len(t.Scopes) == 0 {
+		return fmt.Errorf("%w '%s': scopes are required", ErrIncorrectToken, t.Name)
+	}
+	for _, scope := range t.Scopes {
+		switch scope {
+		case ScopeRead, ScopeWrite, ScopeAdmin:
+		default:
+			return fmt.Errorf("%w '%s': unknown scope '%s'", ErrIncorrectToken, t.Name, scope)
+		}
+	}
+	return nil
+}
+
+// HashToken возвращает хэш токена в формате хранения.
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// Authenticate ищет токен по его значению.
+func (ts *TokenStore) Authenticate(token string) (Token, error) {
+	found, ok := ts.tokens[HashToken(token)]
+	if !ok {
+		return Token{}, ErrUnknownToken
+	}
+	return found, nil
+}
+
+// BearerToken возвращает токен из заголовка "Authorization: Bearer <token>".
+func BearerToken(request *http.Request) (string, error) {
+	header := request.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", ErrNoToken
+	}
+	return strings.TrimSpace(header[len(prefix):]), nil
+}
+
+// tokenContextKey ключ контекста запроса для аутентифицированного токена.
+type tokenContextKey struct{}
+
+// WithToken возвращает контекст с аутентифицированным токеном.
+func WithToken(ctx context.Context, token Token) context.Context {
+	return context.WithValue(ctx, tokenContextKey{}, token)
+}
+
+// TokenFromContext возвращает аутентифицированный токен из контекста запроса.
+func TokenFromContext(ctx context.Context) (Token, bool) {
+	token, ok := ctx.Value(tokenContextKey{}).(Token)
+	return token, ok
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashToken(t *testing.T) {
+	// echo -n secret | sha256sum
+	assert.Equal(t, "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b", HashToken("secret"))
+}
+
+func TestToken_HasScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes []Scope
+		scope  Scope
+		want   bool
+	}{
+		{name: "Test 1. Has scope.", scopes: []Scope{ScopeRead, ScopeWrite}, scope: ScopeWrite, want: true},
+		{name: "Test 2. Has no scope.", scopes: []Scope{ScopeRead}, scope: ScopeWrite, want: false},
+		{name: "Test 3. Admin has any scope.", scopes: []Scope{ScopeAdmin}, scope: ScopeWrite, want: true},
+		{name: "Test 4. Admin scope required.", scopes: []Scope{ScopeRead, ScopeWrite}, scope: ScopeAdmin, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Token{Scopes: tt.scopes}.HasScope(tt.scope))
+		})
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr error
+	}{
+		{
+			name: "Test 1. Correct tokens.",
+			content: `{"tokens":[{"name":"agent","hash":"` + HashToken("agent-token") + `","scopes":["write"]},` +
+				`{"name":"dashboard","hash":"` + HashToken("dashboard-token") + `","scopes":["read"]}]}`,
+		},
+		{
+			name:    "Test 2. Hash is not sha256.",
+			content: `{"tokens":[{"name":"agent","hash":"agent-token","scopes":["write"]}]}`,
+			wantErr: ErrIncorrectToken,
+		},
+		{
+			name:    "Test 3. Unknown scope.",
+			content: `{"tokens":[{"name":"agent","hash":"` + HashToken("agent-token") + `","scopes":["delete"]}]}`,
+			wantErr: ErrIncorrectToken,
+		},
+		{
+			name:    "Test 4. Scopes are not set.",
+			content: `{"tokens":[{"name":"agent","hash":"` + HashToken("agent-token") + `"}]}`,
+			wantErr: ErrIncorrectToken,
+		},
+		{
+			name: "Test 5. Duplicate hash.",
+			content: `{"tokens":[{"name":"agent","hash":"` + HashToken("agent-token") + `","scopes":["write"]},` +
+				`{"name":"agent2","hash":"` + HashToken("agent-token") + `","scopes":["read"]}]}`,
+			wantErr: ErrIncorrectToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "tokens.json")
+			require.NoError(t, os.WriteFile(filePath, []byte(tt.content), 0644))
+
+			store, err := LoadTokens(filePath)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr != nil {
+				return
+			}
+			token, err := store.Authenticate("agent-token")
+			require.NoError(t, err)
+			assert.Equal(t, "agent", token.Name)
+			_, err = store.Authenticate("wrong-token")
+			assert.ErrorIs(t, err, ErrUnknownToken)
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		wantToken string
+		wantErr   error
+	}{
+		{name: "Test 1. Correct header.", header: "Bearer abc", wantToken: "abc"},
+		{name: "Test 2. Scheme is case insensitive.", header: "bearer abc", wantToken: "abc"},
+		{name: "Test 3. Header is not set.", header: "", wantErr: ErrNoToken},
+		{name: "Test 4. Other scheme.", header: "Basic YWJjOmRlZg==", wantErr: ErrNoToken},
+		{name: "Test 5. Empty token.", header: "Bearer ", wantErr: ErrNoToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodGet, "/", nil)
+			require.NoError(t, err)
+			request.Header.Set("Authorization", tt.header)
+
+			token, err := BearerToken(request)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantToken, token)
+		})
+	}
+}
+
+func TestTokenFromContext(t *testing.T) {
+	_, ok := TokenFromContext(context.Background())
+	assert.False(t, ok)
+
+	want := Token{Name: "agent", Scopes: []Scope{ScopeWrite}}
+	got, ok := TokenFromContext(WithToken(context.Background(), want))
+	assert.True(t, ok)
+	assert.Equal(t, want, got)
+}
@@ -0,0 +1,69 @@
+// Package compression реализует сжатие тела запросов(Content-Encoding) кодеками gzip и zstd.
+// Используется агентом для сжатия отправляемых метрик и сервером для распаковки запросов.
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// поддерживаемые значения Content-Encoding.
+const (
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// ErrUnsupportedEncoding кодек не поддерживается.
+var ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// IsSupported проверяет, поддерживается ли кодек encoding.
+func IsSupported(encoding string) bool {
+	return encoding == Gzip || encoding == Zstd
+}
+
+// Compress сжимает data кодеком encoding.
+func Compress(encoding string, data []byte) ([]byte, error) {
+	var b bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case Gzip:
+		w = gzip.NewWriter(&b)
+	case Zstd:
+		zw, err := zstd.NewWriter(&b, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		w = zw
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// NewReader возвращает reader, распаковывающий r кодеком encoding.
+func NewReader(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+}
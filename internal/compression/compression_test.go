@@ -0,0 +1,89 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+// testBatch возвращает тело /updates/ из count метрик, похожее на отправляемое агентом.
+func testBatch(t testing.TB, count int) []byte {
+	metrics := make([]message.Metrics, 0, count)
+	for i := 0; i < count; i++ {
+		if i%2 == 0 {
+			value := rand.Float64() * 1e6
+			metrics = append(metrics, message.Metrics{ID: fmt.Sprintf("GaugeMetric%d", i), MType: "gauge", Value: &value})
+		} else {
+			delta := rand.Int63n(1000)
+			metrics = append(metrics, message.Metrics{ID: fmt.Sprintf("CounterMetric%d", i), MType: "counter", Delta: &delta})
+		}
+	}
+	body, err := json.Marshal(metrics)
+	require.NoError(t, err)
+	return body
+}
+
+func TestCompressNewReader(t *testing.T) {
+	data := testBatch(t, 40)
+
+	tests := []struct {
+		name     string
+		encoding string
+		wantErr  error
+	}{
+		{name: "Test 1. Gzip.", encoding: Gzip},
+		{name: "Test 2. Zstd.", encoding: Zstd},
+		{name: "Test 3. Unsupported encoding.", encoding: "br", wantErr: ErrUnsupportedEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantErr == nil, IsSupported(tt.encoding))
+
+			compressed, err := Compress(tt.encoding, data)
+			assert.ErrorIs(t, err, tt.wantErr)
+			_, err = NewReader(tt.encoding, bytes.NewReader(compressed))
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr != nil {
+				return
+			}
+			assert.Less(t, len(compressed), len(data))
+
+			r, err := NewReader(tt.encoding, bytes.NewReader(compressed))
+			require.NoError(t, err)
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, data, got)
+		})
+	}
+}
+
+// BenchmarkCompress сравнивает размер тела /updates/ после сжатия(compressed_bytes, ratio - доля от исходного).
+func BenchmarkCompress(b *testing.B) {
+	for _, count := range []int{10, 40, 500} {
+		data := testBatch(b, count)
+		for _, encoding := range []string{Gzip, Zstd} {
+			b.Run(fmt.Sprintf("%s/%d_metrics", encoding, count), func(b *testing.B) {
+				var compressed []byte
+				var err error
+				b.SetBytes(int64(len(data)))
+				for i := 0; i < b.N; i++ {
+					if compressed, err = Compress(encoding, data); err != nil {
+						b.Fatal(err)
+					}
+				}
+				b.ReportMetric(float64(len(data)), "raw_bytes")
+				b.ReportMetric(float64(len(compressed)), "compressed_bytes")
+				b.ReportMetric(float64(len(compressed))/float64(len(data)), "ratio")
+			})
+		}
+	}
+}
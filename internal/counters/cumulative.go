@@ -0,0 +1,120 @@
+package counters
+
+import "sync"
+
+// CumulativeConverter пересчитывает кумулятивные значения счетчиков(значение с момента старта источника) в дельты.
+// Последние значения хранятся отдельно по источнику(агенту) и названию счетчика.
+// Первое значение счетчика источника(в т.ч. после рестарта сервера) только запоминается, его дельта - 0:
+// предыдущее значение источника неизвестно, а сохраненное значение метрики - сумма дельт, а не значение источника.
+// Блокировка удерживается только на время обращения к последним значениям. Нулевое значение готово к использованию.
+type CumulativeConverter struct {
+	lastValues map[cumulativeKey]int64
+	mutex      sync.Mutex
+}
+
+// cumulativeKey ключ последнего значения: источник + название счетчика.
+type cumulativeKey struct {
+	source string
+	name   string
+}
+
+// observation пересчитанное в рамках CumulativeBatch значение и предыдущее значение(для отката).
+type observation struct {
+	key      cumulativeKey
+	value    int64
+	previous int64
+	known    bool
+}
+
+// CumulativeBatch пересчет значений одного запроса источника. Пересчитанные значения сразу запоминаются
+// как последние, Rollback(если метрики не были сохранены) восстанавливает предыдущие.
+type CumulativeBatch struct {
+	converter    *CumulativeConverter
+	source       string
+	observations []observation
+	done         bool
+}
+
+// Begin начинает пересчет значений запроса источника source.
+func (c *CumulativeConverter) Begin(source string) *CumulativeBatch {
+	return &CumulativeBatch{converter: c, source: source}
+}
+
+// ToDelta возвращает дельту между текущим(value) и предыдущим кумулятивным значением счетчика `name`.
+// Если предыдущее значение неизвестно - дельта 0. Если значение меньше предыдущего - считается,
+// что счетчик был сброшен(рестарт источника), и дельтой является само значение.
+func (b *CumulativeBatch) ToDelta(name string, value int64) int64 {
+	c := b.converter
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.lastValues == nil {
+		c.lastValues = map[cumulativeKey]int64{}
+	}
+
+	key := cumulativeKey{source: b.source, name: name}
+	lastValue, known := c.lastValues[key]
+	c.lastValues[key] = value
+	b.observations = append(b.observations, observation{key: key, value: value, previous: lastValue, known: known})
+	switch {
+	case !known:
+		return 0
+	case value < lastValue:
+		return value
+	default:
+		return value - lastValue
+	}
+}
+
+// Discard отменяет последнее пересчитанное значение, если это значение счетчика name(метрика не будет сохранена).
+// nil batch - игнорируется.
+func (b *CumulativeBatch) Discard(name string) {
+	if b == nil || len(b.observations) == 0 {
+		return
+	}
+	last := len(b.observations) - 1
+	if b.observations[last].key.name != name {
+		return
+	}
+
+	b.converter.mutex.Lock()
+	b.converter.restore(b.observations[last])
+	b.converter.mutex.Unlock()
+	b.observations = b.observations[:last]
+}
+
+// Commit завершает пересчет, пересчитанные значения остаются последними.
+// Вызывается после успешного сохранения метрик. nil batch(пересчет не выполнялся) - игнорируется.
+func (b *CumulativeBatch) Commit() {
+	if b == nil {
+		return
+	}
+	b.done = true
+}
+
+// Rollback завершает пересчет и восстанавливает предыдущие значения, если их не изменил другой запрос.
+// После Commit - ничего не делает.
+func (b *CumulativeBatch) Rollback() {
+	if b == nil || b.done {
+		return
+	}
+	b.done = true
+
+	b.converter.mutex.Lock()
+	defer b.converter.mutex.Unlock()
+	for i := len(b.observations) - 1; i >= 0; i-- {
+		b.converter.restore(b.observations[i])
+	}
+}
+
+// restore восстанавливает значение, предшествовавшее o, если последнее значение все еще o.value.
+// Вызывается под mutex.
+func (c *CumulativeConverter) restore(o observation) {
+	if current, ok := c.lastValues[o.key]; !ok || current != o.value {
+		return
+	}
+	if o.known {
+		c.lastValues[o.key] = o.previous
+	} else {
+		delete(c.lastValues, o.key)
+	}
+}
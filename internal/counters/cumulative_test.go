@@ -0,0 +1,125 @@
+package counters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCumulativeBatch_ToDelta(t *testing.T) {
+	type cumulativeValue struct {
+		source string
+		name   string
+		value  int64
+	}
+	tests := []struct {
+		name       string
+		values     []cumulativeValue
+		wantDeltas []int64
+	}{
+		{
+			name:       "Test 1. First value, previous is unknown.",
+			values:     []cumulativeValue{{name: "PollCount", value: 10}},
+			wantDeltas: []int64{0},
+		},
+		{
+			name: "Test 2. Growing counter.",
+			values: []cumulativeValue{
+				{name: "PollCount", value: 10},
+				{name: "PollCount", value: 15},
+				{name: "PollCount", value: 15},
+				{name: "PollCount", value: 40},
+			},
+			wantDeltas: []int64{0, 5, 0, 25},
+		},
+		{
+			name: "Test 3. Counter reset.",
+			values: []cumulativeValue{
+				{name: "PollCount", value: 10},
+				{name: "PollCount", value: 3},
+				{name: "PollCount", value: 7},
+			},
+			wantDeltas: []int64{0, 3, 4},
+		},
+		{
+			name: "Test 4. Different counters are independent.",
+			values: []cumulativeValue{
+				{name: "PollCount", value: 10},
+				{name: "Requests", value: 100},
+				{name: "PollCount", value: 12},
+				{name: "Requests", value: 150},
+			},
+			wantDeltas: []int64{0, 0, 2, 50},
+		},
+		{
+			name: "Test 5. Different sources are independent.",
+			values: []cumulativeValue{
+				{source: "agent1", name: "PollCount", value: 10},
+				{source: "agent2", name: "PollCount", value: 100},
+				{source: "agent1", name: "PollCount", value: 12},
+				{source: "agent2", name: "PollCount", value: 150},
+			},
+			wantDeltas: []int64{0, 0, 2, 50},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := CumulativeConverter{}
+			var gotDeltas []int64
+			// каждое значение - отдельный запрос
+			for _, v := range tt.values {
+				batch := c.Begin(v.source)
+				gotDeltas = append(gotDeltas, batch.ToDelta(v.name, v.value))
+				batch.Commit()
+			}
+			assert.Equal(t, tt.wantDeltas, gotDeltas)
+		})
+	}
+}
+
+func TestCumulativeBatch_Rollback(t *testing.T) {
+	c := CumulativeConverter{}
+
+	// значения внутри batch пересчитываются последовательно
+	batch := c.Begin("agent1")
+	assert.Equal(t, int64(0), batch.ToDelta("PollCount", 10))
+	assert.Equal(t, int64(15), batch.ToDelta("PollCount", 25))
+	batch.Commit()
+	batch.Rollback()
+
+	// без Commit значения восстанавливаются
+	batch = c.Begin("agent1")
+	assert.Equal(t, int64(475), batch.ToDelta("PollCount", 500))
+	assert.Equal(t, int64(0), batch.ToDelta("Requests", 500))
+	batch.Rollback()
+
+	// отмененное значение восстанавливается
+	batch = c.Begin("agent1")
+	assert.Equal(t, int64(475), batch.ToDelta("PollCount", 500))
+	batch.Discard("Requests")
+	batch.Discard("PollCount")
+	assert.Equal(t, int64(15), batch.ToDelta("PollCount", 40))
+	batch.Commit()
+
+	batch = c.Begin("agent1")
+	assert.Equal(t, int64(10), batch.ToDelta("PollCount", 50))
+	assert.Equal(t, int64(0), batch.ToDelta("Requests", 10))
+	batch.Commit()
+
+	// значение, измененное другим запросом, не восстанавливается
+	batch = c.Begin("agent1")
+	assert.Equal(t, int64(10), batch.ToDelta("PollCount", 60))
+	other := c.Begin("agent1")
+	assert.Equal(t, int64(10), other.ToDelta("PollCount", 70))
+	other.Commit()
+	batch.Rollback()
+	batch = c.Begin("agent1")
+	assert.Equal(t, int64(5), batch.ToDelta("PollCount", 75))
+	batch.Commit()
+
+	// nil batch(режим дельт) игнорируется
+	var nilBatch *CumulativeBatch
+	nilBatch.Discard("PollCount")
+	nilBatch.Commit()
+	nilBatch.Rollback()
+}
@@ -0,0 +1,10 @@
+// Package counters реализует серверную обработку counter метрик.
+/*
+В рамках модуля реализованы:
+1) Пересчет кумулятивных значений счетчиков в дельты по источнику(CumulativeConverter, CumulativeBatch),
+с обнаружением сброса счетчика и откатом значений, если метрики не были записаны(Rollback) -
+файл cumulative.go + тесты
+2) История полученных дельт счетчиков и вычисление скорости(в секунду) их роста за окно(History) -
+файл history.go + тесты
+*/
+package counters
@@ -0,0 +1,75 @@
+package counters
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrHistoryDisabled ошибка "история счетчиков не ведется"
+	ErrHistoryDisabled = errors.New("counter history is disabled")
+	// ErrWindowOutOfRange ошибка "окно вне диапазона хранимой истории"
+	ErrWindowOutOfRange = errors.New("window is out of stored history range")
+)
+
+// sample дельта счетчика, полученная в момент Time.
+type sample struct {
+	Time  time.Time
+	Delta int64
+}
+
+// History хранит полученные дельты счетчиков за последние Retention.
+type History struct {
+	samples   map[string][]sample
+	Retention time.Duration
+	mutex     sync.RWMutex
+}
+
+// NewHistory конструктор для History.
+// Если retention не больше нуля - возвращает nil(история не ведется).
+func NewHistory(retention time.Duration) *History {
+	if retention <= 0 {
+		return nil
+	}
+	return &History{Retention: retention, samples: map[string][]sample{}}
+}
+
+// Add добавляет дельту счетчика `name`, полученную в момент t. Дельты старше Retention удаляются.
+// Для nil History ничего не делает.
+func (h *History) Add(name string, delta int64, t time.Time) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	samples := append(h.samples[name], sample{Time: t, Delta: delta})
+	keepFrom := 0
+	for keepFrom < len(samples) && samples[keepFrom].Time.Before(t.Add(-h.Retention)) {
+		keepFrom++
+	}
+	h.samples[name] = samples[keepFrom:]
+}
+
+// Rate возвращает скорость роста(в секунду) счетчика `name` за окно window, заканчивающееся в момент now.
+// Скорость вычисляется как сумма дельт, полученных за окно, деленная на длительность окна.
+func (h *History) Rate(name string, window time.Duration, now time.Time) (float64, error) {
+	if h == nil {
+		return 0, ErrHistoryDisabled
+	}
+	if window <= 0 || window > h.Retention {
+		return 0, ErrWindowOutOfRange
+	}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	windowStart := now.Add(-window)
+	var increase int64
+	for _, s := range h.samples[name] {
+		if s.Time.After(windowStart) && !s.Time.After(now) {
+			increase += s.Delta
+		}
+	}
+	return float64(increase) / window.Seconds(), nil
+}
@@ -0,0 +1,102 @@
+package counters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHistory(t *testing.T) {
+	assert.Nil(t, NewHistory(0))
+	assert.Nil(t, NewHistory(-time.Second))
+	assert.Equal(t, time.Minute, NewHistory(time.Minute).Retention)
+}
+
+func TestHistory_Add(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := NewHistory(time.Minute)
+	h.Add("PollCount", 1, start)
+	h.Add("PollCount", 2, start.Add(30*time.Second))
+	h.Add("PollCount", 3, start.Add(90*time.Second))
+
+	// первая дельта старше Retention относительно последней и должна быть удалена
+	assert.Equal(t, []sample{
+		{Time: start.Add(30 * time.Second), Delta: 2},
+		{Time: start.Add(90 * time.Second), Delta: 3},
+	}, h.samples["PollCount"])
+
+	// для nil истории Add ничего не делает
+	var nilHistory *History
+	require.NotPanics(t, func() {
+		nilHistory.Add("PollCount", 1, start)
+	})
+}
+
+func TestHistory_Rate(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 10, 0, 0, time.UTC)
+	h := NewHistory(10 * time.Minute)
+	h.Add("PollCount", 60, now.Add(-5*time.Minute))
+	h.Add("PollCount", 30, now.Add(-30*time.Second))
+	h.Add("PollCount", 30, now.Add(-10*time.Second))
+
+	tests := []struct {
+		history    *History
+		wantErr    error
+		name       string
+		metricName string
+		window     time.Duration
+		wantRate   float64
+	}{
+		{
+			name:       "Test 1. Window contains part of samples.",
+			history:    h,
+			metricName: "PollCount",
+			window:     time.Minute,
+			wantRate:   1,
+		},
+		{
+			name:       "Test 2. Window contains all samples.",
+			history:    h,
+			metricName: "PollCount",
+			window:     10 * time.Minute,
+			wantRate:   0.2,
+		},
+		{
+			name:       "Test 3. Unknown counter.",
+			history:    h,
+			metricName: "Unknown",
+			window:     time.Minute,
+			wantRate:   0,
+		},
+		{
+			name:       "Test 4. Window greater than retention.",
+			history:    h,
+			metricName: "PollCount",
+			window:     time.Hour,
+			wantErr:    ErrWindowOutOfRange,
+		},
+		{
+			name:       "Test 5. Zero window.",
+			history:    h,
+			metricName: "PollCount",
+			window:     0,
+			wantErr:    ErrWindowOutOfRange,
+		},
+		{
+			name:       "Test 6. History is disabled.",
+			history:    nil,
+			metricName: "PollCount",
+			window:     time.Minute,
+			wantErr:    ErrHistoryDisabled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotRate, err := tt.history.Rate(tt.metricName, tt.window, now)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.InDelta(t, tt.wantRate, gotRate, 1e-9)
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package crypt
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/json"
 	"github.com/firesworder/devopsmetrics/internal"
 	"github.com/firesworder/devopsmetrics/internal/message"
@@ -35,6 +39,21 @@ func TestNewEncoder(t *testing.T) {
 			publicKeyFp: "test/empty_file.txt",
 			wantErr:     true,
 		},
+		{
+			name:        "Test 5. Public key in PKIX format.",
+			publicKeyFp: "test/publicKey_1_pkix_test.pem",
+			wantErr:     false,
+		},
+		{
+			name:        "Test 6. X.509 certificate.",
+			publicKeyFp: "test/certificate_1_test.pem",
+			wantErr:     false,
+		},
+		{
+			name:        "Test 7. Not RSA key.",
+			publicKeyFp: "../tlsconfig/test/server_test.pem",
+			wantErr:     true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -70,6 +89,16 @@ func TestNewDecoder(t *testing.T) {
 			privateKeyFp: "test/empty_file.txt",
 			wantErr:      true,
 		},
+		{
+			name:         "Test 5. Private key in PKCS#8 format.",
+			privateKeyFp: "test/privateKey_1_pkcs8_test.pem",
+			wantErr:      false,
+		},
+		{
+			name:         "Test 6. Not RSA key in PKCS#8 format.",
+			privateKeyFp: "../tlsconfig/test/server_key_test.pem",
+			wantErr:      true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -137,3 +166,49 @@ func TestEncodeDecode(t *testing.T) {
 		})
 	}
 }
+
+func TestEncodeDecodeMessageSize(t *testing.T) {
+	encoder, err := NewEncoder("test/publicKey_1_test.pem")
+	require.NoError(t, err)
+	decoder, err := NewDecoder("test/privateKey_1_test.pem")
+	require.NoError(t, err)
+	keySize := encoder.publicKey.Size()
+
+	tests := []struct {
+		name string
+		msg  []byte
+	}{
+		{name: "Test 1. Empty message.", msg: nil},
+		{name: "Test 2. Message larger than key.", msg: bytes.Repeat([]byte("a"), 10*keySize)},
+		{name: "Test 3. Message of key size.", msg: bytes.Repeat([]byte("b"), keySize)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encodedMsg, err := encoder.Encode(tt.msg)
+			require.NoError(t, err)
+			gotMsg, err := decoder.Decode(encodedMsg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.msg, gotMsg)
+		})
+	}
+
+	t.Run("Test 4. Message encrypted only with RSA OAEP.", func(t *testing.T) {
+		msg := []byte(`{"id":"PollCount","type":"counter","delta":10}`)
+		encodedMsg, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, encoder.publicKey, msg, nil)
+		require.NoError(t, err)
+		gotMsg, err := decoder.Decode(encodedMsg)
+		require.NoError(t, err)
+		assert.Equal(t, msg, gotMsg)
+	})
+
+	t.Run("Test 5. Truncated message is decryption error.", func(t *testing.T) {
+		encodedMsg, err := encoder.Encode([]byte("message"))
+		require.NoError(t, err)
+		_, err = decoder.Decode(encodedMsg[:keySize-1])
+		assert.ErrorIs(t, err, rsa.ErrDecryption)
+		_, err = decoder.Decode(encodedMsg[:keySize+1])
+		assert.ErrorIs(t, err, rsa.ErrDecryption)
+		_, err = decoder.Decode(encodedMsg[:len(encodedMsg)-1])
+		assert.ErrorIs(t, err, rsa.ErrDecryption)
+	})
+}
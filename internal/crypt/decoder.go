@@ -4,8 +4,6 @@ import (
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
-	"crypto/x509"
-	"encoding/pem"
 	"fmt"
 	"os"
 )
@@ -16,7 +14,7 @@ type Decoder struct {
 }
 
 // NewDecoder конструктор типа Decoder.
-// На вход ожидается путь к файлу приватного ключа в pem-формате.
+// На вход ожидается путь к файлу приватного ключа в pem-формате(PKCS#1 или PKCS#8).
 func NewDecoder(privateKeyFp string) (*Decoder, error) {
 	d := &Decoder{}
 
@@ -26,14 +24,8 @@ func NewDecoder(privateKeyFp string) (*Decoder, error) {
 		return nil, err
 	}
 
-	// декодировать pem формат приватного ключа
-	block, _ := pem.Decode(content)
-	if block == nil {
-		return nil, fmt.Errorf("private key is empty")
-	}
-
-	// парсим приватный ключ
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	// парсим приватный ключ(PKCS#1 или PKCS#8)
+	privateKey, _, err := ParsePrivateKey(content)
 	if err != nil {
 		return nil, err
 	}
@@ -42,12 +34,46 @@ func NewDecoder(privateKeyFp string) (*Decoder, error) {
 	return d, nil
 }
 
-// Decode дешифрует сообщение посредством RSA OAEP.
+// KeyID возвращает идентификатор ключа, см. KeyID.
+func (d *Decoder) KeyID() string {
+	return KeyID(&d.privateKey.PublicKey)
+}
+
+// PublicKey возвращает публичный ключ, парный приватному ключу декодера.
+func (d *Decoder) PublicKey() *rsa.PublicKey {
+	return &d.privateKey.PublicKey
+}
+
+// Decode дешифрует сообщение, зашифрованное Encoder.Encode(RSA OAEP ключ + AES-GCM сообщение).
+// Сообщение размером ровно с RSA ключ дешифруется целиком посредством RSA OAEP(формат до гибридного шифрования).
+// Любая ошибка расшифровки(в т.ч. незашифрованное сообщение) оборачивает rsa.ErrDecryption.
 func (d *Decoder) Decode(encryptedMsg []byte) ([]byte, error) {
-	// шифрование сообщения
-	msg, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, d.privateKey, encryptedMsg, nil)
+	keySize := d.privateKey.Size()
+	if len(encryptedMsg) == keySize {
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, d.privateKey, encryptedMsg, nil)
+	}
+	if len(encryptedMsg) < keySize {
+		return nil, fmt.Errorf("%w: message is shorter than key", rsa.ErrDecryption)
+	}
+
+	// дешифрование ключа сообщения
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, d.privateKey, encryptedMsg[:keySize], nil)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(sessionKey)
 	if err != nil {
 		return nil, err
 	}
+	// дешифрование сообщения
+	encryptedMsg = encryptedMsg[keySize:]
+	if len(encryptedMsg) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: message is shorter than nonce", rsa.ErrDecryption)
+	}
+	nonce, encryptedMsg := encryptedMsg[:gcm.NonceSize()], encryptedMsg[gcm.NonceSize():]
+	msg, err := gcm.Open(nil, nonce, encryptedMsg, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", rsa.ErrDecryption, err)
+	}
 	return msg, nil
 }
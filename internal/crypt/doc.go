@@ -1,3 +1,4 @@
 // Package crypt реализует шифрование\дешифрование сообщения методом RSA с использованием публичного\приватного
-// ключа соответственно.
+// ключа соответственно(гибридно: сообщение шифруется AES-GCM, его ключ - RSA).
+// Сервер может хранить несколько приватных ключей(KeyRing), ключ выбирается по его id.
 package crypt
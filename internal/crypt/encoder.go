@@ -1,54 +1,80 @@
 package crypt
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
-	"crypto/x509"
-	"encoding/pem"
-	"fmt"
 	"os"
 )
 
+// sessionKeySize размер(байт) случайного AES-256 ключа, которым шифруется сообщение.
+const sessionKeySize = 32
+
 // Encoder RSA энкодер, при помощи прив.ключа шифрует сообщения.
 type Encoder struct {
 	publicKey *rsa.PublicKey
 }
 
 // NewEncoder конструктор типа Encoder.
-// На вход ожидается путь к файлу публичного ключа в pem-формате.
+// На вход ожидается путь к файлу публичного ключа в pem-формате(PKCS#1, PKIX или X.509 сертификат).
 func NewEncoder(publicKeyFp string) (*Encoder, error) {
-	e := &Encoder{}
-
 	// Получить публичный ключ
 	content, err := os.ReadFile(publicKeyFp)
 	if err != nil {
 		return nil, err
 	}
+	return NewEncoderFromPEM(content)
+}
 
-	// декодировать pem формат публичного ключа
-	block, _ := pem.Decode(content)
-	if block == nil {
-		return nil, fmt.Errorf("certificate block was not found")
-	}
-
-	// парсим публичный ключ
-	publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+// NewEncoderFromPEM конструктор типа Encoder по содержимому pem-файла публичного ключа.
+func NewEncoderFromPEM(content []byte) (*Encoder, error) {
+	// парсим публичный ключ(PKCS#1, PKIX или X.509 сертификат)
+	publicKey, _, err := ParsePublicKey(content)
 	if err != nil {
 		return nil, err
 	}
-	e.publicKey = publicKey
+	return &Encoder{publicKey: publicKey}, nil
+}
 
-	return e, nil
+// KeyID возвращает идентификатор ключа, см. KeyID.
+// Передается серверу в заголовке KeyIDHeader, чтобы сервер выбрал нужный приватный ключ.
+func (e *Encoder) KeyID() string {
+	return KeyID(e.publicKey)
 }
 
-// Encode шифрует сообщение посредством RSA OAEP.
+// Encode шифрует сообщение гибридно: сообщение - AES-GCM случайным ключом, ключ - RSA OAEP.
+// Результат: зашифрованный ключ(размер RSA ключа), nonce и зашифрованное сообщение, размер сообщения не ограничен.
 func (e *Encoder) Encode(message []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeySize)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+	// шифрование ключа сообщения
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, e.publicKey, sessionKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
 	// шифрование сообщения
-	encryptedMsg, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, e.publicKey, message, nil)
+	gcm, err := newGCM(sessionKey)
 	if err != nil {
 		return nil, err
 	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	encryptedMsg := append(encryptedKey, nonce...)
+	return gcm.Seal(encryptedMsg, nonce, message, nil), nil
+}
 
-	return encryptedMsg, nil
+// newGCM возвращает AES-GCM шифр для ключа key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
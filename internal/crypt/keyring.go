@@ -0,0 +1,148 @@
+package crypt
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KeyIDHeader заголовок запроса, в котором агент передает идентификатор ключа шифрования.
+const KeyIDHeader = "X-Key-ID"
+
+// keyIDSize размер(в байтах) идентификатора ключа.
+const keyIDSize = 8
+
+// ErrUnknownKeyID ключ с переданным идентификатором не загружен.
+var ErrUnknownKeyID = errors.New("unknown key id")
+
+// ErrNoKeys не найдено ни одного приватного ключа.
+var ErrNoKeys = errors.New("no private keys found")
+
+// KeyID возвращает идентификатор ключа: первые 8 байт sha256 от публичного ключа в формате PKCS#1, в hex.
+func KeyID(publicKey *rsa.PublicKey) string {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(publicKey))
+	return hex.EncodeToString(sum[:keyIDSize])
+}
+
+// KeyRing набор приватных ключей сервера, позволяет менять ключи без одновременного перезапуска агентов.
+// Ключ выбирается по идентификатору, переданному агентом(см. KeyIDHeader).
+type KeyRing struct {
+	paths    []string
+	decoders map[string]*Decoder
+	keyIDs   []string
+	mutex    sync.RWMutex
+}
+
+// NewKeyRing конструктор типа KeyRing.
+// На вход ожидаются пути к файлам приватных ключей в pem-формате или к директориям с ними(*.pem).
+func NewKeyRing(paths ...string) (*KeyRing, error) {
+	k := &KeyRing{paths: paths}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload перечитывает ключи по путям, переданным в NewKeyRing.
+// При ошибке загруженные ранее ключи остаются без изменений.
+func (k *KeyRing) Reload() error {
+	var files []string
+	for _, path := range k.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		dirFiles, err := filepath.Glob(filepath.Join(path, "*.pem"))
+		if err != nil {
+			return err
+		}
+		// в директории рядом с приватными могут лежать публичные ключи, их пропускаю
+		for _, file := range dirFiles {
+			isPrivate, err := isPrivateKeyFile(file)
+			if err != nil {
+				return err
+			}
+			if isPrivate {
+				files = append(files, file)
+			}
+		}
+	}
+
+	decoders := map[string]*Decoder{}
+	keyIDs := make([]string, 0, len(files))
+	for _, file := range files {
+		decoder, err := NewDecoder(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		keyID := decoder.KeyID()
+		if _, ok := decoders[keyID]; !ok {
+			keyIDs = append(keyIDs, keyID)
+		}
+		decoders[keyID] = decoder
+	}
+	if len(decoders) == 0 {
+		return ErrNoKeys
+	}
+	sort.Strings(keyIDs)
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.decoders, k.keyIDs = decoders, keyIDs
+	return nil
+}
+
+// isPrivateKeyFile возвращает true, если pem блок файла содержит приватный ключ.
+func isPrivateKeyFile(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	block, _ := pem.Decode(content)
+	return block != nil && strings.HasSuffix(block.Type, "PRIVATE KEY"), nil
+}
+
+// KeyIDs возвращает отсортированный список идентификаторов загруженных ключей.
+func (k *KeyRing) KeyIDs() []string {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return append([]string{}, k.keyIDs...)
+}
+
+// Decode дешифрует сообщение ключом с идентификатором keyID.
+// Если keyID не передан(агенты старых версий) - пробует все загруженные ключи по очереди.
+func (k *KeyRing) Decode(keyID string, encryptedMsg []byte) ([]byte, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if keyID != "" {
+		decoder, ok := k.decoders[keyID]
+		if !ok {
+			return nil, fmt.Errorf("%w '%s'", ErrUnknownKeyID, keyID)
+		}
+		return decoder.Decode(encryptedMsg)
+	}
+
+	err := rsa.ErrDecryption
+	for _, id := range k.keyIDs {
+		var msg []byte
+		msg, err = k.decoders[id].Decode(encryptedMsg)
+		if err == nil {
+			return msg, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,129 @@
+package crypt
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Форматы ключей в pem-файлах.
+const (
+	// FormatPKCS1 приватный или публичный RSA ключ в формате PKCS#1("RSA PRIVATE KEY", "RSA PUBLIC KEY").
+	FormatPKCS1 = "pkcs1"
+	// FormatPKCS8 приватный ключ в формате PKCS#8("PRIVATE KEY").
+	FormatPKCS8 = "pkcs8"
+	// FormatPKIX публичный ключ в формате PKIX("PUBLIC KEY").
+	FormatPKIX = "pkix"
+	// FormatX509 публичный ключ из X.509 сертификата("CERTIFICATE").
+	FormatX509 = "x509"
+)
+
+// ErrNotRSAKey ключ не является RSA ключом.
+var ErrNotRSAKey = errors.New("key is not rsa key")
+
+// ErrUnknownFormat неизвестный формат ключа.
+var ErrUnknownFormat = errors.New("unknown key format")
+
+// ParsePrivateKey разбирает приватный RSA ключ в pem-формате: PKCS#1 или PKCS#8.
+// Возвращает ключ и формат, в котором он был записан.
+func ParsePrivateKey(content []byte) (*rsa.PrivateKey, string, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, "", fmt.Errorf("private key is empty")
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, FormatPKCS1, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot parse private key('%s') as pkcs1 or pkcs8: %w", block.Type, err)
+	}
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %T", ErrNotRSAKey, key)
+	}
+	return privateKey, FormatPKCS8, nil
+}
+
+// ParsePublicKey разбирает публичный RSA ключ в pem-формате: PKCS#1, PKIX или X.509 сертификат.
+// Возвращает ключ и формат, в котором он был записан.
+func ParsePublicKey(content []byte) (*rsa.PublicKey, string, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, "", fmt.Errorf("certificate block was not found")
+	}
+
+	if publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return publicKey, FormatPKCS1, nil
+	}
+
+	format := FormatPKIX
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, "", fmt.Errorf("cannot parse public key('%s') as pkcs1, pkix or x509: %w", block.Type, err)
+		}
+		key, format = cert.PublicKey, FormatX509
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %T", ErrNotRSAKey, key)
+	}
+	return publicKey, format, nil
+}
+
+// MarshalPrivateKey записывает приватный ключ в pem-формате: FormatPKCS1 или FormatPKCS8.
+func MarshalPrivateKey(privateKey *rsa.PrivateKey, format string) ([]byte, error) {
+	switch format {
+	case FormatPKCS1:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}), nil
+	case FormatPKCS8:
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	default:
+		return nil, fmt.Errorf("%w '%s' for private key", ErrUnknownFormat, format)
+	}
+}
+
+// MarshalPublicKey записывает публичный ключ в pem-формате: FormatPKCS1 или FormatPKIX.
+func MarshalPublicKey(publicKey *rsa.PublicKey, format string) ([]byte, error) {
+	switch format {
+	case FormatPKCS1:
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(publicKey)}), nil
+	case FormatPKIX:
+		der, err := x509.MarshalPKIXPublicKey(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+	default:
+		return nil, fmt.Errorf("%w '%s' for public key", ErrUnknownFormat, format)
+	}
+}
+
+// Fingerprint возвращает отпечаток ключа: sha256 от публичного ключа в формате PKIX(DER), байты через ':'.
+// Совпадает с `openssl pkey -pubin -outform DER | sha256sum`.
+func Fingerprint(publicKey *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	hexSum := hex.EncodeToString(sum[:])
+	pairs := make([]string, 0, len(sum))
+	for i := 0; i < len(hexSum); i += 2 {
+		pairs = append(pairs, hexSum[i:i+2])
+	}
+	return "SHA256:" + strings.Join(pairs, ":"), nil
+}
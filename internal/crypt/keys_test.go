@@ -0,0 +1,123 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		fp         string
+		wantFormat string
+		wantErr    error
+	}{
+		{name: "Test 1. PKCS#1.", fp: "test/privateKey_1_test.pem", wantFormat: FormatPKCS1},
+		{name: "Test 2. PKCS#8.", fp: "test/privateKey_1_pkcs8_test.pem", wantFormat: FormatPKCS8},
+		{name: "Test 3. ECDSA key in PKCS#8.", fp: "../tlsconfig/test/server_key_test.pem", wantErr: ErrNotRSAKey},
+	}
+	wantKey, _, err := ParsePrivateKey(readTestFile(t, "test/privateKey_1_test.pem"))
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, format, err := ParsePrivateKey(readTestFile(t, tt.fp))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFormat, format)
+			assert.True(t, wantKey.Equal(key))
+		})
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		fp         string
+		wantFormat string
+		wantErr    error
+	}{
+		{name: "Test 1. PKCS#1.", fp: "test/publicKey_1_test.pem", wantFormat: FormatPKCS1},
+		{name: "Test 2. PKIX.", fp: "test/publicKey_1_pkix_test.pem", wantFormat: FormatPKIX},
+		{name: "Test 3. X.509 certificate.", fp: "test/certificate_1_test.pem", wantFormat: FormatX509},
+		{name: "Test 4. ECDSA certificate.", fp: "../tlsconfig/test/server_test.pem", wantErr: ErrNotRSAKey},
+	}
+	wantKey, _, err := ParsePublicKey(readTestFile(t, "test/publicKey_1_test.pem"))
+	require.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, format, err := ParsePublicKey(readTestFile(t, tt.fp))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantFormat, format)
+			assert.True(t, wantKey.Equal(key))
+		})
+	}
+}
+
+func TestMarshalKeys(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	for _, format := range []string{FormatPKCS1, FormatPKCS8} {
+		content, err := MarshalPrivateKey(privateKey, format)
+		require.NoError(t, err)
+		gotKey, gotFormat, err := ParsePrivateKey(content)
+		require.NoError(t, err)
+		assert.Equal(t, format, gotFormat)
+		assert.True(t, privateKey.Equal(gotKey))
+	}
+	_, err = MarshalPrivateKey(privateKey, FormatPKIX)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+
+	for _, format := range []string{FormatPKCS1, FormatPKIX} {
+		content, err := MarshalPublicKey(&privateKey.PublicKey, format)
+		require.NoError(t, err)
+		gotKey, gotFormat, err := ParsePublicKey(content)
+		require.NoError(t, err)
+		assert.Equal(t, format, gotFormat)
+		assert.True(t, privateKey.PublicKey.Equal(gotKey))
+	}
+	_, err = MarshalPublicKey(&privateKey.PublicKey, FormatPKCS8)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestFingerprint(t *testing.T) {
+	pkcs1Key, _, err := ParsePublicKey(readTestFile(t, "test/publicKey_1_test.pem"))
+	require.NoError(t, err)
+	certKey, _, err := ParsePublicKey(readTestFile(t, "test/certificate_1_test.pem"))
+	require.NoError(t, err)
+	otherKey, _, err := ParsePublicKey(readTestFile(t, "test/publicKey_2_test.pem"))
+	require.NoError(t, err)
+
+	fingerprint, err := Fingerprint(pkcs1Key)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(fingerprint, "SHA256:"))
+	assert.Len(t, fingerprint, len("SHA256:")+32*3-1)
+
+	certFingerprint, err := Fingerprint(certKey)
+	require.NoError(t, err)
+	assert.Equal(t, fingerprint, certFingerprint)
+
+	otherFingerprint, err := Fingerprint(otherKey)
+	require.NoError(t, err)
+	assert.NotEqual(t, fingerprint, otherFingerprint)
+}
+
+// readTestFile читает тестовый файл.
+func readTestFile(t *testing.T, fp string) []byte {
+	content, err := os.ReadFile(fp)
+	require.NoError(t, err)
+	return content
+}
@@ -0,0 +1,76 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Заголовки ответа сервера, подписанного и\или зашифрованного для клиента.
+const (
+	// SignatureHeader подпись ответа(RSA-PSS, sha256, см. ResponseSignatureMessage) в base64, до шифрования.
+	SignatureHeader = "X-Response-Signature"
+	// ClientKeyIDHeader id зарегистрированного ключа клиента: в запросе - зашифровать ответ этим ключом,
+	// в ответе - ответ зашифрован этим ключом.
+	ClientKeyIDHeader = "X-Client-Key-ID"
+	// ResponseNonceHeader случайный nonce запроса, который сервер включает в подпись ответа.
+	ResponseNonceHeader = "X-Response-Nonce"
+)
+
+// responseNonceSize размер(в байтах) случайного nonce запроса.
+const responseNonceSize = 16
+
+// NewResponseNonce возвращает случайный nonce запроса(hex) для заголовка ResponseNonceHeader.
+func NewResponseNonce() (string, error) {
+	nonce := make([]byte, responseNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(nonce), nil
+}
+
+// ResponseSignatureMessage возвращает подписываемое сообщение ответа: метод и путь(с query) запроса,
+// nonce запроса(ResponseNonceHeader) и тело ответа. Подпись ответа нельзя использовать для ответа
+// на другой или повторный запрос.
+func ResponseSignatureMessage(method, target, nonce string, body []byte) []byte {
+	var msg bytes.Buffer
+	msg.WriteString(method + "\n" + target + "\n" + nonce + "\n")
+	msg.Write(body)
+	return msg.Bytes()
+}
+
+// Sign подписывает сообщение приватным ключом декодера(RSA-PSS, sha256).
+func (d *Decoder) Sign(msg []byte) ([]byte, error) {
+	hashed := sha256.Sum256(msg)
+	return rsa.SignPSS(rand.Reader, d.privateKey, crypto.SHA256, hashed[:], nil)
+}
+
+// Verify проверяет подпись сообщения, сделанную парным приватным ключом(см. Decoder.Sign).
+func (e *Encoder) Verify(msg, signature []byte) error {
+	hashed := sha256.Sum256(msg)
+	return rsa.VerifyPSS(e.publicKey, crypto.SHA256, hashed[:], signature, nil)
+}
+
+// Sign подписывает сообщение ключом с идентификатором keyID, если keyID не передан - первым из KeyIDs.
+// Возвращает подпись и id использованного ключа.
+func (k *KeyRing) Sign(keyID string, msg []byte) ([]byte, string, error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+
+	if keyID == "" {
+		keyID = k.keyIDs[0]
+	}
+	decoder, ok := k.decoders[keyID]
+	if !ok {
+		return nil, "", fmt.Errorf("%w '%s'", ErrUnknownKeyID, keyID)
+	}
+	signature, err := decoder.Sign(msg)
+	if err != nil {
+		return nil, "", err
+	}
+	return signature, keyID, nil
+}
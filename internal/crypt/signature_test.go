@@ -0,0 +1,72 @@
+package crypt
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignVerify(t *testing.T) {
+	msg := []byte(`{"id":"PollCount","type":"counter","delta":10}`)
+	decoder1, err := NewDecoder("test/privateKey_1_test.pem")
+	require.NoError(t, err)
+	encoder1, err := NewEncoder("test/publicKey_1_test.pem")
+	require.NoError(t, err)
+	encoder2, err := NewEncoder("test/publicKey_2_test.pem")
+	require.NoError(t, err)
+
+	signature, err := decoder1.Sign(msg)
+	require.NoError(t, err)
+	assert.NoError(t, encoder1.Verify(msg, signature))
+	assert.Error(t, encoder2.Verify(msg, signature))
+	assert.Error(t, encoder1.Verify([]byte(`{"id":"PollCount","type":"counter","delta":11}`), signature))
+}
+
+func TestResponseSignatureMessage(t *testing.T) {
+	body := []byte("10")
+	msg := ResponseSignatureMessage("GET", "/value/counter/PollCount", "0011", body)
+	assert.Equal(t, "GET\n/value/counter/PollCount\n0011\n10", string(msg))
+	assert.NotEqual(t, msg, ResponseSignatureMessage("GET", "/value/counter/Requests", "0011", body))
+	assert.NotEqual(t, msg, ResponseSignatureMessage("GET", "/value/counter/PollCount", "0012", body))
+
+	nonce1, err := NewResponseNonce()
+	require.NoError(t, err)
+	nonce2, err := NewResponseNonce()
+	require.NoError(t, err)
+	assert.Len(t, nonce1, 2*responseNonceSize)
+	assert.NotEqual(t, nonce1, nonce2)
+}
+
+func TestKeyRing_Sign(t *testing.T) {
+	msg := []byte(`{"id":"PollCount","type":"counter","delta":10}`)
+	encoder1, err := NewEncoder("test/publicKey_1_test.pem")
+	require.NoError(t, err)
+	encoder2, err := NewEncoder("test/publicKey_2_test.pem")
+	require.NoError(t, err)
+	keyRing, err := NewKeyRing("test/privateKey_1_test.pem", "test/privateKey_2_test.pem")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		keyID      string
+		wantKeyID  string
+		wantVerify *Encoder
+		wantErr    bool
+	}{
+		{name: "Test 1. Key 1.", keyID: encoder1.KeyID(), wantKeyID: encoder1.KeyID(), wantVerify: encoder1},
+		{name: "Test 2. Key 2.", keyID: encoder2.KeyID(), wantKeyID: encoder2.KeyID(), wantVerify: encoder2},
+		{name: "Test 3. Key id is not set, first key.", keyID: "", wantKeyID: keyRing.KeyIDs()[0]},
+		{name: "Test 4. Unknown key id.", keyID: "0000000000000000", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signature, keyID, err := keyRing.Sign(tt.keyID, msg)
+			require.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantKeyID, keyID)
+			if tt.wantVerify != nil {
+				assert.NoError(t, tt.wantVerify.Verify(msg, signature))
+			}
+		})
+	}
+}
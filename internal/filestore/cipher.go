@@ -0,0 +1,129 @@
+package filestore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Заголовок зашифрованного файла: encryptedMagic + версия формата, далее nonce и шифротекст AES-GCM.
+// Заголовок аутентифицируется вместе с данными(additional data), его подмена обнаруживается при чтении.
+var encryptedMagic = []byte("DMFSENC")
+
+// encryptionVersion текущая версия формата шифрования.
+const encryptionVersion byte = 1
+
+// ErrNoKey файл зашифрован, а ключ шифрования не задан.
+var ErrNoKey = errors.New("store file is encrypted, but key is not set")
+
+// ErrIncorrectKey ключ шифрования задан неверно.
+var ErrIncorrectKey = errors.New("incorrect store key, want 16, 24 or 32 bytes in hex or base64")
+
+// ErrUnsupportedVersion версия формата зашифрованного файла не поддерживается.
+var ErrUnsupportedVersion = errors.New("unsupported store file encryption version")
+
+// ErrCorruptedFile зашифрованный файл поврежден или ключ не подходит.
+var ErrCorruptedFile = errors.New("store file is corrupted or key does not match")
+
+// Cipher шифрует\расшифровывает содержимое файла хранилища посредством AES-GCM.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher конструктор типа Cipher. key - ключ AES длиной 16, 24 или 32 байта.
+func NewCipher(key []byte) (*Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrIncorrectKey, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// ParseKey разбирает ключ, записанный в hex или base64.
+func ParseKey(encodedKey string) ([]byte, error) {
+	encodedKey = strings.TrimSpace(encodedKey)
+	key, err := hex.DecodeString(encodedKey)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, ErrIncorrectKey
+		}
+	}
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, ErrIncorrectKey
+	}
+}
+
+// NewCipherByEnv создает Cipher по ключу из переменной key или, если она пустая, из файла keyFile.
+// Если не задано ни то, ни другое - возвращает nil(шифрование отключено).
+func NewCipherByEnv(key, keyFile string) (*Cipher, error) {
+	if key == "" && keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = string(content)
+	}
+	if key == "" {
+		return nil, nil
+	}
+
+	parsedKey, err := ParseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewCipher(parsedKey)
+}
+
+// IsEncrypted возвращает true, если данные начинаются с заголовка зашифрованного файла.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
+
+// Encrypt шифрует данные, результат начинается с заголовка текущей версии.
+func (c *Cipher) Encrypt(plain []byte) ([]byte, error) {
+	header := append(append([]byte{}, encryptedMagic...), encryptionVersion)
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	result := append(header, nonce...)
+	return c.aead.Seal(result, nonce, plain, header), nil
+}
+
+// Decrypt расшифровывает данные, зашифрованные Encrypt.
+func (c *Cipher) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) || len(data) < len(encryptedMagic)+1 {
+		return nil, ErrCorruptedFile
+	}
+	headerSize := len(encryptedMagic) + 1
+	if version := data[headerSize-1]; version != encryptionVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	header, data := data[:headerSize], data[headerSize:]
+
+	nonceSize := c.aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, ErrCorruptedFile
+	}
+	plain, err := c.aead.Open(nil, data[:nonceSize], data[nonceSize:], header)
+	if err != nil {
+		return nil, ErrCorruptedFile
+	}
+	return plain, nil
+}
@@ -0,0 +1,139 @@
+package filestore
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		encodedKey string
+		want       []byte
+		wantErr    bool
+	}{
+		{name: "Test 1. Hex key.", encodedKey: hex.EncodeToString(testKey), want: testKey},
+		{name: "Test 2. Base64 key.", encodedKey: base64.StdEncoding.EncodeToString(testKey), want: testKey},
+		{name: "Test 3. Key with new line.", encodedKey: hex.EncodeToString(testKey) + "\n", want: testKey},
+		{name: "Test 4. 16 byte key.", encodedKey: hex.EncodeToString(testKey[:16]), want: testKey[:16]},
+		{name: "Test 5. Incorrect key length.", encodedKey: hex.EncodeToString(testKey[:10]), wantErr: true},
+		{name: "Test 6. Not encoded key.", encodedKey: "not a key!", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKey(tt.encodedKey)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewCipherByEnv(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "store.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte(hex.EncodeToString(testKey)+"\n"), 0600))
+
+	tests := []struct {
+		name       string
+		key        string
+		keyFile    string
+		wantCipher bool
+		wantErr    bool
+	}{
+		{name: "Test 1. Key and key file are not set.", wantCipher: false},
+		{name: "Test 2. Key is set.", key: hex.EncodeToString(testKey), wantCipher: true},
+		{name: "Test 3. Key file is set.", keyFile: keyFile, wantCipher: true},
+		{name: "Test 4. Key file is not exist.", keyFile: "not_exist.key", wantErr: true},
+		{name: "Test 5. Incorrect key.", key: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewCipherByEnv(tt.key, tt.keyFile)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantCipher, got != nil)
+		})
+	}
+}
+
+func TestCipher_EncryptDecrypt(t *testing.T) {
+	plain := []byte(`{"Metrics":{}}`)
+	c, err := NewCipher(testKey)
+	require.NoError(t, err)
+	otherCipher, err := NewCipher([]byte("fedcba9876543210fedcba9876543210"))
+	require.NoError(t, err)
+
+	encrypted, err := c.Encrypt(plain)
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(encrypted))
+	assert.False(t, IsEncrypted(plain))
+	assert.NotContains(t, string(encrypted), string(plain))
+
+	tests := []struct {
+		name    string
+		cipher  *Cipher
+		data    func() []byte
+		wantErr error
+	}{
+		{
+			name:   "Test 1. Correct key.",
+			cipher: c,
+			data:   func() []byte { return encrypted },
+		},
+		{
+			name:    "Test 2. Other key.",
+			cipher:  otherCipher,
+			data:    func() []byte { return encrypted },
+			wantErr: ErrCorruptedFile,
+		},
+		{
+			name:   "Test 3. Ciphertext is changed.",
+			cipher: c,
+			data: func() []byte {
+				changed := append([]byte{}, encrypted...)
+				changed[len(changed)-1] ^= 1
+				return changed
+			},
+			wantErr: ErrCorruptedFile,
+		},
+		{
+			name:   "Test 4. Unsupported version.",
+			cipher: c,
+			data: func() []byte {
+				changed := append([]byte{}, encrypted...)
+				changed[len(encryptedMagic)] = encryptionVersion + 1
+				return changed
+			},
+			wantErr: ErrUnsupportedVersion,
+		},
+		{
+			name:    "Test 5. Truncated file.",
+			cipher:  c,
+			data:    func() []byte { return encrypted[:len(encryptedMagic)+3] },
+			wantErr: ErrCorruptedFile,
+		},
+		{
+			name:    "Test 6. Not encrypted data.",
+			cipher:  c,
+			data:    func() []byte { return plain },
+			wantErr: ErrCorruptedFile,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cipher.Decrypt(tt.data())
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, plain, got)
+		})
+	}
+}
@@ -3,6 +3,7 @@ package filestore
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -12,8 +13,10 @@ import (
 
 // FileStore реализует хранение файла метрик.
 // Для использования доступны методы записи и чтения в\из файла storage.MetricRepository.
+// Если задан Cipher - файл записывается зашифрованным, чтение зашифрованных и открытых файлов прозрачно.
 type FileStore struct {
 	StoreFilePath string
+	Cipher        *Cipher
 }
 
 // NewFileStore конструктор для FileStore.
@@ -34,16 +37,23 @@ func (f *FileStore) Write(memStorage storage.MetricRepository) error {
 			return err
 		}
 	}
-	file, err := os.OpenFile(f.StoreFilePath, os.O_WRONLY|os.O_CREATE, 0644)
+	jsonMS, err := json.Marshal(&memStorage)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	if f.Cipher != nil {
+		jsonMS, err = f.Cipher.Encrypt(jsonMS)
+		if err != nil {
+			return err
+		}
+	}
 
-	jsonMS, err := json.Marshal(&memStorage)
+	// файл обрезается, иначе при уменьшении размера в конце останутся старые данные
+	file, err := os.OpenFile(f.StoreFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
 	_, err = file.Write(jsonMS)
 	if err != nil {
@@ -52,7 +62,8 @@ func (f *FileStore) Write(memStorage storage.MetricRepository) error {
 	return nil
 }
 
-// Read читает объект storage.MetricRepository в файл.
+// Read читает объект storage.MetricRepository из файла.
+// Зашифрованный файл расшифровывается Cipher, открытый(записанный до включения шифрования) читается как есть.
 // Если файла не существует - выбрасывает ошибку.
 func (f *FileStore) Read() (*storage.MemStorage, error) {
 	file, err := os.OpenFile(f.StoreFilePath, os.O_RDONLY, 0644)
@@ -60,10 +71,21 @@ func (f *FileStore) Read() (*storage.MemStorage, error) {
 		return nil, err
 	}
 
+	defer file.Close()
+
 	jsonMS, err := io.ReadAll(file)
 	if err != nil {
 		return nil, err
 	}
+	if IsEncrypted(jsonMS) {
+		if f.Cipher == nil {
+			return nil, ErrNoKey
+		}
+		jsonMS, err = f.Cipher.Decrypt(jsonMS)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	memStorage := storage.NewMemStorage(map[string]storage.Metric{})
 	err = json.Unmarshal(jsonMS, &memStorage)
@@ -72,3 +94,32 @@ func (f *FileStore) Read() (*storage.MemStorage, error) {
 	}
 	return memStorage, nil
 }
+
+// Encrypt шифрует существующий открытый файл хранилища storeFilePath(миграция на шифрование).
+// Содержимое проверяется чтением как storage.MemStorage, файл заменяется атомарно(через временный файл).
+func Encrypt(storeFilePath string, c *Cipher) error {
+	content, err := os.ReadFile(storeFilePath)
+	if err != nil {
+		return err
+	}
+	if IsEncrypted(content) {
+		return fmt.Errorf("store file '%s' is already encrypted", storeFilePath)
+	}
+	if _, err = (&FileStore{StoreFilePath: storeFilePath}).Read(); err != nil {
+		return fmt.Errorf("store file '%s' is not correct: %w", storeFilePath, err)
+	}
+
+	encrypted, err := c.Encrypt(content)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(storeFilePath)
+	if err != nil {
+		return err
+	}
+	tmpFilePath := storeFilePath + ".tmp"
+	if err = os.WriteFile(tmpFilePath, encrypted, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilePath, storeFilePath)
+}
@@ -3,9 +3,11 @@ package filestore
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/firesworder/devopsmetrics/internal"
 	"github.com/firesworder/devopsmetrics/internal/storage"
@@ -101,21 +103,21 @@ func TestFileStore_Read(t *testing.T) {
 func TestFileStore_Write(t *testing.T) {
 	tests := []struct {
 		name          string
-		ms            storage.MemStorage
+		ms            *storage.MemStorage
 		storeFilePath string
 		wantContentAs string
 		wantError     bool
 	}{
 		{
 			name:          "Test #1. Empty storage. (new file)",
-			ms:            *storage.NewMemStorage(map[string]storage.Metric{}),
+			ms:            storage.NewMemStorage(map[string]storage.Metric{}),
 			storeFilePath: "",
 			wantContentAs: "files_test/read_empty_ms_test.json",
 			wantError:     false,
 		},
 		{
 			name: "Test #2. Filled storage. (new file)",
-			ms: *storage.NewMemStorage(map[string]storage.Metric{
+			ms: storage.NewMemStorage(map[string]storage.Metric{
 				metricCounter.Name: *metricCounter,
 				metricGauge.Name:   *metricGauge,
 			}),
@@ -125,7 +127,7 @@ func TestFileStore_Write(t *testing.T) {
 		},
 		{
 			name: "Test #3. File with memstorage already exist.",
-			ms: *storage.NewMemStorage(map[string]storage.Metric{
+			ms: storage.NewMemStorage(map[string]storage.Metric{
 				metricCounter.Name: *metricCounter,
 				metricGauge.Name:   *metricGauge,
 			}),
@@ -135,7 +137,7 @@ func TestFileStore_Write(t *testing.T) {
 		},
 		{
 			name: "Test #5. Not existed filepath.",
-			ms: *storage.NewMemStorage(map[string]storage.Metric{
+			ms: storage.NewMemStorage(map[string]storage.Metric{
 				metricCounter.Name: *metricCounter,
 				metricGauge.Name:   *metricGauge,
 			}),
@@ -153,7 +155,7 @@ func TestFileStore_Write(t *testing.T) {
 				f.StoreFilePath = fmt.Sprintf("files_test/write_test_%d.json", i)
 				defer os.Remove(f.StoreFilePath)
 			}
-			err := f.Write(&tt.ms)
+			err := f.Write(tt.ms)
 			assert.Equal(t, tt.wantError, err != nil)
 
 			if !tt.wantError {
@@ -162,3 +164,74 @@ func TestFileStore_Write(t *testing.T) {
 		})
 	}
 }
+
+func TestFileStore_WriteReadEncrypted(t *testing.T) {
+	ms := storage.NewMemStorage(map[string]storage.Metric{
+		metricCounter.Name: *metricCounter,
+		metricGauge.Name:   *metricGauge,
+	})
+	c, err := NewCipher(testKey)
+	require.NoError(t, err)
+	storeFilePath := filepath.Join(t.TempDir(), "store.json")
+
+	// зашифрованный файл читается только с ключом
+	encryptedStore := &FileStore{StoreFilePath: storeFilePath, Cipher: c}
+	require.NoError(t, encryptedStore.Write(ms))
+	content, err := os.ReadFile(storeFilePath)
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(content))
+
+	got, err := encryptedStore.Read()
+	require.NoError(t, err)
+	assert.Equal(t, ms, got)
+
+	_, err = (&FileStore{StoreFilePath: storeFilePath}).Read()
+	assert.ErrorIs(t, err, ErrNoKey)
+
+	// открытый файл читается и с ключом, следующая запись - зашифрована
+	plainStore := &FileStore{StoreFilePath: storeFilePath}
+	require.NoError(t, plainStore.Write(storage.NewMemStorage(map[string]storage.Metric{})))
+	got, err = encryptedStore.Read()
+	require.NoError(t, err)
+	assert.Equal(t, storage.NewMemStorage(map[string]storage.Metric{}), got)
+}
+
+func TestEncrypt(t *testing.T) {
+	c, err := NewCipher(testKey)
+	require.NoError(t, err)
+	copyFile := func(src string) string {
+		content, err := os.ReadFile(src)
+		require.NoError(t, err)
+		dst := filepath.Join(t.TempDir(), filepath.Base(src))
+		require.NoError(t, os.WriteFile(dst, content, 0644))
+		return dst
+	}
+
+	tests := []struct {
+		name          string
+		storeFilePath string
+		wantErr       bool
+	}{
+		{name: "Test 1. Correct plaintext store.", storeFilePath: copyFile("files_test/read_correct_ms_test.json")},
+		{name: "Test 2. Incorrect json.", storeFilePath: copyFile("files_test/incorrect_json.json"), wantErr: true},
+		{name: "Test 3. File is not exist.", storeFilePath: "files_test/not_exist.json", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Encrypt(tt.storeFilePath, c)
+			require.Equal(t, tt.wantErr, err != nil)
+			if tt.wantErr {
+				return
+			}
+
+			got, err := (&FileStore{StoreFilePath: tt.storeFilePath, Cipher: c}).Read()
+			require.NoError(t, err)
+			want, err := (&FileStore{StoreFilePath: "files_test/read_correct_ms_test.json"}).Read()
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+
+			// повторное шифрование не допускается
+			assert.Error(t, Encrypt(tt.storeFilePath, c))
+		})
+	}
+}
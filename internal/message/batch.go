@@ -0,0 +1,11 @@
+package message
+
+// BatchItemResult результат обработки одной метрики набора(/updates/) в режиме частичного применения.
+// Сервер возвращает результаты в JSON, в порядке метрик в запросе.
+type BatchItemResult struct {
+	ID     string   `json:"id"`               // Имя метрики
+	MType  string   `json:"type"`             // Тип метрики
+	Status int      `json:"status"`           // http статус обработки метрики, 200 - метрика применена
+	Error  string   `json:"error,omitempty"`  // Причина отклонения метрики
+	Metric *Metrics `json:"metric,omitempty"` // Сохраненное на сервере значение примененной метрики
+}
@@ -0,0 +1,235 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// поддерживаемые форматы тела(Content-Type) сообщений-метрик.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeMsgpack  = "application/msgpack"
+)
+
+// ErrUnsupportedContentType формат сообщения не поддерживается.
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// ErrIncorrectProtobuf тело сообщения не соответствует схеме metrics.proto.
+var ErrIncorrectProtobuf = errors.New("incorrect protobuf message")
+
+// contentTypeAliases альтернативные имена форматов, которые принимаются в Content-Type и Accept.
+var contentTypeAliases = map[string]string{
+	ContentTypeJSON:           ContentTypeJSON,
+	ContentTypeProtobuf:       ContentTypeProtobuf,
+	"application/protobuf":    ContentTypeProtobuf,
+	ContentTypeMsgpack:        ContentTypeMsgpack,
+	"application/x-msgpack":   ContentTypeMsgpack,
+	"application/vnd.msgpack": ContentTypeMsgpack,
+}
+
+// ParseContentType возвращает поддерживаемый формат по значению заголовка Content-Type(параметры игнорируются).
+// Пустое значение - JSON, неподдерживаемое - ErrUnsupportedContentType.
+func ParseContentType(header string) (string, error) {
+	if strings.TrimSpace(header) == "" {
+		return ContentTypeJSON, nil
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return "", fmt.Errorf("%w '%s'", ErrUnsupportedContentType, header)
+	}
+	contentType, ok := contentTypeAliases[mediaType]
+	if !ok {
+		return "", fmt.Errorf("%w '%s'", ErrUnsupportedContentType, mediaType)
+	}
+	return contentType, nil
+}
+
+// AcceptedContentType выбирает формат ответа по заголовку Accept: первый поддерживаемый из перечисленных.
+// Если поддерживаемых нет(в т.ч. Accept пустой или */*) - возвращает fallback.
+func AcceptedContentType(accept string, fallback string) string {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if contentType, ok := contentTypeAliases[mediaType]; ok {
+			return contentType
+		}
+	}
+	return fallback
+}
+
+// Marshal кодирует метрику в формате contentType.
+func Marshal(contentType string, m Metrics) ([]byte, error) {
+	switch contentType {
+	case ContentTypeJSON:
+		return json.Marshal(m)
+	case ContentTypeProtobuf:
+		return appendProtoMetric(nil, m), nil
+	case ContentTypeMsgpack:
+		return msgpack.Marshal(m)
+	default:
+		return nil, fmt.Errorf("%w '%s'", ErrUnsupportedContentType, contentType)
+	}
+}
+
+// Unmarshal декодирует метрику из формата contentType.
+func Unmarshal(contentType string, data []byte, m *Metrics) error {
+	switch contentType {
+	case ContentTypeJSON:
+		return json.Unmarshal(data, m)
+	case ContentTypeProtobuf:
+		return consumeProtoMetric(data, m)
+	case ContentTypeMsgpack:
+		return msgpack.Unmarshal(data, m)
+	default:
+		return fmt.Errorf("%w '%s'", ErrUnsupportedContentType, contentType)
+	}
+}
+
+// MarshalBatch кодирует набор метрик в формате contentType.
+func MarshalBatch(contentType string, batch []Metrics) ([]byte, error) {
+	switch contentType {
+	case ContentTypeJSON:
+		if batch == nil {
+			batch = []Metrics{}
+		}
+		return json.Marshal(batch)
+	case ContentTypeProtobuf:
+		var b []byte
+		for _, m := range batch {
+			b = protowire.AppendTag(b, 1, protowire.BytesType)
+			b = protowire.AppendBytes(b, appendProtoMetric(nil, m))
+		}
+		return b, nil
+	case ContentTypeMsgpack:
+		if batch == nil {
+			batch = []Metrics{}
+		}
+		return msgpack.Marshal(batch)
+	default:
+		return nil, fmt.Errorf("%w '%s'", ErrUnsupportedContentType, contentType)
+	}
+}
+
+// UnmarshalBatch декодирует набор метрик из формата contentType.
+func UnmarshalBatch(contentType string, data []byte) ([]Metrics, error) {
+	var batch []Metrics
+	switch contentType {
+	case ContentTypeJSON:
+		if err := json.Unmarshal(data, &batch); err != nil {
+			return nil, err
+		}
+	case ContentTypeProtobuf:
+		for len(data) > 0 {
+			num, typ, n := protowire.ConsumeTag(data)
+			if n < 0 {
+				return nil, ErrIncorrectProtobuf
+			}
+			data = data[n:]
+			if num != 1 || typ != protowire.BytesType {
+				if n = protowire.ConsumeFieldValue(num, typ, data); n < 0 {
+					return nil, ErrIncorrectProtobuf
+				}
+				data = data[n:]
+				continue
+			}
+			v, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return nil, ErrIncorrectProtobuf
+			}
+			data = data[n:]
+			var m Metrics
+			if err := consumeProtoMetric(v, &m); err != nil {
+				return nil, err
+			}
+			batch = append(batch, m)
+		}
+	case ContentTypeMsgpack:
+		if err := msgpack.Unmarshal(data, &batch); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w '%s'", ErrUnsupportedContentType, contentType)
+	}
+	return batch, nil
+}
+
+// appendProtoMetric кодирует метрику сообщением Metric(см. metrics.proto).
+func appendProtoMetric(b []byte, m Metrics) []byte {
+	if m.ID != "" {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, m.ID)
+	}
+	if m.MType != "" {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, m.MType)
+	}
+	if m.Delta != nil {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(*m.Delta))
+	}
+	if m.Value != nil {
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(*m.Value))
+	}
+	if m.Hash != "" {
+		b = protowire.AppendTag(b, 5, protowire.BytesType)
+		b = protowire.AppendString(b, m.Hash)
+	}
+	if m.Stale {
+		b = protowire.AppendTag(b, 6, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(true))
+	}
+	return b
+}
+
+// consumeProtoMetric декодирует сообщение Metric(см. metrics.proto), неизвестные поля пропускаются.
+func consumeProtoMetric(data []byte, m *Metrics) error {
+	*m = Metrics{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return ErrIncorrectProtobuf
+		}
+		data = data[n:]
+
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			m.ID, n = protowire.ConsumeString(data)
+		case num == 2 && typ == protowire.BytesType:
+			m.MType, n = protowire.ConsumeString(data)
+		case num == 3 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(data)
+			delta := int64(v)
+			m.Delta = &delta
+		case num == 4 && typ == protowire.Fixed64Type:
+			var v uint64
+			v, n = protowire.ConsumeFixed64(data)
+			value := math.Float64frombits(v)
+			m.Value = &value
+		case num == 5 && typ == protowire.BytesType:
+			m.Hash, n = protowire.ConsumeString(data)
+		case num == 6 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(data)
+			m.Stale = protowire.DecodeBool(v)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return ErrIncorrectProtobuf
+		}
+		data = data[n:]
+	}
+	return nil
+}
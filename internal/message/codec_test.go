@@ -0,0 +1,136 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestParseContentType(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{name: "Test 1. Empty header.", header: "", want: ContentTypeJSON},
+		{name: "Test 2. JSON with charset.", header: "application/json; charset=utf-8", want: ContentTypeJSON},
+		{name: "Test 3. Protobuf.", header: "application/x-protobuf", want: ContentTypeProtobuf},
+		{name: "Test 4. Protobuf alias.", header: "application/protobuf", want: ContentTypeProtobuf},
+		{name: "Test 5. MessagePack alias.", header: "application/x-msgpack", want: ContentTypeMsgpack},
+		{name: "Test 6. Unsupported type.", header: "text/xml", wantErr: true},
+		{name: "Test 7. Incorrect header.", header: "/;", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseContentType(tt.header)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrUnsupportedContentType)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAcceptedContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		fallback string
+		want     string
+	}{
+		{name: "Test 1. Empty accept.", accept: "", fallback: ContentTypeProtobuf, want: ContentTypeProtobuf},
+		{name: "Test 2. Any type.", accept: "*/*", fallback: ContentTypeJSON, want: ContentTypeJSON},
+		{
+			name:     "Test 3. First supported type.",
+			accept:   "text/html, application/msgpack, application/json;q=0.5",
+			fallback: ContentTypeJSON,
+			want:     ContentTypeMsgpack,
+		},
+		{name: "Test 4. Unsupported type.", accept: "text/html", fallback: ContentTypeJSON, want: ContentTypeJSON},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, AcceptedContentType(tt.accept, tt.fallback))
+		})
+	}
+}
+
+func TestMarshalUnmarshal(t *testing.T) {
+	delta, negativeDelta, value := int64(10), int64(-5), 2.27
+	batch := []Metrics{
+		{ID: "PollCount", MType: "counter", Delta: &delta, Hash: "abc"},
+		{ID: "Negative", MType: "counter", Delta: &negativeDelta},
+		{ID: "RandomValue", MType: "gauge", Value: &value, Stale: true},
+		{ID: "Empty"},
+	}
+
+	for _, contentType := range []string{ContentTypeJSON, ContentTypeProtobuf, ContentTypeMsgpack} {
+		t.Run(contentType, func(t *testing.T) {
+			for _, m := range batch {
+				data, err := Marshal(contentType, m)
+				require.NoError(t, err)
+				var got Metrics
+				require.NoError(t, Unmarshal(contentType, data, &got))
+				assert.Equal(t, m, got)
+			}
+
+			data, err := MarshalBatch(contentType, batch)
+			require.NoError(t, err)
+			got, err := UnmarshalBatch(contentType, data)
+			require.NoError(t, err)
+			assert.Equal(t, batch, got)
+
+			data, err = MarshalBatch(contentType, nil)
+			require.NoError(t, err)
+			got, err = UnmarshalBatch(contentType, data)
+			require.NoError(t, err)
+			assert.Empty(t, got)
+		})
+	}
+
+	_, err := Marshal("text/xml", batch[0])
+	assert.ErrorIs(t, err, ErrUnsupportedContentType)
+	_, err = UnmarshalBatch("text/xml", nil)
+	assert.ErrorIs(t, err, ErrUnsupportedContentType)
+}
+
+func TestUnmarshalProtobuf(t *testing.T) {
+	delta := int64(10)
+	valid, err := Marshal(ContentTypeProtobuf, Metrics{ID: "PollCount", MType: "counter", Delta: &delta})
+	require.NoError(t, err)
+	// неизвестное поле 15 должно пропускаться
+	withUnknownField := protowire.AppendString(protowire.AppendTag(valid, 15, protowire.BytesType), "new field")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    Metrics
+		wantErr bool
+	}{
+		{name: "Test 1. Valid message.", data: valid, want: Metrics{ID: "PollCount", MType: "counter", Delta: &delta}},
+		{
+			name: "Test 2. Unknown field is skipped.",
+			data: withUnknownField,
+			want: Metrics{ID: "PollCount", MType: "counter", Delta: &delta},
+		},
+		{name: "Test 3. Truncated message.", data: valid[:len(valid)-1], wantErr: true},
+		{name: "Test 4. JSON body.", data: []byte(`{"id":"PollCount"}`), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Metrics
+			err := Unmarshal(ContentTypeProtobuf, tt.data, &got)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrIncorrectProtobuf)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -1,5 +1,6 @@
 // Package message реализует объект сообщения-метрики, общий для приложения.
-// Именно такими "сообщениями" обмениваются агентная и серверная часть приложения, через JSON.
+// Именно такими "сообщениями" обмениваются агентная и серверная часть приложения,
+// через JSON, protobuf или MessagePack(см. codec.go).
 package message
 
 import (
@@ -13,11 +14,12 @@ import (
 
 // Metrics объект сообщения-метрики.
 type Metrics struct {
-	ID    string   `json:"id"`              // Имя метрики
-	MType string   `json:"type"`            // Параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // Значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // Значение метрики в случае передачи gauge
-	Hash  string   `json:"hash,omitempty"`  // Значение хеш-функции
+	ID    string   `json:"id" msgpack:"id"`                           // Имя метрики
+	MType string   `json:"type" msgpack:"type"`                       // Параметр, принимающий значение gauge или counter
+	Delta *int64   `json:"delta,omitempty" msgpack:"delta,omitempty"` // Значение метрики в случае передачи counter
+	Value *float64 `json:"value,omitempty" msgpack:"value,omitempty"` // Значение метрики в случае передачи gauge
+	Hash  string   `json:"hash,omitempty" msgpack:"hash,omitempty"`   // Значение хеш-функции
+	Stale bool     `json:"stale,omitempty" msgpack:"stale,omitempty"` // Метрика не обновлялась дольше TTL(заполняется только сервером)
 }
 
 // InitHash формирует подписанный(hmac) хэш метрики и записывает в свойство Hash объекта.
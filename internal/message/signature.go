@@ -0,0 +1,125 @@
+package message
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Заголовки запроса, в которых передается подпись запроса целиком.
+const (
+	AgentIDHeader        = "X-Agent-ID"
+	TimestampHeader      = "X-Timestamp"
+	NonceHeader          = "X-Nonce"
+	BatchSignatureHeader = "X-Batch-Signature"
+)
+
+// CounterModeHeader заголовок запроса, задающий режим передачи counter метрик(дельта или кумулятивное значение).
+const CounterModeHeader = "X-Counter-Mode"
+
+// signedHeaders заголовки запроса, влияющие на разбор тела и значения метрик, их значения входят в подпись.
+var signedHeaders = []string{"Content-Type", CounterModeHeader}
+
+// ErrNoBatchSignature запрос не содержит подписи.
+var ErrNoBatchSignature = errors.New("batch signature is not set")
+
+// ErrIncorrectBatchSignature заголовки подписи заполнены неверно.
+var ErrIncorrectBatchSignature = errors.New("incorrect batch signature headers")
+
+// nonceSize размер(в байтах) случайного nonce.
+const nonceSize = 16
+
+// BatchSignature подпись запроса целиком: hmac от метода, пути(с query), заголовков signedHeaders,
+// id агента, времени, nonce и тела запроса.
+// В отличие от хеша метрики, не позволяет повторно отправить перехваченный запрос.
+type BatchSignature struct {
+	AgentID   string
+	Timestamp time.Time
+	Nonce     string
+	Signature string
+}
+
+// SignatureTarget возвращает подписываемый путь запроса: path и query rawQuery, если он не пустой.
+func SignatureTarget(path, rawQuery string) string {
+	if rawQuery == "" {
+		return path
+	}
+	return path + "?" + rawQuery
+}
+
+// NewBatchSignature формирует подпись запроса, отправляемого агентом agentID в момент now.
+// target - путь запроса с query(см. SignatureTarget), header - заголовки запроса, body - тело запроса до шифрования.
+func NewBatchSignature(key, method, target string, header http.Header, body []byte, agentID string,
+	now time.Time) (*BatchSignature, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key cannot be empty")
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	b := &BatchSignature{AgentID: agentID, Timestamp: time.Unix(now.Unix(), 0), Nonce: hex.EncodeToString(nonce)}
+	b.Signature = b.sign(key, method, target, header, body)
+	return b, nil
+}
+
+// BatchSignatureFromHeader читает подпись из заголовков запроса.
+// Если заголовок подписи не передан - возвращает ErrNoBatchSignature.
+func BatchSignatureFromHeader(header http.Header) (*BatchSignature, error) {
+	signature := header.Get(BatchSignatureHeader)
+	if signature == "" {
+		return nil, ErrNoBatchSignature
+	}
+
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: timestamp '%s'", ErrIncorrectBatchSignature, header.Get(TimestampHeader))
+	}
+	nonce := header.Get(NonceHeader)
+	if nonce == "" {
+		return nil, fmt.Errorf("%w: nonce is not set", ErrIncorrectBatchSignature)
+	}
+	return &BatchSignature{
+		AgentID:   header.Get(AgentIDHeader),
+		Timestamp: time.Unix(timestamp, 0),
+		Nonce:     nonce,
+		Signature: signature,
+	}, nil
+}
+
+// SetHeader записывает подпись в заголовки запроса.
+func (b *BatchSignature) SetHeader(header http.Header) {
+	if b.AgentID != "" {
+		header.Set(AgentIDHeader, b.AgentID)
+	}
+	header.Set(TimestampHeader, strconv.FormatInt(b.Timestamp.Unix(), 10))
+	header.Set(NonceHeader, b.Nonce)
+	header.Set(BatchSignatureHeader, b.Signature)
+}
+
+// Check сверяет полученную и ожидаемую(для ключа key) подпись запроса. target - путь запроса с query.
+func (b *BatchSignature) Check(key, method, target string, header http.Header, body []byte) (bool, error) {
+	if key == "" {
+		return false, fmt.Errorf("key cannot be empty")
+	}
+	return hmac.Equal([]byte(b.Signature), []byte(b.sign(key, method, target, header, body))), nil
+}
+
+// sign возвращает hmac подписи запроса.
+func (b *BatchSignature) sign(key, method, target string, header http.Header, body []byte) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write([]byte(fmt.Sprintf("%s\n%s\n", method, target)))
+	for _, name := range signedHeaders {
+		h.Write([]byte(fmt.Sprintf("%s:%s\n", name, header.Get(name))))
+	}
+	h.Write([]byte(fmt.Sprintf("%s\n%d\n%s\n", b.AgentID, b.Timestamp.Unix(), b.Nonce)))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
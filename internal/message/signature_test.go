@@ -0,0 +1,191 @@
+package message
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchSignature_Check(t *testing.T) {
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	body := []byte(`[{"id":"PollCount","type":"counter","delta":10}]`)
+	header := http.Header{"Content-Type": {ContentTypeJSON}}
+	signature, err := NewBatchSignature("Ayayaka", http.MethodPost, "/updates/", header, body, "agent1", now)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		key       string
+		method    string
+		path      string
+		header    http.Header
+		body      []byte
+		modify    func(b BatchSignature) BatchSignature
+		wantCheck bool
+		wantErr   bool
+	}{
+		{
+			name:      "Test 1. Correct signature.",
+			key:       "Ayayaka",
+			method:    http.MethodPost,
+			path:      "/updates/",
+			body:      body,
+			wantCheck: true,
+		},
+		{
+			name:   "Test 2. Other key.",
+			key:    "Ayayaka2",
+			method: http.MethodPost,
+			path:   "/updates/",
+			body:   body,
+		},
+		{
+			name:   "Test 3. Body is changed.",
+			key:    "Ayayaka",
+			method: http.MethodPost,
+			path:   "/updates/",
+			body:   []byte(`[{"id":"PollCount","type":"counter","delta":11}]`),
+		},
+		{
+			name:   "Test 4. Path is changed.",
+			key:    "Ayayaka",
+			method: http.MethodPost,
+			path:   "/update/",
+			body:   body,
+		},
+		{
+			name:   "Test 5. Timestamp is changed.",
+			key:    "Ayayaka",
+			method: http.MethodPost,
+			path:   "/updates/",
+			body:   body,
+			modify: func(b BatchSignature) BatchSignature {
+				b.Timestamp = b.Timestamp.Add(time.Second)
+				return b
+			},
+		},
+		{
+			name:   "Test 6. Nonce is changed.",
+			key:    "Ayayaka",
+			method: http.MethodPost,
+			path:   "/updates/",
+			body:   body,
+			modify: func(b BatchSignature) BatchSignature {
+				b.Nonce = "00"
+				return b
+			},
+		},
+		{
+			name:   "Test 7. Agent id is changed.",
+			key:    "Ayayaka",
+			method: http.MethodPost,
+			path:   "/updates/",
+			body:   body,
+			modify: func(b BatchSignature) BatchSignature {
+				b.AgentID = "agent2"
+				return b
+			},
+		},
+		{
+			name:   "Test 8. Query is added.",
+			key:    "Ayayaka",
+			method: http.MethodPost,
+			path:   SignatureTarget("/updates/", "partial=true"),
+			body:   body,
+		},
+		{
+			name:   "Test 9. Content type is changed.",
+			key:    "Ayayaka",
+			method: http.MethodPost,
+			path:   "/updates/",
+			header: http.Header{"Content-Type": {ContentTypeMsgpack}},
+			body:   body,
+		},
+		{
+			name:   "Test 10. Counter mode is added.",
+			key:    "Ayayaka",
+			method: http.MethodPost,
+			path:   "/updates/",
+			header: http.Header{"Content-Type": {ContentTypeJSON}, CounterModeHeader: {"cumulative"}},
+			body:   body,
+		},
+		{
+			name:    "Test 11. Key is empty.",
+			key:     "",
+			method:  http.MethodPost,
+			path:    "/updates/",
+			body:    body,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := *signature
+			if tt.modify != nil {
+				b = tt.modify(b)
+			}
+			checkHeader := header
+			if tt.header != nil {
+				checkHeader = tt.header
+			}
+			gotCheck, err := b.Check(tt.key, tt.method, tt.path, checkHeader, tt.body)
+			assert.Equal(t, tt.wantErr, err != nil)
+			assert.Equal(t, tt.wantCheck, gotCheck)
+		})
+	}
+}
+
+func TestSignatureTarget(t *testing.T) {
+	assert.Equal(t, "/updates/", SignatureTarget("/updates/", ""))
+	assert.Equal(t, "/updates/?partial=true", SignatureTarget("/updates/", "partial=true"))
+}
+
+func TestBatchSignatureFromHeader(t *testing.T) {
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	signature, err := NewBatchSignature("Ayayaka", http.MethodPost, "/updates/", http.Header{}, []byte("[]"), "agent1", now)
+	require.NoError(t, err)
+	signedHeader := http.Header{}
+	signature.SetHeader(signedHeader)
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		want    *BatchSignature
+		wantErr error
+	}{
+		{
+			name:   "Test 1. Correct headers.",
+			header: signedHeader,
+			want:   signature,
+		},
+		{
+			name:    "Test 2. Signature is not set.",
+			header:  http.Header{},
+			wantErr: ErrNoBatchSignature,
+		},
+		{
+			name: "Test 3. Incorrect timestamp.",
+			header: http.Header{
+				BatchSignatureHeader: {signature.Signature}, TimestampHeader: {"yesterday"}, NonceHeader: {"00"},
+			},
+			wantErr: ErrIncorrectBatchSignature,
+		},
+		{
+			name: "Test 4. Nonce is not set.",
+			header: http.Header{
+				BatchSignatureHeader: {signature.Signature}, TimestampHeader: {"1677672000"},
+			},
+			wantErr: ErrIncorrectBatchSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BatchSignatureFromHeader(tt.header)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
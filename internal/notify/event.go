@@ -0,0 +1,43 @@
+// Package notify реализует отправку уведомлений(webhook) о событиях сервера:
+// переходах алертов в firing/resolved и о "замолчавших" агентах.
+package notify
+
+import (
+	"time"
+
+	"github.com/firesworder/devopsmetrics/internal/alerts"
+)
+
+// EventType тип события.
+type EventType string
+
+// Типы событий.
+const (
+	EventAlertFiring   EventType = "alert_firing"
+	EventAlertResolved EventType = "alert_resolved"
+	// EventAgentSilent агент не присылал метрики дольше заданного времени.
+	EventAgentSilent EventType = "agent_silent"
+)
+
+// Event событие, о котором отправляется уведомление.
+type Event struct {
+	Type     EventType     `json:"type"`
+	Time     time.Time     `json:"time"`
+	Alert    *alerts.Alert `json:"alert,omitempty"`
+	Agent    string        `json:"agent,omitempty"`
+	LastSeen *time.Time    `json:"last_seen,omitempty"`
+}
+
+// NewAlertEvent создает событие по алерту, перешедшему в состояние firing или resolved.
+func NewAlertEvent(alert alerts.Alert, now time.Time) Event {
+	eventType := EventAlertFiring
+	if alert.State == alerts.StateResolved {
+		eventType = EventAlertResolved
+	}
+	return Event{Type: eventType, Time: now, Alert: &alert}
+}
+
+// NewAgentSilentEvent создает событие "агент не присылает метрики с lastSeen".
+func NewAgentSilentEvent(agent string, lastSeen time.Time, now time.Time) Event {
+	return Event{Type: EventAgentSilent, Time: now, Agent: agent, LastSeen: &lastSeen}
+}
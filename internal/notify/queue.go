@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+// DefaultQueueSize размер очереди уведомлений по умолчанию.
+const DefaultQueueSize = 100
+
+// Ошибки постановки уведомлений в очередь.
+var (
+	ErrQueueFull   = errors.New("notification queue is full")
+	ErrQueueClosed = errors.New("notification queue is closed")
+)
+
+// Queue отправляет уведомления через Notifier в фоне: события помещаются в очередь ограниченного размера
+// и отправляются одним воркером, поэтому вызывающий код не ждет доставки(и повторов) webhook.
+type Queue struct {
+	notifier *Notifier
+	events   chan Event
+	done     chan struct{}
+	closed   bool
+	mutex    sync.Mutex
+}
+
+// NewQueue конструктор Queue, запускает воркер отправки. size - максимальное кол-во ожидающих отправки событий.
+func NewQueue(notifier *Notifier, size int) *Queue {
+	q := &Queue{notifier: notifier, events: make(chan Event, size), done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+// run отправляет события из очереди до ее закрытия. Ошибки доставки логируются.
+func (q *Queue) run() {
+	defer close(q.done)
+	for event := range q.events {
+		if err := q.notifier.Notify(context.Background(), event); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Enqueue добавляет события в очередь. Nil Queue ничего не делает.
+// Если очередь заполнена - не поместившиеся события отбрасываются и возвращается ErrQueueFull.
+func (q *Queue) Enqueue(events ...Event) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	for _, event := range events {
+		select {
+		case q.events <- event:
+		default:
+			return ErrQueueFull
+		}
+	}
+	return nil
+}
+
+// Close прекращает прием событий и ждет отправки событий, уже находящихся в очереди. Nil Queue ничего не делает.
+func (q *Queue) Close() {
+	if q == nil {
+		return
+	}
+
+	q.mutex.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.events)
+	}
+	q.mutex.Unlock()
+	<-q.done
+}
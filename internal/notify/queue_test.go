@@ -0,0 +1,45 @@
+package notify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue_Enqueue(t *testing.T) {
+	var received atomic.Int32
+	started, release := make(chan struct{}, 10), make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		started <- struct{}{}
+		<-release
+		received.Add(1)
+	}))
+	defer ts.Close()
+	n, err := NewNotifier([]string{ts.URL}, "", "")
+	require.NoError(t, err)
+
+	q := NewQueue(n, 1)
+	event := NewAgentSilentEvent("127.0.0.1", time.Now(), time.Now())
+	// Enqueue не ждет доставки: первое событие отправляется воркером, второе ждет в очереди
+	require.NoError(t, q.Enqueue(event))
+	<-started
+	require.NoError(t, q.Enqueue(event))
+	// очередь заполнена - событие отбрасывается
+	assert.ErrorIs(t, q.Enqueue(event), ErrQueueFull)
+
+	// Close ждет отправки событий из очереди
+	close(release)
+	q.Close()
+	assert.Equal(t, int32(2), received.Load())
+	assert.ErrorIs(t, q.Enqueue(event), ErrQueueClosed)
+	q.Close()
+
+	var nilQueue *Queue
+	assert.NoError(t, nilQueue.Enqueue(event))
+	nilQueue.Close()
+}
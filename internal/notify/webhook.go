@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// SignatureHeader заголовок запроса с hmac(sha256) подписью тела, в hex.
+const SignatureHeader = "X-Signature"
+
+// Параметры повторной отправки по умолчанию.
+const (
+	DefaultRetries       = 3
+	DefaultRetryInterval = time.Second
+)
+
+// ErrDeliveryFailed ошибка "уведомление не доставлено".
+var ErrDeliveryFailed = errors.New("webhook delivery failed")
+
+// Notifier отправляет уведомления POST запросами на webhook адреса.
+type Notifier struct {
+	URLs []string
+	// Key ключ подписи тела запроса, если пустой - запрос не подписывается.
+	Key string
+	// Template шаблон тела запроса(данные - Event), если nil - тело Event в json.
+	Template *template.Template
+	// ContentType Content-Type тела, сформированного по шаблону. Если пустой(или шаблон не задан) - application/json.
+	ContentType string
+	Client      *http.Client
+	// Retries кол-во повторных попыток отправки, RetryInterval - пауза перед первым повтором(растет с каждой попыткой).
+	Retries       int
+	RetryInterval time.Duration
+}
+
+// NewNotifier конструктор Notifier. Если templatePath задан - тело запроса формируется по шаблону из файла.
+func NewNotifier(urls []string, key string, templatePath string) (*Notifier, error) {
+	n := &Notifier{
+		URLs:          urls,
+		Key:           key,
+		Client:        &http.Client{Timeout: 10 * time.Second},
+		Retries:       DefaultRetries,
+		RetryInterval: DefaultRetryInterval,
+	}
+	if templatePath != "" {
+		tmpl, err := template.New(filepath.Base(templatePath)).Funcs(templateFuncs).ParseFiles(templatePath)
+		if err != nil {
+			return nil, err
+		}
+		n.Template = tmpl
+	}
+	return n, nil
+}
+
+// templateFuncs функции, доступные в шаблоне тела запроса.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// Notify отправляет уведомления о событиях на все адреса. Nil Notifier ничего не делает.
+// Возвращает ErrDeliveryFailed, если хотя бы одно уведомление не было доставлено.
+func (n *Notifier) Notify(ctx context.Context, events ...Event) error {
+	if n == nil {
+		return nil
+	}
+
+	var failures []string
+	for _, event := range events {
+		body, err := n.renderBody(event)
+		if err != nil {
+			return err
+		}
+		for _, url := range n.URLs {
+			if err = n.send(ctx, url, body); err != nil {
+				failures = append(failures, fmt.Sprintf("%s '%s': %s", event.Type, url, err))
+			}
+		}
+	}
+	if len(failures) != 0 {
+		return fmt.Errorf("%w: %s", ErrDeliveryFailed, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// renderBody формирует тело запроса по событию.
+func (n *Notifier) renderBody(event Event) ([]byte, error) {
+	if n.Template == nil {
+		return json.Marshal(event)
+	}
+	var buf bytes.Buffer
+	if err := n.Template.Execute(&buf, event); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// send отправляет тело на адрес, повторяя попытки при сетевых ошибках и ответах 5xx/429.
+func (n *Notifier) send(ctx context.Context, url string, body []byte) error {
+	var err error
+	for attempt := 0; attempt <= n.Retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * n.RetryInterval):
+			}
+		}
+
+		var retryable bool
+		retryable, err = n.sendOnce(ctx, url, body)
+		if err == nil || !retryable {
+			return err
+		}
+	}
+	return err
+}
+
+// contentType возвращает Content-Type тела запроса.
+func (n *Notifier) contentType() string {
+	if n.Template != nil && n.ContentType != "" {
+		return n.ContentType
+	}
+	return "application/json"
+}
+
+// sendOnce выполняет одну попытку отправки. Возвращает true, если попытку имеет смысл повторить.
+func (n *Notifier) sendOnce(ctx context.Context, url string, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", n.contentType())
+	if n.Key != "" {
+		request.Header.Set(SignatureHeader, Sign(body, n.Key))
+	}
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return false, nil
+	}
+	retryable := response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+	return retryable, fmt.Errorf("unexpected status code %d", response.StatusCode)
+}
+
+// Sign возвращает hmac(sha256) подпись тела в hex.
+func Sign(body []byte, key string) string {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
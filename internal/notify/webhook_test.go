@@ -0,0 +1,184 @@
+package notify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal/alerts"
+)
+
+// receivedRequest запрос, полученный тестовым приемником.
+type receivedRequest struct {
+	body        string
+	signature   string
+	contentType string
+}
+
+// testReceiver тестовый приемник webhook, отвечает статусами из statuses по очереди(затем - 200).
+type testReceiver struct {
+	requests []receivedRequest
+	statuses []int
+	mutex    sync.Mutex
+}
+
+func (r *testReceiver) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, receivedRequest{
+		body: string(body), signature: request.Header.Get(SignatureHeader), contentType: request.Header.Get("Content-Type"),
+	})
+	if len(r.statuses) != 0 {
+		writer.WriteHeader(r.statuses[0])
+		r.statuses = r.statuses[1:]
+	}
+}
+
+func TestNotifier_Notify(t *testing.T) {
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	alert := alerts.Alert{
+		Rule: "HighCPU", Metric: "CPUutilization1", State: alerts.StateFiring, Value: 95,
+		Op: alerts.OpGreater, Threshold: 90, ActiveSince: now,
+	}
+	alertJSON := `{"type":"alert_firing","time":"2023-01-01T00:00:00Z","alert":{"rule":"HighCPU",` +
+		`"metric":"CPUutilization1","state":"firing","value":95,"op":">","threshold":90,` +
+		`"active_since":"2023-01-01T00:00:00Z"}}`
+
+	templatePath := filepath.Join(t.TempDir(), "webhook.tmpl")
+	require.NoError(t, os.WriteFile(templatePath,
+		[]byte(`{"text":"{{.Type}}: {{.Alert.Rule}} {{.Alert.Metric}}={{.Alert.Value}}","alert":{{json .Alert.State}}}`),
+		0644))
+	textTemplatePath := filepath.Join(t.TempDir(), "webhook.txt")
+	require.NoError(t, os.WriteFile(textTemplatePath, []byte(`{{.Type}}: {{.Alert.Rule}}`), 0644))
+
+	tests := []struct {
+		name         string
+		key          string
+		templatePath string
+		contentType  string
+		statuses     []int
+		event        Event
+		wantRequests []receivedRequest
+		// wantContentType Content-Type запросов, если пустой - application/json
+		wantContentType string
+		wantErr         error
+	}{
+		{
+			name:         "Test 1. Default body, without key.",
+			event:        NewAlertEvent(alert, now),
+			wantRequests: []receivedRequest{{body: alertJSON}},
+		},
+		{
+			name:         "Test 2. Default body, signed.",
+			key:          "secret",
+			event:        NewAlertEvent(alert, now),
+			wantRequests: []receivedRequest{{body: alertJSON}},
+		},
+		{
+			name:         "Test 3. Templated body.",
+			templatePath: templatePath,
+			event:        NewAlertEvent(alert, now),
+			wantRequests: []receivedRequest{
+				{body: `{"text":"alert_firing: HighCPU CPUutilization1=95","alert":"firing"}`},
+			},
+		},
+		{
+			name:     "Test 4. Retried after 5xx.",
+			statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests},
+			event:    NewAgentSilentEvent("127.0.0.1", now.Add(-time.Minute), now),
+			wantRequests: []receivedRequest{
+				{body: `{"type":"agent_silent","time":"2023-01-01T00:00:00Z","agent":"127.0.0.1","last_seen":"2022-12-31T23:59:00Z"}`},
+				{body: `{"type":"agent_silent","time":"2023-01-01T00:00:00Z","agent":"127.0.0.1","last_seen":"2022-12-31T23:59:00Z"}`},
+				{body: `{"type":"agent_silent","time":"2023-01-01T00:00:00Z","agent":"127.0.0.1","last_seen":"2022-12-31T23:59:00Z"}`},
+			},
+		},
+		{
+			name:     "Test 5. Retries exhausted.",
+			statuses: []int{500, 500, 500, 500},
+			event:    NewAlertEvent(alert, now),
+			wantRequests: []receivedRequest{
+				{body: alertJSON}, {body: alertJSON}, {body: alertJSON}, {body: alertJSON},
+			},
+			wantErr: ErrDeliveryFailed,
+		},
+		{
+			name:         "Test 6. 4xx is not retried.",
+			statuses:     []int{http.StatusBadRequest},
+			event:        NewAlertEvent(alert, now),
+			wantRequests: []receivedRequest{{body: alertJSON}},
+			wantErr:      ErrDeliveryFailed,
+		},
+		{
+			name:            "Test 7. Templated body with content type.",
+			templatePath:    textTemplatePath,
+			contentType:     "text/plain; charset=utf-8",
+			event:           NewAlertEvent(alert, now),
+			wantRequests:    []receivedRequest{{body: `alert_firing: HighCPU`}},
+			wantContentType: "text/plain; charset=utf-8",
+		},
+		{
+			name:         "Test 8. Content type without template is ignored.",
+			contentType:  "text/plain; charset=utf-8",
+			event:        NewAlertEvent(alert, now),
+			wantRequests: []receivedRequest{{body: alertJSON}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := &testReceiver{statuses: tt.statuses}
+			ts := httptest.NewServer(receiver)
+			defer ts.Close()
+
+			n, err := NewNotifier([]string{ts.URL}, tt.key, tt.templatePath)
+			require.NoError(t, err)
+			n.RetryInterval = time.Millisecond
+			n.ContentType = tt.contentType
+			wantContentType := tt.wantContentType
+			if wantContentType == "" {
+				wantContentType = "application/json"
+			}
+
+			err = n.Notify(context.Background(), tt.event)
+			assert.ErrorIs(t, err, tt.wantErr)
+			require.Len(t, receiver.requests, len(tt.wantRequests))
+			for i, want := range tt.wantRequests {
+				assert.Equal(t, wantContentType, receiver.requests[i].contentType)
+				if wantContentType == "application/json" {
+					assert.JSONEq(t, want.body, receiver.requests[i].body)
+				} else {
+					assert.Equal(t, want.body, receiver.requests[i].body)
+				}
+				// подпись проверяется по фактически полученному телу
+				wantSignature := ""
+				if tt.key != "" {
+					wantSignature = Sign([]byte(receiver.requests[i].body), tt.key)
+				}
+				assert.Equal(t, wantSignature, receiver.requests[i].signature)
+			}
+		})
+	}
+}
+
+func TestNewNotifier(t *testing.T) {
+	_, err := NewNotifier([]string{"http://localhost"}, "", filepath.Join(t.TempDir(), "not_exist.tmpl"))
+	assert.Error(t, err)
+
+	var n *Notifier
+	assert.NoError(t, n.Notify(context.Background(), Event{Type: EventAgentSilent}))
+}
+
+func TestNewAlertEvent(t *testing.T) {
+	now := time.Now()
+	assert.Equal(t, EventAlertFiring, NewAlertEvent(alerts.Alert{State: alerts.StateFiring}, now).Type)
+	assert.Equal(t, EventAlertResolved, NewAlertEvent(alerts.Alert{State: alerts.StateResolved}, now).Type)
+}
@@ -0,0 +1,263 @@
+// Package query реализует выборку метрик из хранилища по условиям: фильтрация по названию(glob/regex) и типу,
+// сортировка, пагинация и агрегация(sum/min/max/avg) найденных метрик.
+package query
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+// ErrIncorrectQuery ошибка "некорректные параметры запроса".
+var ErrIncorrectQuery = errors.New("incorrect query")
+
+// Поля сортировки.
+const (
+	SortByName  = "name"
+	SortByValue = "value"
+)
+
+// Функции агрегации.
+const (
+	AggregationSum = "sum"
+	AggregationMin = "min"
+	AggregationMax = "max"
+	AggregationAvg = "avg"
+)
+
+// Query параметры выборки метрик.
+type Query struct {
+	// Name glob шаблон названия метрики(синтаксис path.Match), например CPUutilization*.
+	Name string
+	// Regex регулярное выражение для названия метрики.
+	Regex *regexp.Regexp
+	// Type тип метрики(gauge/counter), пустая строка - любой тип.
+	Type string
+	// SortBy поле сортировки(name/value).
+	SortBy string
+	// Desc сортировка по убыванию.
+	Desc bool
+	// Aggregation функция агрегации, пустая строка - без агрегации.
+	Aggregation string
+	// Limit максимальное кол-во метрик в ответе, 0 - без ограничения.
+	Limit int
+	// Offset кол-во пропускаемых метрик(после сортировки).
+	Offset int
+}
+
+// ParseQuery возвращает Query по параметрам url запроса:
+// name, regex, type, sort(name/value), order(asc/desc), agg(sum/min/max/avg), limit, offset.
+func ParseQuery(values url.Values) (*Query, error) {
+	q := &Query{
+		Name:        values.Get("name"),
+		Type:        values.Get("type"),
+		SortBy:      values.Get("sort"),
+		Aggregation: values.Get("agg"),
+	}
+
+	if q.Name != "" {
+		if _, err := path.Match(q.Name, ""); err != nil {
+			return nil, fmt.Errorf("%w: param 'name': %s", ErrIncorrectQuery, err)
+		}
+	}
+	if rawRegex := values.Get("regex"); rawRegex != "" {
+		var err error
+		q.Regex, err = regexp.Compile(rawRegex)
+		if err != nil {
+			return nil, fmt.Errorf("%w: param 'regex': %s", ErrIncorrectQuery, err)
+		}
+	}
+
+	switch q.Type {
+	case "", internal.GaugeTypeName, internal.CounterTypeName:
+	default:
+		return nil, fmt.Errorf("%w: unknown type '%s'", ErrIncorrectQuery, q.Type)
+	}
+
+	switch q.SortBy {
+	case "":
+		q.SortBy = SortByName
+	case SortByName, SortByValue:
+	default:
+		return nil, fmt.Errorf("%w: unknown sort field '%s'", ErrIncorrectQuery, q.SortBy)
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return nil, fmt.Errorf("%w: unknown order '%s'", ErrIncorrectQuery, values.Get("order"))
+	}
+
+	switch q.Aggregation {
+	case "", AggregationSum, AggregationMin, AggregationMax, AggregationAvg:
+	default:
+		return nil, fmt.Errorf("%w: unknown aggregation '%s'", ErrIncorrectQuery, q.Aggregation)
+	}
+
+	var err error
+	if q.Limit, err = parseNotNegativeInt(values, "limit"); err != nil {
+		return nil, err
+	}
+	if q.Offset, err = parseNotNegativeInt(values, "offset"); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// parseNotNegativeInt возвращает значение параметра `key` как неотрицательное число(0, если параметр не задан).
+func parseNotNegativeInt(values url.Values, key string) (int, error) {
+	rawValue := values.Get(key)
+	if rawValue == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(rawValue)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%w: param '%s' must be not negative integer", ErrIncorrectQuery, key)
+	}
+	return value, nil
+}
+
+// Match возвращает true, если метрика удовлетворяет условиям фильтрации запроса.
+func (q *Query) Match(msg message.Metrics) bool {
+	if q.Type != "" && msg.MType != q.Type {
+		return false
+	}
+	if q.Name != "" {
+		// шаблон проверен в ParseQuery, ошибки быть не может
+		if ok, _ := path.Match(q.Name, msg.ID); !ok {
+			return false
+		}
+	}
+	if q.Regex != nil && !q.Regex.MatchString(msg.ID) {
+		return false
+	}
+	return true
+}
+
+// Apply выполняет запрос над метриками.
+// Возвращает найденные метрики(с учетом пагинации или агрегации) и общее кол-во найденных метрик.
+func (q *Query) Apply(metrics map[string]storage.Metric) ([]message.Metrics, int) {
+	result := make([]message.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		msg := metric.GetMessageMetric()
+		if q.Match(msg) {
+			result = append(result, msg)
+		}
+	}
+	total := len(result)
+
+	if q.Aggregation != "" {
+		if total == 0 {
+			return result, total
+		}
+		return []message.Metrics{q.aggregate(result)}, total
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if q.Desc {
+			i, j = j, i
+		}
+		if q.SortBy == SortByValue {
+			vI, vJ := metricValue(result[i]), metricValue(result[j])
+			if vI != vJ {
+				return vI < vJ
+			}
+		}
+		return result[i].ID < result[j].ID
+	})
+
+	if q.Offset >= len(result) {
+		return result[:0], total
+	}
+	result = result[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(result) {
+		result = result[:q.Limit]
+	}
+	return result, total
+}
+
+// aggregate агрегирует метрики функцией Aggregation.
+// Если все метрики counter и функция не avg - результат counter метрика(агрегация в int64), иначе gauge.
+// Название результата - "<функция>(<условие по названию>)", например sum(CPUutilization*).
+func (q *Query) aggregate(metrics []message.Metrics) message.Metrics {
+	aggMsg := message.Metrics{ID: fmt.Sprintf("%s(%s)", q.Aggregation, q.nameCondition())}
+
+	allCounters := q.Aggregation != AggregationAvg
+	for _, msg := range metrics {
+		if msg.MType != internal.CounterTypeName {
+			allCounters = false
+		}
+	}
+
+	if allCounters {
+		delta := *metrics[0].Delta
+		for _, msg := range metrics[1:] {
+			switch q.Aggregation {
+			case AggregationSum:
+				delta += *msg.Delta
+			case AggregationMin:
+				if *msg.Delta < delta {
+					delta = *msg.Delta
+				}
+			case AggregationMax:
+				if *msg.Delta > delta {
+					delta = *msg.Delta
+				}
+			}
+		}
+		aggMsg.MType, aggMsg.Delta = internal.CounterTypeName, &delta
+		return aggMsg
+	}
+
+	value := metricValue(metrics[0])
+	for _, msg := range metrics[1:] {
+		switch q.Aggregation {
+		case AggregationSum, AggregationAvg:
+			value += metricValue(msg)
+		case AggregationMin:
+			value = math.Min(value, metricValue(msg))
+		case AggregationMax:
+			value = math.Max(value, metricValue(msg))
+		}
+	}
+	if q.Aggregation == AggregationAvg {
+		value /= float64(len(metrics))
+	}
+	aggMsg.MType, aggMsg.Value = internal.GaugeTypeName, &value
+	return aggMsg
+}
+
+// nameCondition возвращает условие по названию метрики в текстовом виде.
+func (q *Query) nameCondition() string {
+	switch {
+	case q.Name != "" && q.Regex != nil:
+		return q.Name + "," + q.Regex.String()
+	case q.Regex != nil:
+		return q.Regex.String()
+	case q.Name != "":
+		return q.Name
+	}
+	return "*"
+}
+
+// metricValue возвращает значение метрики как float64.
+func metricValue(msg message.Metrics) float64 {
+	switch {
+	case msg.Value != nil:
+		return *msg.Value
+	case msg.Delta != nil:
+		return float64(*msg.Delta)
+	}
+	return 0
+}
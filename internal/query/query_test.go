@@ -0,0 +1,215 @@
+package query
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+func newTestMetric(name, typeName string, value interface{}) storage.Metric {
+	m, err := storage.NewMetric(name, typeName, value)
+	if err != nil {
+		panic(err)
+	}
+	return *m
+}
+
+func getTestMetrics() map[string]storage.Metric {
+	metrics := []storage.Metric{
+		newTestMetric("CPUutilization0", internal.GaugeTypeName, 10.0),
+		newTestMetric("CPUutilization1", internal.GaugeTypeName, 30.0),
+		newTestMetric("CPUutilization2", internal.GaugeTypeName, 20.0),
+		newTestMetric("FreeMemory", internal.GaugeTypeName, 1024.0),
+		newTestMetric("PollCount", internal.CounterTypeName, int64(5)),
+		newTestMetric("Requests", internal.CounterTypeName, int64(7)),
+	}
+	result := map[string]storage.Metric{}
+	for _, m := range metrics {
+		result[m.Name] = m
+	}
+	return result
+}
+
+// getIDs возвращает названия метрик в порядке следования.
+func getIDs(metrics []message.Metrics) []string {
+	ids := make([]string, 0, len(metrics))
+	for _, m := range metrics {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawQuery string
+		wantErr  bool
+	}{
+		{name: "Test 1. Empty query.", rawQuery: "", wantErr: false},
+		{
+			name:     "Test 2. All params set.",
+			rawQuery: "name=CPU*&regex=^CPU&type=gauge&sort=value&order=desc&limit=2&offset=1&agg=avg",
+			wantErr:  false,
+		},
+		{name: "Test 3. Incorrect glob.", rawQuery: "name=CPU[", wantErr: true},
+		{name: "Test 4. Incorrect regex.", rawQuery: "regex=CPU(", wantErr: true},
+		{name: "Test 5. Unknown type.", rawQuery: "type=histogram", wantErr: true},
+		{name: "Test 6. Unknown sort field.", rawQuery: "sort=type", wantErr: true},
+		{name: "Test 7. Unknown order.", rawQuery: "order=random", wantErr: true},
+		{name: "Test 8. Unknown aggregation.", rawQuery: "agg=median", wantErr: true},
+		{name: "Test 9. Negative limit.", rawQuery: "limit=-1", wantErr: true},
+		{name: "Test 10. Not integer offset.", rawQuery: "offset=first", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.rawQuery)
+			require.NoError(t, err)
+			_, err = ParseQuery(values)
+			assert.Equal(t, tt.wantErr, err != nil)
+			if err != nil {
+				assert.ErrorIs(t, err, ErrIncorrectQuery)
+			}
+		})
+	}
+}
+
+func TestQuery_Apply(t *testing.T) {
+	tests := []struct {
+		name      string
+		rawQuery  string
+		wantIDs   []string
+		wantTotal int
+	}{
+		{
+			name:     "Test 1. Empty query, all metrics sorted by name.",
+			rawQuery: "",
+			wantIDs: []string{
+				"CPUutilization0", "CPUutilization1", "CPUutilization2", "FreeMemory", "PollCount", "Requests",
+			},
+			wantTotal: 6,
+		},
+		{
+			name:      "Test 2. Glob.",
+			rawQuery:  "name=CPUutilization*",
+			wantIDs:   []string{"CPUutilization0", "CPUutilization1", "CPUutilization2"},
+			wantTotal: 3,
+		},
+		{
+			name:      "Test 3. Regex.",
+			rawQuery:  "regex=^(Free|Poll)",
+			wantIDs:   []string{"FreeMemory", "PollCount"},
+			wantTotal: 2,
+		},
+		{
+			name:      "Test 4. Type filter.",
+			rawQuery:  "type=counter",
+			wantIDs:   []string{"PollCount", "Requests"},
+			wantTotal: 2,
+		},
+		{
+			name:      "Test 5. Sort by value desc.",
+			rawQuery:  "name=CPUutilization*&sort=value&order=desc",
+			wantIDs:   []string{"CPUutilization1", "CPUutilization2", "CPUutilization0"},
+			wantTotal: 3,
+		},
+		{
+			name:      "Test 6. Pagination.",
+			rawQuery:  "limit=2&offset=1",
+			wantIDs:   []string{"CPUutilization1", "CPUutilization2"},
+			wantTotal: 6,
+		},
+		{
+			name:      "Test 7. Offset out of range.",
+			rawQuery:  "offset=10",
+			wantIDs:   []string{},
+			wantTotal: 6,
+		},
+		{
+			name:      "Test 8. Nothing matched.",
+			rawQuery:  "name=Unknown*",
+			wantIDs:   []string{},
+			wantTotal: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.rawQuery)
+			require.NoError(t, err)
+			q, err := ParseQuery(values)
+			require.NoError(t, err)
+
+			result, total := q.Apply(getTestMetrics())
+			assert.Equal(t, tt.wantIDs, getIDs(result))
+			assert.Equal(t, tt.wantTotal, total)
+		})
+	}
+}
+
+func TestQuery_ApplyAggregation(t *testing.T) {
+	float64Value := func(v float64) *float64 { return &v }
+	int64Value := func(v int64) *int64 { return &v }
+
+	tests := []struct {
+		name     string
+		rawQuery string
+		want     []message.Metrics
+	}{
+		{
+			name:     "Test 1. Sum of gauges.",
+			rawQuery: "name=CPUutilization*&agg=sum",
+			want: []message.Metrics{
+				{ID: "sum(CPUutilization*)", MType: internal.GaugeTypeName, Value: float64Value(60)},
+			},
+		},
+		{
+			name:     "Test 2. Avg of gauges.",
+			rawQuery: "name=CPUutilization*&agg=avg",
+			want: []message.Metrics{
+				{ID: "avg(CPUutilization*)", MType: internal.GaugeTypeName, Value: float64Value(20)},
+			},
+		},
+		{
+			name:     "Test 3. Min of gauges by regex.",
+			rawQuery: "regex=^CPU&agg=min",
+			want: []message.Metrics{
+				{ID: "min(^CPU)", MType: internal.GaugeTypeName, Value: float64Value(10)},
+			},
+		},
+		{
+			name:     "Test 4. Max of counters is counter.",
+			rawQuery: "type=counter&agg=max",
+			want: []message.Metrics{
+				{ID: "max(*)", MType: internal.CounterTypeName, Delta: int64Value(7)},
+			},
+		},
+		{
+			name:     "Test 5. Avg of counters is gauge.",
+			rawQuery: "type=counter&agg=avg",
+			want: []message.Metrics{
+				{ID: "avg(*)", MType: internal.GaugeTypeName, Value: float64Value(6)},
+			},
+		},
+		{
+			name:     "Test 6. Nothing matched.",
+			rawQuery: "name=Unknown*&agg=sum",
+			want:     []message.Metrics{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.rawQuery)
+			require.NoError(t, err)
+			q, err := ParseQuery(values)
+			require.NoError(t, err)
+
+			result, _ := q.Apply(getTestMetrics())
+			assert.Equal(t, tt.want, result)
+		})
+	}
+}
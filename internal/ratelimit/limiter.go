@@ -0,0 +1,102 @@
+// Package ratelimit реализует ограничение частоты запросов алгоритмом token bucket, отдельно для каждого ключа.
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// cleanupInterval как часто удаляются корзины ключей, давно не делавших запросов.
+const cleanupInterval = time.Minute
+
+// maxBuckets максимальное число хранимых корзин. При превышении удаляется корзина ключа,
+// дольше всех не делавшего запросов.
+const maxBuckets = 100000
+
+// bucket корзина токенов одного ключа.
+type bucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+	element *list.Element
+}
+
+// Limiter ограничивает частоту запросов: rate запросов в секунду, с возможностью всплеска до burst запросов.
+type Limiter struct {
+	rate        float64
+	burst       float64
+	buckets     map[string]*bucket
+	recent      *list.List // корзины по времени последнего запроса, в начале - последние
+	maxBuckets  int
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+// NewLimiter конструктор Limiter. Если burst < 1 - используется округленный вверх rate(но не меньше 1).
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &Limiter{
+		rate:       rate,
+		burst:      float64(burst),
+		buckets:    map[string]*bucket{},
+		recent:     list.New(),
+		maxBuckets: maxBuckets,
+	}
+}
+
+// Allow списывает токен из корзины ключа key на момент now.
+// Если токенов нет - возвращает false и время, через которое появится следующий токен.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.cleanup(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= l.maxBuckets {
+			l.remove(l.recent.Back().Value.(*bucket))
+		}
+		b = &bucket{key: key, tokens: l.burst, updated: now}
+		b.element = l.recent.PushFront(b)
+		l.buckets[key] = b
+	} else {
+		l.recent.MoveToFront(b.element)
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+		b.updated = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// cleanup удаляет корзины, которые за время простоя полностью восполнились(не отличаются от новых).
+func (l *Limiter) cleanup(now time.Time) {
+	if now.Sub(l.lastCleanup) < cleanupInterval {
+		return
+	}
+	l.lastCleanup = now
+	fullAfter := time.Duration(l.burst / l.rate * float64(time.Second))
+	for e := l.recent.Back(); e != nil; e = l.recent.Back() {
+		b := e.Value.(*bucket)
+		if now.Sub(b.updated) < fullAfter {
+			return
+		}
+		l.remove(b)
+	}
+}
+
+// remove удаляет корзину.
+func (l *Limiter) remove(b *bucket) {
+	l.recent.Remove(b.element)
+	delete(l.buckets, b.key)
+}
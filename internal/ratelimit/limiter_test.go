@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	type call struct {
+		key       string
+		offset    time.Duration
+		wantAllow bool
+		wantWait  time.Duration
+	}
+	tests := []struct {
+		name  string
+		rate  float64
+		burst int
+		calls []call
+	}{
+		{
+			name:  "Test 1. Burst then limited, tokens are refilled.",
+			rate:  2,
+			burst: 2,
+			calls: []call{
+				{key: "a", wantAllow: true},
+				{key: "a", wantAllow: true},
+				{key: "a", wantAllow: false, wantWait: 500 * time.Millisecond},
+				{key: "a", offset: 250 * time.Millisecond, wantAllow: false, wantWait: 250 * time.Millisecond},
+				{key: "a", offset: 500 * time.Millisecond, wantAllow: true},
+			},
+		},
+		{
+			name:  "Test 2. Keys are limited separately.",
+			rate:  1,
+			burst: 1,
+			calls: []call{
+				{key: "a", wantAllow: true},
+				{key: "a", wantAllow: false, wantWait: time.Second},
+				{key: "b", wantAllow: true},
+			},
+		},
+		{
+			name:  "Test 3. Default burst is rate rounded up.",
+			rate:  0.5,
+			burst: 0,
+			calls: []call{
+				{key: "a", wantAllow: true},
+				{key: "a", wantAllow: false, wantWait: 2 * time.Second},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewLimiter(tt.rate, tt.burst)
+			for i, c := range tt.calls {
+				allow, wait := limiter.Allow(c.key, start.Add(c.offset))
+				assert.Equal(t, c.wantAllow, allow, "call %d", i)
+				assert.Equal(t, c.wantWait, wait, "call %d", i)
+			}
+		})
+	}
+}
+
+func TestLimiter_cleanup(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(1, 5)
+	limiter.Allow("idle", start)
+	limiter.Allow("active", start.Add(cleanupInterval-time.Second))
+	assert.Len(t, limiter.buckets, 2)
+
+	limiter.Allow("active", start.Add(cleanupInterval+time.Second))
+	assert.Len(t, limiter.buckets, 1)
+	assert.Contains(t, limiter.buckets, "active")
+}
+
+func TestLimiter_maxBuckets(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(0.1, 1)
+	limiter.maxBuckets = 2
+	limiter.Allow("a", start)
+	limiter.Allow("b", start.Add(time.Second))
+	limiter.Allow("a", start.Add(2*time.Second))
+
+	// корзина "b" дольше всех без запросов - удаляется при добавлении новой
+	limiter.Allow("c", start.Add(3*time.Second))
+	assert.Len(t, limiter.buckets, 2)
+	assert.Equal(t, 2, limiter.recent.Len())
+	assert.Contains(t, limiter.buckets, "a")
+	assert.Contains(t, limiter.buckets, "c")
+
+	allow, _ := limiter.Allow("a", start.Add(4*time.Second))
+	assert.False(t, allow)
+}
@@ -0,0 +1,178 @@
+// Package relabel реализует правила перемаркировки(relabel) метрик в стиле Prometheus:
+// отбор(keep), отбрасывание(drop) и замена(replace) значений меток, в т.ч. названия метрики(__name__).
+// Правила загружаются из json файла и применяются по порядку.
+package relabel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ErrIncorrectRule ошибка "некорректное правило relabel".
+var ErrIncorrectRule = errors.New("incorrect relabel rule")
+
+// Действия правил.
+const (
+	ActionReplace = "replace"
+	ActionKeep    = "keep"
+	ActionDrop    = "drop"
+)
+
+// Метки метрики, доступные правилам.
+const (
+	// LabelName название метрики, изменение метки переименовывает метрику.
+	LabelName = "__name__"
+	// LabelType тип метрики(gauge/counter).
+	LabelType = "type"
+	// LabelInstance адрес агента, отправившего метрику.
+	LabelInstance = "instance"
+)
+
+// Значения полей правила по умолчанию(как в Prometheus).
+const (
+	defaultSeparator   = ";"
+	defaultRegex       = "(.*)"
+	defaultReplacement = "$1"
+)
+
+// Rule правило relabel.
+// Значения меток SourceLabels объединяются через Separator и сравниваются с Regex(целиком).
+// keep - метрика остается только при совпадении, drop - отбрасывается при совпадении,
+// replace - при совпадении метке TargetLabel присваивается Replacement(с подстановкой групп $1, ${name}).
+type Rule struct {
+	SourceLabels []string
+	Separator    string
+	Regex        *regexp.Regexp
+	Action       string
+	TargetLabel  string
+	Replacement  string
+}
+
+// ruleConfig представление Rule в json файле правил.
+type ruleConfig struct {
+	SourceLabels []string `json:"source_labels"`
+	Separator    *string  `json:"separator"`
+	Regex        *string  `json:"regex"`
+	Action       string   `json:"action"`
+	TargetLabel  string   `json:"target_label"`
+	Replacement  *string  `json:"replacement"`
+}
+
+// rulesConfig структура json файла правил.
+type rulesConfig struct {
+	Rules []ruleConfig `json:"rules"`
+}
+
+// LoadRules читает и проверяет правила relabel из json файла.
+func LoadRules(filePath string) ([]Rule, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := rulesConfig{}
+	if err = json.NewDecoder(f).Decode(&config); err != nil {
+		return nil, err
+	}
+
+	rules := make([]Rule, 0, len(config.Rules))
+	for i, rc := range config.Rules {
+		rule, err := rc.rule()
+		if err != nil {
+			return nil, fmt.Errorf("%w #%d: %s", ErrIncorrectRule, i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// rule возвращает Rule из ruleConfig, заполняя незаданные поля значениями по умолчанию.
+func (rc ruleConfig) rule() (Rule, error) {
+	rule := Rule{
+		SourceLabels: rc.SourceLabels,
+		Separator:    defaultSeparator,
+		Action:       rc.Action,
+		TargetLabel:  rc.TargetLabel,
+		Replacement:  defaultReplacement,
+	}
+	if rule.Action == "" {
+		rule.Action = ActionReplace
+	}
+	if rc.Separator != nil {
+		rule.Separator = *rc.Separator
+	}
+	if rc.Replacement != nil {
+		rule.Replacement = *rc.Replacement
+	}
+	rawRegex := defaultRegex
+	if rc.Regex != nil {
+		rawRegex = *rc.Regex
+	}
+	regex, err := regexp.Compile("^(?:" + rawRegex + ")$")
+	if err != nil {
+		return Rule{}, err
+	}
+	rule.Regex = regex
+
+	switch rule.Action {
+	case ActionKeep, ActionDrop:
+		if len(rule.SourceLabels) == 0 {
+			return Rule{}, fmt.Errorf("action '%s' requires source_labels", rule.Action)
+		}
+	case ActionReplace:
+		if rule.TargetLabel == "" {
+			return Rule{}, fmt.Errorf("action '%s' requires target_label", rule.Action)
+		}
+	default:
+		return Rule{}, fmt.Errorf("unknown action '%s'", rule.Action)
+	}
+	return rule, nil
+}
+
+// Relabel применяет правила rules к меткам labels по порядку, labels не изменяется.
+// Возвращает итоговые метки и false, если метрика отброшена(drop/keep или пустое название).
+func Relabel(rules []Rule, labels map[string]string) (map[string]string, bool) {
+	result := make(map[string]string, len(labels))
+	for k, v := range labels {
+		result[k] = v
+	}
+
+	for _, rule := range rules {
+		values := make([]string, 0, len(rule.SourceLabels))
+		for _, label := range rule.SourceLabels {
+			values = append(values, result[label])
+		}
+		value := strings.Join(values, rule.Separator)
+
+		switch rule.Action {
+		case ActionKeep:
+			if !rule.Regex.MatchString(value) {
+				return nil, false
+			}
+		case ActionDrop:
+			if rule.Regex.MatchString(value) {
+				return nil, false
+			}
+		case ActionReplace:
+			match := rule.Regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			target := string(rule.Regex.ExpandString(nil, rule.Replacement, value, match))
+			if target == "" {
+				delete(result, rule.TargetLabel)
+			} else {
+				result[rule.TargetLabel] = target
+			}
+		}
+	}
+	if result[LabelName] == "" {
+		return nil, false
+	}
+	return result, true
+}
@@ -0,0 +1,169 @@
+package relabel
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantRules []Rule
+		wantErr   error
+	}{
+		{
+			name: "Test 1. Correct rules.",
+			content: `{"rules":[` +
+				`{"source_labels":["__name__"],"regex":"Lookups|RandomValue","action":"drop"},` +
+				`{"source_labels":["__name__"],"regex":"CPUutilization(\\d+)","target_label":"__name__",` +
+				`"replacement":"cpu_$1"}]}`,
+			wantRules: []Rule{
+				{
+					SourceLabels: []string{LabelName}, Separator: ";", Regex: regexp.MustCompile("^(?:Lookups|RandomValue)$"),
+					Action: ActionDrop, Replacement: "$1",
+				},
+				{
+					SourceLabels: []string{LabelName}, Separator: ";", Regex: regexp.MustCompile(`^(?:CPUutilization(\d+))$`),
+					Action: ActionReplace, TargetLabel: LabelName, Replacement: "cpu_$1",
+				},
+			},
+		},
+		{
+			name:    "Test 2. Unknown action.",
+			content: `{"rules":[{"source_labels":["__name__"],"action":"hashmod"}]}`,
+			wantErr: ErrIncorrectRule,
+		},
+		{
+			name:    "Test 3. Incorrect regex.",
+			content: `{"rules":[{"source_labels":["__name__"],"regex":"CPU[","action":"drop"}]}`,
+			wantErr: ErrIncorrectRule,
+		},
+		{
+			name:    "Test 4. Replace without target label.",
+			content: `{"rules":[{"source_labels":["__name__"],"replacement":"new"}]}`,
+			wantErr: ErrIncorrectRule,
+		},
+		{
+			name:    "Test 5. Keep without source labels.",
+			content: `{"rules":[{"regex":"CPU.*","action":"keep"}]}`,
+			wantErr: ErrIncorrectRule,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "relabel.json")
+			require.NoError(t, os.WriteFile(filePath, []byte(tt.content), 0644))
+
+			rules, err := LoadRules(filePath)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantRules, rules)
+		})
+	}
+
+	_, err := LoadRules(filepath.Join(t.TempDir(), "missing.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestRelabel(t *testing.T) {
+	rule := func(rc ruleConfig) Rule {
+		r, err := rc.rule()
+		require.NoError(t, err)
+		return r
+	}
+	ptr := func(s string) *string {
+		return &s
+	}
+	dropNoisy := rule(ruleConfig{SourceLabels: []string{LabelName}, Regex: ptr("Lookups|RandomValue"), Action: ActionDrop})
+	keepGauges := rule(ruleConfig{SourceLabels: []string{LabelType}, Regex: ptr("gauge"), Action: ActionKeep})
+	renameCPU := rule(ruleConfig{
+		SourceLabels: []string{LabelName}, Regex: ptr(`CPUutilization(\d+)`),
+		TargetLabel: LabelName, Replacement: ptr("cpu_utilization_$1"),
+	})
+	prefixByInstance := rule(ruleConfig{
+		SourceLabels: []string{LabelInstance, LabelName}, Separator: ptr("/"), Regex: ptr(`10\.0\.0\.1/(.*)`),
+		TargetLabel: LabelName, Replacement: ptr("edge_$1"),
+	})
+	clearName := rule(ruleConfig{
+		SourceLabels: []string{LabelName}, Regex: ptr("Debug.*"), TargetLabel: LabelName, Replacement: ptr(""),
+	})
+
+	labels := func(name, typeName, instance string) map[string]string {
+		return map[string]string{LabelName: name, LabelType: typeName, LabelInstance: instance}
+	}
+
+	tests := []struct {
+		name       string
+		rules      []Rule
+		labels     map[string]string
+		wantLabels map[string]string
+		wantKeep   bool
+	}{
+		{
+			name:       "Test 1. No rules.",
+			labels:     labels("Alloc", "gauge", "127.0.0.1"),
+			wantLabels: labels("Alloc", "gauge", "127.0.0.1"),
+			wantKeep:   true,
+		},
+		{
+			name:     "Test 2. Drop by name.",
+			rules:    []Rule{dropNoisy, renameCPU},
+			labels:   labels("RandomValue", "gauge", "127.0.0.1"),
+			wantKeep: false,
+		},
+		{
+			name:       "Test 3. Rename by name.",
+			rules:      []Rule{dropNoisy, renameCPU},
+			labels:     labels("CPUutilization1", "gauge", "127.0.0.1"),
+			wantLabels: labels("cpu_utilization_1", "gauge", "127.0.0.1"),
+			wantKeep:   true,
+		},
+		{
+			name:       "Test 4. Not matched replace.",
+			rules:      []Rule{renameCPU},
+			labels:     labels("Alloc", "gauge", "127.0.0.1"),
+			wantLabels: labels("Alloc", "gauge", "127.0.0.1"),
+			wantKeep:   true,
+		},
+		{
+			name:     "Test 5. Keep by type.",
+			rules:    []Rule{keepGauges},
+			labels:   labels("PollCount", "counter", "127.0.0.1"),
+			wantKeep: false,
+		},
+		{
+			name:       "Test 6. Several source labels.",
+			rules:      []Rule{prefixByInstance},
+			labels:     labels("Alloc", "gauge", "10.0.0.1"),
+			wantLabels: labels("edge_Alloc", "gauge", "10.0.0.1"),
+			wantKeep:   true,
+		},
+		{
+			name:     "Test 7. Empty name drops metric.",
+			rules:    []Rule{clearName},
+			labels:   labels("DebugValue", "gauge", "127.0.0.1"),
+			wantKeep: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := map[string]string{}
+			for k, v := range tt.labels {
+				before[k] = v
+			}
+			got, keep := Relabel(tt.rules, tt.labels)
+			assert.Equal(t, tt.wantKeep, keep)
+			assert.Equal(t, tt.wantLabels, got)
+			assert.Equal(t, before, tt.labels)
+		})
+	}
+}
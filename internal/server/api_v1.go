@@ -0,0 +1,308 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/alerts"
+	"github.com/firesworder/devopsmetrics/internal/counters"
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/query"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+// Режимы передачи counter метрик, задаются заголовком запроса counterModeHeader.
+const (
+	counterModeHeader = message.CounterModeHeader
+	// deltaCounterMode передается прирост счетчика(режим по умолчанию).
+	deltaCounterMode = "delta"
+	// cumulativeCounterMode передается значение счетчика с момента старта источника.
+	cumulativeCounterMode = "cumulative"
+)
+
+// streamKeepAliveInterval интервал отправки keep-alive комментариев в SSE поток.
+var streamKeepAliveInterval = 15 * time.Second
+
+// errUnknownCounterMode ошибка "передан неизвестный режим counter метрик".
+var errUnknownCounterMode = errors.New("unknown counter mode")
+
+// rateResponse ответ хендлера handlerRate.
+type rateResponse struct {
+	ID     string  `json:"id"`
+	Window string  `json:"window"`
+	Rate   float64 `json:"rate"`
+}
+
+// handlerRate godoc
+//
+//	@Tags			JSON
+//	@Summary		Обрабатывает GET запросы получения скорости роста counter метрики.
+//	@Description	Скорость(в секунду) вычисляется по истории полученных сервером дельт счетчика за окно window.
+//
+// Окно не может превышать время хранения истории(COUNTER_HISTORY).
+//
+//	@ID				handlerRate
+//	@Produce		json
+//	@Param			name	query		string	true	"Название метрики"
+//	@Param			window	query		string	true	"Окно расчета(например, 1m)"
+//	@Success		200		{string}	string	"ok"
+//	@Failure		400		{string}	string	"Неверный запрос"
+//	@Failure		404		{string}	string	"unknown metric"
+//	@Failure		500		{string}	string	"Внутренняя ошибка"
+//	@Security		BearerAuth
+//	@Router			/api/v1/rate [get]
+func (s *Server) handlerRate(writer http.ResponseWriter, request *http.Request) {
+	name := request.URL.Query().Get("name")
+	if name == "" {
+		http.Error(writer, "param 'name' is required", http.StatusBadRequest)
+		return
+	}
+	window, err := time.ParseDuration(request.URL.Query().Get("window"))
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("param 'window' is incorrect: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	metric, err := s.MetricStorage.GetMetric(request.Context(), name)
+	if err != nil {
+		if errors.Is(err, storage.ErrMetricNotFound) {
+			http.Error(writer, "unknown metric", http.StatusNotFound)
+		} else {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if _, _, mT := metric.GetMetricParamsString(); mT != internal.CounterTypeName {
+		http.Error(writer, "rate is available only for counter metrics", http.StatusBadRequest)
+		return
+	}
+
+	rate, err := s.CounterHistory.Rate(name, window, time.Now())
+	if err != nil {
+		if errors.Is(err, counters.ErrHistoryDisabled) {
+			http.Error(writer, err.Error(), http.StatusNotImplemented)
+		} else {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	msgJSON, err := json.Marshal(rateResponse{ID: name, Window: window.String(), Rate: rate})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(msgJSON)
+}
+
+// handlerQuery godoc
+//
+//	@Tags			JSON
+//	@Summary		Обрабатывает GET запросы выборки метрик по условиям.
+//	@Description	В ответ возвращает массив message.Metrics найденных метрик.
+//
+// Общее кол-во найденных метрик(до пагинации) возвращается в заголовке X-Total-Count.
+// Если задана агрегация - массив содержит одну метрику "<agg>(<условие по названию>)".
+//
+//	@ID				handlerQuery
+//	@Produce		json
+//	@Param			name	query		string	false	"glob шаблон названия метрики, например CPUutilization*"
+//	@Param			regex	query		string	false	"Регулярное выражение для названия метрики"
+//	@Param			type	query		string	false	"Тип метрики(gauge/counter)"
+//	@Param			sort	query		string	false	"Поле сортировки(name/value), по умолчанию name"
+//	@Param			order	query		string	false	"Порядок сортировки(asc/desc), по умолчанию asc"
+//	@Param			limit	query		int		false	"Максимальное кол-во метрик в ответе"
+//	@Param			offset	query		int		false	"Кол-во пропускаемых метрик"
+//	@Param			agg		query		string	false	"Агрегация найденных метрик(sum/min/max/avg)"
+//	@Success		200		{string}	string	"ok"
+//	@Failure		400		{string}	string	"Неверный запрос"
+//	@Failure		500		{string}	string	"Внутренняя ошибка"
+//	@Security		BearerAuth
+//	@Router			/api/v1/query [get]
+func (s *Server) handlerQuery(writer http.ResponseWriter, request *http.Request) {
+	q, err := query.ParseQuery(request.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	allMetrics, err := s.MetricStorage.GetAll(request.Context())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	result, total := q.Apply(allMetrics)
+	for i := range result {
+		if q.Aggregation == "" {
+			result[i].Stale = s.isMetricStale(result[i].ID)
+		}
+		if Env.Key != "" {
+			if err = result[i].InitHash(Env.Key); err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	msgJSON, err := json.Marshal(result)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("X-Total-Count", strconv.Itoa(total))
+	writer.Write(msgJSON)
+}
+
+// publishMetricsUpdate рассылает SSE подписчикам текущие(после обновления) значения метрик.
+func (s *Server) publishMetricsUpdate(ctx context.Context, metrics ...storage.Metric) {
+	if !s.streamHub.HasSubscribers() {
+		return
+	}
+
+	msgs := make([]message.Metrics, 0, len(metrics))
+	published := map[string]bool{}
+	for _, metric := range metrics {
+		if published[metric.Name] {
+			continue
+		}
+		published[metric.Name] = true
+
+		current, err := s.MetricStorage.GetMetric(ctx, metric.Name)
+		if err != nil {
+			log.Printf("cannot publish metric '%s' update: %s", metric.Name, err)
+			continue
+		}
+		msg := current.GetMessageMetric()
+		if Env.Key != "" {
+			if err = msg.InitHash(Env.Key); err != nil {
+				log.Printf("cannot publish metric '%s' update: %s", metric.Name, err)
+				continue
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	s.streamHub.Publish(msgs...)
+}
+
+// CloseStreams закрывает все SSE подписки и запрещает новые. Вызывается при остановке сервера.
+func (s *Server) CloseStreams() {
+	s.streamHub.Close()
+}
+
+// handlerStream godoc
+//
+//	@Tags			JSON
+//	@Summary		Обрабатывает GET запросы подписки на обновления метрик(Server-Sent Events).
+//	@Description	Каждое успешное обновление метрики отправляется событием "metric" с message.Metrics в data.
+//
+// Если клиент не успевает читать события - ему отправляется событие "dropped" и поток закрывается.
+//
+//	@ID				handlerStream
+//	@Produce		text/event-stream
+//	@Param			name	query		string	false	"glob шаблон названия метрики, например CPUutilization*"
+//	@Param			regex	query		string	false	"Регулярное выражение для названия метрики"
+//	@Param			type	query		string	false	"Тип метрики(gauge/counter)"
+//	@Success		200		{string}	string	"ok"
+//	@Failure		400		{string}	string	"Неверный запрос"
+//	@Failure		500		{string}	string	"Внутренняя ошибка"
+//	@Failure		503		{string}	string	"Сервер останавливается"
+//	@Security		BearerAuth
+//	@Router			/api/v1/stream [get]
+func (s *Server) handlerStream(writer http.ResponseWriter, request *http.Request) {
+	q, err := query.ParseQuery(request.URL.Query())
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sub, err := s.streamHub.Subscribe(q.Match)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer s.streamHub.Unsubscribe(sub)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("Connection", "keep-alive")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(writer, ": keep-alive\n\n")
+		case msg, ok := <-sub.Events:
+			if !ok {
+				if sub.Dropped() {
+					fmt.Fprint(writer, "event: dropped\ndata: subscriber is too slow\n\n")
+					flusher.Flush()
+				}
+				return
+			}
+			msgJSON, err := json.Marshal(msg)
+			if err != nil {
+				log.Printf("cannot marshal stream event: %s", err)
+				return
+			}
+			fmt.Fprintf(writer, "event: metric\ndata: %s\n\n", msgJSON)
+		}
+		flusher.Flush()
+	}
+}
+
+// handlerAlerts godoc
+//
+//	@Tags			JSON
+//	@Summary		Обрабатывает GET запросы получения текущих алертов.
+//	@Description	В ответ возвращает массив алертов(правило, метрика, состояние pending/firing/resolved).
+//
+// Resolved алерты возвращаются в течение часа после разрешения.
+// Если правила алертов не заданы - возвращает пустой массив.
+//
+//	@ID				handlerAlerts
+//	@Produce		json
+//	@Param			state	query		string	false	"Фильтр по состоянию(pending/firing/resolved)"
+//	@Success		200		{string}	string	"ok"
+//	@Failure		400		{string}	string	"Неверный запрос"
+//	@Failure		500		{string}	string	"Внутренняя ошибка"
+//	@Security		BearerAuth
+//	@Router			/api/v1/alerts [get]
+func (s *Server) handlerAlerts(writer http.ResponseWriter, request *http.Request) {
+	var states []alerts.State
+	if stateParam := request.URL.Query().Get("state"); stateParam != "" {
+		state, ok := alerts.ParseState(stateParam)
+		if !ok {
+			http.Error(writer, fmt.Sprintf("unknown alert state '%s'", stateParam), http.StatusBadRequest)
+			return
+		}
+		states = append(states, state)
+	}
+
+	msgJSON, err := json.Marshal(s.AlertEngine.Alerts(states...))
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(msgJSON)
+}
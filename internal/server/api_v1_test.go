@@ -0,0 +1,546 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/alerts"
+	"github.com/firesworder/devopsmetrics/internal/counters"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+// sendTestRequestWithHeaders аналог sendTestRequest, с возможностью передать доп. заголовки запроса.
+func sendTestRequestWithHeaders(t *testing.T, ts *httptest.Server, r requestArgs,
+	headers map[string]string) (int, string, string) {
+	// создаю реквест
+	req, err := http.NewRequest(r.method, ts.URL+r.url, strings.NewReader(r.body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	// делаю реквест на дефолтном клиенте
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	// читаю ответ сервера
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	return resp.StatusCode, resp.Header.Get("Content-Type"), string(respBody)
+}
+
+// metricCounter возвращает counter метрику PollCount со значением delta.
+func metricCounter(t *testing.T, delta int64) storage.Metric {
+	metric, err := storage.NewMetric("PollCount", internal.CounterTypeName, delta)
+	require.NoError(t, err)
+	return *metric
+}
+
+func TestServer_convertCumulativeCounters(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+	tests := []struct {
+		name            string
+		counterMode     string
+		maxCounterDelta int64
+		initState       map[string]storage.Metric
+		requests        []requestArgs
+		wantStatuses    []int
+		wantState       map[string]storage.Metric
+	}{
+		{
+			name:        "Test 1. Delta mode(default). Values are summed.",
+			counterMode: "",
+			requests: []requestArgs{
+				{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+				{method: http.MethodPost, url: "/update/counter/PollCount/15"},
+			},
+			wantStatuses: []int{http.StatusOK, http.StatusOK},
+			wantState:    map[string]storage.Metric{metric1.Name: metricCounter(t, 25)},
+		},
+		{
+			name:        "Test 2. Cumulative mode. Growing counter, first value is not counted, url handler.",
+			counterMode: cumulativeCounterMode,
+			requests: []requestArgs{
+				{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+				{method: http.MethodPost, url: "/update/counter/PollCount/25"},
+			},
+			wantStatuses: []int{http.StatusOK, http.StatusOK},
+			wantState:    map[string]storage.Metric{metric1.Name: metricCounter(t, 15)},
+		},
+		{
+			name:        "Test 3. Cumulative mode. Counter reset, json handler.",
+			counterMode: cumulativeCounterMode,
+			requests: []requestArgs{
+				{
+					method: http.MethodPost, url: "/update/", contentType: "application/json",
+					body: `{"id":"PollCount","type":"counter","delta":25}`,
+				},
+				{
+					method: http.MethodPost, url: "/update/", contentType: "application/json",
+					body: `{"id":"PollCount","type":"counter","delta":15}`,
+				},
+			},
+			wantStatuses: []int{http.StatusOK, http.StatusOK},
+			wantState:    map[string]storage.Metric{metric1.Name: metricCounter(t, 15)},
+		},
+		{
+			name:        "Test 4. Cumulative mode. Batch handler, gauge is not converted.",
+			counterMode: cumulativeCounterMode,
+			requests: []requestArgs{
+				{
+					method: http.MethodPost, url: "/updates/", contentType: "application/json",
+					body: `[{"id":"PollCount","type":"counter","delta":10},{"id":"PollCount","type":"counter","delta":25},` +
+						`{"id":"RandomValue","type":"gauge","value":12.133}]`,
+				},
+			},
+			wantStatuses: []int{http.StatusOK},
+			wantState: map[string]storage.Metric{
+				metric1.Name: metricCounter(t, 15),
+				metric2.Name: *metric2,
+			},
+		},
+		{
+			name:        "Test 5. Unknown counter mode.",
+			counterMode: "absolute",
+			requests: []requestArgs{
+				{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+			},
+			wantStatuses: []int{http.StatusBadRequest},
+			wantState:    map[string]storage.Metric{},
+		},
+		{
+			name:        "Test 6. Cumulative mode. Stored total is not used as previous value(server restart).",
+			counterMode: cumulativeCounterMode,
+			initState:   map[string]storage.Metric{metric1.Name: metricCounter(t, 100)},
+			requests: []requestArgs{
+				{method: http.MethodPost, url: "/update/counter/PollCount/70"},
+				{method: http.MethodPost, url: "/update/counter/PollCount/80"},
+			},
+			wantStatuses: []int{http.StatusOK, http.StatusOK},
+			wantState:    map[string]storage.Metric{metric1.Name: metricCounter(t, 110)},
+		},
+		{
+			name:            "Test 7. Cumulative mode. Rejected value is not used as previous.",
+			counterMode:     cumulativeCounterMode,
+			maxCounterDelta: 100,
+			requests: []requestArgs{
+				{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+				{method: http.MethodPost, url: "/update/counter/PollCount/500"},
+				{method: http.MethodPost, url: "/update/counter/PollCount/50"},
+			},
+			wantStatuses: []int{http.StatusOK, http.StatusBadRequest, http.StatusOK},
+			wantState:    map[string]storage.Metric{metric1.Name: metricCounter(t, 40)},
+		},
+		{
+			name:            "Test 8. Cumulative mode. Partial batch, rejected value is not used as previous.",
+			counterMode:     cumulativeCounterMode,
+			maxCounterDelta: 100,
+			requests: []requestArgs{
+				{
+					method: http.MethodPost, url: "/updates/?partial=true", contentType: "application/json",
+					body: `[{"id":"PollCount","type":"counter","delta":10},{"id":"PollCount","type":"counter","delta":500}]`,
+				},
+				{method: http.MethodPost, url: "/update/counter/PollCount/50"},
+			},
+			wantStatuses: []int{http.StatusOK, http.StatusOK},
+			wantState:    map[string]storage.Metric{metric1.Name: metricCounter(t, 40)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Env = environment{MaxCounterDelta: tt.maxCounterDelta}
+			initState := tt.initState
+			if initState == nil {
+				initState = map[string]storage.Metric{}
+			}
+			s := &Server{MetricStorage: storage.NewMemStorage(initState)}
+			require.NoError(t, s.initValidator())
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			for i, r := range tt.requests {
+				statusCode, _, _ := sendTestRequestWithHeaders(t, ts, r,
+					map[string]string{counterModeHeader: tt.counterMode})
+				assert.Equal(t, tt.wantStatuses[i], statusCode)
+			}
+			compareMetricsState(t, tt.wantState, s.MetricStorage, context.Background())
+		})
+	}
+}
+
+func TestServer_handlerRate(t *testing.T) {
+	s := &Server{
+		MetricStorage:  storage.NewMemStorage(map[string]storage.Metric{}),
+		CounterHistory: counters.NewHistory(10 * time.Minute),
+	}
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+
+	updateURLs := []string{
+		"/update/counter/PollCount/30", "/update/counter/PollCount/30", "/update/gauge/RandomValue/1",
+	}
+	for _, url := range updateURLs {
+		statusCode, _, _ := sendTestRequest(t, ts, requestArgs{method: http.MethodPost, url: url})
+		require.Equal(t, http.StatusOK, statusCode)
+	}
+
+	tests := []struct {
+		name         string
+		history      *counters.History
+		request      requestArgs
+		wantResponse response
+	}{
+		{
+			name:    "Test 1. Correct request.",
+			history: s.CounterHistory,
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/rate?name=PollCount&window=1m"},
+			wantResponse: response{
+				statusCode:  http.StatusOK,
+				contentType: "application/json",
+				body:        `{"id":"PollCount","window":"1m0s","rate":1}`,
+			},
+		},
+		{
+			name:    "Test 2. Param name is not set.",
+			history: s.CounterHistory,
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/rate?window=1m"},
+			wantResponse: response{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			name:    "Test 3. Param window is incorrect.",
+			history: s.CounterHistory,
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/rate?name=PollCount&window=minute"},
+			wantResponse: response{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			name:    "Test 4. Window is greater than history retention.",
+			history: s.CounterHistory,
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/rate?name=PollCount&window=1h"},
+			wantResponse: response{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			name:    "Test 5. Unknown metric.",
+			history: s.CounterHistory,
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/rate?name=Unknown&window=1m"},
+			wantResponse: response{
+				statusCode: http.StatusNotFound,
+			},
+		},
+		{
+			name:    "Test 6. Gauge metric.",
+			history: s.CounterHistory,
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/rate?name=RandomValue&window=1m"},
+			wantResponse: response{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+		{
+			name:    "Test 7. History is disabled.",
+			history: nil,
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/rate?name=PollCount&window=1m"},
+			wantResponse: response{
+				statusCode: http.StatusNotImplemented,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := s.CounterHistory
+			s.CounterHistory = tt.history
+			defer func() {
+				s.CounterHistory = history
+			}()
+
+			statusCode, contentType, body := sendTestRequest(t, ts, tt.request)
+			assert.Equal(t, tt.wantResponse.statusCode, statusCode)
+			if statusCode == http.StatusOK {
+				assert.Equal(t, tt.wantResponse.contentType, contentType)
+				assert.JSONEq(t, tt.wantResponse.body, body)
+			}
+		})
+	}
+}
+
+func TestServer_handlerQuery(t *testing.T) {
+	s := &Server{MetricStorage: storage.NewMemStorage(getMetricsMap())}
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		request        requestArgs
+		wantResponse   response
+		wantTotalCount string
+	}{
+		{
+			name:    "Test 1. Gauges sorted by value.",
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/query?type=gauge&sort=value"},
+			wantResponse: response{
+				statusCode:  http.StatusOK,
+				contentType: "application/json",
+				body: `[{"id":"Alloc","type":"gauge","value":7.77},` +
+					`{"id":"RandomValue","type":"gauge","value":12.133}]`,
+			},
+			wantTotalCount: "2",
+		},
+		{
+			name:    "Test 2. Pagination.",
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/query?limit=1&offset=1"},
+			wantResponse: response{
+				statusCode:  http.StatusOK,
+				contentType: "application/json",
+				body:        `[{"id":"PollCount","type":"counter","delta":10}]`,
+			},
+			wantTotalCount: "3",
+		},
+		{
+			name:    "Test 3. Aggregation.",
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/query?type=gauge&agg=max"},
+			wantResponse: response{
+				statusCode:  http.StatusOK,
+				contentType: "application/json",
+				body:        `[{"id":"max(*)","type":"gauge","value":12.133}]`,
+			},
+			wantTotalCount: "2",
+		},
+		{
+			name:    "Test 4. Nothing matched.",
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/query?name=CPUutilization*"},
+			wantResponse: response{
+				statusCode:  http.StatusOK,
+				contentType: "application/json",
+				body:        `[]`,
+			},
+			wantTotalCount: "0",
+		},
+		{
+			name:    "Test 5. Incorrect query.",
+			request: requestArgs{method: http.MethodGet, url: "/api/v1/query?agg=median"},
+			wantResponse: response{
+				statusCode: http.StatusBadRequest,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + tt.request.url)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.wantResponse.statusCode, resp.StatusCode)
+			if resp.StatusCode == http.StatusOK {
+				assert.Equal(t, tt.wantResponse.contentType, resp.Header.Get("Content-Type"))
+				assert.Equal(t, tt.wantTotalCount, resp.Header.Get("X-Total-Count"))
+				assert.JSONEq(t, tt.wantResponse.body, string(body))
+			}
+		})
+	}
+}
+
+// readStreamEvent читает из SSE потока одно событие(пропуская комментарии), возвращает его тип и данные.
+func readStreamEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && event != "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+func TestServer_handlerStream(t *testing.T) {
+	s := &Server{MetricStorage: storage.NewMemStorage(getMetricsMap())}
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+
+	tests := []struct {
+		name       string
+		streamURL  string
+		updates    []requestArgs
+		wantStatus int
+		wantEvents []string
+	}{
+		{
+			name:      "Test 1. All metrics, url and batch handlers.",
+			streamURL: "/api/v1/stream",
+			updates: []requestArgs{
+				{method: http.MethodPost, url: "/update/counter/PollCount/5"},
+				{
+					method: http.MethodPost, url: "/updates/", contentType: "application/json",
+					body: `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"Alloc","type":"gauge","value":2.5}]`,
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantEvents: []string{
+				`{"id":"PollCount","type":"counter","delta":15}`,
+				`{"id":"Alloc","type":"gauge","value":2.5}`,
+			},
+		},
+		{
+			name:      "Test 2. Filter by name, json handler.",
+			streamURL: "/api/v1/stream?name=Random*",
+			updates: []requestArgs{
+				{
+					method: http.MethodPost, url: "/update/", contentType: "application/json",
+					body: `{"id":"PollCount","type":"counter","delta":1}`,
+				},
+				{
+					method: http.MethodPost, url: "/update/", contentType: "application/json",
+					body: `{"id":"RandomValue","type":"gauge","value":3}`,
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantEvents: []string{`{"id":"RandomValue","type":"gauge","value":3}`},
+		},
+		{
+			name:       "Test 3. Incorrect filter.",
+			streamURL:  "/api/v1/stream?regex=(",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+tt.streamURL, nil)
+			require.NoError(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+			for _, r := range tt.updates {
+				statusCode, _, _ := sendTestRequest(t, ts, r)
+				require.Equal(t, http.StatusOK, statusCode)
+			}
+			reader := bufio.NewReader(resp.Body)
+			for _, wantEvent := range tt.wantEvents {
+				event, data := readStreamEvent(t, reader)
+				assert.Equal(t, "metric", event)
+				assert.JSONEq(t, wantEvent, data)
+			}
+		})
+	}
+}
+
+func TestServer_CloseStreams(t *testing.T) {
+	s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{})}
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/api/v1/stream")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// после закрытия подписок поток завершается, а новые подписки отклоняются
+	s.CloseStreams()
+	_, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+
+	statusCode, _, _ := sendTestRequest(t, ts, requestArgs{method: http.MethodGet, url: "/api/v1/stream"})
+	assert.Equal(t, http.StatusServiceUnavailable, statusCode)
+}
+
+func TestServer_handlerAlerts(t *testing.T) {
+	s := &Server{
+		MetricStorage: storage.NewMemStorage(getMetricsMap()),
+		AlertEngine: alerts.NewEngine([]alerts.Rule{
+			{Name: "HighRandomValue", Metric: "Random*", Op: alerts.OpGreater, Threshold: 10},
+			{Name: "LowAlloc", Metric: "Alloc", Op: alerts.OpLess, Threshold: 1, For: time.Hour},
+			{Name: "HighPollCount", Metric: "PollCount", Op: alerts.OpGreater, Threshold: 5, For: time.Hour},
+		}),
+	}
+	require.NoError(t, s.evaluateAlerts(context.Background()))
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		url          string
+		wantStatus   int
+		wantStateMap map[string]alerts.State
+	}{
+		{
+			name:       "Test 1. All alerts.",
+			url:        "/api/v1/alerts",
+			wantStatus: http.StatusOK,
+			wantStateMap: map[string]alerts.State{
+				"HighPollCount": alerts.StatePending, "HighRandomValue": alerts.StateFiring,
+			},
+		},
+		{
+			name:         "Test 2. Filter by state.",
+			url:          "/api/v1/alerts?state=firing",
+			wantStatus:   http.StatusOK,
+			wantStateMap: map[string]alerts.State{"HighRandomValue": alerts.StateFiring},
+		},
+		{
+			name:       "Test 3. Unknown state.",
+			url:        "/api/v1/alerts?state=silenced",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(ts.URL + tt.url)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode)
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+			var gotAlerts []alerts.Alert
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&gotAlerts))
+			gotStateMap := map[string]alerts.State{}
+			for _, alert := range gotAlerts {
+				gotStateMap[alert.Rule] = alert.State
+			}
+			assert.Equal(t, tt.wantStateMap, gotStateMap)
+		})
+	}
+
+	// без правил алертов возвращается пустой массив
+	tsNoRules := httptest.NewServer((&Server{MetricStorage: storage.NewMemStorage(nil)}).newRouter())
+	defer tsNoRules.Close()
+	_, _, body := sendTestRequest(t, tsNoRules, requestArgs{method: http.MethodGet, url: "/api/v1/alerts"})
+	assert.JSONEq(t, `[]`, body)
+}
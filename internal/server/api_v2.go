@@ -0,0 +1,417 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/firesworder/devopsmetrics/internal/auth"
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+// Коды ошибок API v2(поле code тела ошибки).
+const (
+	codeBadRequest      = "bad_request"
+	codeInvalidMetric   = "invalid_metric"
+	codeInvalidHash     = "invalid_hash"
+	codeMetricNotFound  = "metric_not_found"
+	codeTypeMismatch    = "type_mismatch"
+	codeUnsupportedType = "unsupported_type"
+	codeBatchTooLong    = "batch_too_long"
+	codeInternal        = "internal_error"
+)
+
+// apiError ошибка API v2: http статус и тело ответа.
+type apiError struct {
+	Status  int                    `json:"-"`
+	Code    string                 `json:"code" example:"metric_not_found"`
+	Message string                 `json:"message" example:"metric 'PollCount' was not found"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// apiErrorResponse тело ответа API v2 с ошибкой.
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// withDetails возвращает ошибку с дополнительными полями details.
+func (e *apiError) withDetails(details map[string]interface{}) *apiError {
+	if e.Details == nil {
+		e.Details = map[string]interface{}{}
+	}
+	for k, v := range details {
+		e.Details[k] = v
+	}
+	return e
+}
+
+// storageAPIError сопоставляет ошибку storage статусу и коду API v2, неизвестные ошибки - 500.
+func storageAPIError(err error) *apiError {
+	switch {
+	case errors.Is(err, storage.ErrMetricNotFound):
+		return &apiError{Status: http.StatusNotFound, Code: codeMetricNotFound, Message: err.Error()}
+	case errors.Is(err, storage.ErrTypeMismatch):
+		return &apiError{Status: http.StatusConflict, Code: codeTypeMismatch, Message: err.Error()}
+	case errors.Is(err, storage.ErrUnhandledValueType):
+		return &apiError{Status: http.StatusNotImplemented, Code: codeUnsupportedType, Message: err.Error()}
+	default:
+		return &apiError{Status: http.StatusInternalServerError, Code: codeInternal, Message: err.Error()}
+	}
+}
+
+// writeAPIError пишет ошибку в ответ в формате apiErrorResponse.
+func writeAPIError(writer http.ResponseWriter, err *apiError) {
+	body, mErr := json.Marshal(apiErrorResponse{Error: *err})
+	if mErr != nil {
+		http.Error(writer, mErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Del("X-Content-Type-Options")
+	writer.WriteHeader(err.Status)
+	writer.Write(body)
+}
+
+// writeAPIResponse пишет в ответ v в JSON.
+func writeAPIResponse(writer http.ResponseWriter, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeAPIError(writer, storageAPIError(err))
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
+
+// apiErrorWriter перехватывает текстовые ошибки(http.Error) middleware для преобразования в apiErrorResponse.
+type apiErrorWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *apiErrorWriter) WriteHeader(statusCode int) {
+	if statusCode >= http.StatusBadRequest && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.status = statusCode
+		return
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *apiErrorWriter) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// apiV2Errors - middleware, преобразующий текстовые ошибки общих middleware(авторизация, лимиты, подпись)
+// в тело apiErrorResponse, с кодом по http статусу(например, too_many_requests).
+func (s *Server) apiV2Errors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		errorWriter := &apiErrorWriter{ResponseWriter: writer}
+		next.ServeHTTP(errorWriter, request)
+		if errorWriter.status != 0 {
+			writeAPIError(writer, &apiError{
+				Status:  errorWriter.status,
+				Code:    strings.ReplaceAll(strings.ToLower(http.StatusText(errorWriter.status)), " ", "_"),
+				Message: strings.TrimSpace(errorWriter.body.String()),
+			})
+		}
+	})
+}
+
+// newAPIv2Router возвращает роутер API v2(/api/v2): JSON запросы и ответы, ошибки - apiErrorResponse.
+func (s *Server) newAPIv2Router() chi.Router {
+	r := chi.NewRouter()
+	r.Use(s.apiV2Errors)
+	r.NotFound(func(writer http.ResponseWriter, request *http.Request) {
+		writeAPIError(writer, &apiError{Status: http.StatusNotFound, Code: "not_found", Message: "route not found"})
+	})
+	r.MethodNotAllowed(func(writer http.ResponseWriter, request *http.Request) {
+		writeAPIError(writer, &apiError{
+			Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Message: "method not allowed",
+		})
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(s.requireScope(auth.ScopeRead))
+		r.Get("/metrics", s.handlerV2Metrics)
+		r.Get("/value/{typeName}/{metricName}", s.handlerV2Value)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(s.checkTrustedSubnet)
+		r.Use(s.requireScope(auth.ScopeWrite))
+		r.Use(s.checkBatchSignature)
+		r.Post("/update", s.handlerV2Update)
+		r.Post("/updates", s.handlerV2BatchUpdate)
+	})
+	return r
+}
+
+// storedMessage возвращает сохраненную метрику name в виде message.Metrics(с признаком Stale и хешем).
+func (s *Server) storedMessage(request *http.Request, name string) (message.Metrics, *apiError) {
+	metric, err := s.MetricStorage.GetMetric(request.Context(), name)
+	if err != nil {
+		return message.Metrics{}, storageAPIError(err).withDetails(map[string]interface{}{"id": name})
+	}
+	msg := metric.GetMessageMetric()
+	msg.Stale = s.isMetricStale(metric.Name)
+	if Env.Key != "" {
+		if err = msg.InitHash(Env.Key); err != nil {
+			return message.Metrics{}, storageAPIError(err)
+		}
+	}
+	return msg, nil
+}
+
+// updateMetrics проверяет и сохраняет метрики msgs целиком(при ошибке в одной - не сохраняется ни одна).
+// Возвращает сохраненные значения метрик в порядке msgs, без отброшенных правилами relabel.
+func (s *Server) updateMetrics(request *http.Request, msgs []message.Metrics) ([]message.Metrics, *apiError) {
+	metrics := make([]storage.Metric, 0, len(msgs))
+	// индексы сохраняемых метрик в msgs
+	indices := make([]int, 0, len(msgs))
+	for i, msg := range msgs {
+		details := map[string]interface{}{"index": i, "id": msg.ID}
+		if Env.Key != "" {
+			isHashCorrect, err := msg.CheckHash(Env.Key)
+			if err != nil {
+				return nil, (&apiError{
+					Status: http.StatusBadRequest, Code: codeInvalidMetric, Message: err.Error(),
+				}).withDetails(details)
+			} else if !isHashCorrect {
+				return nil, (&apiError{
+					Status: http.StatusBadRequest, Code: codeInvalidHash, Message: "hash is not correct",
+				}).withDetails(details)
+			}
+		}
+
+		metric, err := storage.NewMetricFromMessage(&msg)
+		if err != nil {
+			if errors.Is(err, storage.ErrUnhandledValueType) {
+				return nil, storageAPIError(err).withDetails(details)
+			}
+			return nil, (&apiError{
+				Status: http.StatusBadRequest, Code: codeInvalidMetric, Message: err.Error(),
+			}).withDetails(details)
+		}
+		if !s.relabelMetric(request, metric) {
+			continue
+		}
+		metrics = append(metrics, *metric)
+		indices = append(indices, i)
+	}
+
+	cumulative, err := s.beginCumulativeCounters(request)
+	if err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Code: codeBadRequest, Message: err.Error()}
+	}
+	defer cumulative.Rollback()
+	if err = s.convertCumulativeCounters(cumulative, metrics); err != nil {
+		return nil, &apiError{Status: http.StatusBadRequest, Code: codeBadRequest, Message: err.Error()}
+	}
+	if i, err := s.validateMetrics(metrics); err != nil {
+		return nil, (&apiError{
+			Status: http.StatusBadRequest, Code: codeInvalidMetric, Message: err.Error(),
+		}).withDetails(map[string]interface{}{"index": indices[i], "id": msgs[indices[i]].ID})
+	}
+	// тип проверяется хранилищем атомарно с обновлением: при конфликте набор не применяется
+	if err = s.MetricStorage.BatchUpdateStrict(request.Context(), metrics); err != nil {
+		var mismatch *storage.TypeMismatchError
+		if errors.As(err, &mismatch) {
+			return nil, storageAPIError(err).withDetails(
+				map[string]interface{}{"index": indices[mismatch.Index], "id": msgs[indices[mismatch.Index]].ID})
+		}
+		return nil, storageAPIError(err)
+	}
+	cumulative.Commit()
+	s.afterMetricsUpdate(request, metrics...)
+	if err := s.syncSaveMetricStorage(); err != nil {
+		return nil, storageAPIError(err)
+	}
+
+	stored := make([]message.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		storedMsg, err := s.storedMessage(request, metric.Name)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, storedMsg)
+	}
+	return stored, nil
+}
+
+// handlerV2Metrics godoc
+//
+//	@Tags			APIv2
+//	@Summary		Возвращает все метрики сервера.
+//	@Description	В ответ возвращает массив message.Metrics, отсортированный по названию метрики.
+//	@ID				handlerV2Metrics
+//	@Produce		json
+//	@Success		200	{array}		message.Metrics
+//	@Failure		401	{object}	apiErrorResponse
+//	@Failure		500	{object}	apiErrorResponse
+//	@Security		BearerAuth
+//	@Router			/api/v2/metrics [get]
+func (s *Server) handlerV2Metrics(writer http.ResponseWriter, request *http.Request) {
+	allMetrics, err := s.MetricStorage.GetAll(request.Context())
+	if err != nil {
+		writeAPIError(writer, storageAPIError(err))
+		return
+	}
+
+	names := make([]string, 0, len(allMetrics))
+	for name := range allMetrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]message.Metrics, 0, len(names))
+	for _, name := range names {
+		metric := allMetrics[name]
+		msg := metric.GetMessageMetric()
+		msg.Stale = s.isMetricStale(name)
+		if Env.Key != "" {
+			if err = msg.InitHash(Env.Key); err != nil {
+				writeAPIError(writer, storageAPIError(err))
+				return
+			}
+		}
+		result = append(result, msg)
+	}
+	writeAPIResponse(writer, result)
+}
+
+// handlerV2Value godoc
+//
+//	@Tags			APIv2
+//	@Summary		Возвращает метрику по типу и названию.
+//	@Description	Если метрика с таким названием есть, но другого типа - 404.
+//	@ID				handlerV2Value
+//	@Produce		json
+//	@Param			typeName	path		string	true	"Тип метрики"
+//	@Param			metricName	path		string	true	"Название метрики"
+//	@Success		200			{object}	message.Metrics
+//	@Failure		401			{object}	apiErrorResponse
+//	@Failure		404			{object}	apiErrorResponse	"metric_not_found"
+//	@Failure		500			{object}	apiErrorResponse
+//	@Security		BearerAuth
+//	@Router			/api/v2/value/{typeName}/{metricName} [get]
+func (s *Server) handlerV2Value(writer http.ResponseWriter, request *http.Request) {
+	typeName, metricName := chi.URLParam(request, "typeName"), chi.URLParam(request, "metricName")
+	msg, apiErr := s.storedMessage(request, metricName)
+	if apiErr != nil {
+		writeAPIError(writer, apiErr)
+		return
+	}
+	if msg.MType != typeName {
+		writeAPIError(writer, &apiError{
+			Status:  http.StatusNotFound,
+			Code:    codeMetricNotFound,
+			Message: fmt.Sprintf("metric '%s' of type '%s' was not found", metricName, typeName),
+			Details: map[string]interface{}{"id": metricName, "type": msg.MType},
+		})
+		return
+	}
+	writeAPIResponse(writer, msg)
+}
+
+// handlerV2Update godoc
+//
+//	@Tags			APIv2
+//	@Summary		Сохраняет метрику.
+//	@Description	Метрика передается в теле запроса(message.Metrics), в ответ возвращается сохраненное значение.
+//	@Description	Ошибки storage: тип значения не совпадает с сохраненным - 409, нереализованный тип - 501.
+//	@Description	Если метрика отброшена правилами relabel - не сохраняется, в ответ возвращается полученная метрика.
+//	@ID				handlerV2Update
+//	@Accept			json
+//	@Produce		json
+//	@Param			metric	body		message.Metrics	true	"Метрика"
+//	@Success		200		{object}	message.Metrics
+//	@Failure		400		{object}	apiErrorResponse	"bad_request, invalid_metric, invalid_hash"
+//	@Failure		401		{object}	apiErrorResponse
+//	@Failure		403		{object}	apiErrorResponse
+//	@Failure		409		{object}	apiErrorResponse	"type_mismatch"
+//	@Failure		413		{object}	apiErrorResponse
+//	@Failure		429		{object}	apiErrorResponse
+//	@Failure		500		{object}	apiErrorResponse
+//	@Failure		501		{object}	apiErrorResponse	"unsupported_type"
+//	@Security		BearerAuth
+//	@Router			/api/v2/update [post]
+func (s *Server) handlerV2Update(writer http.ResponseWriter, request *http.Request) {
+	var msg message.Metrics
+	if err := json.NewDecoder(request.Body).Decode(&msg); err != nil {
+		writeAPIError(writer, &apiError{Status: http.StatusBadRequest, Code: codeBadRequest, Message: err.Error()})
+		return
+	}
+
+	stored, apiErr := s.updateMetrics(request, []message.Metrics{msg})
+	if apiErr != nil {
+		writeAPIError(writer, apiErr)
+		return
+	}
+	// метрика отброшена правилами relabel - в ответ возвращается полученная метрика
+	if len(stored) == 0 {
+		writeAPIResponse(writer, msg)
+		return
+	}
+	writeAPIResponse(writer, stored[0])
+}
+
+// handlerV2BatchUpdate godoc
+//
+//	@Tags			APIv2
+//	@Summary		Сохраняет набор метрик.
+//	@Description	Набор применяется целиком: при ошибке в одной из метрик не сохраняется ни одна,
+//	@Description	в details ошибки передаются index и id метрики. В ответ - сохраненные значения в порядке запроса.
+//	@Description	Метрики, отброшенные правилами relabel, не сохраняются и не возвращаются в ответе.
+//	@ID				handlerV2BatchUpdate
+//	@Accept			json
+//	@Produce		json
+//	@Param			metrics	body		[]message.Metrics	true	"Метрики"
+//	@Success		200		{array}		message.Metrics
+//	@Failure		400		{object}	apiErrorResponse	"bad_request, invalid_metric, invalid_hash"
+//	@Failure		401		{object}	apiErrorResponse
+//	@Failure		403		{object}	apiErrorResponse
+//	@Failure		409		{object}	apiErrorResponse	"type_mismatch"
+//	@Failure		413		{object}	apiErrorResponse	"batch_too_long"
+//	@Failure		429		{object}	apiErrorResponse
+//	@Failure		500		{object}	apiErrorResponse
+//	@Failure		501		{object}	apiErrorResponse	"unsupported_type"
+//	@Security		BearerAuth
+//	@Router			/api/v2/updates [post]
+func (s *Server) handlerV2BatchUpdate(writer http.ResponseWriter, request *http.Request) {
+	var msgs []message.Metrics
+	if err := json.NewDecoder(request.Body).Decode(&msgs); err != nil {
+		writeAPIError(writer, &apiError{Status: http.StatusBadRequest, Code: codeBadRequest, Message: err.Error()})
+		return
+	}
+	if Env.MaxBatchLength > 0 && len(msgs) > Env.MaxBatchLength {
+		s.rejected.batchTooLong.Add(1)
+		writeAPIError(writer, &apiError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Code:    codeBatchTooLong,
+			Message: fmt.Sprintf("batch length %d exceeds %d", len(msgs), Env.MaxBatchLength),
+			Details: map[string]interface{}{"length": len(msgs), "max_length": Env.MaxBatchLength},
+		})
+		return
+	}
+
+	stored, apiErr := s.updateMetrics(request, msgs)
+	if apiErr != nil {
+		writeAPIError(writer, apiErr)
+		return
+	}
+	writeAPIResponse(writer, stored)
+}
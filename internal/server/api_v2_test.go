@@ -0,0 +1,235 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/auth"
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+func TestServer_apiV2(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+
+	delta10, delta30, value := int64(10), int64(30), 12.133
+	hashedMsg := func(msg message.Metrics, key string) string {
+		require.NoError(t, msg.InitHash(key))
+		body, err := json.Marshal(msg)
+		require.NoError(t, err)
+		return string(body)
+	}
+	tokens, err := auth.NewTokenStore([]auth.Token{
+		{Name: "dashboard", Hash: auth.HashToken("dashboard-token"), Scopes: []auth.Scope{auth.ScopeRead}},
+	})
+	require.NoError(t, err)
+	metricRandomValue, err := storage.NewMetric("RandomValue", internal.GaugeTypeName, 12.133)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		env            environment
+		tokens         *auth.TokenStore
+		request        requestArgs
+		initState      map[string]storage.Metric
+		wantStatusCode int
+		wantError      *apiError
+		wantResponse   interface{}
+		wantedState    map[string]storage.Metric
+	}{
+		{
+			name: "Test 1. Update metric.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/api/v2/update", body: `{"id":"PollCount","type":"counter","delta":20}`,
+			},
+			initState:      map[string]storage.Metric{metric1.Name: *metric1},
+			wantStatusCode: http.StatusOK,
+			wantResponse:   message.Metrics{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta30},
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1upd20},
+		},
+		{
+			name: "Test 2. Incorrect hash.",
+			env:  environment{Key: "Ayaka"},
+			request: requestArgs{
+				method: http.MethodPost, url: "/api/v2/update",
+				body: hashedMsg(message.Metrics{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta10}, "Other"),
+			},
+			initState:      map[string]storage.Metric{},
+			wantStatusCode: http.StatusBadRequest,
+			wantError: &apiError{
+				Code: codeInvalidHash, Message: "hash is not correct",
+				Details: map[string]interface{}{"index": float64(0), "id": "PollCount"},
+			},
+			wantedState: map[string]storage.Metric{},
+		},
+		{
+			name: "Test 3. Type mismatch.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/api/v2/update", body: `{"id":"PollCount","type":"gauge","value":1.5}`,
+			},
+			initState:      map[string]storage.Metric{metric1.Name: *metric1},
+			wantStatusCode: http.StatusConflict,
+			wantError: &apiError{
+				Code:    codeTypeMismatch,
+				Message: "metric 'PollCount': current(storage.counter) and new(storage.gauge) value type mismatch",
+				Details: map[string]interface{}{"index": float64(0), "id": "PollCount"},
+			},
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name: "Test 4. Unsupported type.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/api/v2/update", body: `{"id":"PollCount","type":"unknownType","delta":1}`,
+			},
+			initState:      map[string]storage.Metric{},
+			wantStatusCode: http.StatusNotImplemented,
+			wantError: &apiError{
+				Code: codeUnsupportedType, Message: "unhandled value type 'unknownType'",
+				Details: map[string]interface{}{"index": float64(0), "id": "PollCount"},
+			},
+			wantedState: map[string]storage.Metric{},
+		},
+		{
+			name:           "Test 5. Incorrect body.",
+			request:        requestArgs{method: http.MethodPost, url: "/api/v2/update", body: `{"id":`},
+			initState:      map[string]storage.Metric{},
+			wantStatusCode: http.StatusBadRequest,
+			wantError:      &apiError{Code: codeBadRequest, Message: "unexpected EOF"},
+			wantedState:    map[string]storage.Metric{},
+		},
+		{
+			name:           "Test 6. Get metric.",
+			request:        requestArgs{method: http.MethodGet, url: "/api/v2/value/gauge/RandomValue"},
+			initState:      map[string]storage.Metric{metricRandomValue.Name: *metricRandomValue},
+			wantStatusCode: http.StatusOK,
+			wantResponse:   message.Metrics{ID: "RandomValue", MType: internal.GaugeTypeName, Value: &value},
+			wantedState:    map[string]storage.Metric{metricRandomValue.Name: *metricRandomValue},
+		},
+		{
+			name:           "Test 7. Metric not found.",
+			request:        requestArgs{method: http.MethodGet, url: "/api/v2/value/gauge/Unknown"},
+			initState:      map[string]storage.Metric{},
+			wantStatusCode: http.StatusNotFound,
+			wantError: &apiError{
+				Code: codeMetricNotFound, Message: "metric was not found",
+				Details: map[string]interface{}{"id": "Unknown"},
+			},
+			wantedState: map[string]storage.Metric{},
+		},
+		{
+			name:           "Test 8. Metric of other type.",
+			request:        requestArgs{method: http.MethodGet, url: "/api/v2/value/gauge/PollCount"},
+			initState:      map[string]storage.Metric{metric1.Name: *metric1},
+			wantStatusCode: http.StatusNotFound,
+			wantError: &apiError{
+				Code: codeMetricNotFound, Message: "metric 'PollCount' of type 'gauge' was not found",
+				Details: map[string]interface{}{"id": "PollCount", "type": "counter"},
+			},
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name: "Test 9. Batch update.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/api/v2/updates",
+				body: `[{"id":"PollCount","type":"counter","delta":20},{"id":"RandomValue","type":"gauge","value":12.133}]`,
+			},
+			initState:      map[string]storage.Metric{metric1.Name: *metric1},
+			wantStatusCode: http.StatusOK,
+			wantResponse: []message.Metrics{
+				{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta30},
+				{ID: "RandomValue", MType: internal.GaugeTypeName, Value: &value},
+			},
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1upd20, metricRandomValue.Name: *metricRandomValue},
+		},
+		{
+			name: "Test 10. Batch with invalid metric is not applied.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/api/v2/updates",
+				body: `[{"id":"PollCount","type":"counter","delta":20},{"id":"RandomValue","type":"gauge"}]`,
+			},
+			initState:      map[string]storage.Metric{metric1.Name: *metric1},
+			wantStatusCode: http.StatusBadRequest,
+			wantError: &apiError{
+				Code:    codeInvalidMetric,
+				Message: "param 'value' cannot be nil for type 'gauge'",
+				Details: map[string]interface{}{"index": float64(1), "id": "RandomValue"},
+			},
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name: "Test 11. Batch is too long.",
+			env:  environment{MaxBatchLength: 1},
+			request: requestArgs{
+				method: http.MethodPost, url: "/api/v2/updates",
+				body: `[{"id":"PollCount","type":"counter","delta":20},{"id":"RandomValue","type":"gauge","value":1}]`,
+			},
+			initState:      map[string]storage.Metric{},
+			wantStatusCode: http.StatusRequestEntityTooLarge,
+			wantError: &apiError{
+				Code: codeBatchTooLong, Message: "batch length 2 exceeds 1",
+				Details: map[string]interface{}{"length": float64(2), "max_length": float64(1)},
+			},
+			wantedState: map[string]storage.Metric{},
+		},
+		{
+			name:           "Test 12. Middleware error is converted to JSON.",
+			tokens:         tokens,
+			request:        requestArgs{method: http.MethodGet, url: "/api/v2/metrics"},
+			initState:      map[string]storage.Metric{},
+			wantStatusCode: http.StatusUnauthorized,
+			wantError:      &apiError{Code: "unauthorized", Message: "bearer token is required"},
+			wantedState:    map[string]storage.Metric{},
+		},
+		{
+			name:           "Test 13. Unknown route.",
+			request:        requestArgs{method: http.MethodGet, url: "/api/v2/unknown"},
+			initState:      map[string]storage.Metric{},
+			wantStatusCode: http.StatusNotFound,
+			wantError:      &apiError{Code: "not_found", Message: "route not found"},
+			wantedState:    map[string]storage.Metric{},
+		},
+		{
+			name:           "Test 14. All metrics.",
+			request:        requestArgs{method: http.MethodGet, url: "/api/v2/metrics"},
+			initState:      map[string]storage.Metric{metric1.Name: *metric1, metricRandomValue.Name: *metricRandomValue},
+			wantStatusCode: http.StatusOK,
+			wantResponse: []message.Metrics{
+				{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta10},
+				{ID: "RandomValue", MType: internal.GaugeTypeName, Value: &value},
+			},
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1, metricRandomValue.Name: *metricRandomValue},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Env = tt.env
+			s := &Server{MetricStorage: storage.NewMemStorage(tt.initState), Tokens: tt.tokens}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			statusCode, contentType, body := sendTestRequest(t, ts, tt.request)
+			require.Equal(t, tt.wantStatusCode, statusCode, body)
+			assert.Equal(t, "application/json", contentType)
+			if tt.wantError != nil {
+				var got apiErrorResponse
+				require.NoError(t, json.Unmarshal([]byte(body), &got))
+				assert.Equal(t, *tt.wantError, got.Error)
+			} else {
+				wantBody, err := json.Marshal(tt.wantResponse)
+				require.NoError(t, err)
+				assert.JSONEq(t, string(wantBody), body)
+			}
+			compareMetricsState(t, tt.wantedState, s.MetricStorage, context.Background())
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+// batchUpdatePartial применяет корректные метрики набора, пропуская отклоненные(неверный хеш, тип,
+// не прошедшие проверку политикой validator или с типом, отличным от сохраненного - статус 409).
+// В ответ пишет JSON массив message.BatchItemResult в порядке метрик запроса.
+// Для отброшенных правилами relabel метрик - статус 200 без сохраненного значения.
+// Ошибки, относящиеся ко всему запросу(режим счетчиков, сохранение в хранилище) - по-прежнему http ошибка.
+func (s *Server) batchUpdatePartial(writer http.ResponseWriter, request *http.Request, batch []message.Metrics) {
+	results := make([]message.BatchItemResult, len(batch))
+	var candidates []storage.Metric
+	var candidateIndices []int
+
+	for i, metricMessage := range batch {
+		results[i] = message.BatchItemResult{ID: metricMessage.ID, MType: metricMessage.MType, Status: http.StatusOK}
+		if Env.Key != "" {
+			isHashCorrect, err := metricMessage.CheckHash(Env.Key)
+			if err != nil {
+				results[i].Status, results[i].Error = http.StatusBadRequest, err.Error()
+				continue
+			} else if !isHashCorrect {
+				results[i].Status, results[i].Error = http.StatusBadRequest, "hash is not correct"
+				continue
+			}
+		}
+
+		m, err := storage.NewMetricFromMessage(&metricMessage)
+		if err != nil {
+			results[i].Status, results[i].Error = http.StatusBadRequest, err.Error()
+			if errors.Is(err, storage.ErrUnhandledValueType) {
+				results[i].Status = http.StatusNotImplemented
+			}
+			continue
+		}
+		if !s.relabelMetric(request, m) {
+			continue
+		}
+		candidates = append(candidates, *m)
+		candidateIndices = append(candidateIndices, i)
+	}
+
+	// метрики с типом, отличным от сохраненного, отклоняются по отдельности, остальные применяются
+	conflicts, err := s.checkMetricTypes(request.Context(), candidates)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cumulative, err := s.beginCumulativeCounters(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cumulative.Rollback()
+
+	var metrics []storage.Metric
+	var applied []int
+	for j, i := range candidateIndices {
+		if conflicts[j] != nil {
+			results[i].Status, results[i].Error = http.StatusConflict, conflicts[j].Error()
+			continue
+		}
+		// кумулятивные значения пересчитываются до проверки, чтобы ограничение дельты counter применялось к дельте
+		converted := []storage.Metric{candidates[j]}
+		if err = s.convertCumulativeCounters(cumulative, converted); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, err = s.validateMetrics(converted); err != nil {
+			cumulative.Discard(converted[0].Name)
+			results[i].Status, results[i].Error = http.StatusBadRequest, err.Error()
+			continue
+		}
+		metrics = append(metrics, converted[0])
+		applied = append(applied, i)
+	}
+
+	if len(metrics) > 0 {
+		// тип мог измениться после проверки checkMetricTypes: такие метрики отклоняются при сохранении
+		storeConflicts, err := s.updateMatchingTypes(request.Context(), metrics)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cumulative.Commit()
+		stored, storedIndices := metrics[:0], applied[:0]
+		for j, conflict := range storeConflicts {
+			if conflict != nil {
+				results[applied[j]].Status, results[applied[j]].Error = http.StatusConflict, conflict.Error()
+				continue
+			}
+			stored, storedIndices = append(stored, metrics[j]), append(storedIndices, applied[j])
+		}
+		metrics, applied = stored, storedIndices
+		s.afterMetricsUpdate(request, metrics...)
+		if err = s.syncSaveMetricStorage(); err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	for j, i := range applied {
+		metric, err := s.MetricStorage.GetMetric(request.Context(), metrics[j].Name)
+		if err != nil {
+			results[i].Status, results[i].Error = http.StatusInternalServerError, "metric was not updated:"+err.Error()
+			continue
+		}
+		stored := metric.GetMessageMetric()
+		if Env.Key != "" {
+			if err = stored.InitHash(Env.Key); err != nil {
+				results[i].Status, results[i].Error = http.StatusInternalServerError, err.Error()
+				continue
+			}
+		}
+		results[i].Metric = &stored
+	}
+
+	msgJSON, err := json.Marshal(results)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(msgJSON)
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+func TestServer_batchUpdatePartial(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+
+	hashedMsg := func(msg message.Metrics, key string) message.Metrics {
+		require.NoError(t, msg.InitHash(key))
+		return msg
+	}
+	delta10, delta20, value := int64(10), int64(20), 12.133
+	metricRandomValue, err := storage.NewMetric("RandomValue", internal.GaugeTypeName, 12.133)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		key            string
+		url            string
+		batch          []message.Metrics
+		initState      map[string]storage.Metric
+		wantStatusCode int
+		wantResults    []message.BatchItemResult
+		wantedState    map[string]storage.Metric
+	}{
+		{
+			name: "Test 1. Valid and rejected metrics.",
+			url:  "/updates/?partial=true",
+			batch: []message.Metrics{
+				{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta20},
+				{ID: "Unknown", MType: "unknownType", Delta: &delta10},
+				{ID: "RandomValue", MType: internal.GaugeTypeName, Value: &value},
+			},
+			initState:      map[string]storage.Metric{metric1.Name: *metric1},
+			wantStatusCode: http.StatusOK,
+			wantResults: []message.BatchItemResult{
+				{
+					ID: "PollCount", MType: internal.CounterTypeName, Status: http.StatusOK,
+					Metric: &message.Metrics{ID: "PollCount", MType: internal.CounterTypeName, Delta: func() *int64 {
+						v := int64(30)
+						return &v
+					}()},
+				},
+				{ID: "Unknown", MType: "unknownType", Status: http.StatusNotImplemented, Error: "unhandled value type"},
+				{
+					ID: "RandomValue", MType: internal.GaugeTypeName, Status: http.StatusOK,
+					Metric: &message.Metrics{ID: "RandomValue", MType: internal.GaugeTypeName, Value: &value},
+				},
+			},
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1upd20, metricRandomValue.Name: *metricRandomValue},
+		},
+		{
+			name: "Test 2. Incorrect hash rejects only one metric.",
+			key:  "Ayaka",
+			url:  "/updates/?partial=true",
+			batch: []message.Metrics{
+				hashedMsg(message.Metrics{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta10}, "Ayaka"),
+				hashedMsg(message.Metrics{ID: "RandomValue", MType: internal.GaugeTypeName, Value: &value}, "Other"),
+			},
+			initState:      map[string]storage.Metric{},
+			wantStatusCode: http.StatusOK,
+			wantResults: []message.BatchItemResult{
+				{
+					ID: "PollCount", MType: internal.CounterTypeName, Status: http.StatusOK,
+					Metric: func() *message.Metrics {
+						m := hashedMsg(message.Metrics{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta10}, "Ayaka")
+						return &m
+					}(),
+				},
+				{ID: "RandomValue", MType: internal.GaugeTypeName, Status: http.StatusBadRequest, Error: "hash is not correct"},
+			},
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name: "Test 3. All metrics are rejected.",
+			url:  "/updates/?partial=1",
+			batch: []message.Metrics{
+				{ID: "PollCount", MType: internal.CounterTypeName},
+			},
+			initState:      map[string]storage.Metric{},
+			wantStatusCode: http.StatusOK,
+			wantResults: []message.BatchItemResult{
+				{ID: "PollCount", MType: internal.CounterTypeName, Status: http.StatusBadRequest, Error: "cannot be nil"},
+			},
+			wantedState: map[string]storage.Metric{},
+		},
+		{
+			name: "Test 4. Type mismatch rejects only one metric.",
+			url:  "/updates/?partial=true",
+			batch: []message.Metrics{
+				{ID: "RandomValue", MType: internal.GaugeTypeName, Value: &value},
+				{ID: "PollCount", MType: internal.GaugeTypeName, Value: &value},
+			},
+			initState:      map[string]storage.Metric{metric1.Name: *metric1},
+			wantStatusCode: http.StatusOK,
+			wantResults: []message.BatchItemResult{
+				{
+					ID: "RandomValue", MType: internal.GaugeTypeName, Status: http.StatusOK,
+					Metric: &message.Metrics{ID: "RandomValue", MType: internal.GaugeTypeName, Value: &value},
+				},
+				{ID: "PollCount", MType: internal.GaugeTypeName, Status: http.StatusConflict, Error: "type mismatch"},
+			},
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1, metricRandomValue.Name: *metricRandomValue},
+		},
+		{
+			name: "Test 5. Partial mode is disabled, batch is rejected.",
+			url:  "/updates/?partial=false",
+			batch: []message.Metrics{
+				{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta10},
+				{ID: "Unknown", MType: "unknownType", Delta: &delta10},
+			},
+			initState:      map[string]storage.Metric{},
+			wantStatusCode: http.StatusNotImplemented,
+			wantedState:    map[string]storage.Metric{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Env = environment{Key: tt.key}
+			s := &Server{MetricStorage: storage.NewMemStorage(tt.initState)}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			body, err := json.Marshal(tt.batch)
+			require.NoError(t, err)
+			statusCode, contentType, respBody := sendTestRequest(t, ts, requestArgs{
+				method: http.MethodPost, url: tt.url, body: string(body),
+			})
+			require.Equal(t, tt.wantStatusCode, statusCode, respBody)
+			if tt.wantResults != nil {
+				assert.Equal(t, "application/json", contentType)
+				var gotResults []message.BatchItemResult
+				require.NoError(t, json.Unmarshal([]byte(respBody), &gotResults))
+				require.Len(t, gotResults, len(tt.wantResults))
+				for i, want := range tt.wantResults {
+					got := gotResults[i]
+					assert.Contains(t, got.Error, want.Error)
+					got.Error = want.Error
+					assert.Equal(t, want, got)
+				}
+			}
+			compareMetricsState(t, tt.wantedState, s.MetricStorage, context.Background())
+		})
+	}
+}
@@ -8,12 +8,42 @@ import (
 )
 
 type envConfig struct {
-	ServerAddress      string `json:"address"`
-	Restore            bool   `json:"restore"`
-	StoreInterval      string `json:"store_interval"`
-	StoreFile          string `json:"store_file"`
-	DatabaseDsn        string `json:"database_dsn"`
-	PrivateCryptoKeyFp string `json:"crypto_key"`
+	ServerAddress      string  `json:"address"`
+	Restore            bool    `json:"restore"`
+	StoreInterval      string  `json:"store_interval"`
+	StoreFile          string  `json:"store_file"`
+	DatabaseDsn        string  `json:"database_dsn"`
+	PrivateCryptoKeyFp string  `json:"crypto_key"`
+	MetricTTL          string  `json:"metric_ttl"`
+	DropStale          bool    `json:"drop_stale"`
+	CounterHistory     string  `json:"counter_history"`
+	AlertRulesFile     string  `json:"alert_rules"`
+	AlertInterval      string  `json:"alert_interval"`
+	WebhookURLs        string  `json:"webhook_urls"`
+	WebhookTemplate    string  `json:"webhook_template"`
+	WebhookContentType string  `json:"webhook_content_type"`
+	AgentSilentAfter   string  `json:"agent_silent_after"`
+	TrustedSubnet      string  `json:"trusted_subnet"`
+	TrustedProxies     string  `json:"trusted_proxies"`
+	TLSCertFp          string  `json:"tls_cert"`
+	TLSKeyFp           string  `json:"tls_key"`
+	TLSClientCAFp      string  `json:"tls_client_ca"`
+	AuthTokensFp       string  `json:"auth_tokens"`
+	RateLimit          float64 `json:"rate_limit"`
+	RateBurst          int     `json:"rate_burst"`
+	MaxBodySize        int64   `json:"max_body_size"`
+	MaxBatchLength     int     `json:"max_batch_length"`
+	SignatureSkew      string  `json:"signature_skew"`
+	RequireSignature   bool    `json:"require_signature"`
+	StoreKeyFile       string  `json:"store_key_file"`
+	SignResponses      bool    `json:"sign_responses"`
+	MetricNameRegex    string  `json:"metric_name_regex"`
+	MetricNameMaxLen   int     `json:"metric_name_max_length"`
+	AllowedTypes       string  `json:"allowed_types"`
+	AllowNonFinite     bool    `json:"allow_non_finite"`
+	MaxCounterDelta    int64   `json:"max_counter_delta"`
+	DenyMetrics        string  `json:"deny_metrics"`
+	RelabelRulesFile   string  `json:"relabel_rules"`
 }
 
 func parseJSONConfig() error {
@@ -25,26 +55,116 @@ func parseJSONConfig() error {
 		"StoreFile":          true,
 		"DatabaseDsn":        true,
 		"PrivateCryptoKeyFp": true,
+		"MetricTTL":          true,
+		"DropStale":          true,
+		"CounterHistory":     true,
+		"AlertRulesFile":     true,
+		"AlertInterval":      true,
+		"WebhookURLs":        true,
+		"WebhookTemplate":    true,
+		"WebhookContentType": true,
+		"AgentSilentAfter":   true,
+		"TrustedSubnet":      true,
+		"TrustedProxies":     true,
+		"TLSCertFp":          true,
+		"TLSKeyFp":           true,
+		"TLSClientCAFp":      true,
+		"AuthTokensFp":       true,
+		"RateLimit":          true,
+		"RateBurst":          true,
+		"MaxBodySize":        true,
+		"MaxBatchLength":     true,
+		"SignatureSkew":      true,
+		"RequireSignature":   true,
+		"StoreKeyFile":       true,
+		"SignResponses":      true,
+		"MetricNameRegex":    true,
+		"MetricNameMaxLen":   true,
+		"AllowedTypes":       true,
+		"AllowNonFinite":     true,
+		"MaxCounterDelta":    true,
+		"DenyMetrics":        true,
+		"RelabelRulesFile":   true,
 	}
 
 	// словарь [ключ ком.строки: имя ассоц. поля Env]
 	var cmdEnvDict = map[string]string{
-		"a":          "ServerAddress",
-		"r":          "Restore",
-		"i":          "StoreInterval",
-		"f":          "StoreFile",
-		"d":          "DatabaseDsn",
-		"crypto-key": "PrivateCryptoKeyFp",
+		"a":                      "ServerAddress",
+		"r":                      "Restore",
+		"i":                      "StoreInterval",
+		"f":                      "StoreFile",
+		"d":                      "DatabaseDsn",
+		"crypto-key":             "PrivateCryptoKeyFp",
+		"ttl":                    "MetricTTL",
+		"drop-stale":             "DropStale",
+		"counter-history":        "CounterHistory",
+		"alert-rules":            "AlertRulesFile",
+		"alert-interval":         "AlertInterval",
+		"webhook-urls":           "WebhookURLs",
+		"webhook-template":       "WebhookTemplate",
+		"webhook-content-type":   "WebhookContentType",
+		"agent-silent-after":     "AgentSilentAfter",
+		"t":                      "TrustedSubnet",
+		"trusted-proxies":        "TrustedProxies",
+		"tls-cert":               "TLSCertFp",
+		"tls-key":                "TLSKeyFp",
+		"tls-client-ca":          "TLSClientCAFp",
+		"auth-tokens":            "AuthTokensFp",
+		"rate-limit":             "RateLimit",
+		"rate-burst":             "RateBurst",
+		"max-body-size":          "MaxBodySize",
+		"max-batch-length":       "MaxBatchLength",
+		"signature-skew":         "SignatureSkew",
+		"require-signature":      "RequireSignature",
+		"store-key-file":         "StoreKeyFile",
+		"sign-responses":         "SignResponses",
+		"metric-name-regex":      "MetricNameRegex",
+		"metric-name-max-length": "MetricNameMaxLen",
+		"allowed-types":          "AllowedTypes",
+		"allow-non-finite":       "AllowNonFinite",
+		"max-counter-delta":      "MaxCounterDelta",
+		"deny-metrics":           "DenyMetrics",
+		"relabel-rules":          "RelabelRulesFile",
 	}
 
 	// словарь [перем.окружения: имя ассоц. поля Env]
 	var osEnvEnvDict = map[string]string{
-		"ADDRESS":        "ServerAddress",
-		"RESTORE":        "Restore",
-		"STORE_INTERVAL": "StoreInterval",
-		"STORE_FILE":     "StoreFile",
-		"DATABASE_DSN":   "DatabaseDsn",
-		"CRYPTO_KEY":     "PrivateCryptoKeyFp",
+		"ADDRESS":                "ServerAddress",
+		"RESTORE":                "Restore",
+		"STORE_INTERVAL":         "StoreInterval",
+		"STORE_FILE":             "StoreFile",
+		"DATABASE_DSN":           "DatabaseDsn",
+		"CRYPTO_KEY":             "PrivateCryptoKeyFp",
+		"METRIC_TTL":             "MetricTTL",
+		"DROP_STALE":             "DropStale",
+		"COUNTER_HISTORY":        "CounterHistory",
+		"ALERT_RULES":            "AlertRulesFile",
+		"ALERT_INTERVAL":         "AlertInterval",
+		"WEBHOOK_URLS":           "WebhookURLs",
+		"WEBHOOK_TEMPLATE":       "WebhookTemplate",
+		"WEBHOOK_CONTENT_TYPE":   "WebhookContentType",
+		"AGENT_SILENT_AFTER":     "AgentSilentAfter",
+		"TRUSTED_SUBNET":         "TrustedSubnet",
+		"TRUSTED_PROXIES":        "TrustedProxies",
+		"TLS_CERT":               "TLSCertFp",
+		"TLS_KEY":                "TLSKeyFp",
+		"TLS_CLIENT_CA":          "TLSClientCAFp",
+		"AUTH_TOKENS":            "AuthTokensFp",
+		"RATE_LIMIT":             "RateLimit",
+		"RATE_BURST":             "RateBurst",
+		"MAX_BODY_SIZE":          "MaxBodySize",
+		"MAX_BATCH_LENGTH":       "MaxBatchLength",
+		"SIGNATURE_SKEW":         "SignatureSkew",
+		"REQUIRE_SIGNATURE":      "RequireSignature",
+		"STORE_KEY_FILE":         "StoreKeyFile",
+		"SIGN_RESPONSES":         "SignResponses",
+		"METRIC_NAME_REGEX":      "MetricNameRegex",
+		"METRIC_NAME_MAX_LENGTH": "MetricNameMaxLen",
+		"ALLOWED_TYPES":          "AllowedTypes",
+		"ALLOW_NON_FINITE":       "AllowNonFinite",
+		"MAX_COUNTER_DELTA":      "MaxCounterDelta",
+		"DENY_METRICS":           "DenyMetrics",
+		"RELABEL_RULES":          "RelabelRulesFile",
 	}
 
 	// получаю json из конфига, путь беру из переменной env
@@ -79,6 +199,119 @@ func parseJSONConfig() error {
 	if fieldsToSet["PrivateCryptoKeyFp"] {
 		Env.PrivateCryptoKeyFp = config.PrivateCryptoKeyFp
 	}
+	// metric_ttl необязателен в конфиге, пустое значение не переопределяет Env
+	if fieldsToSet["MetricTTL"] && config.MetricTTL != "" {
+		dur, err := time.ParseDuration(config.MetricTTL)
+		if err != nil {
+			return err
+		}
+		Env.MetricTTL = dur
+	}
+	if fieldsToSet["DropStale"] {
+		Env.DropStale = config.DropStale
+	}
+	if fieldsToSet["CounterHistory"] && config.CounterHistory != "" {
+		dur, err := time.ParseDuration(config.CounterHistory)
+		if err != nil {
+			return err
+		}
+		Env.CounterHistory = dur
+	}
+	if fieldsToSet["AlertRulesFile"] && config.AlertRulesFile != "" {
+		Env.AlertRulesFile = config.AlertRulesFile
+	}
+	if fieldsToSet["AlertInterval"] && config.AlertInterval != "" {
+		dur, err := time.ParseDuration(config.AlertInterval)
+		if err != nil {
+			return err
+		}
+		Env.AlertInterval = dur
+	}
+	if fieldsToSet["WebhookURLs"] && config.WebhookURLs != "" {
+		Env.WebhookURLs = config.WebhookURLs
+	}
+	if fieldsToSet["WebhookTemplate"] && config.WebhookTemplate != "" {
+		Env.WebhookTemplate = config.WebhookTemplate
+	}
+	if fieldsToSet["WebhookContentType"] && config.WebhookContentType != "" {
+		Env.WebhookContentType = config.WebhookContentType
+	}
+	if fieldsToSet["AgentSilentAfter"] && config.AgentSilentAfter != "" {
+		dur, err := time.ParseDuration(config.AgentSilentAfter)
+		if err != nil {
+			return err
+		}
+		Env.AgentSilentAfter = dur
+	}
+	if fieldsToSet["TrustedSubnet"] && config.TrustedSubnet != "" {
+		Env.TrustedSubnet = config.TrustedSubnet
+	}
+	if fieldsToSet["TrustedProxies"] && config.TrustedProxies != "" {
+		Env.TrustedProxies = config.TrustedProxies
+	}
+	if fieldsToSet["TLSCertFp"] && config.TLSCertFp != "" {
+		Env.TLSCertFp = config.TLSCertFp
+	}
+	if fieldsToSet["TLSKeyFp"] && config.TLSKeyFp != "" {
+		Env.TLSKeyFp = config.TLSKeyFp
+	}
+	if fieldsToSet["TLSClientCAFp"] && config.TLSClientCAFp != "" {
+		Env.TLSClientCAFp = config.TLSClientCAFp
+	}
+	if fieldsToSet["AuthTokensFp"] && config.AuthTokensFp != "" {
+		Env.AuthTokensFp = config.AuthTokensFp
+	}
+	// нулевые значения лимитов в конфиге не переопределяют Env
+	if fieldsToSet["RateLimit"] && config.RateLimit != 0 {
+		Env.RateLimit = config.RateLimit
+	}
+	if fieldsToSet["RateBurst"] && config.RateBurst != 0 {
+		Env.RateBurst = config.RateBurst
+	}
+	if fieldsToSet["MaxBodySize"] && config.MaxBodySize != 0 {
+		Env.MaxBodySize = config.MaxBodySize
+	}
+	if fieldsToSet["MaxBatchLength"] && config.MaxBatchLength != 0 {
+		Env.MaxBatchLength = config.MaxBatchLength
+	}
+	if fieldsToSet["SignatureSkew"] && config.SignatureSkew != "" {
+		dur, err := time.ParseDuration(config.SignatureSkew)
+		if err != nil {
+			return err
+		}
+		Env.SignatureSkew = dur
+	}
+	if fieldsToSet["RequireSignature"] {
+		Env.RequireSignature = config.RequireSignature
+	}
+	if fieldsToSet["StoreKeyFile"] && config.StoreKeyFile != "" {
+		Env.StoreKeyFile = config.StoreKeyFile
+	}
+	if fieldsToSet["SignResponses"] && config.SignResponses {
+		Env.SignResponses = config.SignResponses
+	}
+	// пустые значения политики проверки метрик в конфиге не переопределяют Env
+	if fieldsToSet["MetricNameRegex"] && config.MetricNameRegex != "" {
+		Env.MetricNameRegex = config.MetricNameRegex
+	}
+	if fieldsToSet["MetricNameMaxLen"] && config.MetricNameMaxLen != 0 {
+		Env.MetricNameMaxLen = config.MetricNameMaxLen
+	}
+	if fieldsToSet["AllowedTypes"] && config.AllowedTypes != "" {
+		Env.AllowedTypes = config.AllowedTypes
+	}
+	if fieldsToSet["AllowNonFinite"] && config.AllowNonFinite {
+		Env.AllowNonFinite = config.AllowNonFinite
+	}
+	if fieldsToSet["MaxCounterDelta"] && config.MaxCounterDelta != 0 {
+		Env.MaxCounterDelta = config.MaxCounterDelta
+	}
+	if fieldsToSet["DenyMetrics"] && config.DenyMetrics != "" {
+		Env.DenyMetrics = config.DenyMetrics
+	}
+	if fieldsToSet["RelabelRulesFile"] && config.RelabelRulesFile != "" {
+		Env.RelabelRulesFile = config.RelabelRulesFile
+	}
 	return nil
 }
 
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+// Названия счетчиков отклоненных запросов, возвращаемых handlerRejected.
+const (
+	rejectedRateLimitedName  = "RejectedRateLimited"
+	rejectedBodyTooLargeName = "RejectedBodyTooLarge"
+	rejectedBatchTooLongName = "RejectedBatchTooLong"
+)
+
+// rejectedRequests счетчики запросов, отклоненных из-за ограничений сервера.
+type rejectedRequests struct {
+	rateLimited  atomic.Int64
+	bodyTooLarge atomic.Int64
+	batchTooLong atomic.Int64
+}
+
+// limitBodySize - middleware, ограничивающий размер тела запроса(после распаковки) значением MaxBodySize.
+// Тело читается целиком, при превышении - 413.
+func (s *Server) limitBodySize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if Env.MaxBodySize <= 0 {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		tooLargeMsg := fmt.Sprintf("request body exceeds %d bytes", Env.MaxBodySize)
+		if request.ContentLength > Env.MaxBodySize {
+			s.rejected.bodyTooLarge.Add(1)
+			http.Error(writer, tooLargeMsg, http.StatusRequestEntityTooLarge)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, Env.MaxBodySize))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				s.rejected.bodyTooLarge.Add(1)
+				http.Error(writer, tooLargeMsg, http.StatusRequestEntityTooLarge)
+			} else {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		request.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// limitRate - middleware, ограничивающий частоту запросов клиента(RATE_LIMIT запросов в секунду).
+// Клиент определяется по адресу подключения(X-Real-IP - только от доверенного прокси, см. clientIP).
+// Выполняется первым, до распаковки, чтения и расшифровки тела запроса.
+// При превышении - 429 с заголовком Retry-After.
+func (s *Server) limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if s.rateLimiter == nil {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		key := s.clientIP(request)
+		allow, wait := s.rateLimiter.Allow(key, time.Now())
+		if !allow {
+			s.rejected.rateLimited.Add(1)
+			retryAfter := int(math.Max(1, math.Ceil(wait.Seconds())))
+			writer.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			http.Error(writer, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// handlerRejected godoc
+//
+//	@Tags			JSON
+//	@Summary		Обрабатывает GET запросы получения счетчиков отклоненных сервером запросов.
+//	@Description	В ответ возвращает массив counter метрик: RejectedRateLimited(429),
+//
+// RejectedBodyTooLarge и RejectedBatchTooLong(413). Счетчики считаются с момента запуска сервера.
+// Если токены заданы - требуется токен с правом admin.
+//
+//	@ID				handlerRejected
+//	@Produce		json
+//	@Success		200	{string}	string	"ok"
+//	@Failure		500	{string}	string	"Внутренняя ошибка"
+//	@Security		BearerAuth
+//	@Router			/api/v1/rejected [get]
+func (s *Server) handlerRejected(writer http.ResponseWriter, request *http.Request) {
+	counters := []struct {
+		name  string
+		value int64
+	}{
+		{name: rejectedBatchTooLongName, value: s.rejected.batchTooLong.Load()},
+		{name: rejectedBodyTooLargeName, value: s.rejected.bodyTooLarge.Load()},
+		{name: rejectedRateLimitedName, value: s.rejected.rateLimited.Load()},
+	}
+
+	result := make([]message.Metrics, 0, len(counters))
+	for _, c := range counters {
+		value := c.value
+		msg := message.Metrics{ID: c.name, MType: internal.CounterTypeName, Delta: &value}
+		if Env.Key != "" {
+			if err := msg.InitHash(Env.Key); err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		result = append(result, msg)
+	}
+
+	msgJSON, err := json.Marshal(result)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(msgJSON)
+}
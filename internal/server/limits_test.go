@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal/auth"
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/ratelimit"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+func TestServer_limitBodySize(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+
+	batchBody := `[{"id":"PollCount","type":"counter","delta":10},{"id":"RandomValue","type":"gauge","value":1.5}]`
+	tests := []struct {
+		name             string
+		maxBodySize      int64
+		maxBatchLength   int
+		request          requestArgs
+		wantStatusCode   int
+		wantBodyTooLarge int64
+		wantBatchTooLong int64
+	}{
+		{
+			name:           "Test 1. Limits are disabled.",
+			request:        requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Test 2. Body size is within limit.",
+			maxBodySize:    int64(len(batchBody)),
+			request:        requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:             "Test 3. Body size exceeds limit.",
+			maxBodySize:      int64(len(batchBody)) - 1,
+			request:          requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+			wantStatusCode:   http.StatusRequestEntityTooLarge,
+			wantBodyTooLarge: 1,
+		},
+		{
+			name:             "Test 4. Body size exceeds limit, json handler.",
+			maxBodySize:      10,
+			request:          requestArgs{method: http.MethodPost, url: "/update/", body: `{"id":"PollCount","type":"counter","delta":10}`},
+			wantStatusCode:   http.StatusRequestEntityTooLarge,
+			wantBodyTooLarge: 1,
+		},
+		{
+			name:           "Test 5. Batch length is within limit.",
+			maxBatchLength: 2,
+			request:        requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:             "Test 6. Batch length exceeds limit.",
+			maxBatchLength:   1,
+			request:          requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+			wantStatusCode:   http.StatusRequestEntityTooLarge,
+			wantBatchTooLong: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Env.MaxBodySize = tt.maxBodySize
+			Env.MaxBatchLength = tt.maxBatchLength
+			s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{})}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			statusCode, _, _ := sendTestRequest(t, ts, tt.request)
+			assert.Equal(t, tt.wantStatusCode, statusCode)
+			assert.Equal(t, tt.wantBodyTooLarge, s.rejected.bodyTooLarge.Load())
+			assert.Equal(t, tt.wantBatchTooLong, s.rejected.batchTooLong.Load())
+		})
+	}
+}
+
+func TestServer_limitRate(t *testing.T) {
+	tokens, err := auth.NewTokenStore([]auth.Token{
+		{Name: "agent1", Hash: auth.HashToken("agent1-token"), Scopes: []auth.Scope{auth.ScopeWrite}},
+		{Name: "agent2", Hash: auth.HashToken("agent2-token"), Scopes: []auth.Scope{auth.ScopeWrite}},
+	})
+	require.NoError(t, err)
+
+	updateRequest := requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"}
+	tests := []struct {
+		name            string
+		tokens          *auth.TokenStore
+		limiter         *ratelimit.Limiter
+		trustedProxies  string
+		headers         []map[string]string
+		wantStatusCodes []int
+	}{
+		{
+			name:            "Test 1. Rate limit is disabled.",
+			headers:         []map[string]string{{}, {}, {}},
+			wantStatusCodes: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:           "Test 2. Rate limit by ip.",
+			limiter:        ratelimit.NewLimiter(0.1, 2),
+			trustedProxies: "127.0.0.0/8",
+			headers: []map[string]string{
+				{"X-Real-IP": "10.0.0.1"}, {"X-Real-IP": "10.0.0.1"}, {"X-Real-IP": "10.0.0.1"},
+				{"X-Real-IP": "10.0.0.2"},
+			},
+			wantStatusCodes: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+		{
+			name:    "Test 3. X-Real-IP from untrusted proxy is ignored.",
+			limiter: ratelimit.NewLimiter(0.1, 2),
+			headers: []map[string]string{
+				{"X-Real-IP": "10.0.0.1"}, {"X-Real-IP": "10.0.0.2"}, {"X-Real-IP": "10.0.0.3"},
+			},
+			wantStatusCodes: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:           "Test 4. Rate limit by ip, token is ignored.",
+			tokens:         tokens,
+			limiter:        ratelimit.NewLimiter(0.1, 1),
+			trustedProxies: "127.0.0.0/8",
+			headers: []map[string]string{
+				{"Authorization": "Bearer agent1-token", "X-Real-IP": "10.0.0.1"},
+				{"Authorization": "Bearer agent1-token", "X-Real-IP": "10.0.0.2"},
+				{"Authorization": "Bearer agent2-token", "X-Real-IP": "10.0.0.1"},
+			},
+			wantStatusCodes: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:    "Test 5. Limited before body processing(broken gzip body - 429, not 500).",
+			limiter: ratelimit.NewLimiter(0.1, 1),
+			headers: []map[string]string{
+				{"Content-Encoding": "gzip"}, {"Content-Encoding": "gzip"},
+			},
+			wantStatusCodes: []int{http.StatusInternalServerError, http.StatusTooManyRequests},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trustedProxies, err := parseTrustedSubnets(tt.trustedProxies)
+			require.NoError(t, err)
+			s := &Server{
+				MetricStorage:  storage.NewMemStorage(map[string]storage.Metric{}),
+				Tokens:         tt.tokens,
+				rateLimiter:    tt.limiter,
+				trustedProxies: trustedProxies,
+			}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			var wantRejected int64
+			for i, headers := range tt.headers {
+				req, err := http.NewRequest(updateRequest.method, ts.URL+updateRequest.url, nil)
+				require.NoError(t, err)
+				for key, value := range headers {
+					req.Header.Set(key, value)
+				}
+				resp, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				resp.Body.Close()
+
+				assert.Equal(t, tt.wantStatusCodes[i], resp.StatusCode)
+				if tt.wantStatusCodes[i] == http.StatusTooManyRequests {
+					wantRejected++
+					assert.Equal(t, "10", resp.Header.Get("Retry-After"))
+				}
+			}
+			assert.Equal(t, wantRejected, s.rejected.rateLimited.Load())
+		})
+	}
+}
+
+func TestServer_handlerRejected(t *testing.T) {
+	s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{})}
+	s.rejected.rateLimited.Add(3)
+	s.rejected.batchTooLong.Add(1)
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+
+	statusCode, contentType, body := sendTestRequest(t, ts, requestArgs{method: http.MethodGet, url: "/api/v1/rejected"})
+	require.Equal(t, http.StatusOK, statusCode)
+	assert.True(t, strings.HasPrefix(contentType, "application/json"))
+
+	var result []message.Metrics
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	got := map[string]int64{}
+	for _, msg := range result {
+		require.NotNil(t, msg.Delta)
+		got[msg.ID] = *msg.Delta
+	}
+	assert.Equal(t, map[string]int64{
+		rejectedBatchTooLongName: 1,
+		rejectedBodyTooLargeName: 0,
+		rejectedRateLimitedName:  3,
+	}, got)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+// requestContentType возвращает формат тела запроса(JSON, protobuf, MessagePack) по заголовку Content-Type.
+// Для пустого и неподдерживаемого Content-Type - JSON, как до поддержки других форматов.
+func requestContentType(request *http.Request) string {
+	contentType, err := message.ParseContentType(request.Header.Get("Content-Type"))
+	if err != nil {
+		return message.ContentTypeJSON
+	}
+	return contentType
+}
+
+// responseContentType возвращает формат ответа: первый поддерживаемый из Accept, иначе - формат запроса.
+func responseContentType(request *http.Request) string {
+	return message.AcceptedContentType(request.Header.Get("Accept"), requestContentType(request))
+}
+
+// readMetricMessage читает из тела запроса метрику в формате requestContentType.
+func readMetricMessage(request *http.Request, m *message.Metrics) error {
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		return err
+	}
+	return message.Unmarshal(requestContentType(request), body, m)
+}
+
+// writeMetricMessage пишет в ответ метрику в формате responseContentType.
+func writeMetricMessage(writer http.ResponseWriter, request *http.Request, m message.Metrics) {
+	contentType := responseContentType(request)
+	body, err := message.Marshal(contentType, m)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.Write(body)
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+func TestServer_contentNegotiation(t *testing.T) {
+	delta, value := int64(10), 2.27
+	pollCount := message.Metrics{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta}
+	randomValue := message.Metrics{ID: "RandomValue", MType: internal.GaugeTypeName, Value: &value}
+	metricPollCount, err := storage.NewMetric("PollCount", internal.CounterTypeName, int64(10))
+	require.NoError(t, err)
+	metricRandomValue, err := storage.NewMetric("RandomValue", internal.GaugeTypeName, 2.27)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		url             string
+		contentType     string
+		accept          string
+		body            func(contentType string) []byte
+		wantContentType string
+		wantResponse    *message.Metrics
+		wantedState     map[string]storage.Metric
+	}{
+		{
+			name:        "Test 1. Protobuf update.",
+			url:         "/update/",
+			contentType: message.ContentTypeProtobuf,
+			body: func(contentType string) []byte {
+				b, _ := message.Marshal(contentType, pollCount)
+				return b
+			},
+			wantContentType: message.ContentTypeProtobuf,
+			wantResponse:    &pollCount,
+			wantedState:     map[string]storage.Metric{metricPollCount.Name: *metricPollCount},
+		},
+		{
+			name:        "Test 2. MessagePack update, JSON response by Accept.",
+			url:         "/update/",
+			contentType: message.ContentTypeMsgpack,
+			accept:      "application/json",
+			body: func(contentType string) []byte {
+				b, _ := message.Marshal(contentType, pollCount)
+				return b
+			},
+			wantContentType: message.ContentTypeJSON,
+			wantResponse:    &pollCount,
+			wantedState:     map[string]storage.Metric{metricPollCount.Name: *metricPollCount},
+		},
+		{
+			name:        "Test 3. Protobuf batch update.",
+			url:         "/updates/",
+			contentType: message.ContentTypeProtobuf,
+			body: func(contentType string) []byte {
+				b, _ := message.MarshalBatch(contentType, []message.Metrics{pollCount, randomValue})
+				return b
+			},
+			wantContentType: message.ContentTypeProtobuf,
+			wantedState: map[string]storage.Metric{
+				metricPollCount.Name: *metricPollCount, metricRandomValue.Name: *metricRandomValue,
+			},
+		},
+		{
+			name:        "Test 4. MessagePack batch update.",
+			url:         "/updates/",
+			contentType: message.ContentTypeMsgpack,
+			body: func(contentType string) []byte {
+				b, _ := message.MarshalBatch(contentType, []message.Metrics{pollCount, randomValue})
+				return b
+			},
+			wantContentType: message.ContentTypeMsgpack,
+			wantedState: map[string]storage.Metric{
+				metricPollCount.Name: *metricPollCount, metricRandomValue.Name: *metricRandomValue,
+			},
+		},
+		{
+			name:        "Test 5. JSON value request, MessagePack response by Accept.",
+			url:         "/value/",
+			contentType: message.ContentTypeJSON,
+			accept:      "application/msgpack",
+			body: func(contentType string) []byte {
+				return []byte(`{"id":"RandomValue","type":"gauge"}`)
+			},
+			wantContentType: message.ContentTypeMsgpack,
+			wantResponse:    &randomValue,
+			wantedState:     map[string]storage.Metric{metricRandomValue.Name: *metricRandomValue},
+		},
+		{
+			name:        "Test 6. Unsupported content type is parsed as JSON.",
+			url:         "/update/",
+			contentType: "text/plain",
+			body: func(contentType string) []byte {
+				return []byte(`{"id":"PollCount","type":"counter","delta":10}`)
+			},
+			wantContentType: message.ContentTypeJSON,
+			wantResponse:    &pollCount,
+			wantedState:     map[string]storage.Metric{metricPollCount.Name: *metricPollCount},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initState := map[string]storage.Metric{}
+			if tt.url == "/value/" {
+				initState[metricRandomValue.Name] = *metricRandomValue
+			}
+			s := &Server{MetricStorage: storage.NewMemStorage(initState)}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodPost, ts.URL+tt.url, bytes.NewReader(tt.body(tt.contentType)))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+			assert.Equal(t, tt.wantContentType, resp.Header.Get("Content-Type"))
+			if tt.wantResponse != nil {
+				var got message.Metrics
+				require.NoError(t, message.Unmarshal(tt.wantContentType, body, &got))
+				assert.Equal(t, *tt.wantResponse, got)
+			} else {
+				got, err := message.UnmarshalBatch(tt.wantContentType, body)
+				require.NoError(t, err)
+				assert.Empty(t, got)
+			}
+			compareMetricsState(t, tt.wantedState, s.MetricStorage, context.Background())
+		})
+	}
+}
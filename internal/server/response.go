@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/firesworder/devopsmetrics/internal/auth"
+	"github.com/firesworder/devopsmetrics/internal/crypt"
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+// bufferedResponseWriter накапливает тело и статус ответа хендлера, заголовки пишутся в исходный writer.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+// maxClientKeys максимальное число зарегистрированных ключей клиентов. При регистрации сверх лимита удаляется ключ,
+// дольше всех не использовавшийся.
+const maxClientKeys = 1000
+
+// registeredClientKey зарегистрированный ключ клиента.
+type registeredClientKey struct {
+	encoder *crypt.Encoder
+	// owner агент, зарегистрировавший ключ(см. clientKeyOwner).
+	owner string
+	// lastUsed время(unix nano) регистрации или последнего шифрования ответа ключом.
+	lastUsed atomic.Int64
+}
+
+// clientKey возвращает зарегистрированный ключ клиента по id, nil - если ключ не зарегистрирован.
+func (s *Server) clientKey(keyID string) *crypt.Encoder {
+	s.clientKeysMutex.RLock()
+	defer s.clientKeysMutex.RUnlock()
+	registered, ok := s.clientKeys[keyID]
+	if !ok {
+		return nil
+	}
+	registered.lastUsed.Store(time.Now().UnixNano())
+	return registered.encoder
+}
+
+// clientKeyOwner возвращает агента, регистрирующего ключ: имя bearer токена, если токены заданы,
+// иначе id агента из подписи или адрес отправителя(requestAgent).
+func (s *Server) clientKeyOwner(request *http.Request) string {
+	if token, ok := auth.TokenFromContext(request.Context()); ok {
+		return "token:" + token.Name
+	}
+	return s.requestAgent(request)
+}
+
+// addClientKey регистрирует ключ клиента owner. У агента хранится один ключ: ранее зарегистрированный им
+// ключ удаляется. Если зарегистрировано maxClientKeys ключей - удаляет дольше всех не использовавшийся.
+func (s *Server) addClientKey(owner string, clientKey *crypt.Encoder) {
+	s.clientKeysMutex.Lock()
+	defer s.clientKeysMutex.Unlock()
+
+	if s.clientKeys == nil {
+		s.clientKeys = map[string]*registeredClientKey{}
+	}
+	for keyID, registered := range s.clientKeys {
+		if registered.owner == owner && keyID != clientKey.KeyID() {
+			delete(s.clientKeys, keyID)
+		}
+	}
+	if _, ok := s.clientKeys[clientKey.KeyID()]; !ok && len(s.clientKeys) >= maxClientKeys {
+		var oldestID string
+		var oldest int64
+		for keyID, registered := range s.clientKeys {
+			if lastUsed := registered.lastUsed.Load(); oldestID == "" || lastUsed < oldest {
+				oldestID, oldest = keyID, lastUsed
+			}
+		}
+		delete(s.clientKeys, oldestID)
+	}
+	registered := &registeredClientKey{encoder: clientKey, owner: owner}
+	registered.lastUsed.Store(time.Now().UnixNano())
+	s.clientKeys[clientKey.KeyID()] = registered
+}
+
+// secureResponse - middleware, подписывающий(SIGN_RESPONSES) и шифрующий для клиента успешные ответы.
+// Подпись(crypt.SignatureHeader) делается приватным ключом сервера, id которого передан агентом в X-Key-ID.
+// Подписываются метод и путь запроса, nonce запроса(crypt.ResponseNonceHeader) и тело ответа.
+// Ответ шифруется, если в запросе передан id зарегистрированного ключа клиента(crypt.ClientKeyIDHeader),
+// для незарегистрированного id - 400.
+func (s *Server) secureResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		var clientKey *crypt.Encoder
+		if clientKeyID := request.Header.Get(crypt.ClientKeyIDHeader); clientKeyID != "" {
+			if clientKey = s.clientKey(clientKeyID); clientKey == nil {
+				http.Error(writer, "unknown client key id '"+clientKeyID+"'", http.StatusBadRequest)
+				return
+			}
+		}
+		if (!Env.SignResponses || s.Decoder == nil) && clientKey == nil {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		buffered := &bufferedResponseWriter{ResponseWriter: writer, statusCode: http.StatusOK}
+		next.ServeHTTP(buffered, request)
+		body := buffered.body.Bytes()
+		if buffered.statusCode != http.StatusOK {
+			writer.WriteHeader(buffered.statusCode)
+			writer.Write(body)
+			return
+		}
+
+		if Env.SignResponses && s.Decoder != nil {
+			target := message.SignatureTarget(request.URL.Path, request.URL.RawQuery)
+			signed := crypt.ResponseSignatureMessage(request.Method, target,
+				request.Header.Get(crypt.ResponseNonceHeader), body)
+			signature, keyID, err := s.Decoder.Sign(request.Header.Get(crypt.KeyIDHeader), signed)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set(crypt.SignatureHeader, base64.StdEncoding.EncodeToString(signature))
+			writer.Header().Set(crypt.KeyIDHeader, keyID)
+		}
+		if clientKey != nil {
+			encrypted, err := clientKey.Encode(body)
+			if err != nil {
+				http.Error(writer, "cannot encrypt response: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			body = encrypted
+			writer.Header().Set(crypt.ClientKeyIDHeader, clientKey.KeyID())
+			writer.Header().Set("Content-Type", "application/octet-stream")
+		}
+		writer.Write(body)
+	})
+}
+
+// handlerRegisterClientKey godoc
+//
+//	@Tags			NoJSON
+//	@Summary		Регистрирует публичный ключ клиента для шифрования ответов.
+//	@Description	В теле передается публичный RSA ключ в pem-формате(PKCS#1, PKIX или X.509 сертификат).
+//
+// В ответ возвращает id ключа, который клиент передает в заголовке X-Client-Key-ID,
+// чтобы ответы /value/ и /update/ шифровались этим ключом. Ключи хранятся до перезапуска сервера,
+// но не более 1000: при превышении удаляется ключ, дольше всех не использовавшийся.
+// У агента(токена, если токены заданы) хранится один ключ: повторная регистрация заменяет предыдущий ключ.
+//
+//	@ID				handlerRegisterClientKey
+//	@Accept			plain
+//	@Produce		json
+//	@Success		200	{string}	string	"ok"
+//	@Failure		400	{string}	string	"Неверный ключ"
+//	@Failure		403	{string}	string	"ip is not in trusted subnet"
+//	@Failure		500	{string}	string	"Внутренняя ошибка"
+//	@Security		BearerAuth
+//	@Router			/keys/ [post]
+func (s *Server) handlerRegisterClientKey(writer http.ResponseWriter, request *http.Request) {
+	content, err := io.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientKey, err := crypt.NewEncoderFromPEM(content)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.addClientKey(s.clientKeyOwner(request), clientKey)
+
+	msgJSON, err := json.Marshal(map[string]string{"key_id": clientKey.KeyID()})
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(msgJSON)
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/auth"
+	"github.com/firesworder/devopsmetrics/internal/crypt"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+func TestServer_handlerRegisterClientKey(t *testing.T) {
+	testKeysDir := "../crypt/test/"
+	publicKey, err := os.ReadFile(testKeysDir + "publicKey_2_test.pem")
+	require.NoError(t, err)
+	clientKey, err := crypt.NewEncoderFromPEM(publicKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		body           string
+		wantStatusCode int
+		wantKeyID      string
+	}{
+		{
+			name:           "Test 1. Correct public key.",
+			body:           string(publicKey),
+			wantStatusCode: http.StatusOK,
+			wantKeyID:      clientKey.KeyID(),
+		},
+		{
+			name:           "Test 2. Not a public key.",
+			body:           "not a key",
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{})}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			statusCode, _, body := sendTestRequest(t, ts, requestArgs{method: http.MethodPost, url: "/keys/", body: tt.body})
+			require.Equal(t, tt.wantStatusCode, statusCode)
+			if tt.wantKeyID == "" {
+				assert.Nil(t, s.clientKey(tt.wantKeyID))
+				return
+			}
+			var registered map[string]string
+			require.NoError(t, json.Unmarshal([]byte(body), &registered))
+			assert.Equal(t, tt.wantKeyID, registered["key_id"])
+			assert.NotNil(t, s.clientKey(tt.wantKeyID))
+		})
+	}
+}
+
+func TestServer_secureResponse(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+	testKeysDir := "../crypt/test/"
+
+	serverKeys, err := crypt.NewKeyRing(testKeysDir + "privateKey_1_test.pem")
+	require.NoError(t, err)
+	serverPublicKey, err := crypt.NewEncoder(testKeysDir + "publicKey_1_test.pem")
+	require.NoError(t, err)
+	clientPublicKey, err := crypt.NewEncoder(testKeysDir + "publicKey_2_test.pem")
+	require.NoError(t, err)
+	clientPrivateKey, err := crypt.NewDecoder(testKeysDir + "privateKey_2_test.pem")
+	require.NoError(t, err)
+
+	metric, err := storage.NewMetric("PollCount", internal.CounterTypeName, int64(10))
+	require.NoError(t, err)
+	wantValueBody := `{"id":"PollCount","type":"counter","delta":10}`
+	// ответ больше размера RSA ключа
+	longName := strings.Repeat("PollCount", 50)
+	longNameMetric, err := storage.NewMetric(longName, internal.CounterTypeName, int64(10))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		signResponses  bool
+		request        requestArgs
+		clientKeyID    string
+		wantStatusCode int
+		wantSigned     bool
+		wantEncrypted  bool
+		wantBody       string
+	}{
+		{
+			name:           "Test 1. Signing and encryption are disabled.",
+			request:        requestArgs{method: http.MethodPost, url: "/value/", body: `{"id":"PollCount","type":"counter"}`},
+			wantStatusCode: http.StatusOK,
+			wantBody:       wantValueBody,
+		},
+		{
+			name:           "Test 2. Signed value response.",
+			signResponses:  true,
+			request:        requestArgs{method: http.MethodPost, url: "/value/", body: `{"id":"PollCount","type":"counter"}`},
+			wantStatusCode: http.StatusOK,
+			wantSigned:     true,
+			wantBody:       wantValueBody,
+		},
+		{
+			name:           "Test 3. Signed and encrypted value response.",
+			signResponses:  true,
+			request:        requestArgs{method: http.MethodPost, url: "/value/", body: `{"id":"PollCount","type":"counter"}`},
+			clientKeyID:    clientPublicKey.KeyID(),
+			wantStatusCode: http.StatusOK,
+			wantSigned:     true,
+			wantEncrypted:  true,
+			wantBody:       wantValueBody,
+		},
+		{
+			name: "Test 4. Encrypted update response.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/update/", body: `{"id":"PollCount","type":"counter","delta":5}`,
+			},
+			clientKeyID:    clientPublicKey.KeyID(),
+			wantStatusCode: http.StatusOK,
+			wantEncrypted:  true,
+			wantBody:       `{"id":"PollCount","type":"counter","delta":15}`,
+		},
+		{
+			name:           "Test 5. Signed url value response.",
+			signResponses:  true,
+			request:        requestArgs{method: http.MethodGet, url: "/value/counter/PollCount"},
+			wantStatusCode: http.StatusOK,
+			wantSigned:     true,
+			wantBody:       "10",
+		},
+		{
+			name:           "Test 6. Unknown client key id.",
+			request:        requestArgs{method: http.MethodPost, url: "/value/", body: `{"id":"PollCount","type":"counter"}`},
+			clientKeyID:    "0000000000000000",
+			wantStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:          "Test 7. Encrypted response larger than key.",
+			signResponses: true,
+			request: requestArgs{
+				method: http.MethodPost, url: "/value/", body: `{"id":"` + longName + `","type":"counter"}`,
+			},
+			clientKeyID:    clientPublicKey.KeyID(),
+			wantStatusCode: http.StatusOK,
+			wantSigned:     true,
+			wantEncrypted:  true,
+			wantBody:       `{"id":"` + longName + `","type":"counter","delta":10}`,
+		},
+		{
+			name:           "Test 8. Error responses are not signed.",
+			signResponses:  true,
+			request:        requestArgs{method: http.MethodPost, url: "/value/", body: `{"id":"Unknown","type":"counter"}`},
+			wantStatusCode: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Env = environment{SignResponses: tt.signResponses}
+			s := &Server{
+				MetricStorage: storage.NewMemStorage(map[string]storage.Metric{
+					metric.Name: *metric, longNameMetric.Name: *longNameMetric,
+				}),
+				Decoder: serverKeys,
+			}
+			s.addClientKey("agent1", clientPublicKey)
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			req, err := http.NewRequest(tt.request.method, ts.URL+tt.request.url, strings.NewReader(tt.request.body))
+			require.NoError(t, err)
+			req.Header.Set("Content-Type", "application/json")
+			if tt.clientKeyID != "" {
+				req.Header.Set(crypt.ClientKeyIDHeader, tt.clientKeyID)
+			}
+			req.Header.Set(crypt.ResponseNonceHeader, "0011")
+			resp, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			require.Equal(t, tt.wantStatusCode, resp.StatusCode, string(body))
+			assert.Equal(t, tt.wantEncrypted, resp.Header.Get(crypt.ClientKeyIDHeader) != "")
+			assert.Equal(t, tt.wantSigned, resp.Header.Get(crypt.SignatureHeader) != "")
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			if tt.wantEncrypted {
+				body, err = clientPrivateKey.Decode(body)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantBody, string(body))
+			if tt.wantSigned {
+				signature, err := base64.StdEncoding.DecodeString(resp.Header.Get(crypt.SignatureHeader))
+				require.NoError(t, err)
+				// подпись связана с запросом: методом, путем и nonce
+				signed := crypt.ResponseSignatureMessage(req.Method, req.URL.Path, "0011", body)
+				assert.NoError(t, serverPublicKey.Verify(signed, signature))
+				assert.Error(t, serverPublicKey.Verify(body, signature))
+				otherNonce := crypt.ResponseSignatureMessage(req.Method, req.URL.Path, "0012", body)
+				assert.Error(t, serverPublicKey.Verify(otherNonce, signature))
+				assert.Equal(t, serverPublicKey.KeyID(), resp.Header.Get(crypt.KeyIDHeader))
+			}
+		})
+	}
+}
+
+func TestServer_addClientKey(t *testing.T) {
+	testKeysDir := "../crypt/test/"
+	clientKey1, err := crypt.NewEncoder(testKeysDir + "publicKey_1_test.pem")
+	require.NoError(t, err)
+	clientKey2, err := crypt.NewEncoder(testKeysDir + "publicKey_2_test.pem")
+	require.NoError(t, err)
+
+	s := &Server{}
+	// заполняю реестр ключей до лимита
+	s.clientKeys = map[string]*registeredClientKey{}
+	for i := 0; i < maxClientKeys-1; i++ {
+		registered := &registeredClientKey{encoder: clientKey2, owner: fmt.Sprintf("agent%d", i)}
+		registered.lastUsed.Store(int64(i + 1))
+		s.clientKeys[fmt.Sprintf("key%d", i)] = registered
+	}
+	s.addClientKey("agent1000", clientKey1)
+	assert.Len(t, s.clientKeys, maxClientKeys)
+
+	// повторная регистрация не удаляет ключи
+	s.addClientKey("agent1000", clientKey1)
+	assert.Len(t, s.clientKeys, maxClientKeys)
+
+	// сверх лимита удаляется дольше всех не использовавшийся ключ
+	assert.NotNil(t, s.clientKey("key0"))
+	s.addClientKey("agent1001", clientKey2)
+	assert.Len(t, s.clientKeys, maxClientKeys)
+	assert.NotNil(t, s.clientKey("key0"))
+	assert.Nil(t, s.clientKey("key1"))
+	assert.NotNil(t, s.clientKey(clientKey1.KeyID()))
+	assert.NotNil(t, s.clientKey(clientKey2.KeyID()))
+
+	// у агента хранится один ключ, новый ключ заменяет предыдущий
+	s.addClientKey("agent2", clientKey1)
+	assert.Len(t, s.clientKeys, maxClientKeys-1)
+	assert.Nil(t, s.clientKey("key2"))
+	assert.Equal(t, "agent2", s.clientKeys[clientKey1.KeyID()].owner)
+}
+
+func TestServer_clientKeyOwner(t *testing.T) {
+	s := &Server{}
+	request := httptest.NewRequest(http.MethodPost, "/keys/", nil)
+	request.RemoteAddr = "10.0.0.1:4000"
+	assert.Equal(t, "10.0.0.1", s.clientKeyOwner(request))
+
+	signed := request.WithContext(context.WithValue(request.Context(), agentIDContextKey{}, "agent1"))
+	assert.Equal(t, "agent1", s.clientKeyOwner(signed))
+
+	// при заданных токенах агент определяется по токену
+	authorized := signed.WithContext(auth.WithToken(signed.Context(), auth.Token{Name: "agent-token"}))
+	assert.Equal(t, "token:agent-token", s.clientKeyOwner(authorized))
+}
@@ -5,9 +5,10 @@ package server
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/rsa"
+	"crypto/tls"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -15,10 +16,13 @@ import (
 	"html/template"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/caarlos0/env/v7"
@@ -26,9 +30,20 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	_ "github.com/jackc/pgx/v5/stdlib"
 
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/alerts"
+	"github.com/firesworder/devopsmetrics/internal/auth"
+	"github.com/firesworder/devopsmetrics/internal/compression"
+	"github.com/firesworder/devopsmetrics/internal/counters"
 	"github.com/firesworder/devopsmetrics/internal/filestore"
 	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/notify"
+	"github.com/firesworder/devopsmetrics/internal/ratelimit"
+	"github.com/firesworder/devopsmetrics/internal/relabel"
 	"github.com/firesworder/devopsmetrics/internal/storage"
+	"github.com/firesworder/devopsmetrics/internal/stream"
+	"github.com/firesworder/devopsmetrics/internal/tlsconfig"
+	"github.com/firesworder/devopsmetrics/internal/validation"
 )
 
 // Инициализирует параметры командной строки.
@@ -46,6 +61,37 @@ type environment struct {
 	StoreInterval      time.Duration `env:"STORE_INTERVAL"`
 	PrivateCryptoKeyFp string        `env:"CRYPTO_KEY"`
 	ConfigFilepath     string        `env:"CONFIG"`
+	MetricTTL          time.Duration `env:"METRIC_TTL"`
+	DropStale          bool          `env:"DROP_STALE"`
+	CounterHistory     time.Duration `env:"COUNTER_HISTORY"`
+	AlertRulesFile     string        `env:"ALERT_RULES"`
+	AlertInterval      time.Duration `env:"ALERT_INTERVAL"`
+	WebhookURLs        string        `env:"WEBHOOK_URLS"`
+	WebhookTemplate    string        `env:"WEBHOOK_TEMPLATE"`
+	WebhookContentType string        `env:"WEBHOOK_CONTENT_TYPE"`
+	AgentSilentAfter   time.Duration `env:"AGENT_SILENT_AFTER"`
+	TrustedSubnet      string        `env:"TRUSTED_SUBNET"`
+	TrustedProxies     string        `env:"TRUSTED_PROXIES"`
+	TLSCertFp          string        `env:"TLS_CERT"`
+	TLSKeyFp           string        `env:"TLS_KEY"`
+	TLSClientCAFp      string        `env:"TLS_CLIENT_CA"`
+	AuthTokensFp       string        `env:"AUTH_TOKENS"`
+	RateLimit          float64       `env:"RATE_LIMIT"`
+	RateBurst          int           `env:"RATE_BURST"`
+	MaxBodySize        int64         `env:"MAX_BODY_SIZE"`
+	MaxBatchLength     int           `env:"MAX_BATCH_LENGTH"`
+	SignatureSkew      time.Duration `env:"SIGNATURE_SKEW"`
+	RequireSignature   bool          `env:"REQUIRE_SIGNATURE"`
+	StoreKey           string        `env:"STORE_KEY"`
+	StoreKeyFile       string        `env:"STORE_KEY_FILE"`
+	SignResponses      bool          `env:"SIGN_RESPONSES"`
+	MetricNameRegex    string        `env:"METRIC_NAME_REGEX"`
+	MetricNameMaxLen   int           `env:"METRIC_NAME_MAX_LENGTH"`
+	AllowedTypes       string        `env:"ALLOWED_TYPES"`
+	AllowNonFinite     bool          `env:"ALLOW_NON_FINITE"`
+	MaxCounterDelta    int64         `env:"MAX_COUNTER_DELTA"`
+	DenyMetrics        string        `env:"DENY_METRICS"`
+	RelabelRulesFile   string        `env:"RELABEL_RULES"`
 }
 
 // Env объект с переменными окружения(из ENV и cmd args).
@@ -60,9 +106,50 @@ func initCmdArgs() {
 	flag.StringVar(&Env.StoreFile, "f", "/tmp/devops-metrics-db.json", "store file")
 	flag.StringVar(&Env.Key, "k", "", "key for hash func")
 	flag.StringVar(&Env.DatabaseDsn, "d", "", "database address")
-	flag.StringVar(&Env.PrivateCryptoKeyFp, "crypto-key", "", "filepath to private key")
+	flag.StringVar(&Env.PrivateCryptoKeyFp, "crypto-key", "",
+		"comma separated private key files or dirs with *.pem keys")
 	flag.StringVar(&Env.ConfigFilepath, "config", "", "filepath to json env config")
 	flag.StringVar(&Env.ConfigFilepath, "c", "", "filepath to json env config")
+	flag.DurationVar(&Env.MetricTTL, "ttl", 0, "metric ttl, metrics not updated longer are stale(0 - disabled)")
+	flag.BoolVar(&Env.DropStale, "drop-stale", false, "delete stale metrics instead of marking them")
+	flag.DurationVar(&Env.CounterHistory, "counter-history", 10*time.Minute,
+		"how long counter deltas are kept for rate calculation(0 - disabled)")
+	flag.StringVar(&Env.AlertRulesFile, "alert-rules", "", "filepath to json alert rules")
+	flag.DurationVar(&Env.AlertInterval, "alert-interval", 10*time.Second, "alert rules evaluation interval")
+	flag.StringVar(&Env.WebhookURLs, "webhook-urls", "", "comma separated webhook urls for notifications")
+	flag.StringVar(&Env.WebhookTemplate, "webhook-template", "", "filepath to webhook body template")
+	flag.StringVar(&Env.WebhookContentType, "webhook-content-type", "",
+		"content type of webhook body rendered by template(empty - application/json)")
+	flag.DurationVar(&Env.AgentSilentAfter, "agent-silent-after", 0,
+		"notify if agent sends no metrics longer than this(0 - disabled)")
+	flag.StringVar(&Env.TrustedSubnet, "t", "", "comma separated CIDR list, allowed to update metrics(empty - any)")
+	flag.StringVar(&Env.TrustedProxies, "trusted-proxies", "",
+		"comma separated CIDR list of proxies, allowed to set X-Real-IP(empty - header is ignored)")
+	flag.StringVar(&Env.TLSCertFp, "tls-cert", "", "filepath to tls certificate(empty - serve http)")
+	flag.StringVar(&Env.TLSKeyFp, "tls-key", "", "filepath to tls private key")
+	flag.StringVar(&Env.TLSClientCAFp, "tls-client-ca", "", "filepath to CA bundle to verify client certs(mTLS)")
+	flag.StringVar(&Env.AuthTokensFp, "auth-tokens", "", "filepath to json with api tokens(empty - auth disabled)")
+	flag.Float64Var(&Env.RateLimit, "rate-limit", 0, "requests per second per client ip(0 - unlimited)")
+	flag.IntVar(&Env.RateBurst, "rate-burst", 0, "rate limit burst(0 - rate limit rounded up)")
+	flag.Int64Var(&Env.MaxBodySize, "max-body-size", 10<<20, "max request body size in bytes(0 - unlimited)")
+	flag.IntVar(&Env.MaxBatchLength, "max-batch-length", 10000, "max metrics count in batch update(0 - unlimited)")
+	flag.DurationVar(&Env.SignatureSkew, "signature-skew", 5*time.Minute,
+		"allowed clock skew for signed update requests")
+	flag.BoolVar(&Env.RequireSignature, "require-signature", false,
+		"reject update requests without batch signature(only if key is set)")
+	flag.StringVar(&Env.StoreKeyFile, "store-key-file", "",
+		"filepath to AES key(hex or base64) to encrypt store file(empty - STORE_KEY env or no encryption)")
+	flag.BoolVar(&Env.SignResponses, "sign-responses", false,
+		"sign /value/ and /update/ responses with private key(requires crypto-key)")
+	flag.StringVar(&Env.MetricNameRegex, "metric-name-regex", validation.DefaultNameRegex,
+		"regex for metric names in update requests(empty - any name)")
+	flag.IntVar(&Env.MetricNameMaxLen, "metric-name-max-length", validation.DefaultMaxNameLength,
+		"max metric name length(0 - unlimited)")
+	flag.StringVar(&Env.AllowedTypes, "allowed-types", "", "comma separated allowed metric types(empty - any)")
+	flag.BoolVar(&Env.AllowNonFinite, "allow-non-finite", false, "accept NaN and Inf gauge values")
+	flag.Int64Var(&Env.MaxCounterDelta, "max-counter-delta", 0, "max absolute counter delta(0 - unlimited)")
+	flag.StringVar(&Env.DenyMetrics, "deny-metrics", "", "comma separated glob patterns of denied metric names")
+	flag.StringVar(&Env.RelabelRulesFile, "relabel-rules", "", "filepath to json relabel rules for updated metrics")
 }
 
 // ParseEnvArgs Парсит значения полей Env. Сначала из cmd аргументов, затем из перем-х окружения.
@@ -86,13 +173,36 @@ func ParseEnvArgs() {
 // Server реализует серверную логику.
 // Всё взаимодействие с серверной частью происходит через него.
 type Server struct {
-	FileStore     *filestore.FileStore
-	WriteTicker   *time.Ticker
-	Router        chi.Router
-	MetricStorage storage.MetricRepository
-	DBConn        *sql.DB
-	LayoutsDir    string
-	Decoder       *crypt.Decoder
+	FileStore        *filestore.FileStore
+	WriteTicker      *time.Ticker
+	Router           chi.Router
+	MetricStorage    storage.MetricRepository
+	DBConn           *sql.DB
+	LayoutsDir       string
+	Decoder          *crypt.KeyRing
+	TLSConfig        *tls.Config
+	Tokens           *auth.TokenStore
+	StaleTicker      *time.Ticker
+	CounterHistory   *counters.History
+	AlertEngine      *alerts.Engine
+	AlertTicker      *time.Ticker
+	NotifyQueue      *notify.Queue
+	AgentTicker      *time.Ticker
+	staleMetrics     map[string]bool
+	staleMutex       sync.RWMutex
+	counterConverter counters.CumulativeConverter
+	streamHub        stream.Hub
+	agentLastSeen    map[string]time.Time
+	agentsMutex      sync.Mutex
+	trustedSubnets   []*net.IPNet
+	trustedProxies   []*net.IPNet
+	rateLimiter      *ratelimit.Limiter
+	rejected         rejectedRequests
+	nonces           nonceCache
+	clientKeys       map[string]*registeredClientKey
+	clientKeysMutex  sync.RWMutex
+	validator        *validation.Policy
+	relabelRules     []relabel.Rule
 }
 
 // NewServer конструктор для Server.
@@ -100,7 +210,9 @@ type Server struct {
 // иначе хранит в памяти + запись в файл.
 func NewServer() (*Server, error) {
 	server := Server{}
-	server.initFileStore()
+	if err := server.initFileStore(); err != nil {
+		return nil, err
+	}
 	if Env.DatabaseDsn == "" {
 		server.initMetricStorage()
 		server.initRepeatableSave()
@@ -112,14 +224,56 @@ func NewServer() (*Server, error) {
 		server.MetricStorage = sqlStorage
 		server.DBConn = sqlStorage.Connection
 	}
+	server.initStaleSweeper()
+	server.CounterHistory = counters.NewHistory(Env.CounterHistory)
+	trustedSubnets, err := parseTrustedSubnets(Env.TrustedSubnet)
+	if err != nil {
+		return nil, err
+	}
+	server.trustedSubnets = trustedSubnets
+	trustedProxies, err := parseTrustedSubnets(Env.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	server.trustedProxies = trustedProxies
+	if err := server.initValidator(); err != nil {
+		return nil, err
+	}
+	if err := server.initRelabel(); err != nil {
+		return nil, err
+	}
+	if err := server.initNotifier(); err != nil {
+		return nil, err
+	}
+	if err := server.initAlerts(); err != nil {
+		return nil, err
+	}
+	server.initAgentWatcher()
 	server.Router = server.newRouter()
 
+	if err := server.initTLSConfig(); err != nil {
+		return nil, err
+	}
+	if Env.RateLimit > 0 {
+		server.rateLimiter = ratelimit.NewLimiter(Env.RateLimit, Env.RateBurst)
+	}
+	if Env.AuthTokensFp != "" {
+		tokens, err := auth.LoadTokens(Env.AuthTokensFp)
+		if err != nil {
+			return nil, err
+		}
+		server.Tokens = tokens
+	}
+
 	if Env.PrivateCryptoKeyFp != "" {
-		decoder, err := crypt.NewDecoder(Env.PrivateCryptoKeyFp)
+		keyRing, err := crypt.NewKeyRing(strings.Split(Env.PrivateCryptoKeyFp, ",")...)
 		if err != nil {
 			return nil, err
 		}
-		server.Decoder = decoder
+		server.Decoder = keyRing
+	}
+	if Env.SignResponses && server.Decoder == nil {
+		return nil, errors.New("sign responses requires private key(crypto-key)")
 	}
 
 	workingDir, _ := os.Getwd()
@@ -128,12 +282,69 @@ func NewServer() (*Server, error) {
 	return &server, nil
 }
 
+// initTLSConfig инициализирует tls конфигурацию сервера.
+// Выполняется только если задан сертификат, иначе сервер работает по http.
+func (s *Server) initTLSConfig() error {
+	if Env.TLSCertFp == "" {
+		return nil
+	}
+	tlsConfig, err := tlsconfig.NewServerConfig(Env.TLSCertFp, Env.TLSKeyFp, Env.TLSClientCAFp)
+	if err != nil {
+		return err
+	}
+	s.TLSConfig = tlsConfig
+	return nil
+}
+
+// initValidator инициализирует политику проверки метрик в запросах обновления(METRIC_NAME_REGEX и др.).
+func (s *Server) initValidator() error {
+	config := validation.Config{
+		NameRegex:       Env.MetricNameRegex,
+		MaxNameLength:   Env.MetricNameMaxLen,
+		AllowNonFinite:  Env.AllowNonFinite,
+		MaxCounterDelta: Env.MaxCounterDelta,
+	}
+	if Env.AllowedTypes != "" {
+		config.AllowedTypes = strings.Split(Env.AllowedTypes, ",")
+	}
+	if Env.DenyMetrics != "" {
+		config.DenyList = strings.Split(Env.DenyMetrics, ",")
+	}
+	validator, err := validation.NewPolicy(config)
+	if err != nil {
+		return err
+	}
+	s.validator = validator
+	return nil
+}
+
+// initRelabel загружает правила relabel метрик в запросах обновления.
+// Выполняется только если задан файл правил.
+func (s *Server) initRelabel() error {
+	if Env.RelabelRulesFile == "" {
+		return nil
+	}
+	rules, err := relabel.LoadRules(Env.RelabelRulesFile)
+	if err != nil {
+		return err
+	}
+	s.relabelRules = rules
+	return nil
+}
+
 // initFileStore инициализирует объект файл-хранилища метрик.
 // Иниц-ия происходит только если DatabaseDsn не определен, а путь к файлу - определен.
-func (s *Server) initFileStore() {
+// Если задан ключ(STORE_KEY или StoreKeyFile) - файл хранилища шифруется.
+func (s *Server) initFileStore() error {
 	if Env.DatabaseDsn == "" && Env.StoreFile != "" {
 		s.FileStore = filestore.NewFileStore(Env.StoreFile)
+		storeCipher, err := filestore.NewCipherByEnv(Env.StoreKey, Env.StoreKeyFile)
+		if err != nil {
+			return err
+		}
+		s.FileStore.Cipher = storeCipher
 	}
+	return nil
 }
 
 // initMetricStorage инициал-ет MetricStorage.
@@ -158,9 +369,9 @@ func (s *Server) initMetricStorage() {
 // Выполняется только при соблюдении условий.
 func (s *Server) initRepeatableSave() {
 	if Env.DatabaseDsn == "" && Env.StoreInterval > 0 && s.FileStore != nil {
+		s.WriteTicker = time.NewTicker(Env.StoreInterval)
 		go func() {
 			var err error
-			s.WriteTicker = time.NewTicker(Env.StoreInterval)
 			for range s.WriteTicker.C {
 				// нет смысла писать nil MetricStorage
 				if s.MetricStorage == nil {
@@ -186,25 +397,431 @@ func (s *Server) syncSaveMetricStorage() error {
 	return nil
 }
 
+// initStaleSweeper регулярно(раз в половину MetricTTL) проверяет метрики на устаревание.
+// Выполняется только если MetricTTL задан.
+func (s *Server) initStaleSweeper() {
+	if Env.MetricTTL > 0 {
+		s.StaleTicker = time.NewTicker(Env.MetricTTL / 2)
+		go func() {
+			for range s.StaleTicker.C {
+				if s.MetricStorage == nil {
+					continue
+				}
+
+				if err := s.sweepStaleMetrics(context.Background()); err != nil {
+					log.Println(err)
+				}
+			}
+		}()
+	}
+}
+
+// sweepStaleMetrics находит метрики, не обновлявшиеся дольше MetricTTL.
+// Если установлен DropStale - такие метрики удаляются из хранилища(проверка и удаление выполняются хранилищем
+// атомарно, см. DeleteStale), иначе помечаются как устаревшие.
+func (s *Server) sweepStaleMetrics(ctx context.Context) error {
+	staleBefore := time.Now().Add(-Env.MetricTTL)
+	if Env.DropStale {
+		deleted, err := s.MetricStorage.DeleteStale(ctx, staleBefore)
+		if err != nil {
+			return err
+		}
+		for _, name := range deleted {
+			log.Printf("stale metric '%s' was deleted", name)
+		}
+
+		s.staleMutex.Lock()
+		s.staleMetrics = map[string]bool{}
+		s.staleMutex.Unlock()
+
+		if len(deleted) > 0 {
+			return s.syncSaveMetricStorage()
+		}
+		return nil
+	}
+
+	updateTimes, err := s.MetricStorage.GetUpdateTimes(ctx)
+	if err != nil {
+		return err
+	}
+	staleMetrics := map[string]bool{}
+	for name, updateTime := range updateTimes {
+		if updateTime.Before(staleBefore) {
+			staleMetrics[name] = true
+		}
+	}
+
+	s.staleMutex.Lock()
+	s.staleMetrics = staleMetrics
+	s.staleMutex.Unlock()
+	return nil
+}
+
+// initAlerts загружает правила алертов и регулярно(параметр AlertInterval) проверяет их.
+// Выполняется только если задан файл правил.
+func (s *Server) initAlerts() error {
+	if Env.AlertRulesFile == "" {
+		return nil
+	}
+	rules, err := alerts.LoadRules(Env.AlertRulesFile)
+	if err != nil {
+		return err
+	}
+	s.AlertEngine = alerts.NewEngine(rules)
+
+	if Env.AlertInterval > 0 {
+		s.AlertTicker = time.NewTicker(Env.AlertInterval)
+		go func() {
+			for range s.AlertTicker.C {
+				if s.MetricStorage == nil {
+					continue
+				}
+
+				if err := s.evaluateAlerts(context.Background()); err != nil {
+					log.Println(err)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// evaluateAlerts проверяет правила алертов по текущим значениям метрик.
+func (s *Server) evaluateAlerts(ctx context.Context) error {
+	allMetrics, err := s.MetricStorage.GetAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var events []notify.Event
+	for _, alert := range s.AlertEngine.Evaluate(allMetrics, now) {
+		log.Printf("alert '%s' is %s: %s = %v", alert.Rule, alert.State, alert.Metric, alert.Value)
+		events = append(events, notify.NewAlertEvent(alert, now))
+	}
+	return s.NotifyQueue.Enqueue(events...)
+}
+
+// initNotifier инициализирует отправку уведомлений на webhook через очередь(отправка не блокирует проверки).
+// Выполняется только если заданы адреса webhook.
+func (s *Server) initNotifier() error {
+	if Env.WebhookURLs == "" {
+		return nil
+	}
+	notifier, err := notify.NewNotifier(strings.Split(Env.WebhookURLs, ","), Env.Key, Env.WebhookTemplate)
+	if err != nil {
+		return err
+	}
+	notifier.ContentType = Env.WebhookContentType
+	s.NotifyQueue = notify.NewQueue(notifier, notify.DefaultQueueSize)
+	return nil
+}
+
+// CloseNotifier прекращает прием уведомлений и ждет отправки уже поставленных в очередь.
+// Вызывается при остановке сервера.
+func (s *Server) CloseNotifier() {
+	s.NotifyQueue.Close()
+}
+
+// initAgentWatcher регулярно(раз в половину AgentSilentAfter) проверяет, что агенты присылают метрики.
+// Выполняется только если AgentSilentAfter задан.
+func (s *Server) initAgentWatcher() {
+	if Env.AgentSilentAfter > 0 {
+		s.AgentTicker = time.NewTicker(Env.AgentSilentAfter / 2)
+		go func() {
+			for range s.AgentTicker.C {
+				if err := s.checkSilentAgents(time.Now()); err != nil {
+					log.Println(err)
+				}
+			}
+		}()
+	}
+}
+
+// remoteIP возвращает адрес подключения отправителя запроса(без порта).
+func remoteIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// clientIP возвращает адрес отправителя запроса: X-Real-IP, если запрос пришел от доверенного прокси
+// (TRUSTED_PROXIES), иначе адрес подключения.
+func (s *Server) clientIP(request *http.Request) string {
+	host := remoteIP(request)
+	realIP := request.Header.Get("X-Real-IP")
+	if realIP == "" {
+		return host
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, proxy := range s.trustedProxies {
+			if proxy.Contains(ip) {
+				return realIP
+			}
+		}
+	}
+	return host
+}
+
+// parseTrustedSubnets разбирает список CIDR, разделенных запятой.
+func parseTrustedSubnets(cidrList string) ([]*net.IPNet, error) {
+	var subnets []*net.IPNet
+	for _, cidr := range strings.Split(cidrList, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// touchAgent запоминает время последнего обновления метрик агентом(см. requestAgent).
+func (s *Server) touchAgent(agent string, now time.Time) {
+	s.agentsMutex.Lock()
+	defer s.agentsMutex.Unlock()
+	if s.agentLastSeen == nil {
+		s.agentLastSeen = map[string]time.Time{}
+	}
+	s.agentLastSeen[agent] = now
+}
+
+// checkSilentAgents отправляет уведомление по агентам, не присылавшим метрики дольше AgentSilentAfter.
+// Агент, по которому отправлено уведомление, перестает отслеживаться до возобновления им отправки метрик,
+// поэтому уведомление отправляется один раз, а агенты, прекратившие работу, не накапливаются.
+func (s *Server) checkSilentAgents(now time.Time) error {
+	var events []notify.Event
+	s.agentsMutex.Lock()
+	for agent, lastSeen := range s.agentLastSeen {
+		if now.Sub(lastSeen) < Env.AgentSilentAfter {
+			continue
+		}
+		delete(s.agentLastSeen, agent)
+		log.Printf("agent '%s' is silent since %s", agent, lastSeen.Format(time.RFC3339))
+		events = append(events, notify.NewAgentSilentEvent(agent, lastSeen, now))
+	}
+	s.agentsMutex.Unlock()
+
+	return s.NotifyQueue.Enqueue(events...)
+}
+
+// isMetricStale возвращает true, если метрика была помечена как устаревшая.
+func (s *Server) isMetricStale(name string) bool {
+	s.staleMutex.RLock()
+	defer s.staleMutex.RUnlock()
+	return s.staleMetrics[name]
+}
+
+// unmarkStaleMetrics снимает отметку устаревания с обновленных метрик.
+func (s *Server) unmarkStaleMetrics(metrics ...storage.Metric) {
+	s.staleMutex.Lock()
+	defer s.staleMutex.Unlock()
+	for _, metric := range metrics {
+		delete(s.staleMetrics, metric.Name)
+	}
+}
+
+// beginCumulativeCounters начинает пересчет кумулятивных значений counter метрик в дельты, если запрос отправлен
+// в кумулятивном режиме(заголовок X-Counter-Mode: cumulative). В режиме дельт возвращает nil.
+// Значения пересчитываются по агенту(requestAgent), если метрики не записаны - откатываются(Rollback).
+func (s *Server) beginCumulativeCounters(request *http.Request) (*counters.CumulativeBatch, error) {
+	switch request.Header.Get(counterModeHeader) {
+	case "", deltaCounterMode:
+		return nil, nil
+	case cumulativeCounterMode:
+		return s.counterConverter.Begin(s.requestAgent(request)), nil
+	default:
+		return nil, fmt.Errorf("%w '%s'", errUnknownCounterMode, request.Header.Get(counterModeHeader))
+	}
+}
+
+// convertCumulativeCounters пересчитывает кумулятивные значения counter метрик в дельты в рамках cumulative
+// (nil - метрики не изменяются).
+func (s *Server) convertCumulativeCounters(cumulative *counters.CumulativeBatch, metrics []storage.Metric) error {
+	if cumulative == nil {
+		return nil
+	}
+
+	for i, metric := range metrics {
+		msg := metric.GetMessageMetric()
+		if msg.MType != internal.CounterTypeName {
+			continue
+		}
+		delta := cumulative.ToDelta(metric.Name, *msg.Delta)
+		converted, err := storage.NewMetric(metric.Name, internal.CounterTypeName, delta)
+		if err != nil {
+			return err
+		}
+		metrics[i] = *converted
+	}
+	return nil
+}
+
+// relabelMetric применяет к метрике правила relabelRules(метки __name__, type и instance - адрес агента).
+// Переименовывает метрику, если правила изменили __name__. Возвращает false, если метрика отброшена.
+func (s *Server) relabelMetric(request *http.Request, metric *storage.Metric) bool {
+	if len(s.relabelRules) == 0 {
+		return true
+	}
+	labels, keep := relabel.Relabel(s.relabelRules, map[string]string{
+		relabel.LabelName:     metric.Name,
+		relabel.LabelType:     metric.GetMessageMetric().MType,
+		relabel.LabelInstance: s.clientIP(request),
+	})
+	if !keep {
+		return false
+	}
+	metric.Name = labels[relabel.LabelName]
+	return true
+}
+
+// relabelMetrics применяет правила relabelRules к метрикам, возвращает не отброшенные правилами метрики.
+func (s *Server) relabelMetrics(request *http.Request, metrics []storage.Metric) []storage.Metric {
+	kept := metrics[:0]
+	for i := range metrics {
+		if s.relabelMetric(request, &metrics[i]) {
+			kept = append(kept, metrics[i])
+		}
+	}
+	return kept
+}
+
+// validateMetrics проверяет метрики политикой validator.
+// Возвращает индекс первой не прошедшей проверку метрики и ошибку(оборачивает validation.ErrInvalidMetric).
+func (s *Server) validateMetrics(metrics []storage.Metric) (int, error) {
+	for i, metric := range metrics {
+		if err := s.validator.Validate(metric.GetMessageMetric()); err != nil {
+			return i, err
+		}
+	}
+	return -1, nil
+}
+
+// checkMetricTypes сверяет типы значений метрик с сохраненными метриками и с предыдущими метриками набора
+// с тем же названием. Возвращает ошибки по индексам metrics(nil - тип совпадает, оборачивает
+// storage.ErrTypeMismatch) и ошибку хранилища.
+// Проверка предварительная: тип может измениться до обновления, окончательно его проверяет updateMatchingTypes.
+func (s *Server) checkMetricTypes(ctx context.Context, metrics []storage.Metric) ([]error, error) {
+	conflicts := make([]error, len(metrics))
+	known := map[string]storage.Metric{}
+	for i, metric := range metrics {
+		current, ok := known[metric.Name]
+		if !ok {
+			stored, err := s.MetricStorage.GetMetric(ctx, metric.Name)
+			if errors.Is(err, storage.ErrMetricNotFound) {
+				known[metric.Name] = metric
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			current = stored
+			known[metric.Name] = stored
+		}
+		if current.GetMessageMetric().MType != metric.GetMessageMetric().MType {
+			conflicts[i] = fmt.Errorf("metric '%s': current(%T) and new(%T) value %w",
+				metric.Name, current.Value, metric.Value, storage.ErrTypeMismatch)
+		}
+	}
+	return conflicts, nil
+}
+
+// updateMatchingTypes сохраняет метрики, тип значения которых совпадает с сохраненными метриками. Тип проверяется
+// хранилищем атомарно с обновлением(storage.BatchUpdateStrict), метрики другого типа пропускаются.
+// Возвращает ошибки по индексам metrics(nil - метрика сохранена) и ошибку хранилища.
+func (s *Server) updateMatchingTypes(ctx context.Context, metrics []storage.Metric) ([]error, error) {
+	conflicts := make([]error, len(metrics))
+	pending := append([]storage.Metric(nil), metrics...)
+	// indices индексы metrics для pending
+	indices := make([]int, len(metrics))
+	for i := range indices {
+		indices[i] = i
+	}
+	for len(pending) > 0 {
+		err := s.MetricStorage.BatchUpdateStrict(ctx, pending)
+		var mismatch *storage.TypeMismatchError
+		if !errors.As(err, &mismatch) {
+			return conflicts, err
+		}
+		conflicts[indices[mismatch.Index]] = err
+		pending = append(pending[:mismatch.Index], pending[mismatch.Index+1:]...)
+		indices = append(indices[:mismatch.Index], indices[mismatch.Index+1:]...)
+	}
+	return conflicts, nil
+}
+
+// appliedMetrics возвращает метрики, сохраненные updateMatchingTypes(без ошибки в conflicts).
+func appliedMetrics(metrics []storage.Metric, conflicts []error) []storage.Metric {
+	applied := make([]storage.Metric, 0, len(metrics))
+	for i, metric := range metrics {
+		if conflicts[i] == nil {
+			applied = append(applied, metric)
+		}
+	}
+	return applied
+}
+
+// afterMetricsUpdate выполняет действия, общие для всех хендлеров, после успешного обновления метрик.
+// Передаются только фактически сохраненные метрики.
+func (s *Server) afterMetricsUpdate(request *http.Request, metrics ...storage.Metric) {
+	s.unmarkStaleMetrics(metrics...)
+	s.touchAgent(s.requestAgent(request), time.Now())
+	s.publishMetricsUpdate(request.Context(), metrics...)
+
+	now := time.Now()
+	for _, metric := range metrics {
+		msg := metric.GetMessageMetric()
+		if msg.MType == internal.CounterTypeName {
+			s.CounterHistory.Add(metric.Name, *msg.Delta, now)
+		}
+	}
+}
+
 // newRouter определяет и возвращает роутер для сервера.
 func (s *Server) newRouter() chi.Router {
 	r := chi.NewRouter()
 
-	r.Use(s.gzipDecompressor)
+	r.Use(s.limitRate)
+	r.Use(s.decompressRequest)
+	r.Use(s.limitBodySize)
 	r.Use(s.gzipCompressor)
 	r.Use(s.decryptMessage)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
 	r.Route("/", func(r chi.Router) {
-		r.Get("/", s.handlerShowAllMetrics)
-		r.Get("/value/{typeName}/{metricName}", s.handlerGet)
 		r.Get("/ping", s.handlerPing)
-		r.Post("/updates/", s.handlerBatchUpdate)
-		r.Post("/update/{typeName}/{metricName}/{metricValue}", s.handlerAddUpdateMetric)
-		r.Post("/update/", s.handlerJSONAddUpdateMetric)
-		r.Post("/value/", s.handlerJSONGetMetric)
+		r.Group(func(r chi.Router) {
+			r.Use(s.requireScope(auth.ScopeRead))
+			r.Get("/", s.handlerShowAllMetrics)
+			r.With(s.secureResponse).Get("/value/{typeName}/{metricName}", s.handlerGet)
+			r.With(s.secureResponse).Post("/value/", s.handlerJSONGetMetric)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(s.checkTrustedSubnet)
+			r.Use(s.requireScope(auth.ScopeWrite))
+			r.Use(s.checkBatchSignature)
+			r.Post("/updates/", s.handlerBatchUpdate)
+			r.With(s.secureResponse).Post("/update/{typeName}/{metricName}/{metricValue}", s.handlerAddUpdateMetric)
+			r.With(s.secureResponse).Post("/update/", s.handlerJSONAddUpdateMetric)
+			r.Post("/keys/", s.handlerRegisterClientKey)
+		})
 	})
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(s.requireScope(auth.ScopeRead))
+		r.Get("/rate", s.handlerRate)
+		r.Get("/query", s.handlerQuery)
+		r.Get("/stream", s.handlerStream)
+		// алерты доступны с правом read, как и на главной странице
+		r.Get("/alerts", s.handlerAlerts)
+		r.With(s.requireScope(auth.ScopeAdmin)).Get("/rejected", s.handlerRejected)
+	})
+	r.Mount("/api/v2", s.newAPIv2Router())
 	return r
 }
 
@@ -218,17 +835,45 @@ func (w gzipResponseWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
-// gzipDecompressor - middleware для обработки входящих запросов с gzip сжатием.
-func (s *Server) gzipDecompressor(next http.Handler) http.Handler {
+// Flush сбрасывает сжатые данные клиенту(нужен для потоковых ответов).
+func (w gzipResponseWriter) Flush() {
+	if gzipWriter, ok := w.Writer.(*gzip.Writer); ok {
+		gzipWriter.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// decompressRequest - middleware для обработки входящих запросов со сжатием(Content-Encoding: gzip, zstd).
+// Если кодеков несколько - распаковывает в обратном порядке. Для неподдерживаемого кодека - 415.
+func (s *Server) decompressRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		if strings.Contains(request.Header.Get("Content-Encoding"), "gzip") {
-			gz, err := gzip.NewReader(request.Body)
+		var encodings []string
+		for _, encoding := range strings.Split(request.Header.Get("Content-Encoding"), ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding == "" || encoding == "identity" {
+				continue
+			}
+			if !compression.IsSupported(encoding) {
+				http.Error(writer, "unsupported content encoding '"+encoding+"'", http.StatusUnsupportedMediaType)
+				return
+			}
+			encodings = append(encodings, encoding)
+		}
+
+		for i := len(encodings) - 1; i >= 0; i-- {
+			reader, err := compression.NewReader(encodings[i], request.Body)
 			if err != nil {
 				http.Error(writer, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			request.Body = gz
-			defer gz.Close()
+			request.Body = reader
+			defer reader.Close()
+		}
+		if len(encodings) > 0 {
+			request.Header.Del("Content-Encoding")
+			request.ContentLength = -1
 		}
 		next.ServeHTTP(writer, request)
 	})
@@ -253,7 +898,21 @@ func (s *Server) gzipCompressor(next http.Handler) http.Handler {
 	})
 }
 
-// decryptMessage - middleware для расшифр. в асимм.шифровании
+// ReloadKeys перечитывает приватные ключи(CRYPTO_KEY), не прерывая обработку запросов.
+// При ошибке продолжают использоваться загруженные ранее ключи.
+func (s *Server) ReloadKeys() error {
+	if s.Decoder == nil {
+		return nil
+	}
+	if err := s.Decoder.Reload(); err != nil {
+		return err
+	}
+	log.Printf("private keys reloaded, key ids: %s", strings.Join(s.Decoder.KeyIDs(), ", "))
+	return nil
+}
+
+// decryptMessage - middleware для расшифр. в асимм.шифровании.
+// Ключ выбирается по заголовку X-Key-ID, если он не передан - перебираются все загруженные ключи.
 func (s *Server) decryptMessage(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		if s.Decoder != nil {
@@ -263,22 +922,80 @@ func (s *Server) decryptMessage(next http.Handler) http.Handler {
 				return
 			}
 
-			r, err := s.Decoder.Decode(body)
+			r, err := s.Decoder.Decode(request.Header.Get(crypt.KeyIDHeader), body)
+			// если ключ с переданным id не загружен - агент шифрует ключом, о котором сервер не знает
 			// если есть ошибка и это не ошибка расшифровки - выбросить http ошибку
 			// если ошибка расшифровки - ничего не делать(оставить изначальный request.Body)
-			if err != nil && !errors.Is(err, rsa.ErrDecryption) {
+			if errors.Is(err, crypt.ErrUnknownKeyID) {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil && !errors.Is(err, rsa.ErrDecryption) {
 				http.Error(writer, err.Error(), http.StatusInternalServerError)
 				return
 				// если ошибок нет - заменить reader на расшифр.сообщение
 			} else if err == nil {
 				reader := io.NopCloser(bytes.NewReader(r))
 				request.Body = reader
+			} else {
+				request.Body = io.NopCloser(bytes.NewReader(body))
 			}
 		}
 		next.ServeHTTP(writer, request)
 	})
 }
 
+// checkTrustedSubnet - middleware, пропускающий запросы только из доверенных подсетей(TRUSTED_SUBNET).
+// Адрес отправителя берется из X-Real-IP доверенного прокси(TRUSTED_PROXIES), иначе - адрес подключения.
+// Если подсети не заданы - пропускает все.
+func (s *Server) checkTrustedSubnet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if len(s.trustedSubnets) == 0 {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		ip := net.ParseIP(s.clientIP(request))
+		for _, subnet := range s.trustedSubnets {
+			if ip != nil && subnet.Contains(ip) {
+				next.ServeHTTP(writer, request)
+				return
+			}
+		}
+		http.Error(writer, "ip is not in trusted subnet", http.StatusForbidden)
+	})
+}
+
+// requireScope - middleware, пропускающий только запросы с bearer токеном, у которого есть право scope.
+// Без токена или с неизвестным токеном - 401, без нужного права - 403. Если токены не заданы - пропускает все.
+func (s *Server) requireScope(scope auth.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if s.Tokens == nil {
+				next.ServeHTTP(writer, request)
+				return
+			}
+
+			bearerToken, err := auth.BearerToken(request)
+			if err != nil {
+				writer.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(writer, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			token, err := s.Tokens.Authenticate(bearerToken)
+			if err != nil {
+				writer.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(writer, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if !token.HasScope(scope) {
+				http.Error(writer, fmt.Sprintf("token has no '%s' scope", scope), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(writer, request.WithContext(auth.WithToken(request.Context(), token)))
+		})
+	}
+}
+
 // Handlers
 
 //	@Title			Server Devops API
@@ -296,6 +1013,11 @@ func (s *Server) decryptMessage(next http.Handler) http.Handler {
 //	@Tag.name			NoJSON
 //	@Tag.description	"Группа запросов не использующих JSON."
 
+//	@SecurityDefinitions.apikey	BearerAuth
+//	@In							header
+//	@Name						Authorization
+//	@Description				"Bearer <token>", проверяется только если на сервере заданы токены(AUTH_TOKENS).
+
 // handlerShowAllMetrics godoc
 //
 //	@Tags		NoJSON
@@ -304,6 +1026,7 @@ func (s *Server) decryptMessage(next http.Handler) http.Handler {
 //	@Produce	html
 //	@Success	200	{string}	string	"ok"
 //	@Failure	500	{string}	string	"Внутренняя ошибка"
+//	@Security	BearerAuth
 //	@Router		/ [get]
 func (s *Server) handlerShowAllMetrics(writer http.ResponseWriter, request *http.Request) {
 	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -324,11 +1047,18 @@ func (s *Server) handlerShowAllMetrics(writer http.ResponseWriter, request *http
 		return
 	}
 
+	staleMetrics := map[string]bool{}
+	for name := range allMetrics {
+		staleMetrics[name] = s.isMetricStale(name)
+	}
+
 	err = tmpl.Execute(writer,
 		struct {
-			Metrics   map[string]storage.Metric
-			PageTitle string
-		}{PageTitle: "Metrics", Metrics: allMetrics},
+			Metrics      map[string]storage.Metric
+			StaleMetrics map[string]bool
+			Alerts       []alerts.Alert
+			PageTitle    string
+		}{PageTitle: "Metrics", Metrics: allMetrics, StaleMetrics: staleMetrics, Alerts: s.AlertEngine.Alerts()},
 	)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
@@ -348,6 +1078,7 @@ func (s *Server) handlerShowAllMetrics(writer http.ResponseWriter, request *http
 //	@Success		200			{string}	string	"<Значение метрики>"
 //	@Failure		404			{string}	string	"unknown metric"
 //	@Failure		500			{string}	string	"Внутренняя ошибка"
+//	@Security		BearerAuth
 //	@Router			/value/{typeName}/{metricName} [get]
 func (s *Server) handlerGet(writer http.ResponseWriter, request *http.Request) {
 	metric, err := s.MetricStorage.GetMetric(request.Context(), chi.URLParam(request, "metricName"))
@@ -359,6 +1090,9 @@ func (s *Server) handlerGet(writer http.ResponseWriter, request *http.Request) {
 		}
 		return
 	}
+	if s.isMetricStale(metric.Name) {
+		writer.Header().Set("X-Metric-Stale", "true")
+	}
 	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	writer.Write([]byte(metric.GetValueString()))
 }
@@ -378,8 +1112,13 @@ func (s *Server) handlerGet(writer http.ResponseWriter, request *http.Request) {
 //	@Param			metricName	path		int		true	"Название метрики"
 //	@Param			metricValue	path		int		true	"Значение метрики"
 //	@Success		200			{string}	string	"ok"
+//	@Failure		400			{string}	string	"Подпись запроса(X-Batch-Signature) неверна, устарела или nonce уже использован"
+//	@Failure		403			{string}	string	"ip is not in trusted subnet"
+//	@Failure		413			{string}	string	"Слишком большое тело запроса"
+//	@Failure		429			{string}	string	"Превышена частота запросов, см. Retry-After"
 //	@Failure		404			{string}	string	"unknown metric"
 //	@Failure		500			{string}	string	"Внутренняя ошибка"
+//	@Security		BearerAuth
 //	@Router			/update/{typeName}/{metricName}/{metricValue} [get]
 func (s *Server) handlerAddUpdateMetric(writer http.ResponseWriter, request *http.Request) {
 	var err error
@@ -397,12 +1136,34 @@ func (s *Server) handlerAddUpdateMetric(writer http.ResponseWriter, request *htt
 		}
 		return
 	}
+	// метрика отброшена правилами relabel - не сохраняется
+	if !s.relabelMetric(request, m) {
+		return
+	}
+	metrics := []storage.Metric{*m}
+	cumulative, err := s.beginCumulativeCounters(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cumulative.Rollback()
+	if err = s.convertCumulativeCounters(cumulative, metrics); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err = s.validateMetrics(metrics); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	err = s.MetricStorage.UpdateOrAddMetric(request.Context(), *m)
+	// метрика другого типа не сохраняется(без ошибки в API v1), события по ней не публикуются
+	conflicts, err := s.updateMatchingTypes(request.Context(), metrics)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
+	cumulative.Commit()
+	s.afterMetricsUpdate(request, appliedMetrics(metrics, conflicts)...)
 	if err = s.syncSaveMetricStorage(); err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
@@ -416,25 +1177,31 @@ func (s *Server) handlerAddUpdateMetric(writer http.ResponseWriter, request *htt
 //	@Description	Метрика(наим-ие, тип и значение) передается через тело запроса, посредством message.Metrics.
 //
 // В ответ возвращает сохраненную на сервере метрику(после выполнения запроса).
+// Формат тела выбирается по Content-Type(JSON, protobuf или MessagePack), формат ответа - по Accept.
 //
 // Если метрика с таким именем не присутствует на сервере - добавляет ее, иначе обновляет существующую.
 //
 //	@ID				handlerJSONAddUpdateMetric
-//	@Accept			json
-//	@Produce		json
+//	@Accept			json,application/x-protobuf,application/msgpack
+//	@Produce		json,application/x-protobuf,application/msgpack
 //	@Success		200	{string}	string	"ok"
 //	@Failure		400	{string}	string	"Неверный запрос"
 //	@Failure		400	{string}	string	"hash is not correct"	если	полученный	хеш	не	совпал	с	созданным	на	сервере.
+//	@Failure		400	{string}	string	"Подпись запроса(X-Batch-Signature) неверна, устарела или nonce уже использован"
 //	@Failure		404	{string}	string	"unknown metric"
+//	@Failure		403	{string}	string	"ip is not in trusted subnet"
+//	@Failure		413	{string}	string	"Слишком большое тело запроса"
+//	@Failure		429	{string}	string	"Превышена частота запросов, см. Retry-After"
 //	@Failure		500	{string}	string	"Внутренняя ошибка"
 //	@Failure		501	{string}	string	"Not Implemented"	если	передан	нереализованный	на	сервере	тип	метрики.
+//	@Security		BearerAuth
 //	@Router			/update/ [post]
 func (s *Server) handlerJSONAddUpdateMetric(writer http.ResponseWriter, request *http.Request) {
 	var metricMessage message.Metrics
 	var metric *storage.Metric
 	var err error
 
-	if err = json.NewDecoder(request.Body).Decode(&metricMessage); err != nil {
+	if err = readMetricMessage(request, &metricMessage); err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -460,12 +1227,35 @@ func (s *Server) handlerJSONAddUpdateMetric(writer http.ResponseWriter, request
 		}
 		return
 	}
+	// метрика отброшена правилами relabel - не сохраняется, в ответ возвращается полученная метрика
+	if !s.relabelMetric(request, metric) {
+		writeMetricMessage(writer, request, metricMessage)
+		return
+	}
+	metrics := []storage.Metric{*metric}
+	cumulative, err := s.beginCumulativeCounters(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cumulative.Rollback()
+	if err = s.convertCumulativeCounters(cumulative, metrics); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err = s.validateMetrics(metrics); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	err = s.MetricStorage.UpdateOrAddMetric(request.Context(), *metric)
+	// метрика другого типа не сохраняется(без ошибки в API v1), события по ней не публикуются
+	conflicts, err := s.updateMatchingTypes(request.Context(), metrics)
 	if err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
+	cumulative.Commit()
+	s.afterMetricsUpdate(request, appliedMetrics(metrics, conflicts)...)
 	if err = s.syncSaveMetricStorage(); err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
@@ -487,13 +1277,7 @@ func (s *Server) handlerJSONAddUpdateMetric(writer http.ResponseWriter, request
 		}
 	}
 
-	msgJSON, err := json.Marshal(responseMsg)
-	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	writer.Header().Set("Content-Type", "application/json")
-	writer.Write(msgJSON)
+	writeMetricMessage(writer, request, responseMsg)
 }
 
 // handlerJSONGetMetric godoc
@@ -503,20 +1287,22 @@ func (s *Server) handlerJSONAddUpdateMetric(writer http.ResponseWriter, request
 //	@Description	Наименование треб-ой метрики передается через тело запроса, посредством message.Metrics.
 //
 // В ответ возвращает сохраненную на сервере метрику.
+// Формат тела выбирается по Content-Type(JSON, protobuf или MessagePack), формат ответа - по Accept.
 //
 //	@ID				handlerJSONGetMetric
-//	@Accept			json
-//	@Produce		json
+//	@Accept			json,application/x-protobuf,application/msgpack
+//	@Produce		json,application/x-protobuf,application/msgpack
 //	@Success		200	{string}	string	"ok"
 //	@Failure		400	{string}	string	"Неверный запрос"
 //	@Failure		404	{string}	string	"metric with name <metricname> not found"
 //	@Failure		500	{string}	string	"Внутренняя ошибка"
+//	@Security		BearerAuth
 //	@Router			/value/ [post]
 func (s *Server) handlerJSONGetMetric(writer http.ResponseWriter, request *http.Request) {
 	var metricMessage message.Metrics
 	var err error
 
-	if err = json.NewDecoder(request.Body).Decode(&metricMessage); err != nil {
+	if err = readMetricMessage(request, &metricMessage); err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
@@ -536,6 +1322,7 @@ func (s *Server) handlerJSONGetMetric(writer http.ResponseWriter, request *http.
 	}
 
 	responseMsg := metric.GetMessageMetric()
+	responseMsg.Stale = s.isMetricStale(metric.Name)
 	if Env.Key != "" {
 		err = responseMsg.InitHash(Env.Key)
 		if err != nil {
@@ -544,13 +1331,7 @@ func (s *Server) handlerJSONGetMetric(writer http.ResponseWriter, request *http.
 		}
 	}
 
-	msgJSON, err := json.Marshal(responseMsg)
-	if err != nil {
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	writer.Header().Set("Content-Type", "application/json")
-	writer.Write(msgJSON)
+	writeMetricMessage(writer, request, responseMsg)
 }
 
 // handlerPing godoc
@@ -582,24 +1363,50 @@ func (s *Server) handlerPing(writer http.ResponseWriter, request *http.Request)
 //
 // В ответ возвращает статус обработки запроса.
 // Не существующие на сервере метрики - будут добавлены, иначе обновлены.
+// Формат тела выбирается по Content-Type(JSON, protobuf или MessagePack).
+//
+// С partial=true применяются только корректные метрики, а в ответ возвращается JSON массив
+// message.BatchItemResult: статус, ошибка и сохраненное значение для каждой метрики запроса.
 //
 //	@ID				handlerBatchUpdate
-//	@Accept			json
-//	@Success		200	{string}	string	"ok"
+//	@Accept			json,application/x-protobuf,application/msgpack
+//	@Produce		json,application/x-protobuf,application/msgpack
+//	@Param			partial	query		bool	false	"Частичное применение набора(ответ - результат по каждой метрике)"
+//	@Success		200		{string}	string	"ok"
 //	@Failure		400	{string}	string	"Неверный запрос"
 //	@Failure		400	{string}	string	"hash is not correct"	если	полученный	хеш	не	совпал	с	созданным	на	сервере.
+//	@Failure		400	{string}	string	"Подпись запроса(X-Batch-Signature) неверна, устарела или nonce уже использован"
+//	@Failure		403	{string}	string	"ip is not in trusted subnet"
+//	@Failure		413	{string}	string	"Слишком большое тело запроса или батча"
+//	@Failure		429	{string}	string	"Превышена частота запросов, см. Retry-After"
 //	@Failure		500	{string}	string	"Внутренняя ошибка"
 //	@Failure		501	{string}	string	"Not Implemented"	если	передан	нереализованный	на	сервере	тип	метрики.
+//	@Security		BearerAuth
 //	@Router			/updates/ [post]
 func (s *Server) handlerBatchUpdate(writer http.ResponseWriter, request *http.Request) {
 	var metricMessagesBatch []message.Metrics
 	var metrics []storage.Metric
 	var err error
 
-	if err = json.NewDecoder(request.Body).Decode(&metricMessagesBatch); err != nil {
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if metricMessagesBatch, err = message.UnmarshalBatch(requestContentType(request), body); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if Env.MaxBatchLength > 0 && len(metricMessagesBatch) > Env.MaxBatchLength {
+		s.rejected.batchTooLong.Add(1)
+		http.Error(writer, fmt.Sprintf("batch length %d exceeds %d", len(metricMessagesBatch), Env.MaxBatchLength),
+			http.StatusRequestEntityTooLarge)
+		return
+	}
+	if partial, _ := strconv.ParseBool(request.URL.Query().Get("partial")); partial {
+		s.batchUpdatePartial(writer, request, metricMessagesBatch)
+		return
+	}
 
 	for _, metricMessage := range metricMessagesBatch {
 		if Env.Key != "" {
@@ -626,18 +1433,44 @@ func (s *Server) handlerBatchUpdate(writer http.ResponseWriter, request *http.Re
 		}
 		metrics = append(metrics, *m)
 	}
+	metrics = s.relabelMetrics(request, metrics)
+	cumulative, err := s.beginCumulativeCounters(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer cumulative.Rollback()
+	if err = s.convertCumulativeCounters(cumulative, metrics); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if _, err = s.validateMetrics(metrics); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	if err = s.MetricStorage.BatchUpdate(request.Context(), metrics); err != nil {
+	// метрики другого типа пропускаются без ошибки, события публикуются только по сохраненным
+	conflicts, err := s.updateMatchingTypes(request.Context(), metrics)
+	if err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	cumulative.Commit()
+	s.afterMetricsUpdate(request, appliedMetrics(metrics, conflicts)...)
 
 	if err = s.syncSaveMetricStorage(); err != nil {
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	writer.Header().Set("Content-Type", "application/json")
+	// возвращаю пустой набор, чтобы пройти автотест
+	contentType := responseContentType(request)
+	emptyBatch, err := message.MarshalBatch(contentType, nil)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", contentType)
 	writer.WriteHeader(http.StatusOK)
-	// возвращаю пустую структуру, чтобы пройти автотест
-	writer.Write([]byte("[]"))
+	writer.Write(emptyBatch)
 }
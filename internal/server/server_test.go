@@ -2,15 +2,17 @@ package server
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
+	"encoding/json"
 	"flag"
 	"github.com/firesworder/devopsmetrics/internal/crypt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -18,8 +20,16 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/alerts"
+	"github.com/firesworder/devopsmetrics/internal/auth"
+	"github.com/firesworder/devopsmetrics/internal/compression"
+	"github.com/firesworder/devopsmetrics/internal/counters"
 	"github.com/firesworder/devopsmetrics/internal/filestore"
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/notify"
 	"github.com/firesworder/devopsmetrics/internal/storage"
+	"github.com/firesworder/devopsmetrics/internal/tlsconfig"
+	"github.com/firesworder/devopsmetrics/internal/validation"
 )
 
 // Переменные для формирования состояния MemStorage
@@ -38,6 +48,13 @@ func init() {
 
 var testEnvVars = []string{
 	"ADDRESS", "STORE_FILE", "STORE_INTERVAL", "RESTORE", "KEY", "DATABASE_DSN", "CRYPTO_KEY", "CONFIG",
+	"METRIC_TTL", "DROP_STALE", "COUNTER_HISTORY", "ALERT_RULES", "ALERT_INTERVAL",
+	"WEBHOOK_URLS", "WEBHOOK_TEMPLATE", "AGENT_SILENT_AFTER", "TRUSTED_SUBNET", "TRUSTED_PROXIES",
+	"TLS_CERT", "TLS_KEY", "TLS_CLIENT_CA", "AUTH_TOKENS",
+	"RATE_LIMIT", "RATE_BURST", "MAX_BODY_SIZE", "MAX_BATCH_LENGTH", "SIGNATURE_SKEW", "REQUIRE_SIGNATURE",
+	"STORE_KEY", "STORE_KEY_FILE", "SIGN_RESPONSES",
+	"METRIC_NAME_REGEX", "METRIC_NAME_MAX_LENGTH", "ALLOWED_TYPES", "ALLOW_NON_FINITE", "MAX_COUNTER_DELTA",
+	"DENY_METRICS", "RELABEL_RULES",
 }
 
 func SaveOSVarsState(testEnvVars []string) map[string]string {
@@ -1216,12 +1233,41 @@ func TestServer_InitFileStore(t *testing.T) {
 			}
 			Env = environment{}
 			Env.StoreFile = tt.beforeInitSArgs.StoreFile
-			s.initFileStore()
+			require.NoError(t, s.initFileStore())
 			assert.Equal(t, tt.wantFSArg, s.FileStore)
 		})
 	}
 }
 
+func TestServer_initFileStoreEncryption(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+
+	tests := []struct {
+		name       string
+		storeKey   string
+		wantCipher bool
+		wantErr    bool
+	}{
+		{name: "Test 1. Store key is not set.", storeKey: "", wantCipher: false},
+		{name: "Test 2. Store key is set.", storeKey: "000102030405060708090a0b0c0d0e0f", wantCipher: true},
+		{name: "Test 3. Incorrect store key.", storeKey: "0001", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Env = environment{StoreFile: "some_file_path/file.json", StoreKey: tt.storeKey}
+			s := &Server{}
+			err := s.initFileStore()
+			require.Equal(t, tt.wantErr, err != nil)
+			if !tt.wantErr {
+				assert.Equal(t, tt.wantCipher, s.FileStore.Cipher != nil)
+			}
+		})
+	}
+}
+
 func TestServer_InitMetricStorage(t *testing.T) {
 	type serverArgs struct {
 		FileStore *filestore.FileStore
@@ -1330,10 +1376,17 @@ func TestParseEnvArgs(t *testing.T) {
 			cmdStr:  "file.exe",
 			envVars: map[string]string{},
 			wantEnv: environment{
-				ServerAddress: "localhost:8080",
-				StoreInterval: 300 * time.Second,
-				StoreFile:     "/tmp/devops-metrics-db.json",
-				Restore:       true,
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "localhost:8080",
+				StoreInterval:    300 * time.Second,
+				StoreFile:        "/tmp/devops-metrics-db.json",
+				Restore:          true,
 			},
 			wantPanic: false,
 		},
@@ -1342,10 +1395,17 @@ func TestParseEnvArgs(t *testing.T) {
 			cmdStr:  "file.exe -a=cmd.site -i=20s -f=somefile.json -r=false",
 			envVars: map[string]string{},
 			wantEnv: environment{
-				ServerAddress: "cmd.site",
-				StoreInterval: 20 * time.Second,
-				StoreFile:     "somefile.json",
-				Restore:       false,
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "cmd.site",
+				StoreInterval:    20 * time.Second,
+				StoreFile:        "somefile.json",
+				Restore:          false,
 			},
 			wantPanic: false,
 		},
@@ -1356,10 +1416,17 @@ func TestParseEnvArgs(t *testing.T) {
 				"ADDRESS": "env.site", "STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true",
 			},
 			wantEnv: environment{
-				ServerAddress: "env.site",
-				StoreInterval: 60 * time.Second,
-				StoreFile:     "env.json",
-				Restore:       true,
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "env.site",
+				StoreInterval:    60 * time.Second,
+				StoreFile:        "env.json",
+				Restore:          true,
 			},
 			wantPanic: false,
 		},
@@ -1370,10 +1437,17 @@ func TestParseEnvArgs(t *testing.T) {
 				"ADDRESS": "env.site", "STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true",
 			},
 			wantEnv: environment{
-				ServerAddress: "env.site",
-				StoreInterval: 60 * time.Second,
-				StoreFile:     "env.json",
-				Restore:       true,
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "env.site",
+				StoreInterval:    60 * time.Second,
+				StoreFile:        "env.json",
+				Restore:          true,
 			},
 			wantPanic: false,
 		},
@@ -1384,10 +1458,17 @@ func TestParseEnvArgs(t *testing.T) {
 				"ADDRESS": "env.site", "STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true",
 			},
 			wantEnv: environment{
-				ServerAddress: "env.site",
-				StoreInterval: 60 * time.Second,
-				StoreFile:     "env.json",
-				Restore:       true,
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "env.site",
+				StoreInterval:    60 * time.Second,
+				StoreFile:        "env.json",
+				Restore:          true,
 			},
 			wantPanic: false,
 		},
@@ -1398,10 +1479,17 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true",
 			},
 			wantEnv: environment{
-				ServerAddress: "cmd.site",
-				StoreInterval: 60 * time.Second,
-				StoreFile:     "env.json",
-				Restore:       true,
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "cmd.site",
+				StoreInterval:    60 * time.Second,
+				StoreFile:        "env.json",
+				Restore:          true,
 			},
 			wantPanic: false,
 		},
@@ -1412,11 +1500,18 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true",
 			},
 			wantEnv: environment{
-				ServerAddress: "cmd.site",
-				StoreInterval: 60 * time.Second,
-				StoreFile:     "env.json",
-				Restore:       true,
-				Key:           "ayayaka",
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "cmd.site",
+				StoreInterval:    60 * time.Second,
+				StoreFile:        "env.json",
+				Restore:          true,
+				Key:              "ayayaka",
 			},
 			wantPanic: false,
 		},
@@ -1427,11 +1522,18 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true", "KEY": "ayayaka",
 			},
 			wantEnv: environment{
-				ServerAddress: "cmd.site",
-				StoreInterval: 60 * time.Second,
-				StoreFile:     "env.json",
-				Restore:       true,
-				Key:           "ayayaka",
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "cmd.site",
+				StoreInterval:    60 * time.Second,
+				StoreFile:        "env.json",
+				Restore:          true,
+				Key:              "ayayaka",
 			},
 			wantPanic: false,
 		},
@@ -1442,11 +1544,18 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true",
 			},
 			wantEnv: environment{
-				ServerAddress: "cmd.site",
-				StoreInterval: 60 * time.Second,
-				StoreFile:     "env.json",
-				Restore:       true,
-				Key:           "",
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "cmd.site",
+				StoreInterval:    60 * time.Second,
+				StoreFile:        "env.json",
+				Restore:          true,
+				Key:              "",
 			},
 			wantPanic: false,
 		},
@@ -1458,11 +1567,18 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true",
 			},
 			wantEnv: environment{
-				ServerAddress: "cmd.site",
-				StoreInterval: 60 * time.Second,
-				StoreFile:     "env.json",
-				Restore:       true,
-				DatabaseDsn:   "localhost:5432",
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "cmd.site",
+				StoreInterval:    60 * time.Second,
+				StoreFile:        "env.json",
+				Restore:          true,
+				DatabaseDsn:      "localhost:5432",
 			},
 			wantPanic: false,
 		},
@@ -1473,11 +1589,18 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true", "DATABASE_DSN": "localhost:8080",
 			},
 			wantEnv: environment{
-				ServerAddress: "cmd.site",
-				StoreInterval: 60 * time.Second,
-				StoreFile:     "env.json",
-				Restore:       true,
-				DatabaseDsn:   "localhost:8080",
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "cmd.site",
+				StoreInterval:    60 * time.Second,
+				StoreFile:        "env.json",
+				Restore:          true,
+				DatabaseDsn:      "localhost:8080",
 			},
 			wantPanic: false,
 		},
@@ -1488,11 +1611,18 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true",
 			},
 			wantEnv: environment{
-				ServerAddress: "cmd.site",
-				StoreInterval: 60 * time.Second,
-				StoreFile:     "env.json",
-				Restore:       true,
-				DatabaseDsn:   "",
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "cmd.site",
+				StoreInterval:    60 * time.Second,
+				StoreFile:        "env.json",
+				Restore:          true,
+				DatabaseDsn:      "",
 			},
 			wantPanic: false,
 		},
@@ -1504,6 +1634,13 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true",
 			},
 			wantEnv: environment{
+				CounterHistory:     10 * time.Minute,
+				AlertInterval:      10 * time.Second,
+				MaxBodySize:        10 << 20,
+				MaxBatchLength:     10000,
+				SignatureSkew:      5 * time.Minute,
+				MetricNameRegex:    validation.DefaultNameRegex,
+				MetricNameMaxLen:   validation.DefaultMaxNameLength,
 				ServerAddress:      "cmd.site",
 				StoreInterval:      60 * time.Second,
 				StoreFile:          "env.json",
@@ -1521,6 +1658,13 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true",
 			},
 			wantEnv: environment{
+				CounterHistory:     10 * time.Minute,
+				AlertInterval:      10 * time.Second,
+				MaxBodySize:        10 << 20,
+				MaxBatchLength:     10000,
+				SignatureSkew:      5 * time.Minute,
+				MetricNameRegex:    validation.DefaultNameRegex,
+				MetricNameMaxLen:   validation.DefaultMaxNameLength,
 				ServerAddress:      "cmd.site",
 				StoreInterval:      60 * time.Second,
 				StoreFile:          "env.json",
@@ -1538,6 +1682,13 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_INTERVAL": "60s", "CONFIG": "env_config_test.json", "RESTORE": "true",
 			},
 			wantEnv: environment{
+				CounterHistory:     10 * time.Minute,
+				AlertInterval:      10 * time.Second,
+				MaxBodySize:        10 << 20,
+				MaxBatchLength:     10000,
+				SignatureSkew:      5 * time.Minute,
+				MetricNameRegex:    validation.DefaultNameRegex,
+				MetricNameMaxLen:   validation.DefaultMaxNameLength,
 				ServerAddress:      "cmd.site",
 				StoreInterval:      60 * time.Second,
 				StoreFile:          "/path/to/file.db",
@@ -1555,6 +1706,13 @@ func TestParseEnvArgs(t *testing.T) {
 				"STORE_FILE": "env.json", "STORE_INTERVAL": "60s", "RESTORE": "true", "CONFIG": "not_existed_config.json",
 			},
 			wantEnv: environment{
+				CounterHistory:     10 * time.Minute,
+				AlertInterval:      10 * time.Second,
+				MaxBodySize:        10 << 20,
+				MaxBatchLength:     10000,
+				SignatureSkew:      5 * time.Minute,
+				MetricNameRegex:    validation.DefaultNameRegex,
+				MetricNameMaxLen:   validation.DefaultMaxNameLength,
 				ServerAddress:      "cmd.site",
 				StoreInterval:      60 * time.Second,
 				StoreFile:          "env.json",
@@ -1565,6 +1723,118 @@ func TestParseEnvArgs(t *testing.T) {
 			},
 			wantPanic: true,
 		},
+		{
+			name:    "Test 17. Fields 'MetricTTL' and 'DropStale', set by cmd.",
+			cmdStr:  "file.exe -ttl=10m -drop-stale",
+			envVars: map[string]string{},
+			wantEnv: environment{
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "localhost:8080",
+				StoreInterval:    300 * time.Second,
+				StoreFile:        "/tmp/devops-metrics-db.json",
+				Restore:          true,
+				MetricTTL:        10 * time.Minute,
+				DropStale:        true,
+			},
+			wantPanic: false,
+		},
+		{
+			name:   "Test 18. Fields 'MetricTTL' and 'DropStale', set by env and cmd.",
+			cmdStr: "file.exe -ttl=10m -drop-stale",
+			envVars: map[string]string{
+				"METRIC_TTL": "1h", "DROP_STALE": "false",
+			},
+			wantEnv: environment{
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				ServerAddress:    "localhost:8080",
+				StoreInterval:    300 * time.Second,
+				StoreFile:        "/tmp/devops-metrics-db.json",
+				Restore:          true,
+				MetricTTL:        time.Hour,
+				DropStale:        false,
+			},
+			wantPanic: false,
+		},
+		{
+			name:   "Test 19. Fields 'AlertRulesFile' and 'AlertInterval', set by env and cmd.",
+			cmdStr: "file.exe -alert-rules=cmd_rules.json -alert-interval=1m",
+			envVars: map[string]string{
+				"ALERT_RULES": "env_rules.json",
+			},
+			wantEnv: environment{
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    time.Minute,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				AlertRulesFile:   "env_rules.json",
+				ServerAddress:    "localhost:8080",
+				StoreInterval:    300 * time.Second,
+				StoreFile:        "/tmp/devops-metrics-db.json",
+				Restore:          true,
+			},
+			wantPanic: false,
+		},
+		{
+			name:    "Test 20. Fields 'TrustedSubnet' and 'TrustedProxies', set by cmd and env.",
+			cmdStr:  "file.exe -t=192.168.1.0/24,10.0.0.0/8",
+			envVars: map[string]string{"TRUSTED_PROXIES": "127.0.0.1/32"},
+			wantEnv: environment{
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: validation.DefaultMaxNameLength,
+				TrustedSubnet:    "192.168.1.0/24,10.0.0.0/8",
+				TrustedProxies:   "127.0.0.1/32",
+				ServerAddress:    "localhost:8080",
+				StoreInterval:    300 * time.Second,
+				StoreFile:        "/tmp/devops-metrics-db.json",
+				Restore:          true,
+			},
+			wantPanic: false,
+		},
+		{
+			name: "Test 21. Validation policy and relabel fields, set by cmd and env.",
+			cmdStr: "file.exe -allowed-types=gauge -max-counter-delta=1000 -metric-name-max-length=64 " +
+				"-relabel-rules=relabel.json",
+			envVars: map[string]string{"DENY_METRICS": "Debug*,Test*", "ALLOW_NON_FINITE": "true"},
+			wantEnv: environment{
+				CounterHistory:   10 * time.Minute,
+				AlertInterval:    10 * time.Second,
+				MaxBodySize:      10 << 20,
+				MaxBatchLength:   10000,
+				SignatureSkew:    5 * time.Minute,
+				MetricNameRegex:  validation.DefaultNameRegex,
+				MetricNameMaxLen: 64,
+				AllowedTypes:     "gauge",
+				AllowNonFinite:   true,
+				MaxCounterDelta:  1000,
+				DenyMetrics:      "Debug*,Test*",
+				RelabelRulesFile: "relabel.json",
+				ServerAddress:    "localhost:8080",
+				StoreInterval:    300 * time.Second,
+				StoreFile:        "/tmp/devops-metrics-db.json",
+				Restore:          true,
+			},
+			wantPanic: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1614,15 +1884,13 @@ func sendTestRequestWithCompression(t *testing.T, ts *httptest.Server, r request
 	if r.reqEncoding == "" {
 		req, err = http.NewRequest(r.method, ts.URL+r.url, strings.NewReader(r.body))
 	} else {
-		// Compression
-		var b bytes.Buffer
-		w := gzip.NewWriter(&b)
-		// запись данных
-		_, err = w.Write([]byte(r.body))
-		require.NoError(t, err)
-		err = w.Close()
-		require.NoError(t, err)
-		req, err = http.NewRequest(r.method, ts.URL+r.url, &b)
+		// Compression, неподдерживаемый кодек - тело отправляется без сжатия
+		body := []byte(r.body)
+		if compression.IsSupported(r.reqEncoding) {
+			body, err = compression.Compress(r.reqEncoding, body)
+			require.NoError(t, err)
+		}
+		req, err = http.NewRequest(r.method, ts.URL+r.url, bytes.NewReader(body))
 		req.Header.Set("Content-Encoding", r.reqEncoding)
 	}
 
@@ -1697,7 +1965,7 @@ func TestServer_gzipCompressor(t *testing.T) {
 	}
 }
 
-func TestServer_gzipDecompressor(t *testing.T) {
+func TestServer_decompressRequest(t *testing.T) {
 	s := Server{}
 	ts := httptest.NewServer(s.newRouter())
 	defer ts.Close()
@@ -1730,6 +1998,69 @@ func TestServer_gzipDecompressor(t *testing.T) {
 			initState:   map[string]storage.Metric{},
 			wantedState: map[string]storage.Metric{metric1.Name: *metric1},
 		},
+		{
+			name: "Test 2. Zstd request for 'AddUpdateMetricJSONHandler'",
+			requestArgsWC: requestArgsWC{
+				requestArgs: requestArgs{
+					method:      http.MethodPost,
+					url:         "/update/",
+					contentType: "application/json",
+					body:        `{"id":"PollCount","type":"counter","delta":10}`,
+				},
+				reqEncoding: "zstd",
+			},
+			wantResponse: responseWC{
+				response: response{
+					statusCode:  http.StatusOK,
+					contentType: "application/json",
+					body:        `{"id":"PollCount","type":"counter","delta":10}`,
+				},
+			},
+			initState:   map[string]storage.Metric{},
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name: "Test 3. Unsupported encoding.",
+			requestArgsWC: requestArgsWC{
+				requestArgs: requestArgs{
+					method:      http.MethodPost,
+					url:         "/update/",
+					contentType: "application/json",
+					body:        `{"id":"PollCount","type":"counter","delta":10}`,
+				},
+				reqEncoding: "br",
+			},
+			wantResponse: responseWC{
+				response: response{
+					statusCode:  http.StatusUnsupportedMediaType,
+					contentType: "text/plain; charset=utf-8",
+					body:        "unsupported content encoding 'br'\n",
+				},
+			},
+			initState:   map[string]storage.Metric{},
+			wantedState: map[string]storage.Metric{},
+		},
+		{
+			name: "Test 4. Identity encoding.",
+			requestArgsWC: requestArgsWC{
+				requestArgs: requestArgs{
+					method:      http.MethodPost,
+					url:         "/update/",
+					contentType: "application/json",
+					body:        `{"id":"PollCount","type":"counter","delta":10}`,
+				},
+				reqEncoding: "identity",
+			},
+			wantResponse: responseWC{
+				response: response{
+					statusCode:  http.StatusOK,
+					contentType: "application/json",
+					body:        `{"id":"PollCount","type":"counter","delta":10}`,
+				},
+			},
+			initState:   map[string]storage.Metric{},
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1942,7 +2273,7 @@ func TestServer_decryptMessage(t *testing.T) {
 			// если указан приватный ключ - тестировать с шифрованием
 			if tt.privateKeyName != "" {
 				tt.req.body = string(encMsg)
-				s.Decoder, err = crypt.NewDecoder(testKeysDir + tt.privateKeyName)
+				s.Decoder, err = crypt.NewKeyRing(testKeysDir + tt.privateKeyName)
 				require.NoError(t, err)
 			} else {
 				s.Decoder = nil
@@ -1961,6 +2292,665 @@ func TestServer_decryptMessage(t *testing.T) {
 	}
 }
 
+func TestServer_decryptMessageWithKeyID(t *testing.T) {
+	testKeysDir := "../crypt/test/"
+	testBody := `{"id":"RandomValue","type":"gauge","value":12.13}`
+
+	encoder1, err := crypt.NewEncoder(testKeysDir + "publicKey_1_test.pem")
+	require.NoError(t, err)
+	encoder2, err := crypt.NewEncoder(testKeysDir + "publicKey_2_test.pem")
+	require.NoError(t, err)
+	encMsg1, err := encoder1.Encode([]byte(testBody))
+	require.NoError(t, err)
+	encMsg2, err := encoder2.Encode([]byte(testBody))
+	require.NoError(t, err)
+
+	// сервер знает оба ключа(ротация: старые агенты шифруют ключом 1, новые - ключом 2)
+	keyRing, err := crypt.NewKeyRing(testKeysDir)
+	require.NoError(t, err)
+	s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{}), Decoder: keyRing}
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+
+	tests := []struct {
+		name           string
+		body           []byte
+		keyID          string
+		wantStatusCode int
+	}{
+		{name: "Test 1. Key 1, key id is set.", body: encMsg1, keyID: encoder1.KeyID(), wantStatusCode: http.StatusOK},
+		{name: "Test 2. Key 2, key id is set.", body: encMsg2, keyID: encoder2.KeyID(), wantStatusCode: http.StatusOK},
+		{name: "Test 3. Key 2, key id is not set.", body: encMsg2, keyID: "", wantStatusCode: http.StatusOK},
+		{
+			name: "Test 4. Unknown key id.", body: encMsg1, keyID: "0000000000000000",
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.keyID != "" {
+				headers[crypt.KeyIDHeader] = tt.keyID
+			}
+			statusCode, _, body := sendTestRequestWithHeaders(t, ts,
+				requestArgs{method: http.MethodPost, url: "/update/", body: string(tt.body)}, headers)
+			require.Equal(t, tt.wantStatusCode, statusCode)
+			if statusCode == http.StatusOK {
+				assert.Equal(t, testBody, body)
+			}
+		})
+	}
+}
+
+func TestServer_ReloadKeys(t *testing.T) {
+	testKeysDir := "../crypt/test/"
+	keysDir := t.TempDir()
+	copyKey := func(name string) {
+		content, err := os.ReadFile(testKeysDir + name)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(keysDir, name), content, 0600))
+	}
+
+	// без ключей перезагрузка ничего не делает
+	s := &Server{}
+	assert.NoError(t, s.ReloadKeys())
+
+	copyKey("privateKey_1_test.pem")
+	keyRing, err := crypt.NewKeyRing(keysDir)
+	require.NoError(t, err)
+	s.Decoder = keyRing
+	assert.Len(t, s.Decoder.KeyIDs(), 1)
+
+	copyKey("privateKey_2_test.pem")
+	require.NoError(t, s.ReloadKeys())
+	assert.Len(t, s.Decoder.KeyIDs(), 2)
+}
+
+func TestServer_sweepStaleMetrics(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+
+	tests := []struct {
+		name          string
+		dropStale     bool
+		wantState     map[string]storage.Metric
+		wantStale     map[string]bool
+		wantGetStatus int
+		wantGetStale  string
+	}{
+		{
+			name:      "Test 1. DropStale=false, stale metrics are marked.",
+			dropStale: false,
+			wantState: map[string]storage.Metric{
+				metric1.Name: *metric1,
+				metric2.Name: *metric2,
+			},
+			wantStale:     map[string]bool{metric1.Name: true},
+			wantGetStatus: http.StatusOK,
+			wantGetStale:  "true",
+		},
+		{
+			name:      "Test 2. DropStale=true, stale metrics are deleted.",
+			dropStale: true,
+			wantState: map[string]storage.Metric{
+				metric2.Name: *metric2,
+			},
+			wantStale:     map[string]bool{},
+			wantGetStatus: http.StatusNotFound,
+			wantGetStale:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			Env = environment{MetricTTL: 50 * time.Millisecond, DropStale: tt.dropStale}
+			s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{})}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			require.NoError(t, s.MetricStorage.UpdateOrAddMetric(ctx, *metric1))
+			time.Sleep(100 * time.Millisecond)
+			require.NoError(t, s.MetricStorage.UpdateOrAddMetric(ctx, *metric2))
+
+			err := s.sweepStaleMetrics(ctx)
+			require.NoError(t, err)
+			compareMetricsState(t, tt.wantState, s.MetricStorage, ctx)
+			assert.Equal(t, tt.wantStale, s.staleMetrics)
+
+			resp, err := http.Get(ts.URL + "/value/counter/" + metric1.Name)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, tt.wantGetStatus, resp.StatusCode)
+			assert.Equal(t, tt.wantGetStale, resp.Header.Get("X-Metric-Stale"))
+
+			statusCode, _, body := sendTestRequest(t, ts, requestArgs{
+				method:      http.MethodPost,
+				url:         "/value/",
+				contentType: "application/json",
+				body:        `{"id":"PollCount","type":"counter"}`,
+			})
+			if tt.wantGetStatus == http.StatusOK {
+				assert.Equal(t, http.StatusOK, statusCode)
+				assert.JSONEq(t, `{"id":"PollCount","type":"counter","delta":10,"stale":true}`, body)
+			}
+
+			// обновление метрики снимает отметку устаревания
+			statusCode, _, _ = sendTestRequest(t, ts, requestArgs{
+				method: http.MethodPost,
+				url:    "/update/counter/PollCount/10",
+			})
+			require.Equal(t, http.StatusOK, statusCode)
+			assert.False(t, s.isMetricStale(metric1.Name))
+		})
+	}
+}
+
+// webhookReceiver тестовый приемник webhook уведомлений, сохраняет тела полученных запросов.
+// Возвращает функцию получения копии полученных событий.
+func webhookReceiver(t *testing.T) (*httptest.Server, func() []notify.Event) {
+	var events []notify.Event
+	var mutex sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		event := notify.Event{}
+		assert.NoError(t, json.NewDecoder(request.Body).Decode(&event))
+		mutex.Lock()
+		defer mutex.Unlock()
+		events = append(events, event)
+	}))
+	return ts, func() []notify.Event {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]notify.Event{}, events...)
+	}
+}
+
+// waitEvents ждет, пока приемник получит count событий, и возвращает их.
+func waitEvents(t *testing.T, events func() []notify.Event, count int) []notify.Event {
+	require.Eventually(t, func() bool {
+		return len(events()) >= count
+	}, time.Second, 10*time.Millisecond)
+	got := events()
+	require.Len(t, got, count)
+	return got
+}
+
+func TestServer_checkSilentAgents(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+	Env = environment{AgentSilentAfter: time.Minute, Key: "Ayayaka", SignatureSkew: time.Minute}
+
+	receiver, events := webhookReceiver(t)
+	defer receiver.Close()
+	notifier, err := notify.NewNotifier([]string{receiver.URL}, "", "")
+	require.NoError(t, err)
+	trustedProxies, err := parseTrustedSubnets("127.0.0.0/8")
+	require.NoError(t, err)
+	s := &Server{
+		MetricStorage:  storage.NewMemStorage(map[string]storage.Metric{}),
+		NotifyQueue:    notify.NewQueue(notifier, notify.DefaultQueueSize),
+		trustedProxies: trustedProxies,
+	}
+	defer s.CloseNotifier()
+	ts := httptest.NewServer(s.newRouter())
+	defer ts.Close()
+
+	update := requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"}
+	// signedHeaders заголовки запроса update, подписанного агентом agentID, с адресом отправителя 10.0.0.1
+	signedHeaders := func(agentID string) map[string]string {
+		header := http.Header{"Content-Type": {"application/json"}}
+		signature, err := message.NewBatchSignature(Env.Key, update.method, update.url, header, nil, agentID, time.Now())
+		require.NoError(t, err)
+		signature.SetHeader(header)
+		headers := map[string]string{"X-Real-IP": "10.0.0.1"}
+		for name := range header {
+			headers[name] = header.Get(name)
+		}
+		return headers
+	}
+
+	// агенты за одним адресом различаются по id из подписи, неподписанный запрос - по адресу
+	statusCode, _, _ := sendTestRequestWithHeaders(t, ts, update, signedHeaders("agent1"))
+	require.Equal(t, http.StatusOK, statusCode)
+	statusCode, _, _ = sendTestRequestWithHeaders(t, ts, update, signedHeaders("agent2"))
+	require.Equal(t, http.StatusOK, statusCode)
+	statusCode, _, _ = sendTestRequest(t, ts, update)
+	require.Equal(t, http.StatusOK, statusCode)
+
+	now := time.Now()
+	// агенты еще не "замолчали"
+	require.NoError(t, s.checkSilentAgents(now))
+	assert.Len(t, events(), 0)
+
+	// все агенты "замолчали", уведомление по каждому отправляется один раз, после чего агент не отслеживается
+	require.NoError(t, s.checkSilentAgents(now.Add(2*time.Minute)))
+	require.NoError(t, s.checkSilentAgents(now.Add(3*time.Minute)))
+	gotEvents := waitEvents(t, events, 3)
+	gotAgents := []string{gotEvents[0].Agent, gotEvents[1].Agent, gotEvents[2].Agent}
+	assert.ElementsMatch(t, []string{"agent1", "agent2", "127.0.0.1"}, gotAgents)
+	for _, event := range gotEvents {
+		assert.Equal(t, notify.EventAgentSilent, event.Type)
+	}
+	s.agentsMutex.Lock()
+	assert.Empty(t, s.agentLastSeen)
+	s.agentsMutex.Unlock()
+
+	// после возобновления отправки метрик агент снова отслеживается
+	statusCode, _, _ = sendTestRequestWithHeaders(t, ts, update, signedHeaders("agent1"))
+	require.Equal(t, http.StatusOK, statusCode)
+	require.NoError(t, s.checkSilentAgents(time.Now().Add(2*time.Minute)))
+	gotEvents = waitEvents(t, events, 4)
+	assert.Equal(t, "agent1", gotEvents[3].Agent)
+}
+
+func TestServer_evaluateAlerts(t *testing.T) {
+	receiver, events := webhookReceiver(t)
+	defer receiver.Close()
+	notifier, err := notify.NewNotifier([]string{receiver.URL}, "", "")
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s := &Server{
+		MetricStorage: storage.NewMemStorage(map[string]storage.Metric{metric2.Name: *metric2}),
+		AlertEngine: alerts.NewEngine([]alerts.Rule{
+			{Name: "HighRandomValue", Metric: "RandomValue", Op: alerts.OpGreater, Threshold: 10},
+		}),
+		NotifyQueue: notify.NewQueue(notifier, notify.DefaultQueueSize),
+	}
+
+	require.NoError(t, s.evaluateAlerts(ctx))
+	// повторная проверка без изменения состояния не отправляет уведомлений
+	require.NoError(t, s.evaluateAlerts(ctx))
+	lowRandomValue, err := storage.NewMetric(metric2.Name, internal.GaugeTypeName, 1.5)
+	require.NoError(t, err)
+	require.NoError(t, s.MetricStorage.UpdateMetric(ctx, *lowRandomValue))
+	require.NoError(t, s.evaluateAlerts(ctx))
+	// уведомления отправляются в фоне, Close ждет отправки поставленных в очередь
+	s.CloseNotifier()
+
+	gotEvents := events()
+	require.Len(t, gotEvents, 2)
+	assert.Equal(t, notify.EventAlertFiring, gotEvents[0].Type)
+	assert.Equal(t, notify.EventAlertResolved, gotEvents[1].Type)
+	assert.Equal(t, "HighRandomValue", gotEvents[1].Alert.Rule)
+	assert.Equal(t, 1.5, gotEvents[1].Alert.Value)
+}
+
+func Test_parseTrustedSubnets(t *testing.T) {
+	tests := []struct {
+		name        string
+		cidrList    string
+		wantSubnets []string
+		wantErr     bool
+	}{
+		{name: "Test 1. Empty list.", cidrList: "", wantSubnets: nil},
+		{name: "Test 2. One subnet.", cidrList: "192.168.1.0/24", wantSubnets: []string{"192.168.1.0/24"}},
+		{
+			name:        "Test 3. Several subnets with spaces.",
+			cidrList:    "10.0.0.0/8, 127.0.0.1/32,",
+			wantSubnets: []string{"10.0.0.0/8", "127.0.0.1/32"},
+		},
+		{name: "Test 4. Incorrect CIDR.", cidrList: "10.0.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subnets, err := parseTrustedSubnets(tt.cidrList)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			var gotSubnets []string
+			for _, subnet := range subnets {
+				gotSubnets = append(gotSubnets, subnet.String())
+			}
+			assert.Equal(t, tt.wantSubnets, gotSubnets)
+		})
+	}
+}
+
+func TestServer_checkTrustedSubnet(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedSubnets string
+		trustedProxies string
+		realIP         string
+		request        requestArgs
+		wantStatusCode int
+	}{
+		{
+			name:           "Test 1. Trusted subnet is not set.",
+			trustedSubnets: "",
+			realIP:         "10.0.0.1",
+			request:        requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Test 2. X-Real-IP in trusted subnet.",
+			trustedSubnets: "192.168.1.0/24",
+			trustedProxies: "127.0.0.0/8",
+			realIP:         "192.168.1.15",
+			request:        requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Test 3. X-Real-IP not in trusted subnet, url handler.",
+			trustedSubnets: "192.168.1.0/24",
+			trustedProxies: "127.0.0.0/8",
+			realIP:         "10.0.0.1",
+			request:        requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Test 4. X-Real-IP not in trusted subnet, json handler.",
+			trustedSubnets: "192.168.1.0/24",
+			trustedProxies: "127.0.0.0/8",
+			realIP:         "10.0.0.1",
+			request: requestArgs{
+				method: http.MethodPost, url: "/update/", body: `{"id":"PollCount","type":"counter","delta":10}`,
+			},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Test 5. X-Real-IP not in trusted subnet, batch handler.",
+			trustedSubnets: "192.168.1.0/24",
+			trustedProxies: "127.0.0.0/8",
+			realIP:         "10.0.0.1",
+			request: requestArgs{
+				method: http.MethodPost, url: "/updates/", body: `[{"id":"PollCount","type":"counter","delta":10}]`,
+			},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Test 6. X-Real-IP is not set, remote address is checked.",
+			trustedSubnets: "192.168.1.0/24",
+			realIP:         "",
+			request:        requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Test 7. Remote address in trusted subnet.",
+			trustedSubnets: "192.168.1.0/24,127.0.0.0/8",
+			realIP:         "",
+			request:        requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Test 8. Incorrect X-Real-IP.",
+			trustedSubnets: "192.168.1.0/24",
+			trustedProxies: "127.0.0.0/8",
+			realIP:         "not-an-ip",
+			request:        requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Test 9. X-Real-IP from untrusted proxy is ignored.",
+			trustedSubnets: "192.168.1.0/24",
+			realIP:         "192.168.1.15",
+			request:        requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Test 10. Remote address in trusted subnet, X-Real-IP from untrusted proxy is ignored.",
+			trustedSubnets: "127.0.0.0/8",
+			realIP:         "10.0.0.1",
+			request:        requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Test 11. Read routes are not checked.",
+			trustedSubnets: "192.168.1.0/24",
+			realIP:         "10.0.0.1",
+			request:        requestArgs{method: http.MethodGet, url: "/api/v1/query"},
+			wantStatusCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trustedSubnets, err := parseTrustedSubnets(tt.trustedSubnets)
+			require.NoError(t, err)
+			trustedProxies, err := parseTrustedSubnets(tt.trustedProxies)
+			require.NoError(t, err)
+			s := &Server{
+				MetricStorage:  storage.NewMemStorage(map[string]storage.Metric{}),
+				trustedSubnets: trustedSubnets,
+				trustedProxies: trustedProxies,
+			}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			headers := map[string]string{}
+			if tt.realIP != "" {
+				headers["X-Real-IP"] = tt.realIP
+			}
+			statusCode, _, _ := sendTestRequestWithHeaders(t, ts, tt.request, headers)
+			assert.Equal(t, tt.wantStatusCode, statusCode)
+		})
+	}
+}
+
+func TestServer_initTLSConfig(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+
+	tests := []struct {
+		name          string
+		env           environment
+		wantTLS       bool
+		wantErr       bool
+		clientCertFp  string
+		clientKeyFp   string
+		wantClientErr bool
+	}{
+		{
+			name:    "Test 1. TLS is not set.",
+			env:     environment{},
+			wantTLS: false,
+		},
+		{
+			name: "Test 2. TLS without client verification.",
+			env: environment{
+				TLSCertFp: "../tlsconfig/test/server_test.pem", TLSKeyFp: "../tlsconfig/test/server_key_test.pem",
+			},
+			wantTLS: true,
+		},
+		{
+			name: "Test 3. mTLS, client with cert.",
+			env: environment{
+				TLSCertFp: "../tlsconfig/test/server_test.pem", TLSKeyFp: "../tlsconfig/test/server_key_test.pem",
+				TLSClientCAFp: "../tlsconfig/test/ca_test.pem",
+			},
+			wantTLS:      true,
+			clientCertFp: "../tlsconfig/test/client_test.pem",
+			clientKeyFp:  "../tlsconfig/test/client_key_test.pem",
+		},
+		{
+			name: "Test 4. mTLS, client without cert.",
+			env: environment{
+				TLSCertFp: "../tlsconfig/test/server_test.pem", TLSKeyFp: "../tlsconfig/test/server_key_test.pem",
+				TLSClientCAFp: "../tlsconfig/test/ca_test.pem",
+			},
+			wantTLS:       true,
+			wantClientErr: true,
+		},
+		{
+			name:    "Test 5. Key is not set.",
+			env:     environment{TLSCertFp: "../tlsconfig/test/server_test.pem"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Env = tt.env
+			s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{})}
+			err := s.initTLSConfig()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantTLS, s.TLSConfig != nil)
+			if !tt.wantTLS {
+				return
+			}
+
+			ts := httptest.NewUnstartedServer(s.newRouter())
+			ts.TLS = s.TLSConfig
+			ts.StartTLS()
+			defer ts.Close()
+
+			clientTLSConfig, err := tlsconfig.NewClientConfig("../tlsconfig/test/ca_test.pem", tt.clientCertFp, tt.clientKeyFp)
+			require.NoError(t, err)
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+			resp, err := client.Post(ts.URL+"/update/counter/PollCount/10", "text/plain", nil)
+			if tt.wantClientErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
+
+func TestServer_requireScope(t *testing.T) {
+	tokens, err := auth.NewTokenStore([]auth.Token{
+		{Name: "agent", Hash: auth.HashToken("agent-token"), Scopes: []auth.Scope{auth.ScopeWrite}},
+		{Name: "dashboard", Hash: auth.HashToken("dashboard-token"), Scopes: []auth.Scope{auth.ScopeRead}},
+		{Name: "admin", Hash: auth.HashToken("admin-token"), Scopes: []auth.Scope{auth.ScopeAdmin}},
+	})
+	require.NoError(t, err)
+
+	updateRequest := requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"}
+	queryRequest := requestArgs{method: http.MethodGet, url: "/api/v1/query"}
+	valueRequest := requestArgs{method: http.MethodPost, url: "/value/", body: `{"id":"PollCount","type":"counter"}`}
+	alertsRequest := requestArgs{method: http.MethodGet, url: "/api/v1/alerts"}
+	keysRequest := requestArgs{method: http.MethodPost, url: "/keys/", body: "not a key"}
+	tests := []struct {
+		name           string
+		tokens         *auth.TokenStore
+		authorization  string
+		request        requestArgs
+		wantStatusCode int
+	}{
+		{
+			name:           "Test 1. Auth is disabled.",
+			tokens:         nil,
+			request:        updateRequest,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Test 2. Token is not set.",
+			tokens:         tokens,
+			request:        updateRequest,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Test 3. Unknown token.",
+			tokens:         tokens,
+			authorization:  "Bearer wrong-token",
+			request:        updateRequest,
+			wantStatusCode: http.StatusUnauthorized,
+		},
+		{
+			name:           "Test 4. Write token, write route.",
+			tokens:         tokens,
+			authorization:  "Bearer agent-token",
+			request:        updateRequest,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Test 5. Write token, read route.",
+			tokens:         tokens,
+			authorization:  "Bearer agent-token",
+			request:        queryRequest,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Test 6. Read token, write route.",
+			tokens:         tokens,
+			authorization:  "Bearer dashboard-token",
+			request:        updateRequest,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Test 7. Read token, read routes.",
+			tokens:         tokens,
+			authorization:  "Bearer dashboard-token",
+			request:        valueRequest,
+			wantStatusCode: http.StatusNotFound,
+		},
+		{
+			name:           "Test 8. Admin token, any route.",
+			tokens:         tokens,
+			authorization:  "Bearer admin-token",
+			request:        queryRequest,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Test 9. Ping is not protected(db is not set - 500, not 401).",
+			tokens:         tokens,
+			request:        requestArgs{method: http.MethodGet, url: "/ping"},
+			wantStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:           "Test 10. Read token, alerts route(same alerts as on index page).",
+			tokens:         tokens,
+			authorization:  "Bearer dashboard-token",
+			request:        alertsRequest,
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Test 11. Read token, keys route.",
+			tokens:         tokens,
+			authorization:  "Bearer dashboard-token",
+			request:        keysRequest,
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Test 12. Admin token, admin routes.",
+			tokens:         tokens,
+			authorization:  "Bearer admin-token",
+			request:        requestArgs{method: http.MethodGet, url: "/api/v1/rejected"},
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "Test 13. Read token, admin routes.",
+			tokens:         tokens,
+			authorization:  "Bearer dashboard-token",
+			request:        requestArgs{method: http.MethodGet, url: "/api/v1/rejected"},
+			wantStatusCode: http.StatusForbidden,
+		},
+		{
+			name:           "Test 14. Write token, keys route(key is invalid - 400, not 403).",
+			tokens:         tokens,
+			authorization:  "Bearer agent-token",
+			request:        keysRequest,
+			wantStatusCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{}), Tokens: tt.tokens}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			headers := map[string]string{}
+			if tt.authorization != "" {
+				headers["Authorization"] = tt.authorization
+			}
+			statusCode, _, _ := sendTestRequestWithHeaders(t, ts, tt.request, headers)
+			assert.Equal(t, tt.wantStatusCode, statusCode)
+		})
+	}
+}
+
 // Эти тесты должны быть внизу, т.к. вызывают гонку горутинами
 // Тестирую изолированно только саму функцию(а не ее инъекции в обновл. MS хендлеры)
 func TestServer_SyncSaveMetricStorage(t *testing.T) {
@@ -2039,6 +3029,39 @@ func TestServer_SyncSaveMetricStorage(t *testing.T) {
 	}
 }
 
+func TestServer_initTickers(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+	rulesFile := filepath.Join(t.TempDir(), "rules.json")
+	err := os.WriteFile(rulesFile,
+		[]byte(`{"rules":[{"name":"HighCPU","metric":"CPUutilization*","op":">=","threshold":90}]}`), 0600)
+	require.NoError(t, err)
+	Env = environment{
+		MetricTTL: time.Hour, AlertRulesFile: rulesFile, AlertInterval: time.Hour, AgentSilentAfter: time.Hour,
+		StoreInterval: time.Hour,
+	}
+
+	// тикеры создаются до запуска горутин, их можно остановить сразу после инициализации
+	s := &Server{
+		MetricStorage: storage.NewMemStorage(map[string]storage.Metric{}),
+		FileStore:     filestore.NewFileStore(filepath.Join(t.TempDir(), "store.json")),
+	}
+	s.initRepeatableSave()
+	s.initStaleSweeper()
+	require.NoError(t, s.initAlerts())
+	s.initAgentWatcher()
+	require.NotNil(t, s.WriteTicker)
+	require.NotNil(t, s.StaleTicker)
+	require.NotNil(t, s.AlertTicker)
+	require.NotNil(t, s.AgentTicker)
+	s.WriteTicker.Stop()
+	s.StaleTicker.Stop()
+	s.AlertTicker.Stop()
+	s.AgentTicker.Stop()
+}
+
 func TestServer_InitRepeatableSave(t *testing.T) {
 	type serverArgs struct {
 		FileStore     *filestore.FileStore
@@ -2117,3 +3140,281 @@ func TestServer_InitRepeatableSave(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_validateMetrics(t *testing.T) {
+	// Env не заменяется целиком: горутины предыдущих тестов(initRepeatableSave) читают другие его поля
+	nameRegex, nameMaxLen, allowedTypes := Env.MetricNameRegex, Env.MetricNameMaxLen, Env.AllowedTypes
+	allowNonFinite, maxCounterDelta, denyMetrics := Env.AllowNonFinite, Env.MaxCounterDelta, Env.DenyMetrics
+	t.Cleanup(func() {
+		Env.MetricNameRegex, Env.MetricNameMaxLen, Env.AllowedTypes = nameRegex, nameMaxLen, allowedTypes
+		Env.AllowNonFinite, Env.MaxCounterDelta, Env.DenyMetrics = allowNonFinite, maxCounterDelta, denyMetrics
+	})
+	Env.MetricNameRegex = validation.DefaultNameRegex
+	Env.MetricNameMaxLen = validation.DefaultMaxNameLength
+	Env.AllowedTypes = ""
+	Env.AllowNonFinite = false
+	Env.MaxCounterDelta = 100
+	Env.DenyMetrics = "Debug*"
+
+	tests := []struct {
+		name           string
+		request        requestArgs
+		wantStatusCode int
+		wantBody       string
+		wantedState    map[string]storage.Metric
+	}{
+		{
+			name:           "Test 1. Valid metric.",
+			request:        requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/20"},
+			wantStatusCode: http.StatusOK,
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1upd20},
+		},
+		{
+			name:           "Test 2. Name with space in url.",
+			request:        requestArgs{method: http.MethodPost, url: "/update/counter/Poll%20Count/20"},
+			wantStatusCode: http.StatusBadRequest,
+			wantBody:       "does not match",
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name:           "Test 3. NaN gauge in url.",
+			request:        requestArgs{method: http.MethodPost, url: "/update/gauge/RandomValue/NaN"},
+			wantStatusCode: http.StatusBadRequest,
+			wantBody:       "is not finite",
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name: "Test 4. Denied name in json.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/update/", body: `{"id":"DebugValue","type":"gauge","value":1}`,
+			},
+			wantStatusCode: http.StatusBadRequest,
+			wantBody:       "is denied",
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name: "Test 5. Batch with counter delta over max is not applied.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/updates/",
+				body: `[{"id":"RandomValue","type":"gauge","value":1},{"id":"PollCount","type":"counter","delta":101}]`,
+			},
+			wantStatusCode: http.StatusBadRequest,
+			wantBody:       "exceeds 100",
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name: "Test 6. Partial batch skips invalid metric.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/updates/?partial=true",
+				body: `[{"id":"PollCount","type":"counter","delta":20},{"id":"","type":"gauge","value":1}]`,
+			},
+			wantStatusCode: http.StatusOK,
+			wantBody:       "name is empty",
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1upd20},
+		},
+		{
+			name: "Test 7. API v2 batch.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/api/v2/updates",
+				body: `[{"id":"PollCount","type":"counter","delta":20},{"id":"DebugValue","type":"gauge","value":1}]`,
+			},
+			wantStatusCode: http.StatusBadRequest,
+			wantBody:       `"code":"invalid_metric","message":"invalid metric: name 'DebugValue' is denied"`,
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{metric1.Name: *metric1})}
+			require.NoError(t, s.initValidator())
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			statusCode, _, body := sendTestRequest(t, ts, tt.request)
+			assert.Equal(t, tt.wantStatusCode, statusCode, body)
+			assert.Contains(t, body, tt.wantBody)
+			compareMetricsState(t, tt.wantedState, s.MetricStorage, context.Background())
+		})
+	}
+}
+
+func TestServer_relabelMetrics(t *testing.T) {
+	relabelRulesFile := Env.RelabelRulesFile
+	t.Cleanup(func() {
+		Env.RelabelRulesFile = relabelRulesFile
+	})
+	Env.RelabelRulesFile = filepath.Join(t.TempDir(), "relabel.json")
+	rules := `{"rules":[` +
+		`{"source_labels":["__name__"],"regex":"Lookups|RandomValue","action":"drop"},` +
+		`{"source_labels":["__name__"],"regex":"CPUutilization(\\d+)","target_label":"__name__",` +
+		`"replacement":"cpu_utilization_$1"}]}`
+	require.NoError(t, os.WriteFile(Env.RelabelRulesFile, []byte(rules), 0644))
+
+	metricCPU, err := storage.NewMetric("cpu_utilization_1", internal.GaugeTypeName, 2.5)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		request        requestArgs
+		wantStatusCode int
+		wantBody       string
+		wantedState    map[string]storage.Metric
+	}{
+		{
+			name:           "Test 1. Dropped metric in url.",
+			request:        requestArgs{method: http.MethodPost, url: "/update/gauge/RandomValue/1.5"},
+			wantStatusCode: http.StatusOK,
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name: "Test 2. Renamed metric in json.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/update/", body: `{"id":"CPUutilization1","type":"gauge","value":2.5}`,
+			},
+			wantStatusCode: http.StatusOK,
+			wantBody:       `{"id":"cpu_utilization_1","type":"gauge","value":2.5}`,
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1, metricCPU.Name: *metricCPU},
+		},
+		{
+			name: "Test 3. Dropped metric in json.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/update/", body: `{"id":"Lookups","type":"gauge","value":1}`,
+			},
+			wantStatusCode: http.StatusOK,
+			wantBody:       `{"id":"Lookups","type":"gauge","value":1}`,
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1},
+		},
+		{
+			name: "Test 4. Batch.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/updates/",
+				body: `[{"id":"Lookups","type":"gauge","value":1},{"id":"CPUutilization1","type":"gauge","value":2.5},` +
+					`{"id":"PollCount","type":"counter","delta":20}]`,
+			},
+			wantStatusCode: http.StatusOK,
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1upd20, metricCPU.Name: *metricCPU},
+		},
+		{
+			name: "Test 5. Partial batch.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/updates/?partial=true",
+				body: `[{"id":"Lookups","type":"gauge","value":1},{"id":"CPUutilization1","type":"gauge","value":2.5}]`,
+			},
+			wantStatusCode: http.StatusOK,
+			wantBody: `[{"id":"Lookups","type":"gauge","status":200},{"id":"CPUutilization1","type":"gauge",` +
+				`"status":200,"metric":{"id":"cpu_utilization_1","type":"gauge","value":2.5}}]`,
+			wantedState: map[string]storage.Metric{metric1.Name: *metric1, metricCPU.Name: *metricCPU},
+		},
+		{
+			name: "Test 6. API v2 batch.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/api/v2/updates",
+				body: `[{"id":"RandomValue","type":"gauge","value":1},{"id":"CPUutilization1","type":"gauge","value":2.5}]`,
+			},
+			wantStatusCode: http.StatusOK,
+			wantBody:       `[{"id":"cpu_utilization_1","type":"gauge","value":2.5}]`,
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1, metricCPU.Name: *metricCPU},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{metric1.Name: *metric1})}
+			require.NoError(t, s.initRelabel())
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			statusCode, _, body := sendTestRequest(t, ts, tt.request)
+			assert.Equal(t, tt.wantStatusCode, statusCode, body)
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, body)
+			}
+			compareMetricsState(t, tt.wantedState, s.MetricStorage, context.Background())
+		})
+	}
+}
+
+func TestServer_v1TypeMismatch(t *testing.T) {
+	metricCounter70, err := storage.NewMetric("PollCount", internal.CounterTypeName, int64(70))
+	require.NoError(t, err)
+	tests := []struct {
+		name           string
+		request        requestArgs
+		wantStatusCode int
+		wantedState    map[string]storage.Metric
+		// wantCounterRates скорость роста счетчиков(за минуту) по CounterHistory
+		wantCounterRates map[string]float64
+	}{
+		{
+			name:           "Test 1. Url update with gauge value for counter metric.",
+			request:        requestArgs{method: http.MethodPost, url: "/update/gauge/PollCount/1.5"},
+			wantStatusCode: http.StatusOK,
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1, metric3.Name: *metric3},
+		},
+		{
+			name: "Test 2. Json update with gauge value for counter metric.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/update/", body: `{"id":"PollCount","type":"gauge","value":1.5}`,
+			},
+			wantStatusCode: http.StatusOK,
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1, metric3.Name: *metric3},
+		},
+		{
+			name: "Test 3. Batch update with mismatched metric.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/updates/",
+				body: `[{"id":"PollCount","type":"gauge","value":1.5},{"id":"RandomValue","type":"gauge","value":12.133}]`,
+			},
+			wantStatusCode: http.StatusOK,
+			wantedState: map[string]storage.Metric{
+				metric1.Name: *metric1, metric2.Name: *metric2, metric3.Name: *metric3,
+			},
+		},
+		{
+			name: "Test 4. Api v2 update with mismatched metric.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/api/v2/updates",
+				body: `[{"id":"RandomValue","type":"gauge","value":12.133},{"id":"PollCount","type":"gauge","value":1.5}]`,
+			},
+			wantStatusCode: http.StatusConflict,
+			wantedState:    map[string]storage.Metric{metric1.Name: *metric1, metric3.Name: *metric3},
+		},
+		{
+			name:             "Test 5. Mismatched counter is not added to counter history.",
+			request:          requestArgs{method: http.MethodPost, url: "/update/counter/Alloc/10"},
+			wantStatusCode:   http.StatusOK,
+			wantedState:      map[string]storage.Metric{metric1.Name: *metric1, metric3.Name: *metric3},
+			wantCounterRates: map[string]float64{"Alloc": 0},
+		},
+		{
+			name: "Test 6. Batch update, history contains only applied counters.",
+			request: requestArgs{
+				method: http.MethodPost, url: "/updates/",
+				body: `[{"id":"Alloc","type":"counter","delta":10},{"id":"PollCount","type":"counter","delta":60}]`,
+			},
+			wantStatusCode:   http.StatusOK,
+			wantedState:      map[string]storage.Metric{metric1.Name: *metricCounter70, metric3.Name: *metric3},
+			wantCounterRates: map[string]float64{"Alloc": 0, "PollCount": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				MetricStorage: storage.NewMemStorage(map[string]storage.Metric{
+					metric1.Name: *metric1, metric3.Name: *metric3,
+				}),
+				CounterHistory: counters.NewHistory(time.Minute),
+			}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			statusCode, _, body := sendTestRequest(t, ts, tt.request)
+			assert.Equal(t, tt.wantStatusCode, statusCode, body)
+			compareMetricsState(t, tt.wantedState, s.MetricStorage, context.Background())
+			for name, wantRate := range tt.wantCounterRates {
+				rate, err := s.CounterHistory.Rate(name, time.Minute, time.Now())
+				require.NoError(t, err)
+				assert.Equal(t, wantRate, rate, name)
+			}
+		})
+	}
+}
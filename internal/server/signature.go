@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+// nonceCache хранит использованные nonce подписанных запросов, до истечения допустимого расхождения времени.
+type nonceCache struct {
+	expires     map[string]time.Time
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+// use отмечает nonce как использованный до момента expire.
+// Возвращает false, если nonce уже был использован.
+func (c *nonceCache) use(nonce string, expire, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.expires == nil {
+		c.expires = map[string]time.Time{}
+	}
+	if now.Sub(c.lastCleanup) > Env.SignatureSkew {
+		for n, e := range c.expires {
+			if now.After(e) {
+				delete(c.expires, n)
+			}
+		}
+		c.lastCleanup = now
+	}
+
+	if e, ok := c.expires[nonce]; ok && !now.After(e) {
+		return false
+	}
+	c.expires[nonce] = expire
+	return true
+}
+
+// agentIDContextKey ключ контекста запроса, в котором хранится id агента из проверенной подписи запроса.
+type agentIDContextKey struct{}
+
+// requestAgent возвращает идентификатор агента, отправившего запрос: id агента из проверенной подписи
+// (checkBatchSignature), если он задан, иначе адрес отправителя(clientIP).
+func (s *Server) requestAgent(request *http.Request) string {
+	if agentID, ok := request.Context().Value(agentIDContextKey{}).(string); ok && agentID != "" {
+		return agentID
+	}
+	return s.clientIP(request)
+}
+
+// checkBatchSignature - middleware, проверяющий подпись запроса целиком(message.BatchSignature), если задан Key.
+// Подпись должна быть корректной, время подписи - в пределах SignatureSkew от времени сервера,
+// а nonce - не использованным ранее этим агентом. Иначе 400.
+// Подписываются метод, путь с query, заголовки Content-Type и X-Counter-Mode и тело запроса.
+// Запросы без подписи отклоняются, только если задан RequireSignature, иначе пропускаются
+// (проверяются только хеши метрик).
+func (s *Server) checkBatchSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if Env.Key == "" {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		signature, err := message.BatchSignatureFromHeader(request.Header)
+		if errors.Is(err, message.ErrNoBatchSignature) && !Env.RequireSignature {
+			next.ServeHTTP(writer, request)
+			return
+		} else if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request.Body = io.NopCloser(bytes.NewReader(body))
+
+		target := message.SignatureTarget(request.URL.Path, request.URL.RawQuery)
+		isSignatureCorrect, err := signature.Check(Env.Key, request.Method, target, request.Header, body)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		} else if !isSignatureCorrect {
+			http.Error(writer, "batch signature is not correct", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		skew := now.Sub(signature.Timestamp)
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > Env.SignatureSkew {
+			http.Error(writer, "request timestamp is out of allowed clock skew", http.StatusBadRequest)
+			return
+		}
+		if !s.nonces.use(signature.AgentID+":"+signature.Nonce, signature.Timestamp.Add(Env.SignatureSkew), now) {
+			http.Error(writer, "nonce has already been used", http.StatusBadRequest)
+			return
+		}
+		next.ServeHTTP(writer, request.WithContext(
+			context.WithValue(request.Context(), agentIDContextKey{}, signature.AgentID)))
+	})
+}
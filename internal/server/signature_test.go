@@ -0,0 +1,260 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/message"
+	"github.com/firesworder/devopsmetrics/internal/storage"
+)
+
+func TestServer_checkBatchSignature(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+	Env.Key = "Ayayaka"
+	Env.SignatureSkew = 5 * time.Minute
+
+	delta := int64(10)
+	msg := message.Metrics{ID: "PollCount", MType: internal.CounterTypeName, Delta: &delta}
+	require.NoError(t, msg.InitHash(Env.Key))
+	batchJSON, err := json.Marshal([]message.Metrics{msg})
+	require.NoError(t, err)
+	batchBody := string(batchJSON)
+
+	type signedRequest struct {
+		request requestArgs
+		// signPath путь и query, для которых формируется подпись(если пустой - путь и query запроса)
+		signPath string
+		// headerAfterSign заголовки, изменяемые после формирования подписи
+		headerAfterSign map[string]string
+		signKey         string
+		signTime        time.Time
+		// replay отправить повторно подпись предыдущего запроса
+		replay bool
+	}
+	tests := []struct {
+		name             string
+		requireSignature bool
+		requests         []signedRequest
+		wantStatusCodes  []int
+	}{
+		{
+			name: "Test 1. Signature is not set, not required.",
+			requests: []signedRequest{
+				{request: requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody}},
+			},
+			wantStatusCodes: []int{http.StatusOK},
+		},
+		{
+			name:             "Test 2. Signature is not set, required.",
+			requireSignature: true,
+			requests: []signedRequest{
+				{request: requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody}},
+			},
+			wantStatusCodes: []int{http.StatusBadRequest},
+		},
+		{
+			name:             "Test 3. Correct signature, batch and url handlers.",
+			requireSignature: true,
+			requests: []signedRequest{
+				{
+					request:  requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+					signKey:  "Ayayaka",
+					signTime: time.Now(),
+				},
+				{
+					request:  requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+					signKey:  "Ayayaka",
+					signTime: time.Now(),
+				},
+			},
+			wantStatusCodes: []int{http.StatusOK, http.StatusOK},
+		},
+		{
+			name: "Test 4. Signature made with other key.",
+			requests: []signedRequest{
+				{
+					request:  requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+					signKey:  "Ayayaka2",
+					signTime: time.Now(),
+				},
+			},
+			wantStatusCodes: []int{http.StatusBadRequest},
+		},
+		{
+			name: "Test 5. Signature made for other path.",
+			requests: []signedRequest{
+				{
+					request:  requestArgs{method: http.MethodPost, url: "/update/counter/PollCount/10"},
+					signPath: "/update/counter/PollCount/1",
+					signKey:  "Ayayaka",
+					signTime: time.Now(),
+				},
+			},
+			wantStatusCodes: []int{http.StatusBadRequest},
+		},
+		{
+			name: "Test 6. Timestamp is out of clock skew.",
+			requests: []signedRequest{
+				{
+					request:  requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+					signKey:  "Ayayaka",
+					signTime: time.Now().Add(-10 * time.Minute),
+				},
+				{
+					request:  requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+					signKey:  "Ayayaka",
+					signTime: time.Now().Add(10 * time.Minute),
+				},
+			},
+			wantStatusCodes: []int{http.StatusBadRequest, http.StatusBadRequest},
+		},
+		{
+			name: "Test 7. Replayed request.",
+			requests: []signedRequest{
+				{
+					request:  requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+					signKey:  "Ayayaka",
+					signTime: time.Now(),
+				},
+				{
+					request: requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+					replay:  true,
+				},
+			},
+			wantStatusCodes: []int{http.StatusOK, http.StatusBadRequest},
+		},
+		{
+			name:             "Test 8. Signed query.",
+			requireSignature: true,
+			requests: []signedRequest{
+				{
+					request:  requestArgs{method: http.MethodPost, url: "/updates/?partial=true", body: batchBody},
+					signKey:  "Ayayaka",
+					signTime: time.Now(),
+				},
+			},
+			wantStatusCodes: []int{http.StatusOK},
+		},
+		{
+			name:             "Test 9. Query is added after signing.",
+			requireSignature: true,
+			requests: []signedRequest{
+				{
+					request:  requestArgs{method: http.MethodPost, url: "/updates/?partial=true", body: batchBody},
+					signPath: "/updates/",
+					signKey:  "Ayayaka",
+					signTime: time.Now(),
+				},
+			},
+			wantStatusCodes: []int{http.StatusBadRequest},
+		},
+		{
+			name:             "Test 10. Counter mode is changed after signing.",
+			requireSignature: true,
+			requests: []signedRequest{
+				{
+					request:         requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+					headerAfterSign: map[string]string{counterModeHeader: cumulativeCounterMode},
+					signKey:         "Ayayaka",
+					signTime:        time.Now(),
+				},
+			},
+			wantStatusCodes: []int{http.StatusBadRequest},
+		},
+		{
+			name:             "Test 11. Content type is changed after signing.",
+			requireSignature: true,
+			requests: []signedRequest{
+				{
+					request:         requestArgs{method: http.MethodPost, url: "/updates/", body: batchBody},
+					headerAfterSign: map[string]string{"Content-Type": "application/x-msgpack"},
+					signKey:         "Ayayaka",
+					signTime:        time.Now(),
+				},
+			},
+			wantStatusCodes: []int{http.StatusBadRequest},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Env.RequireSignature = tt.requireSignature
+			s := &Server{MetricStorage: storage.NewMemStorage(map[string]storage.Metric{})}
+			ts := httptest.NewServer(s.newRouter())
+			defer ts.Close()
+
+			var lastSignature *message.BatchSignature
+			for i, r := range tt.requests {
+				req, err := http.NewRequest(r.request.method, ts.URL+r.request.url, strings.NewReader(r.request.body))
+				require.NoError(t, err)
+				req.Header.Set("Content-Type", "application/json")
+
+				signature := lastSignature
+				if !r.replay && r.signKey != "" {
+					signPath := r.signPath
+					if signPath == "" {
+						signPath = message.SignatureTarget(req.URL.Path, req.URL.RawQuery)
+					}
+					signature, err = message.NewBatchSignature(r.signKey, r.request.method, signPath,
+						req.Header, []byte(r.request.body), "agent1", r.signTime)
+					require.NoError(t, err)
+				}
+				if signature != nil {
+					signature.SetHeader(req.Header)
+				}
+				for k, v := range r.headerAfterSign {
+					req.Header.Set(k, v)
+				}
+				lastSignature = signature
+
+				resp, err := http.DefaultClient.Do(req)
+				require.NoError(t, err)
+				resp.Body.Close()
+				assert.Equal(t, tt.wantStatusCodes[i], resp.StatusCode)
+			}
+		})
+	}
+}
+
+func Test_nonceCache_use(t *testing.T) {
+	envBefore := Env
+	defer func() {
+		Env = envBefore
+	}()
+	Env.SignatureSkew = time.Minute
+
+	now := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	c := nonceCache{}
+	assert.True(t, c.use("agent1:00", now.Add(time.Minute), now))
+	assert.False(t, c.use("agent1:00", now.Add(time.Minute), now.Add(time.Second)))
+	assert.True(t, c.use("agent2:00", now.Add(time.Minute), now.Add(time.Second)))
+
+	// истекшие nonce удаляются и могут быть использованы повторно
+	later := now.Add(2 * time.Minute)
+	assert.True(t, c.use("agent1:00", later.Add(time.Minute), later))
+	assert.Len(t, c.expires, 1)
+}
+
+func TestServer_requestAgent(t *testing.T) {
+	s := &Server{}
+	request := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+	request.RemoteAddr = "10.0.0.1:4000"
+	assert.Equal(t, "10.0.0.1", s.requestAgent(request))
+
+	// id агента берется из проверенной подписи
+	signed := request.WithContext(context.WithValue(request.Context(), agentIDContextKey{}, "agent1"))
+	assert.Equal(t, "agent1", s.requestAgent(signed))
+	unnamed := request.WithContext(context.WithValue(request.Context(), agentIDContextKey{}, ""))
+	assert.Equal(t, "10.0.0.1", s.requestAgent(unnamed))
+}
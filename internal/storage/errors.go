@@ -1,10 +1,32 @@
 package storage
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrMetricNotFound ошибка "метрика с данным названием не найдена"
 	ErrMetricNotFound = errors.New("metric was not found")
 	// ErrUnhandledValueType ошибка "указан нереализованный тип метрик"
 	ErrUnhandledValueType = errors.New("unhandled value type")
+	// ErrTypeMismatch ошибка "тип нового значения не совпадает с типом сохраненной метрики"
+	ErrTypeMismatch = errors.New("type mismatch")
 )
+
+// TypeMismatchError ошибка BatchUpdateStrict: тип значения метрики Index набора не совпадает с типом сохраненной
+// метрики(или предыдущей метрики набора с тем же названием). Оборачивает ErrTypeMismatch.
+type TypeMismatchError struct {
+	Index   int
+	Name    string
+	Current interface{}
+	New     interface{}
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("metric '%s': current(%T) and new(%T) value %s", e.Name, e.Current, e.New, ErrTypeMismatch)
+}
+
+func (e *TypeMismatchError) Unwrap() error {
+	return ErrTypeMismatch
+}
@@ -1,6 +1,9 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // MetricRepository интерфейс взаимодействия с репозиторием(коллекцией) метрик.
 type MetricRepository interface {
@@ -21,6 +24,14 @@ type MetricRepository interface {
 	GetAll(context.Context) (map[string]Metric, error)
 	// GetMetric возвращает метрику по названию.
 	GetMetric(context.Context, string) (Metric, error)
+	// GetUpdateTimes возвращает время последнего обновления каждой метрики.
+	GetUpdateTimes(context.Context) (map[string]time.Time, error)
+	// DeleteStale удаляет метрики, не обновлявшиеся с момента before, и возвращает их названия.
+	DeleteStale(ctx context.Context, before time.Time) ([]string, error)
 	// BatchUpdate обновляет репозиторий элементами слайса метрик.
 	BatchUpdate(context.Context, []Metric) error
+	// BatchUpdateStrict обновляет репозиторий элементами слайса метрик, только если типы всех значений совпадают
+	// с типами сохраненных метрик. Проверка и обновление выполняются атомарно.
+	// При несовпадении ничего не обновляет и возвращает *TypeMismatchError.
+	BatchUpdateStrict(context.Context, []Metric) error
 }
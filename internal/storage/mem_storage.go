@@ -4,54 +4,88 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/firesworder/devopsmetrics/internal"
 )
 
 // MemStorage реализует хранение и доступ к метрикам в памяти.
 // Доступ и хранение обеспечиваются посредством мапа Metrics, а также методами, по интерфейсу MetricRepository.
+// Время последнего обновления метрик хранится отдельно от Metrics, в updateTimes.
 type MemStorage struct {
-	Metrics map[string]Metric
+	Metrics     map[string]Metric
+	updateTimes map[string]time.Time
+	mutex       sync.RWMutex
 }
 
 // AddMetric добавляет метрику.
 // Если ключ с названием метрики уже в мапе - возвращает ошибку.
 func (ms *MemStorage) AddMetric(ctx context.Context, metric Metric) (err error) {
-	if mInStorage, _ := ms.IsMetricInStorage(ctx, metric); mInStorage {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.addMetric(metric)
+}
+
+// addMetric реализация AddMetric, без блокировки мьютекса.
+func (ms *MemStorage) addMetric(metric Metric) error {
+	if _, ok := ms.Metrics[metric.Name]; ok {
 		return fmt.Errorf("metric with name '%s' already present in Storage", metric.Name)
 	}
 	ms.Metrics[metric.Name] = metric
-	return
+	ms.touch(metric.Name)
+	return nil
 }
 
 // UpdateMetric обновляет метрику.
 // Если ключ с названием метрики не найден в мапе - возвращает ошибку.
 func (ms *MemStorage) UpdateMetric(ctx context.Context, metric Metric) (err error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.updateMetric(metric)
+}
+
+// updateMetric реализация UpdateMetric, без блокировки мьютекса.
+func (ms *MemStorage) updateMetric(metric Metric) error {
 	metricToUpdate, ok := ms.Metrics[metric.Name]
 	if !ok {
 		return fmt.Errorf("there is no metric with name '%s'", metric.Name)
 	}
-	err = metricToUpdate.Update(metric.Value)
+	err := metricToUpdate.Update(metric.Value)
 	if err != nil {
 		return err
 	}
 	ms.Metrics[metric.Name] = metricToUpdate
-	return
+	ms.touch(metric.Name)
+	return nil
+}
+
+// touch отмечает метрику `name` как обновленную в текущий момент.
+func (ms *MemStorage) touch(name string) {
+	if ms.updateTimes == nil {
+		ms.updateTimes = map[string]time.Time{}
+	}
+	ms.updateTimes[name] = time.Now()
 }
 
 // DeleteMetric удаляет метрику из мапа.
 // Если ключ с названием метрики не найден в мапе - возвращает ошибку.
 func (ms *MemStorage) DeleteMetric(ctx context.Context, metric Metric) (err error) {
-	if mInStorage, _ := ms.IsMetricInStorage(ctx, metric); !mInStorage {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if _, ok := ms.Metrics[metric.Name]; !ok {
 		return fmt.Errorf("there is no metric with name '%s'", metric.Name)
 	}
 	delete(ms.Metrics, metric.Name)
+	delete(ms.updateTimes, metric.Name)
 	return
 }
 
 // IsMetricInStorage возвращает true если метрика с таким названием присутствует в мапе, иначе false.
 // Ошибка не генерируется.
 func (ms *MemStorage) IsMetricInStorage(ctx context.Context, metric Metric) (bool, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
 	_, isMetricExist := ms.Metrics[metric.Name]
 	return isMetricExist, nil
 }
@@ -59,23 +93,79 @@ func (ms *MemStorage) IsMetricInStorage(ctx context.Context, metric Metric) (boo
 // UpdateOrAddMetric Обновляет метрику, если она есть в коллекции, иначе добавляет ее.
 // Ошибка не генерируется.
 func (ms *MemStorage) UpdateOrAddMetric(ctx context.Context, metric Metric) (err error) {
-	if mInStorage, _ := ms.IsMetricInStorage(ctx, metric); mInStorage {
-		_ = ms.UpdateMetric(ctx, metric)
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.updateOrAddMetric(metric)
+	return
+}
+
+// updateOrAddMetric реализация UpdateOrAddMetric, без блокировки мьютекса.
+func (ms *MemStorage) updateOrAddMetric(metric Metric) {
+	if _, ok := ms.Metrics[metric.Name]; ok {
+		_ = ms.updateMetric(metric)
 	} else {
-		_ = ms.AddMetric(ctx, metric)
+		_ = ms.addMetric(metric)
 	}
-	return
 }
 
 // GetAll возвращет все метрики.
+// Возвращается копия мапа Metrics, чтобы ее можно было безопасно читать параллельно с обновлением хранилища.
 // Ошибка не генерируется.
 func (ms *MemStorage) GetAll(ctx context.Context) (map[string]Metric, error) {
-	return ms.Metrics, nil
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	if ms.Metrics == nil {
+		return nil, nil
+	}
+	result := make(map[string]Metric, len(ms.Metrics))
+	for name, metric := range ms.Metrics {
+		result[name] = metric
+	}
+	return result, nil
+}
+
+// GetUpdateTimes возвращает время последнего обновления каждой метрики.
+// Метрики без отметки времени(например, восстановленные из файла) считаются обновленными в момент вызова.
+// Ошибка не генерируется.
+func (ms *MemStorage) GetUpdateTimes(ctx context.Context) (map[string]time.Time, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	result := make(map[string]time.Time, len(ms.Metrics))
+	for name := range ms.Metrics {
+		if _, ok := ms.updateTimes[name]; !ok {
+			ms.touch(name)
+		}
+		result[name] = ms.updateTimes[name]
+	}
+	return result, nil
+}
+
+// DeleteStale удаляет метрики, не обновлявшиеся с момента before, и возвращает их названия.
+// Проверка времени обновления и удаление выполняются под одной блокировкой, поэтому метрика,
+// обновленная во время проверки, не удаляется. Метрики без отметки времени считаются обновленными в момент вызова.
+// Ошибка не генерируется.
+func (ms *MemStorage) DeleteStale(ctx context.Context, before time.Time) ([]string, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	var deleted []string
+	for name := range ms.Metrics {
+		if _, ok := ms.updateTimes[name]; !ok {
+			ms.touch(name)
+		}
+		if ms.updateTimes[name].Before(before) {
+			delete(ms.Metrics, name)
+			delete(ms.updateTimes, name)
+			deleted = append(deleted, name)
+		}
+	}
+	return deleted, nil
 }
 
 // GetMetric возвращает метрику из репозитория по названию `name`.
 // Если метрика не найдена - возвращает ошибку ErrMetricNotFound.
 func (ms *MemStorage) GetMetric(ctx context.Context, name string) (metric Metric, err error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
 	metric, ok := ms.Metrics[name]
 	if !ok {
 		return metric, ErrMetricNotFound
@@ -95,6 +185,9 @@ func (ms *MemStorage) MarshalJSON() ([]byte, error) {
 		Metrics map[string]extendedMetric
 	}
 
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
 	mse := MemStorageExt{Metrics: map[string]extendedMetric{}}
 	var valueType string
 	var extM extendedMetric
@@ -133,6 +226,9 @@ func (ms *MemStorage) UnmarshalJSON(data []byte) error {
 		return err
 	}
 
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
 	var metric Metric
 	for _, extM := range mse.Metrics {
 		metric = Metric{Name: extM.Name}
@@ -153,15 +249,28 @@ func (ms *MemStorage) UnmarshalJSON(data []byte) error {
 // BatchUpdate обновляет метрики в репозитории батчем.
 // Ошибка не генерируется.
 func (ms *MemStorage) BatchUpdate(ctx context.Context, metrics []Metric) (err error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
 	for _, metric := range metrics {
-		err = ms.UpdateOrAddMetric(ctx, metric)
-		if err != nil {
-			return
-		}
+		ms.updateOrAddMetric(metric)
 	}
 	return
 }
 
+// BatchUpdateStrict обновляет метрики в репозитории батчем, если типы всех значений совпадают с сохраненными.
+// Проверка и обновление выполняются под одной блокировкой. Иначе возвращает *TypeMismatchError.
+func (ms *MemStorage) BatchUpdateStrict(ctx context.Context, metrics []Metric) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if err := checkTypes(ms.Metrics, metrics); err != nil {
+		return err
+	}
+	for _, metric := range metrics {
+		ms.updateOrAddMetric(metric)
+	}
+	return nil
+}
+
 // NewMemStorage конструктор.
 // Deprecated: был актуален, когда свойство Metrics было приватным.
 // Можно создавать напрямую объект MemStorage.
@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var metric1Counter10, metric1Counter15, metric1Gauge22d2 Metric
@@ -276,13 +278,113 @@ func TestMemStorage_UpdateOrAddMetric(t *testing.T) {
 				metric4Gauge2d27.Name: metric4Gauge2d27,
 			},
 		},
+		{
+			name:        "Test 3. Type mismatch is ignored.",
+			metricObj:   Metric{Name: metric1Counter10.Name, Value: gauge(2.27)},
+			startState:  map[string]Metric{metric1Counter10.Name: metric1Counter10},
+			wantedState: map[string]Metric{metric1Counter10.Name: metric1Counter10},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			ms := &MemStorage{
 				Metrics: tt.startState,
 			}
-			_ = ms.UpdateOrAddMetric(context.Background(), tt.metricObj)
+			err := ms.UpdateOrAddMetric(context.Background(), tt.metricObj)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantedState, ms.Metrics)
+		})
+	}
+}
+
+func TestMemStorage_BatchUpdate(t *testing.T) {
+	tests := []struct {
+		name        string
+		metrics     []Metric
+		startState  map[string]Metric
+		wantedState map[string]Metric
+	}{
+		{
+			name:       "Test 1. Add and update metrics.",
+			metrics:    []Metric{metric1Counter15, metric4Gauge2d27},
+			startState: map[string]Metric{metric1Counter10.Name: metric1Counter10},
+			wantedState: map[string]Metric{
+				metric1Counter10.Name: {Name: metric1Counter10.Name, Value: counter(25)},
+				metric4Gauge2d27.Name: metric4Gauge2d27,
+			},
+		},
+		{
+			name:       "Test 2. Type mismatch with stored metric is ignored.",
+			metrics:    []Metric{metric4Gauge2d27, {Name: metric1Counter10.Name, Value: gauge(2.27)}},
+			startState: map[string]Metric{metric1Counter10.Name: metric1Counter10},
+			wantedState: map[string]Metric{
+				metric1Counter10.Name: metric1Counter10,
+				metric4Gauge2d27.Name: metric4Gauge2d27,
+			},
+		},
+		{
+			name:        "Test 3. Type mismatch inside batch is ignored.",
+			metrics:     []Metric{metric4Gauge2d27, {Name: metric4Gauge2d27.Name, Value: counter(1)}},
+			startState:  map[string]Metric{},
+			wantedState: map[string]Metric{metric4Gauge2d27.Name: metric4Gauge2d27},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := &MemStorage{Metrics: tt.startState}
+			err := ms.BatchUpdate(context.Background(), tt.metrics)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantedState, ms.Metrics)
+		})
+	}
+}
+
+func TestMemStorage_BatchUpdateStrict(t *testing.T) {
+	tests := []struct {
+		name        string
+		metrics     []Metric
+		startState  map[string]Metric
+		wantedState map[string]Metric
+		wantErr     *TypeMismatchError
+	}{
+		{
+			name:       "Test 1. Add and update metrics.",
+			metrics:    []Metric{metric1Counter15, metric4Gauge2d27},
+			startState: map[string]Metric{metric1Counter10.Name: metric1Counter10},
+			wantedState: map[string]Metric{
+				metric1Counter10.Name: {Name: metric1Counter10.Name, Value: counter(25)},
+				metric4Gauge2d27.Name: metric4Gauge2d27,
+			},
+		},
+		{
+			name:        "Test 2. Type mismatch with stored metric, nothing is updated.",
+			metrics:     []Metric{metric4Gauge2d27, metric1Gauge22d2},
+			startState:  map[string]Metric{metric1Counter10.Name: metric1Counter10},
+			wantedState: map[string]Metric{metric1Counter10.Name: metric1Counter10},
+			wantErr: &TypeMismatchError{
+				Index: 1, Name: metric1Counter10.Name, Current: counter(10), New: gauge(22.2),
+			},
+		},
+		{
+			name:        "Test 3. Type mismatch inside batch, nothing is updated.",
+			metrics:     []Metric{metric4Gauge2d27, {Name: metric4Gauge2d27.Name, Value: counter(1)}},
+			startState:  map[string]Metric{},
+			wantedState: map[string]Metric{},
+			wantErr: &TypeMismatchError{
+				Index: 1, Name: metric4Gauge2d27.Name, Current: gauge(2.27), New: counter(1),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := &MemStorage{Metrics: tt.startState}
+			err := ms.BatchUpdateStrict(context.Background(), tt.metrics)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrTypeMismatch)
+				assert.Equal(t, tt.wantErr, err)
+			}
 			assert.Equal(t, tt.wantedState, ms.Metrics)
 		})
 	}
@@ -357,21 +459,72 @@ func TestMemStorage_GetMetric(t *testing.T) {
 	}
 }
 
+func TestMemStorage_GetUpdateTimes(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStorage(map[string]Metric{metric1Counter10.Name: metric1Counter10})
+
+	// метрика без отметки времени считается обновленной в момент вызова
+	beforeCall := time.Now()
+	gotTimes, err := ms.GetUpdateTimes(ctx)
+	require.NoError(t, err)
+	require.Contains(t, gotTimes, metric1Counter10.Name)
+	assert.False(t, gotTimes[metric1Counter10.Name].Before(beforeCall))
+	restoredTime := gotTimes[metric1Counter10.Name]
+
+	// обновление метрики сдвигает время ее обновления, остальные метрики не затрагиваются
+	beforeUpdate := time.Now()
+	require.NoError(t, ms.UpdateOrAddMetric(ctx, metric4Gauge2d27))
+	gotTimes, err = ms.GetUpdateTimes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, restoredTime, gotTimes[metric1Counter10.Name])
+	assert.False(t, gotTimes[metric4Gauge2d27.Name].Before(beforeUpdate))
+
+	// удаленная метрика пропадает из результата
+	require.NoError(t, ms.DeleteMetric(ctx, metric4Gauge2d27))
+	gotTimes, err = ms.GetUpdateTimes(ctx)
+	require.NoError(t, err)
+	assert.NotContains(t, gotTimes, metric4Gauge2d27.Name)
+}
+
+func TestMemStorage_DeleteStale(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemStorage(map[string]Metric{metric1Counter10.Name: metric1Counter10})
+	require.NoError(t, ms.UpdateOrAddMetric(ctx, metric4Gauge2d27))
+	ms.updateTimes[metric4Gauge2d27.Name] = time.Now().Add(-time.Hour)
+
+	// метрика без отметки времени считается обновленной в момент вызова и не удаляется
+	gotDeleted, err := ms.DeleteStale(ctx, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{metric4Gauge2d27.Name}, gotDeleted)
+	assert.Equal(t, map[string]Metric{metric1Counter10.Name: metric1Counter10}, ms.Metrics)
+	assert.NotContains(t, ms.updateTimes, metric4Gauge2d27.Name)
+
+	// устаревших метрик нет
+	gotDeleted, err = ms.DeleteStale(ctx, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Empty(t, gotDeleted)
+
+	gotDeleted, err = ms.DeleteStale(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, []string{metric1Counter10.Name}, gotDeleted)
+	assert.Empty(t, ms.Metrics)
+}
+
 func TestNewMemStorage(t *testing.T) {
 	tests := []struct {
 		argMetrics map[string]Metric
-		want       MemStorage
+		want       *MemStorage
 		name       string
 	}{
 		{
 			name:       "Test 1. Not nil arg metrics.",
 			argMetrics: map[string]Metric{},
-			want:       MemStorage{Metrics: map[string]Metric{}},
+			want:       &MemStorage{Metrics: map[string]Metric{}},
 		},
 		{
 			name:       "Test 2. Nil arg metrics.",
 			argMetrics: nil,
-			want:       MemStorage{Metrics: nil},
+			want:       &MemStorage{Metrics: nil},
 		},
 		{
 			name: "Test 3. Arg metrics filled with metrics.",
@@ -379,7 +532,7 @@ func TestNewMemStorage(t *testing.T) {
 				metric1Counter10.Name: metric1Counter10,
 				metric4Gauge2d27.Name: metric4Gauge2d27,
 			},
-			want: MemStorage{
+			want: &MemStorage{
 				Metrics: map[string]Metric{
 					metric1Counter10.Name: metric1Counter10,
 					metric4Gauge2d27.Name: metric4Gauge2d27,
@@ -389,7 +542,7 @@ func TestNewMemStorage(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			memStorageObj := *NewMemStorage(tt.argMetrics)
+			memStorageObj := NewMemStorage(tt.argMetrics)
 			assert.Equal(t, tt.want, memStorageObj)
 		})
 	}
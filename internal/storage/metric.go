@@ -82,8 +82,8 @@ func NewMetricFromMessage(metrics *message.Metrics) (newMetric *Metric, err erro
 // Для типа "gauge" - перезаписывает значение, для "counter" - прибавляет новое значение к уже существующему.
 func (m *Metric) Update(value interface{}) error {
 	if reflect.TypeOf(m.Value) != reflect.TypeOf(value) {
-		return fmt.Errorf("current(%T) and new(%T) value type mismatch",
-			m.Value, value)
+		return fmt.Errorf("current(%T) and new(%T) value %w",
+			m.Value, value, ErrTypeMismatch)
 	}
 
 	switch value := value.(type) {
@@ -135,3 +135,24 @@ func (m *Metric) GetMetricParamsString() (mN string, mV string, mT string) {
 	}
 	return
 }
+
+// checkTypes сверяет типы значений metrics с сохраненными метриками stored и с предыдущими метриками набора
+// с тем же названием. Возвращает *TypeMismatchError для первой метрики другого типа.
+func checkTypes(stored map[string]Metric, metrics []Metric) error {
+	known := map[string]Metric{}
+	for i, metric := range metrics {
+		current, ok := known[metric.Name]
+		if !ok {
+			current, ok = stored[metric.Name]
+		}
+		if !ok {
+			known[metric.Name] = metric
+			continue
+		}
+		if reflect.TypeOf(current.Value) != reflect.TypeOf(metric.Value) {
+			return &TypeMismatchError{Index: i, Name: metric.Name, Current: current.Value, New: metric.Value}
+		}
+		known[metric.Name] = current
+	}
+	return nil
+}
@@ -38,16 +38,16 @@ func TestMetric_Update(t *testing.T) {
 			updatedMetric: Metric{Name: "metric1", Value: counter(10)},
 			newValue:      gauge(15.5),
 			wantMetric:    Metric{Name: "metric1", Value: counter(10)},
-			wantError: fmt.Errorf("current(%T) and new(%T) value type mismatch",
-				counter(10), gauge(15.5)),
+			wantError: fmt.Errorf("current(%T) and new(%T) value %w",
+				counter(10), gauge(15.5), ErrTypeMismatch),
 		},
 		{
 			name:          "Test 4. Metric with unhandled value type, incl nil",
 			updatedMetric: Metric{Name: "metric1", Value: nil},
 			newValue:      gauge(15.5),
 			wantMetric:    Metric{Name: "metric1", Value: nil},
-			wantError: fmt.Errorf("current(%T) and new(%T) value type mismatch",
-				nil, gauge(15.5)),
+			wantError: fmt.Errorf("current(%T) and new(%T) value %w",
+				nil, gauge(15.5), ErrTypeMismatch),
 		},
 	}
 	for _, tt := range tests {
@@ -4,8 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	"strconv"
+	"time"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 
@@ -48,6 +48,7 @@ func (db *SQLStorage) openDBConnection(DSN string) error {
 }
 
 // createTableIfNotExist создает таблицу(metrics) для хранения метрик, если она еще не создана.
+// Колонка m_updated_at добавляется отдельно, для таблиц созданных до ее появления.
 func (db *SQLStorage) createTableIfNotExist(ctx context.Context) (err error) {
 	_, err = db.Connection.ExecContext(
 		ctx,
@@ -62,6 +63,13 @@ func (db *SQLStorage) createTableIfNotExist(ctx context.Context) (err error) {
 	if err != nil {
 		return
 	}
+	_, err = db.Connection.ExecContext(
+		ctx,
+		`ALTER TABLE metrics ADD COLUMN IF NOT EXISTS m_updated_at TIMESTAMPTZ NOT NULL DEFAULT now();`,
+	)
+	if err != nil {
+		return
+	}
 	return nil
 }
 
@@ -80,7 +88,7 @@ func (db *SQLStorage) AddMetric(ctx context.Context, metric Metric) (err error)
 
 // UpdateMetric обновляет значение метрики.
 // Сначала метрика(переданная в арг-ах функции) находится в БД, затем обновляется в коде и обновление записывается в БД.
-// BUG(firesworder): возвращается кастомная ошибка вместо ErrMetricNotFound.
+// Если метрика не найдена - возвращает ошибку ErrMetricNotFound.
 func (db *SQLStorage) UpdateMetric(ctx context.Context, metric Metric) (err error) {
 	dbMetric, err := db.GetMetric(ctx, metric.Name)
 	if err != nil {
@@ -93,13 +101,13 @@ func (db *SQLStorage) UpdateMetric(ctx context.Context, metric Metric) (err erro
 
 	mN, mV, mT := dbMetric.GetMetricParamsString()
 	result, err := db.Connection.ExecContext(ctx,
-		"UPDATE metrics SET m_value = $2, m_type = $3 WHERE m_name = $1", mN, mV, mT)
+		"UPDATE metrics SET m_value = $2, m_type = $3, m_updated_at = now() WHERE m_name = $1", mN, mV, mT)
 	if err != nil {
 		return
 	}
 	rAff, err := result.RowsAffected()
 	if rAff == 0 {
-		return fmt.Errorf("metric to update was not found")
+		return ErrMetricNotFound
 	}
 	return
 }
@@ -152,11 +160,17 @@ func (db *SQLStorage) UpdateOrAddMetric(ctx context.Context, metric Metric) (err
 
 // GetAll возвращает все метрики в таблице.
 func (db *SQLStorage) GetAll(ctx context.Context) (result map[string]Metric, err error) {
-	result = map[string]Metric{}
 	rows, err := db.Connection.QueryContext(ctx, "SELECT m_name, m_value, m_type FROM metrics")
 	if err != nil {
 		return
 	}
+	return scanMetrics(rows)
+}
+
+// scanMetrics читает метрики из строк(m_name, m_value, m_type) результата запроса и закрывает rows.
+func scanMetrics(rows *sql.Rows) (result map[string]Metric, err error) {
+	defer rows.Close()
+	result = map[string]Metric{}
 
 	var mN, mV, mT string
 	var mValue interface{}
@@ -231,6 +245,52 @@ func (db *SQLStorage) GetMetric(ctx context.Context, name string) (metric Metric
 	return *m, nil
 }
 
+// GetUpdateTimes возвращает время последнего обновления каждой метрики в таблице.
+func (db *SQLStorage) GetUpdateTimes(ctx context.Context) (result map[string]time.Time, err error) {
+	result = map[string]time.Time{}
+	rows, err := db.Connection.QueryContext(ctx, "SELECT m_name, m_updated_at FROM metrics")
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var mN string
+	var mUpdatedAt time.Time
+	for rows.Next() {
+		err = rows.Scan(&mN, &mUpdatedAt)
+		if err != nil {
+			return
+		}
+		result[mN] = mUpdatedAt
+	}
+
+	err = rows.Err()
+	return
+}
+
+// DeleteStale удаляет метрики, не обновлявшиеся с момента before, и возвращает их названия.
+// Проверка времени обновления и удаление выполняются одним запросом.
+func (db *SQLStorage) DeleteStale(ctx context.Context, before time.Time) (deleted []string, err error) {
+	rows, err := db.Connection.QueryContext(ctx,
+		"DELETE FROM metrics WHERE m_updated_at < $1 RETURNING m_name", before)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	var mN string
+	for rows.Next() {
+		err = rows.Scan(&mN)
+		if err != nil {
+			return
+		}
+		deleted = append(deleted, mN)
+	}
+
+	err = rows.Err()
+	return
+}
+
 // BatchUpdate обновляет метрики в таблице батчем metrics.
 // Обрабатан кейс нескольких обновлений одной и той же метрики.
 //
@@ -248,6 +308,48 @@ func (db *SQLStorage) BatchUpdate(ctx context.Context, metrics []Metric) (err er
 	}
 	defer tx.Rollback()
 
+	if err = writeBatch(ctx, tx, existedMetrics, metrics); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// BatchUpdateStrict обновляет метрики в таблице батчем metrics, если типы всех значений совпадают с сохраненными.
+// Сохраненные метрики батча читаются в той же транзакции с блокировкой строк(FOR UPDATE),
+// поэтому их тип не может измениться между проверкой и обновлением. Иначе возвращает *TypeMismatchError.
+func (db *SQLStorage) BatchUpdateStrict(ctx context.Context, metrics []Metric) (err error) {
+	tx, err := db.Connection.BeginTx(ctx, nil)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	names := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		names = append(names, metric.Name)
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT m_name, m_value, m_type FROM metrics WHERE m_name = ANY($1) FOR UPDATE", names)
+	if err != nil {
+		return
+	}
+	existedMetrics, err := scanMetrics(rows)
+	if err != nil {
+		return
+	}
+	if err = checkTypes(existedMetrics, metrics); err != nil {
+		return
+	}
+
+	if err = writeBatch(ctx, tx, existedMetrics, metrics); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+// writeBatch записывает в транзакции tx суммарные изменения метрик metrics:
+// обновляет метрики из existedMetrics и добавляет остальные.
+func writeBatch(ctx context.Context, tx *sql.Tx, existedMetrics map[string]Metric, metrics []Metric) (err error) {
 	metricsUpdate := map[string]Metric{}
 	for _, metric := range metrics {
 		if metricUpdate, ok := metricsUpdate[metric.Name]; ok {
@@ -269,7 +371,8 @@ func (db *SQLStorage) BatchUpdate(ctx context.Context, metrics []Metric) (err er
 			}
 			mN, mV, mT = existedMetric.GetMetricParamsString()
 			if _, err = tx.ExecContext(ctx,
-				"UPDATE metrics SET m_value = $2, m_type = $3 WHERE m_name = $1", mN, mV, mT); err != nil {
+				"UPDATE metrics SET m_value = $2, m_type = $3, m_updated_at = now() WHERE m_name = $1",
+				mN, mV, mT); err != nil {
 				return
 			}
 		} else {
@@ -280,6 +383,5 @@ func (db *SQLStorage) BatchUpdate(ctx context.Context, metrics []Metric) (err er
 			}
 		}
 	}
-
-	return tx.Commit()
+	return nil
 }
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -95,6 +96,34 @@ func TestSqlStorage_BatchUpdate(t *testing.T) {
 	}
 }
 
+func TestSQLStorage_BatchUpdateStrict(t *testing.T) {
+	ctx := context.Background()
+	sqlStorage, err := NewSQLStorage(devDSN)
+	if err != nil {
+		t.Skipf("cannot connect to db. db mocks are not ready yet")
+	}
+	defer sqlStorage.Connection.Close()
+
+	initDBState := map[string]Metric{metric1Counter10.Name: metric1Counter10}
+	prepareDBState(t, sqlStorage, ctx, initDBState)
+
+	// метрика другого типа - батч не применяется целиком
+	err = sqlStorage.BatchUpdateStrict(ctx, []Metric{metric4Gauge2d27, metric1Gauge22d2})
+	assert.ErrorIs(t, err, ErrTypeMismatch)
+	gotDBState, err := sqlStorage.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, initDBState, gotDBState)
+
+	err = sqlStorage.BatchUpdateStrict(ctx, []Metric{metric1Counter10, metric4Gauge2d27})
+	require.NoError(t, err)
+	gotDBState, err = sqlStorage.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Metric{
+		metric1Counter10.Name: *metric1counter20,
+		metric4Gauge2d27.Name: metric4Gauge2d27,
+	}, gotDBState)
+}
+
 func TestSQLStorage_AddMetric(t *testing.T) {
 	var err error
 	ctx := context.Background()
@@ -504,3 +533,46 @@ func TestSQLStorage_GetMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestSQLStorage_GetUpdateTimes(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	sqlStorage, err := NewSQLStorage(devDSN)
+	if err != nil {
+		t.Skipf("cannot connect to db. db mocks are not ready yet")
+	}
+	defer sqlStorage.Connection.Close()
+
+	prepareDBState(t, sqlStorage, ctx, map[string]Metric{metric1Counter10.Name: metric1Counter10})
+	gotTimes, err := sqlStorage.GetUpdateTimes(ctx)
+	require.NoError(t, err)
+	require.Contains(t, gotTimes, metric1Counter10.Name)
+	insertTime := gotTimes[metric1Counter10.Name]
+
+	err = sqlStorage.UpdateMetric(ctx, metric1Counter10)
+	require.NoError(t, err)
+	gotTimes, err = sqlStorage.GetUpdateTimes(ctx)
+	require.NoError(t, err)
+	assert.True(t, gotTimes[metric1Counter10.Name].After(insertTime))
+}
+
+func TestSQLStorage_DeleteStale(t *testing.T) {
+	var err error
+	ctx := context.Background()
+	sqlStorage, err := NewSQLStorage(devDSN)
+	if err != nil {
+		t.Skipf("cannot connect to db. db mocks are not ready yet")
+	}
+	defer sqlStorage.Connection.Close()
+
+	prepareDBState(t, sqlStorage, ctx, map[string]Metric{metric1Counter10.Name: metric1Counter10})
+	gotDeleted, err := sqlStorage.DeleteStale(ctx, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, gotDeleted)
+
+	gotDeleted, err = sqlStorage.DeleteStale(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []string{metric1Counter10.Name}, gotDeleted)
+	_, err = sqlStorage.GetMetric(ctx, metric1Counter10.Name)
+	assert.ErrorIs(t, err, ErrMetricNotFound)
+}
@@ -0,0 +1,119 @@
+// Package stream реализует рассылку обновлений метрик подписчикам(используется для Server-Sent Events).
+// Рассылка не блокирует отправителя: подписчик, не успевающий читать события, отключается.
+package stream
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+// DefaultBufferSize размер буфера событий подписчика по умолчанию.
+const DefaultBufferSize = 64
+
+// ErrHubClosed ошибка "рассылка остановлена".
+var ErrHubClosed = errors.New("stream hub is closed")
+
+// Filter возвращает true, если событие по метрике нужно отправить подписчику.
+type Filter func(msg message.Metrics) bool
+
+// Subscription подписка на обновления метрик.
+// Канал Events закрывается при отписке, остановке рассылки или если подписчик не успевал читать события.
+type Subscription struct {
+	Events  <-chan message.Metrics
+	events  chan message.Metrics
+	filter  Filter
+	dropped bool
+}
+
+// Dropped возвращает true, если подписка была отключена из-за переполнения буфера.
+// Значение актуально после закрытия канала Events.
+func (sub *Subscription) Dropped() bool {
+	return sub.dropped
+}
+
+// Hub рассылает обновления метрик подписчикам. Нулевое значение готово к использованию.
+type Hub struct {
+	subscriptions map[*Subscription]struct{}
+	// BufferSize размер буфера событий подписчика, если не задан - DefaultBufferSize.
+	BufferSize int
+	mutex      sync.Mutex
+	closed     bool
+}
+
+// Subscribe создает подписку на обновления метрик, удовлетворяющих filter(nil - все метрики).
+func (h *Hub) Subscribe(filter Filter) (*Subscription, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return nil, ErrHubClosed
+	}
+
+	bufferSize := h.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	events := make(chan message.Metrics, bufferSize)
+	sub := &Subscription{Events: events, events: events, filter: filter}
+	if h.subscriptions == nil {
+		h.subscriptions = map[*Subscription]struct{}{}
+	}
+	h.subscriptions[sub] = struct{}{}
+	return sub, nil
+}
+
+// Unsubscribe удаляет подписку и закрывает ее канал событий. Повторный вызов ничего не делает.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.removeSubscription(sub)
+}
+
+// removeSubscription реализация Unsubscribe, без блокировки мьютекса.
+func (h *Hub) removeSubscription(sub *Subscription) {
+	if _, ok := h.subscriptions[sub]; !ok {
+		return
+	}
+	delete(h.subscriptions, sub)
+	close(sub.events)
+}
+
+// HasSubscribers возвращает true, если есть хотя бы одна подписка.
+func (h *Hub) HasSubscribers() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.subscriptions) != 0
+}
+
+// Publish рассылает события подписчикам. Не блокируется: если буфер подписчика заполнен - подписка отключается.
+func (h *Hub) Publish(msgs ...message.Metrics) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for sub := range h.subscriptions {
+		for _, msg := range msgs {
+			if sub.filter != nil && !sub.filter(msg) {
+				continue
+			}
+			select {
+			case sub.events <- msg:
+				continue
+			default:
+			}
+			sub.dropped = true
+			h.removeSubscription(sub)
+			break
+		}
+	}
+}
+
+// Close останавливает рассылку: закрывает все подписки и запрещает создание новых.
+func (h *Hub) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.closed = true
+	for sub := range h.subscriptions {
+		h.removeSubscription(sub)
+	}
+}
@@ -0,0 +1,104 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+var (
+	msgPollCount = message.Metrics{ID: "PollCount", MType: "counter"}
+	msgAlloc     = message.Metrics{ID: "Alloc", MType: "gauge"}
+)
+
+// readEvents вычитывает из подписки все события, находящиеся в буфере.
+func readEvents(sub *Subscription) []message.Metrics {
+	var events []message.Metrics
+	for {
+		select {
+		case msg, ok := <-sub.Events:
+			if !ok {
+				return events
+			}
+			events = append(events, msg)
+		default:
+			return events
+		}
+	}
+}
+
+func TestHub_Publish(t *testing.T) {
+	tests := []struct {
+		name        string
+		bufferSize  int
+		filter      Filter
+		publish     []message.Metrics
+		wantEvents  []message.Metrics
+		wantDropped bool
+	}{
+		{
+			name:       "Test 1. Without filter.",
+			publish:    []message.Metrics{msgPollCount, msgAlloc},
+			wantEvents: []message.Metrics{msgPollCount, msgAlloc},
+		},
+		{
+			name:       "Test 2. With filter.",
+			filter:     func(msg message.Metrics) bool { return msg.MType == "gauge" },
+			publish:    []message.Metrics{msgPollCount, msgAlloc},
+			wantEvents: []message.Metrics{msgAlloc},
+		},
+		{
+			name:        "Test 3. Slow subscriber is dropped.",
+			bufferSize:  1,
+			publish:     []message.Metrics{msgPollCount, msgAlloc},
+			wantEvents:  []message.Metrics{msgPollCount},
+			wantDropped: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := &Hub{BufferSize: tt.bufferSize}
+			sub, err := hub.Subscribe(tt.filter)
+			require.NoError(t, err)
+
+			hub.Publish(tt.publish...)
+			assert.Equal(t, tt.wantEvents, readEvents(sub))
+			assert.Equal(t, tt.wantDropped, sub.Dropped())
+			assert.Equal(t, !tt.wantDropped, hub.HasSubscribers())
+		})
+	}
+}
+
+func TestHub_Unsubscribe(t *testing.T) {
+	hub := &Hub{}
+	sub, err := hub.Subscribe(nil)
+	require.NoError(t, err)
+	assert.True(t, hub.HasSubscribers())
+
+	hub.Unsubscribe(sub)
+	hub.Unsubscribe(sub)
+	assert.False(t, hub.HasSubscribers())
+	_, ok := <-sub.Events
+	assert.False(t, ok)
+	assert.False(t, sub.Dropped())
+
+	// публикация без подписчиков не блокируется
+	hub.Publish(msgPollCount)
+}
+
+func TestHub_Close(t *testing.T) {
+	hub := &Hub{}
+	sub, err := hub.Subscribe(nil)
+	require.NoError(t, err)
+
+	hub.Close()
+	_, ok := <-sub.Events
+	assert.False(t, ok)
+	assert.False(t, hub.HasSubscribers())
+
+	_, err = hub.Subscribe(nil)
+	assert.ErrorIs(t, err, ErrHubClosed)
+}
@@ -0,0 +1,77 @@
+// Package tlsconfig формирует tls конфигурации сервера и агента из PEM файлов сертификатов и ключей.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrIncorrectConfig ошибка "некорректные параметры tls".
+var ErrIncorrectConfig = errors.New("incorrect tls config")
+
+// NewServerConfig возвращает tls конфигурацию сервера с сертификатом certFile и ключом keyFile.
+// Если clientCAFile задан - сервер требует сертификат клиента, подписанный одним из CA из этого файла(mTLS).
+func NewServerConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("%w: both cert and key files are required", ErrIncorrectConfig)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile != "" {
+		config.ClientCAs, err = LoadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// NewClientConfig возвращает tls конфигурацию клиента.
+// Если caFile задан - доверяет только CA из этого файла(иначе - системным), certFile и keyFile - сертификат клиента(mTLS).
+func NewClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	var err error
+	if caFile != "" {
+		config.RootCAs, err = LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if certFile != "" || keyFile != "" {
+		if certFile == "" || keyFile == "" {
+			return nil, fmt.Errorf("%w: both client cert and key files are required", ErrIncorrectConfig)
+		}
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// LoadCertPool читает PEM файл с одним или несколькими сертификатами CA.
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	content, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, fmt.Errorf("%w: no certificates found in '%s'", ErrIncorrectConfig, caFile)
+	}
+	return pool, nil
+}
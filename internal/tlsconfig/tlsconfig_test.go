@@ -0,0 +1,135 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewServerConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		certFile       string
+		keyFile        string
+		clientCAFile   string
+		wantClientAuth tls.ClientAuthType
+		wantErr        bool
+	}{
+		{
+			name:     "Test 1. TLS without client verification.",
+			certFile: "test/server_test.pem", keyFile: "test/server_key_test.pem",
+			wantClientAuth: tls.NoClientCert,
+		},
+		{
+			name:     "Test 2. mTLS.",
+			certFile: "test/server_test.pem", keyFile: "test/server_key_test.pem", clientCAFile: "test/ca_test.pem",
+			wantClientAuth: tls.RequireAndVerifyClientCert,
+		},
+		{
+			name:     "Test 3. Key is not set.",
+			certFile: "test/server_test.pem",
+			wantErr:  true,
+		},
+		{
+			name:     "Test 4. Key does not match cert.",
+			certFile: "test/server_test.pem", keyFile: "test/client_key_test.pem",
+			wantErr: true,
+		},
+		{
+			name:     "Test 5. Client CA file has no certificates.",
+			certFile: "test/server_test.pem", keyFile: "test/server_key_test.pem", clientCAFile: "test/server_key_test.pem",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := NewServerConfig(tt.certFile, tt.keyFile, tt.clientCAFile)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, config.Certificates, 1)
+			assert.Equal(t, tt.wantClientAuth, config.ClientAuth)
+		})
+	}
+}
+
+func TestNewClientConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		caFile   string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{name: "Test 1. System CAs, without client cert."},
+		{name: "Test 2. Pinned CA and client cert.", caFile: "test/ca_test.pem",
+			certFile: "test/client_test.pem", keyFile: "test/client_key_test.pem"},
+		{name: "Test 3. CA file is not exist.", caFile: "test/not_exist.pem", wantErr: true},
+		{name: "Test 4. Client key is not set.", certFile: "test/client_test.pem", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewClientConfig(tt.caFile, tt.certFile, tt.keyFile)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverConfig, err := NewServerConfig("test/server_test.pem", "test/server_key_test.pem", "test/ca_test.pem")
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = serverConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		caFile   string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{
+			name:   "Test 1. Pinned CA and client cert.",
+			caFile: "test/ca_test.pem", certFile: "test/client_test.pem", keyFile: "test/client_key_test.pem",
+		},
+		{
+			name:    "Test 2. Without client cert.",
+			caFile:  "test/ca_test.pem",
+			wantErr: true,
+		},
+		{
+			name:   "Test 3. Client cert signed by untrusted CA.",
+			caFile: "test/ca_test.pem", certFile: "test/other_client_test.pem", keyFile: "test/other_client_key_test.pem",
+			wantErr: true,
+		},
+		{
+			name:   "Test 4. Server cert is not signed by pinned CA.",
+			caFile: "test/other_ca_test.pem", certFile: "test/client_test.pem", keyFile: "test/client_key_test.pem",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientConfig, err := NewClientConfig(tt.caFile, tt.certFile, tt.keyFile)
+			require.NoError(t, err)
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+
+			resp, err := client.Get(ts.URL)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Package validation реализует политику проверки метрик перед сохранением на сервере:
+// название(регулярное выражение, длина, запрещенные названия), тип и значение(NaN/Inf, максимальная дельта counter).
+package validation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+// ErrInvalidMetric ошибка "метрика не прошла проверку политики".
+var ErrInvalidMetric = errors.New("invalid metric")
+
+// Значения политики по умолчанию.
+const (
+	DefaultNameRegex     = `^[A-Za-z0-9_.:-]+$`
+	DefaultMaxNameLength = 255
+)
+
+// Config параметры политики проверки метрик.
+type Config struct {
+	// NameRegex регулярное выражение для названия метрики, пустая строка - любое название.
+	NameRegex string
+	// MaxNameLength максимальная длина названия метрики, 0 - без ограничения.
+	MaxNameLength int
+	// AllowedTypes разрешенные типы метрик, пустой список - любой тип.
+	AllowedTypes []string
+	// AllowNonFinite разрешает NaN и Inf значения gauge метрик.
+	AllowNonFinite bool
+	// MaxCounterDelta максимальная(по модулю) дельта counter метрики, 0 - без ограничения.
+	MaxCounterDelta int64
+	// DenyList glob шаблоны(синтаксис path.Match) запрещенных названий метрик.
+	DenyList []string
+}
+
+// Policy политика проверки метрик. nil Policy пропускает любые метрики.
+type Policy struct {
+	nameRegex       *regexp.Regexp
+	maxNameLength   int
+	allowedTypes    map[string]bool
+	allowNonFinite  bool
+	maxCounterDelta int64
+	denyList        []string
+}
+
+// NewPolicy конструктор Policy. Возвращает ошибку для некорректного регулярного выражения, шаблона или типа.
+func NewPolicy(config Config) (*Policy, error) {
+	p := &Policy{
+		maxNameLength:   config.MaxNameLength,
+		allowNonFinite:  config.AllowNonFinite,
+		maxCounterDelta: config.MaxCounterDelta,
+	}
+	if config.NameRegex != "" {
+		nameRegex, err := regexp.Compile(config.NameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("name regex: %w", err)
+		}
+		p.nameRegex = nameRegex
+	}
+	for _, typeName := range config.AllowedTypes {
+		typeName = strings.TrimSpace(typeName)
+		if typeName == "" {
+			continue
+		}
+		if typeName != internal.GaugeTypeName && typeName != internal.CounterTypeName {
+			return nil, fmt.Errorf("unknown metric type '%s' in allowed types", typeName)
+		}
+		if p.allowedTypes == nil {
+			p.allowedTypes = map[string]bool{}
+		}
+		p.allowedTypes[typeName] = true
+	}
+	for _, pattern := range config.DenyList {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("deny list pattern '%s': %w", pattern, err)
+		}
+		p.denyList = append(p.denyList, pattern)
+	}
+	return p, nil
+}
+
+// Validate проверяет метрику msg по политике. Ошибка оборачивает ErrInvalidMetric.
+func (p *Policy) Validate(msg message.Metrics) error {
+	if p == nil {
+		return nil
+	}
+
+	if msg.ID == "" {
+		return fmt.Errorf("%w: name is empty", ErrInvalidMetric)
+	}
+	if p.maxNameLength > 0 && len(msg.ID) > p.maxNameLength {
+		return fmt.Errorf("%w: name '%s' is longer than %d", ErrInvalidMetric, msg.ID, p.maxNameLength)
+	}
+	if p.nameRegex != nil && !p.nameRegex.MatchString(msg.ID) {
+		return fmt.Errorf("%w: name '%s' does not match '%s'", ErrInvalidMetric, msg.ID, p.nameRegex)
+	}
+	for _, pattern := range p.denyList {
+		if matched, _ := path.Match(pattern, msg.ID); matched {
+			return fmt.Errorf("%w: name '%s' is denied", ErrInvalidMetric, msg.ID)
+		}
+	}
+	if p.allowedTypes != nil && !p.allowedTypes[msg.MType] {
+		return fmt.Errorf("%w: type '%s' is not allowed", ErrInvalidMetric, msg.MType)
+	}
+
+	switch msg.MType {
+	case internal.GaugeTypeName:
+		if msg.Value != nil && !p.allowNonFinite && (math.IsNaN(*msg.Value) || math.IsInf(*msg.Value, 0)) {
+			return fmt.Errorf("%w: value %v of '%s' is not finite", ErrInvalidMetric, *msg.Value, msg.ID)
+		}
+	case internal.CounterTypeName:
+		if msg.Delta != nil && p.maxCounterDelta > 0 &&
+			(*msg.Delta > p.maxCounterDelta || *msg.Delta < -p.maxCounterDelta) {
+			return fmt.Errorf("%w: delta %d of '%s' exceeds %d", ErrInvalidMetric, *msg.Delta, msg.ID, p.maxCounterDelta)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package validation
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/firesworder/devopsmetrics/internal"
+	"github.com/firesworder/devopsmetrics/internal/message"
+)
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "Test 1. Empty config.", config: Config{}},
+		{
+			name: "Test 2. Full config.",
+			config: Config{
+				NameRegex: DefaultNameRegex, MaxNameLength: DefaultMaxNameLength,
+				AllowedTypes: []string{"gauge", " counter", ""}, MaxCounterDelta: 100, DenyList: []string{"Debug*"},
+			},
+		},
+		{name: "Test 3. Incorrect regex.", config: Config{NameRegex: "[a-z"}, wantErr: true},
+		{name: "Test 4. Unknown type.", config: Config{AllowedTypes: []string{"histogram"}}, wantErr: true},
+		{name: "Test 5. Incorrect deny pattern.", config: Config{DenyList: []string{"[Debug"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewPolicy(tt.config)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotNil(t, got)
+		})
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	policy, err := NewPolicy(Config{
+		NameRegex:       DefaultNameRegex,
+		MaxNameLength:   16,
+		AllowedTypes:    []string{internal.GaugeTypeName, internal.CounterTypeName},
+		MaxCounterDelta: 100,
+		DenyList:        []string{"Debug*"},
+	})
+	require.NoError(t, err)
+	gaugesOnly, err := NewPolicy(Config{AllowedTypes: []string{internal.GaugeTypeName}, AllowNonFinite: true})
+	require.NoError(t, err)
+
+	gauge := func(name string, value float64) message.Metrics {
+		return message.Metrics{ID: name, MType: internal.GaugeTypeName, Value: &value}
+	}
+	counter := func(name string, delta int64) message.Metrics {
+		return message.Metrics{ID: name, MType: internal.CounterTypeName, Delta: &delta}
+	}
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		msg     message.Metrics
+		wantErr string
+	}{
+		{name: "Test 1. Valid gauge.", policy: policy, msg: gauge("Alloc", 7.77)},
+		{name: "Test 2. Valid counter.", policy: policy, msg: counter("PollCount", -100)},
+		{name: "Test 3. Empty name.", policy: policy, msg: gauge("", 1), wantErr: "name is empty"},
+		{name: "Test 4. Name with spaces.", policy: policy, msg: gauge("Poll Count", 1), wantErr: "does not match"},
+		{
+			name: "Test 5. Name is too long.", policy: policy,
+			msg: gauge(strings.Repeat("a", 17), 1), wantErr: "is longer than 16",
+		},
+		{name: "Test 6. Denied name.", policy: policy, msg: gauge("DebugValue", 1), wantErr: "is denied"},
+		{name: "Test 7. NaN gauge.", policy: policy, msg: gauge("Alloc", math.NaN()), wantErr: "is not finite"},
+		{name: "Test 8. Inf gauge.", policy: policy, msg: gauge("Alloc", math.Inf(-1)), wantErr: "is not finite"},
+		{name: "Test 9. Counter delta exceeds max.", policy: policy, msg: counter("PollCount", 101), wantErr: "exceeds 100"},
+		{
+			name: "Test 10. Type is not allowed.", policy: gaugesOnly,
+			msg: counter("PollCount", 1), wantErr: "type 'counter' is not allowed",
+		},
+		{name: "Test 11. NaN is allowed.", policy: gaugesOnly, msg: gauge("Alloc", math.NaN())},
+		{name: "Test 12. Nil policy.", policy: nil, msg: gauge("", math.NaN())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate(tt.msg)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidMetric)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
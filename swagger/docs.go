@@ -20,14 +20,627 @@ const docTemplate = `{
     "paths": {
         "/": {
             "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "text/html"
+                ],
+                "tags": [
+                    "NoJSON"
+                ],
+                "summary": "Обрабатывает GET запросы вывода всех метрик сохраненных на сервере.",
+                "operationId": "handlerShowAllMetrics",
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/alerts": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "В ответ возвращает массив алертов(правило, метрика, состояние pending/firing/resolved).",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "JSON"
+                ],
+                "summary": "Обрабатывает GET запросы получения текущих алертов.",
+                "operationId": "handlerAlerts",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Фильтр по состоянию(pending/firing/resolved)",
+                        "name": "state",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверный запрос",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/query": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "В ответ возвращает массив message.Metrics найденных метрик.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "JSON"
+                ],
+                "summary": "Обрабатывает GET запросы выборки метрик по условиям.",
+                "operationId": "handlerQuery",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "glob шаблон названия метрики, например CPUutilization*",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Регулярное выражение для названия метрики",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Тип метрики(gauge/counter)",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Поле сортировки(name/value), по умолчанию name",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Порядок сортировки(asc/desc), по умолчанию asc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Максимальное кол-во метрик в ответе",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Кол-во пропускаемых метрик",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Агрегация найденных метрик(sum/min/max/avg)",
+                        "name": "agg",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверный запрос",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/rate": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Скорость(в секунду) вычисляется по истории полученных сервером дельт счетчика за окно window.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "JSON"
+                ],
+                "summary": "Обрабатывает GET запросы получения скорости роста counter метрики.",
+                "operationId": "handlerRate",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Название метрики",
+                        "name": "name",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Окно расчета(например, 1m)",
+                        "name": "window",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверный запрос",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "unknown metric",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/rejected": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "В ответ возвращает массив counter метрик: RejectedRateLimited(429),",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "JSON"
+                ],
+                "summary": "Обрабатывает GET запросы получения счетчиков отклоненных сервером запросов.",
+                "operationId": "handlerRejected",
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/stream": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Каждое успешное обновление метрики отправляется событием \"metric\" с message.Metrics в data.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "JSON"
+                ],
+                "summary": "Обрабатывает GET запросы подписки на обновления метрик(Server-Sent Events).",
+                "operationId": "handlerStream",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "glob шаблон названия метрики, например CPUutilization*",
+                        "name": "name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Регулярное выражение для названия метрики",
+                        "name": "regex",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Тип метрики(gauge/counter)",
+                        "name": "type",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ok",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверный запрос",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "500": {
+                        "description": "Внутренняя ошибка",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Сервер останавливается",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v2/metrics": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "В ответ возвращает массив message.Metrics, отсортированный по названию метрики.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "APIv2"
+                ],
+                "summary": "Возвращает все метрики сервера.",
+                "operationId": "handlerV2Metrics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/message.Metrics"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v2/update": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Метрика передается в теле запроса(message.Metrics), в ответ возвращается сохраненное значение.\nОшибки storage: тип значения не совпадает с сохраненным - 409, нереализованный тип - 501.\nЕсли метрика отброшена правилами relabel - не сохраняется, в ответ возвращается полученная метрика.",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
-                    "text/html"
+                    "application/json"
+                ],
+                "tags": [
+                    "APIv2"
+                ],
+                "summary": "Сохраняет метрику.",
+                "operationId": "handlerV2Update",
+                "parameters": [
+                    {
+                        "description": "Метрика",
+                        "name": "metric",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/message.Metrics"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/message.Metrics"
+                        }
+                    },
+                    "400": {
+                        "description": "bad_request, invalid_metric, invalid_hash",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "type_mismatch",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "unsupported_type",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v2/updates": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Набор применяется целиком: при ошибке в одной из метрик не сохраняется ни одна,\nв details ошибки передаются index и id метрики. В ответ - сохраненные значения в порядке запроса.\nМетрики, отброшенные правилами relabel, не сохраняются и не возвращаются в ответе.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "APIv2"
+                ],
+                "summary": "Сохраняет набор метрик.",
+                "operationId": "handlerV2BatchUpdate",
+                "parameters": [
+                    {
+                        "description": "Метрики",
+                        "name": "metrics",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/message.Metrics"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/message.Metrics"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "bad_request, invalid_metric, invalid_hash",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "409": {
+                        "description": "type_mismatch",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "413": {
+                        "description": "batch_too_long",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "501": {
+                        "description": "unsupported_type",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v2/value/{typeName}/{metricName}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Если метрика с таким названием есть, но другого типа - 404.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "APIv2"
+                ],
+                "summary": "Возвращает метрику по типу и названию.",
+                "operationId": "handlerV2Value",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Тип метрики",
+                        "name": "typeName",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Название метрики",
+                        "name": "metricName",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/message.Metrics"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "metric_not_found",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/server.apiErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/keys/": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "В теле передается публичный RSA ключ в pem-формате(PKCS#1, PKIX или X.509 сертификат).",
+                "consumes": [
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/json"
                 ],
                 "tags": [
                     "NoJSON"
                 ],
-                "summary": "Обрабатывает GET запросы вывода всех метрик сохраненных на сервере.",
-                "operationId": "handlerShowAllMetrics",
+                "summary": "Регистрирует публичный ключ клиента для шифрования ответов.",
+                "operationId": "handlerRegisterClientKey",
                 "responses": {
                     "200": {
                         "description": "ok",
@@ -35,6 +648,18 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "400": {
+                        "description": "Неверный ключ",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "ip is not in trusted subnet",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Внутренняя ошибка",
                         "schema": {
@@ -69,12 +694,21 @@ const docTemplate = `{
         },
         "/update/": {
             "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
                 "description": "Метрика(наим-ие, тип и значение) передается через тело запроса, посредством message.Metrics.",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/x-protobuf",
+                    "application/msgpack"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/x-protobuf",
+                    "application/msgpack"
                 ],
                 "tags": [
                     "JSON"
@@ -89,7 +723,13 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "hash is not correct\"\tесли\tполученный\tхеш\tне\tсовпал\tс\tсозданным\tна\tсервере.",
+                        "description": "Подпись запроса(X-Batch-Signature) неверна, устарела или nonce уже использован",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "ip is not in trusted subnet",
                         "schema": {
                             "type": "string"
                         }
@@ -100,6 +740,18 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "413": {
+                        "description": "Слишком большое тело запроса",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Превышена частота запросов, см. Retry-After",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Внутренняя ошибка",
                         "schema": {
@@ -117,6 +769,11 @@ const docTemplate = `{
         },
         "/update/{typeName}/{metricName}/{metricValue}": {
             "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
                 "description": "Метрика(наим-ие, тип и значение) передается через URLParam.",
                 "tags": [
                     "NoJSON"
@@ -153,12 +810,36 @@ const docTemplate = `{
                             "type": "string"
                         }
                     },
+                    "400": {
+                        "description": "Подпись запроса(X-Batch-Signature) неверна, устарела или nonce уже использован",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "ip is not in trusted subnet",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "404": {
                         "description": "unknown metric",
                         "schema": {
                             "type": "string"
                         }
                     },
+                    "413": {
+                        "description": "Слишком большое тело запроса",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Превышена частота запросов, см. Retry-After",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
                     "500": {
                         "description": "Внутренняя ошибка",
                         "schema": {
@@ -170,15 +851,35 @@ const docTemplate = `{
         },
         "/updates/": {
             "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
                 "description": "Метрики передаются как словарь message.Metrics.",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/x-protobuf",
+                    "application/msgpack"
+                ],
+                "produces": [
+                    "application/json",
+                    "application/x-protobuf",
+                    "application/msgpack"
                 ],
                 "tags": [
                     "JSON"
                 ],
                 "summary": "Обрабатывает POST запросы сохранения набора(словаря) метрик на сервере.",
                 "operationId": "handlerBatchUpdate",
+                "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Частичное применение набора(ответ - результат по каждой метрике)",
+                        "name": "partial",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "ok",
@@ -187,7 +888,25 @@ const docTemplate = `{
                         }
                     },
                     "400": {
-                        "description": "hash is not correct\"\tесли\tполученный\tхеш\tне\tсовпал\tс\tсозданным\tна\tсервере.",
+                        "description": "Подпись запроса(X-Batch-Signature) неверна, устарела или nonce уже использован",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "ip is not in trusted subnet",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "413": {
+                        "description": "Слишком большое тело запроса или батча",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Превышена частота запросов, см. Retry-After",
                         "schema": {
                             "type": "string"
                         }
@@ -209,12 +928,21 @@ const docTemplate = `{
         },
         "/value/": {
             "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
                 "description": "Наименование треб-ой метрики передается через тело запроса, посредством message.Metrics.",
                 "consumes": [
-                    "application/json"
+                    "application/json",
+                    "application/x-protobuf",
+                    "application/msgpack"
                 ],
                 "produces": [
-                    "application/json"
+                    "application/json",
+                    "application/x-protobuf",
+                    "application/msgpack"
                 ],
                 "tags": [
                     "JSON"
@@ -223,7 +951,7 @@ const docTemplate = `{
                 "operationId": "handlerJSONGetMetric",
                 "responses": {
                     "200": {
-                        "description": "OK",
+                        "description": "ok",
                         "schema": {
                             "type": "string"
                         }
@@ -251,6 +979,11 @@ const docTemplate = `{
         },
         "/value/{typeName}/{metricName}": {
             "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
                 "description": "В ответ возвращает значение метрики(в теле ответа).",
                 "produces": [
                     "text/plain"
@@ -299,6 +1032,70 @@ const docTemplate = `{
             }
         }
     },
+    "definitions": {
+        "message.Metrics": {
+            "type": "object",
+            "properties": {
+                "delta": {
+                    "description": "Значение метрики в случае передачи counter",
+                    "type": "integer"
+                },
+                "hash": {
+                    "description": "Значение хеш-функции",
+                    "type": "string"
+                },
+                "id": {
+                    "description": "Имя метрики",
+                    "type": "string"
+                },
+                "stale": {
+                    "description": "Метрика не обновлялась дольше TTL(заполняется только сервером)",
+                    "type": "boolean"
+                },
+                "type": {
+                    "description": "Параметр, принимающий значение gauge или counter",
+                    "type": "string"
+                },
+                "value": {
+                    "description": "Значение метрики в случае передачи gauge",
+                    "type": "number"
+                }
+            }
+        },
+        "server.apiError": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string",
+                    "example": "metric_not_found"
+                },
+                "details": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "message": {
+                    "type": "string",
+                    "example": "metric 'PollCount' was not found"
+                }
+            }
+        },
+        "server.apiErrorResponse": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "$ref": "#/definitions/server.apiError"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "description": "\"Bearer \u003ctoken\u003e\", проверяется только если на сервере заданы токены(AUTH_TOKENS).",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
+    },
     "tags": [
         {
             "description": "\"Группа JSON запросов.\"",